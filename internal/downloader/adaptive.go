@@ -0,0 +1,121 @@
+package downloader
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	concurrencyAuto     = "auto"
+	autoMaxWorkers      = 16              // ceiling of the adaptive limit
+	defaultAutoCooldown = 2 * time.Second // 429s this soon after a cut are from requests already in flight
+)
+
+// workerLimit bounds how many chapters download at once
+type workerLimit interface {
+	acquire()
+	release()
+}
+
+// semaphore is a fixed workerLimit of its capacity
+type semaphore chan struct{}
+
+func (s semaphore) acquire() { s <- struct{}{} }
+func (s semaphore) release() { <-s }
+
+// parseConcurrency returns the number of chapter workers concurrency asks
+// for, fallback when it is empty and 0 for auto; ok is false when it is
+// neither a positive number nor auto
+func parseConcurrency(concurrency string, fallback int) (workers int, ok bool) {
+	switch concurrency {
+	case "":
+		return fallback, true
+	case concurrencyAuto:
+		return 0, true
+	}
+	n, err := strconv.Atoi(concurrency)
+	return n, err == nil && n > 0
+}
+
+// adaptiveLimit is an AIMD limit on concurrent chapter downloads, shared by
+// the workers and fed the status of every HTTP response. It starts at one
+// chapter, grows by one after as many healthy responses in a row as the
+// current limit, and halves on a 429. Further 429s within the cooldown don't
+// cut it again, as they answer requests sent before the last cut.
+type adaptiveLimit struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    int
+	max      int
+	active   int
+	healthy  int // responses since the last change to limit
+	cooldown time.Duration
+	cutAt    time.Time
+	now      func() time.Time
+	log      *logger
+}
+
+func newAdaptiveLimit(max int, log *logger) *adaptiveLimit {
+	l := &adaptiveLimit{
+		limit:    1,
+		max:      max,
+		cooldown: defaultAutoCooldown,
+		now:      time.Now,
+		log:      log,
+	}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+func (l *adaptiveLimit) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.active >= l.limit {
+		l.cond.Wait()
+	}
+	l.active++
+}
+
+func (l *adaptiveLimit) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	l.cond.Signal()
+}
+
+// current returns the limit
+func (l *adaptiveLimit) current() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// observe adjusts the limit to a response status: 429 cuts it, 2xx and 3xx
+// count toward growing it, and anything else leaves it alone
+func (l *adaptiveLimit) observe(status int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch {
+	case status == http.StatusTooManyRequests:
+		l.healthy = 0
+		now := l.now()
+		if !l.cutAt.IsZero() && now.Sub(l.cutAt) < l.cooldown {
+			return
+		}
+		l.cutAt = now
+		if l.limit > 1 {
+			l.limit /= 2
+			l.log.Printf("[-] Rate limited, downloading %d chapters at a time\n", l.limit)
+		}
+	case status >= 200 && status < 400:
+		l.healthy++
+		if l.healthy >= l.limit && l.limit < l.max {
+			l.limit++
+			l.healthy = 0
+			l.cond.Signal()
+		}
+	}
+}
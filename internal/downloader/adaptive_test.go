@@ -0,0 +1,100 @@
+package downloader
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveLimit_BacksOffAndRecovers(t *testing.T) {
+	l := newAdaptiveLimit(8, nil)
+	clock := time.Unix(0, 0)
+	l.now = func() time.Time { return clock }
+
+	healthy := func(n int) {
+		for range n {
+			l.observe(http.StatusOK)
+		}
+	}
+
+	healthy(100)
+	if got := l.current(); got != 8 {
+		t.Fatalf("Expected healthy responses to grow the limit to 8, got %d", got)
+	}
+
+	// Every few seconds the site rate limits a burst of in-flight requests
+	want := []int{4, 2, 1}
+	for _, limit := range want {
+		clock = clock.Add(l.cooldown)
+		for range 3 {
+			l.observe(http.StatusTooManyRequests)
+		}
+		if got := l.current(); got != limit {
+			t.Fatalf("Expected a 429 burst to halve the limit once, to %d, got %d", limit, got)
+		}
+		healthy(l.current() - 1)
+	}
+
+	l.observe(http.StatusNotFound)
+	l.observe(http.StatusInternalServerError)
+	healthy(1)
+	if got := l.current(); got != 2 {
+		t.Errorf("Expected only healthy responses to count toward growth, got limit %d", got)
+	}
+	healthy(100)
+	if got := l.current(); got != 8 {
+		t.Errorf("Expected the limit to recover to 8, got %d", got)
+	}
+}
+
+func TestAdaptiveLimit_AcquireWaitsForLimit(t *testing.T) {
+	l := newAdaptiveLimit(2, nil)
+	l.acquire()
+
+	acquired := make(chan struct{})
+	go func() {
+		l.acquire()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("Expected a second acquire to wait at limit 1")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	l.observe(http.StatusOK)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("Expected a second acquire once the limit grew to 2")
+	}
+	l.release()
+	l.release()
+}
+
+func TestNewDownloader_ConcurrencyAuto(t *testing.T) {
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}, Options{Concurrency: concurrencyAuto})
+	if d.adaptive == nil {
+		t.Fatal("Expected an adaptive worker limit")
+	}
+
+	before := d.adaptive.current()
+	for range before {
+		if _, err := d.client.Get(server.URL + "/ok"); err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+	}
+	if got := d.adaptive.current(); got != before+1 {
+		t.Errorf("Expected client responses to grow the limit from %d, got %d", before, got)
+	}
+
+	d, _ = newTestDownloader(t, http.NotFound, Options{Concurrency: "3"})
+	if d.workers != 3 || d.adaptive != nil {
+		t.Errorf("Expected a fixed limit of 3 overriding the proxy default, got %d", d.workers)
+	}
+	if _, err := NewDownloader(Options{Concurrency: "fast"}); err == nil {
+		t.Error("Expected an unsupported concurrency to be rejected")
+	}
+}
@@ -0,0 +1,45 @@
+package downloader
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	safarihttp "github.com/dacsang97/safaribooks/internal/http"
+)
+
+func TestRun_BanStopsDownload(t *testing.T) {
+	var pages, served atomic.Int32
+	book := &mockBook{Handle: func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path == "/content/ch01.html" {
+			served.Add(1)
+			return false
+		}
+		if r.URL.Path == "/content/ch02.html" || r.URL.Path == "/content/ch03.html" || r.URL.Path == "/content/ch04.html" {
+			pages.Add(1)
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`<html><head><title>Access Denied</title></head><body>You don't have permission to access this page.</body></html>`))
+			return true
+		}
+		return false
+	}}
+	for _, name := range []string{"ch01.html", "ch02.html", "ch03.html", "ch04.html"} {
+		book.Chapters = append(book.Chapters, mockChapter{Title: name, Filename: name, Body: "<p>Text</p>"})
+	}
+
+	d := newMockDownloader(t, book, Options{Concurrency: "1"})
+	err := d.Run()
+	var banned *safarihttp.BannedError
+	if !errors.As(err, &banned) {
+		t.Fatalf("Expected Run to fail with the ban, got %v", err)
+	}
+	if got := pages.Load(); got != 1 {
+		t.Errorf("Expected chapters after the ban to be left alone, got %d banned page requests", got)
+	}
+	// Chapters run in no fixed order, but any finished before the ban stay done
+	if got := len(d.state.Chapters); got != int(served.Load()) {
+		t.Errorf("Expected the %d chapters finished before the ban recorded for --resume, got %d", served.Load(), got)
+	}
+}
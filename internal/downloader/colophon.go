@@ -0,0 +1,48 @@
+package downloader
+
+import (
+	"fmt"
+	stdhtml "html"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dacsang97/safaribooks/internal/models"
+)
+
+// colophonName is the page written after the last chapter with Colophon,
+// named apart from the colophon chapter many books already have
+const colophonName = "safaribooks-colophon.xhtml"
+
+// writeColophon writes the colophon page for the book, downloaded at the
+// given time
+func (d *Downloader) writeColophon(bookInfo models.BookInfo, oebpsPath string, downloaded time.Time) error {
+	page := colophonXHTML(bookInfo, d.doctype(), d.appVersion, downloaded)
+	if err := d.writeFile(filepath.Join(oebpsPath, colophonName), []byte(page)); err != nil {
+		return fmt.Errorf("write %s: %w", colophonName, err)
+	}
+	return nil
+}
+
+// colophonXHTML builds the colophon: where the book was downloaded from,
+// when, and by which version of the tool
+func colophonXHTML(bookInfo models.BookInfo, doctype, version string, downloaded time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<?xml version="1.0" encoding="utf-8"?>
+%s
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head>
+<title>Colophon</title>
+</head>
+<body>
+<h1>Colophon</h1>
+<p><cite>%s</cite> was downloaded on %s with safaribooks %s.</p>
+`, doctype, stdhtml.EscapeString(firstNonEmpty(bookInfo.Title, bookInfo.Identifier)),
+		downloaded.UTC().Format(time.DateOnly), stdhtml.EscapeString(firstNonEmpty(version, "dev")))
+	if bookInfo.WebURL != "" {
+		url := stdhtml.EscapeString(bookInfo.WebURL)
+		fmt.Fprintf(&b, "<p>Original: <a href=\"%s\">%s</a></p>\n", url, url)
+	}
+	b.WriteString("</body>\n</html>\n")
+	return b.String()
+}
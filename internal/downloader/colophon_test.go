@@ -0,0 +1,66 @@
+package downloader
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dacsang97/safaribooks/internal/models"
+)
+
+func TestWriteColophon(t *testing.T) {
+	d := &Downloader{bookID: "123", colophon: true, appVersion: "1.4.0 <beta>"}
+	info := models.BookInfo{
+		Title:  "Tips & Tricks",
+		WebURL: "https://learning.oreilly.com/library/view/tips-tricks/123/?a=1&b=2",
+	}
+	oebpsPath := t.TempDir()
+	if err := d.writeColophon(info, oebpsPath, time.Date(2024, 3, 5, 12, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("writeColophon failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(oebpsPath, colophonName))
+	if err != nil {
+		t.Fatalf("Failed to read colophon: %v", err)
+	}
+	page := string(data)
+
+	for _, want := range []string{
+		`<a href="https://learning.oreilly.com/library/view/tips-tricks/123/?a=1&amp;b=2">`,
+		"safaribooks 1.4.0 &lt;beta&gt;",
+		"<cite>Tips &amp; Tricks</cite>",
+		"2024-03-05",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("Expected colophon to contain %q, got:\n%s", want, page)
+		}
+	}
+	dec := xml.NewDecoder(strings.NewReader(page))
+	for {
+		if _, err := dec.Token(); err != nil {
+			if !errors.Is(err, io.EOF) {
+				t.Errorf("Expected well-formed XHTML, got %v", err)
+			}
+			break
+		}
+	}
+
+	// The book's own colophon chapter keeps its page
+	chapters := []models.Chapter{{Title: "One", Filename: "ch01.xhtml"}, {Title: "Colophon", Filename: "colophon.xhtml"}}
+	pkg := d.buildPackage(info, chapters, oebpsPath, "")
+	refs := pkg.Spine.ItemRefs
+	if len(refs) != 3 || refs[len(refs)-1].IDRef != "colophon" {
+		t.Errorf("Expected the colophon to end the spine, got %+v", refs)
+	}
+	hrefs := make(map[string]bool)
+	for _, item := range pkg.Manifest.Items {
+		if hrefs[item.Href] {
+			t.Errorf("Expected %s in the manifest once", item.Href)
+		}
+		hrefs[item.Href] = true
+	}
+}
@@ -0,0 +1,192 @@
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/dacsang97/safaribooks/internal/html"
+	"github.com/dacsang97/safaribooks/internal/models"
+	"github.com/dacsang97/safaribooks/pkg/utils"
+	nethtml "golang.org/x/net/html"
+)
+
+// cssImportRe matches @import rules in their url(...) and quoted forms,
+// capturing the target URL; media queries after it are dropped when inlined
+var cssImportRe = regexp.MustCompile(`@import\s+(?:url\(\s*)?["']?([^"')\s;]+)["']?\s*\)?[^;]*;`)
+
+// cssURLRe matches url(...) references in CSS, capturing the target
+var cssURLRe = regexp.MustCompile(`url\(\s*["']?([^"')\s]+)["']?\s*\)`)
+
+// inlineCSSFor returns a stylesheet to inline into the chapter pages in
+// oebpsPath, with the files it refers to downloaded, see localizeCSSURLs
+func (d *Downloader) inlineCSSFor(url, oebpsPath string) (string, error) {
+	return d.cachedCSS(d.inlineCache, url, func() (string, error) {
+		css, err := d.fetchCSS(url)
+		if err != nil {
+			return "", err
+		}
+		return d.localizeCSSURLs(css, url, filepath.Join(oebpsPath, "Images")), nil
+	})
+}
+
+// localizeCSSURLs downloads the files the stylesheet at sheetURL refers to
+// with url(), such as fonts and background images, into imagesPath and
+// points the references at them from a page in OEBPS. Relative references
+// would otherwise resolve against the page, not the sheet. Data URIs and
+// imported sheets are left alone; a file that can't be downloaded, or any
+// file with NoImages, is referred to by its absolute URL.
+func (d *Downloader) localizeCSSURLs(css, sheetURL, imagesPath string) string {
+	return cssURLRe.ReplaceAllStringFunc(css, func(ref string) string {
+		target := cssURLRe.FindStringSubmatch(ref)[1]
+		if strings.HasPrefix(target, "data:") || strings.HasPrefix(target, "#") ||
+			strings.EqualFold(filepath.Ext(strings.SplitN(target, "?", 2)[0]), ".css") {
+			return ref
+		}
+		url := utils.ResolveURL(sheetURL, target)
+		name := utils.FilenameFromURL(url)
+		if d.noImages || name == "" {
+			return `url("` + url + `")`
+		}
+		saved, err := d.downloadFile(url, filepath.Join(imagesPath, d.images.name(url, name)))
+		if err != nil {
+			return `url("` + url + `")`
+		}
+		return `url("Images/` + saved + `")`
+	})
+}
+
+// chapterStylesheets lists the stylesheets the API reports for a chapter, used
+// for chapters that were not parsed in this run
+func chapterStylesheets(chapter models.Chapter) []string {
+	var urls []string
+	for _, sheet := range chapter.Stylesheets {
+		if sheet.URL != "" {
+			urls = append(urls, utils.ResolveURL(chapter.AssetBaseURL, sheet.URL))
+		}
+	}
+	for _, sheet := range chapter.SiteStyles {
+		if sheet != "" {
+			urls = append(urls, utils.ResolveURL(chapter.AssetBaseURL, sheet))
+		}
+	}
+	return urls
+}
+
+// writeMergedCSS concatenates every chapter's stylesheets, in chapter and
+// reference order so the cascade is unchanged, into OEBPS/Styles/style.css
+func (d *Downloader) writeMergedCSS(chapters []models.Chapter, oebpsPath string) error {
+	seen := make(map[string]bool)
+	var urls []string
+	for i, chapter := range chapters {
+		refs := chapterStylesheets(chapter)
+		if i < len(d.chapterCSS) && d.chapterCSS[i] != nil {
+			refs = d.chapterCSS[i]
+		}
+		for _, url := range refs {
+			if !seen[url] {
+				seen[url] = true
+				urls = append(urls, url)
+			}
+		}
+	}
+
+	css := mergeStylesheets(urls, d.fetchCSS, func(url string, err error) {
+		d.log.Printf("[-] Leaving %s out of the merged stylesheet: %v\n", url, err)
+	})
+	if err := d.writeFile(filepath.Join(oebpsPath, filepath.FromSlash(html.MergedCSSHref)), []byte(css)); err != nil {
+		return fmt.Errorf("write merged stylesheet: %w", err)
+	}
+	d.log.Printf("[*] Merged %d stylesheets into %s\n", len(urls), html.MergedCSSHref)
+	return nil
+}
+
+// mergeStylesheets fetches each stylesheet and joins them in order, inlining
+// @import rules. Sheets that fail to download are reported to onError and
+// left out.
+func mergeStylesheets(urls []string, fetch func(url string) (string, error), onError func(url string, err error)) string {
+	var b strings.Builder
+	imported := make(map[string]bool)
+	for _, url := range urls {
+		if imported[url] {
+			continue
+		}
+		css, err := fetch(url)
+		if err != nil {
+			onError(url, err)
+			continue
+		}
+		imported[url] = true
+		fmt.Fprintf(&b, "/* %s */\n%s\n", url, inlineImports(css, url, fetch, onError, imported))
+	}
+	return b.String()
+}
+
+// inlineImports replaces @import rules in css with the imported sheets,
+// resolved against baseURL. Each sheet is included at most once, which also
+// breaks import cycles.
+func inlineImports(css, baseURL string, fetch func(url string) (string, error), onError func(url string, err error), imported map[string]bool) string {
+	return cssImportRe.ReplaceAllStringFunc(css, func(rule string) string {
+		url := utils.ResolveURL(baseURL, cssImportRe.FindStringSubmatch(rule)[1])
+		if imported[url] {
+			return ""
+		}
+		imported[url] = true
+		body, err := fetch(url)
+		if err != nil {
+			onError(url, err)
+			return ""
+		}
+		return inlineImports(body, url, fetch, onError, imported)
+	})
+}
+
+// pruneStylesheets drops the rules of the stylesheets under Styles/ that
+// match nothing in the book's pages. The pruned sheets go into the archive
+// only, see writeArchive; the downloaded ones are kept for later runs, whose
+// chapters may use more of them. It gives up, leaving every sheet as it is,
+// when a page cannot be read, since a rule could match only there.
+func (d *Downloader) pruneStylesheets(oebpsPath string) {
+	files, err := chapterPages(oebpsPath)
+	if err != nil {
+		d.log.Printf("[-] Not pruning CSS, the pages could not be listed: %v\n", err)
+		return
+	}
+	pages := make([]*nethtml.Node, 0, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err == nil {
+			var page *nethtml.Node
+			if page, err = nethtml.Parse(bytes.NewReader(data)); err == nil {
+				pages = append(pages, page)
+				continue
+			}
+		}
+		d.log.Printf("[-] Not pruning CSS, %s could not be read: %v\n", filepath.Base(file), err)
+		return
+	}
+
+	sheets, _ := filepath.Glob(filepath.Join(oebpsPath, "Styles", "*.css"))
+	rules, saved := 0, 0
+	for _, sheet := range sheets {
+		data, err := os.ReadFile(sheet)
+		if err != nil {
+			d.log.Printf("[-] Failed to read %s for pruning: %v\n", filepath.Base(sheet), err)
+			continue
+		}
+		pruned, dropped := html.PruneCSS(string(data), pages)
+		if dropped == 0 {
+			continue
+		}
+		if d.prunedCSS == nil {
+			d.prunedCSS = make(map[string][]byte)
+		}
+		d.prunedCSS["OEBPS/Styles/"+filepath.Base(sheet)] = []byte(pruned)
+		rules += dropped
+		saved += len(data) - len(pruned)
+	}
+	d.log.Printf("[*] Pruned %d unused CSS rules from %d stylesheets, saving %d KB\n", rules, len(sheets), saved>>10)
+}
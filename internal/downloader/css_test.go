@@ -0,0 +1,150 @@
+package downloader
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dacsang97/safaribooks/internal/models"
+)
+
+func TestMergeStylesheets_InlinesImportsInOrder(t *testing.T) {
+	sheets := map[string]string{
+		"https://cdn.example.com/css/base.css":  `@import url("fonts.css") screen; body { color: black; }`,
+		"https://cdn.example.com/css/fonts.css": `@import "base.css"; @font-face { font-family: X; }`,
+		"https://cdn.example.com/css/book.css":  `p { margin: 0; }`,
+	}
+	fetch := func(url string) (string, error) {
+		if css, ok := sheets[url]; ok {
+			return css, nil
+		}
+		return "", errors.New("status 404")
+	}
+	var failed []string
+	onError := func(url string, err error) { failed = append(failed, url) }
+
+	css := mergeStylesheets([]string{
+		"https://cdn.example.com/css/base.css",
+		"https://cdn.example.com/css/missing.css",
+		"https://cdn.example.com/css/book.css",
+		"https://cdn.example.com/css/fonts.css",
+	}, fetch, onError)
+
+	if strings.Contains(css, "@import") {
+		t.Errorf("Expected @import rules to be inlined, got:\n%s", css)
+	}
+	font := strings.Index(css, "@font-face")
+	body := strings.Index(css, "body { color: black; }")
+	p := strings.Index(css, "p { margin: 0; }")
+	if font < 0 || body < 0 || p < 0 || !(font < body && body < p) {
+		t.Errorf("Expected imported fonts, then base, then book rules, got:\n%s", css)
+	}
+	if strings.Count(css, "@font-face") != 1 || strings.Count(css, "body {") != 1 {
+		t.Errorf("Expected every sheet included once, got:\n%s", css)
+	}
+	if len(failed) != 1 || failed[0] != "https://cdn.example.com/css/missing.css" {
+		t.Errorf("Expected only missing.css to fail, got %v", failed)
+	}
+}
+
+func TestMergeCSS_SingleFileAndLinks(t *testing.T) {
+	var base string
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/css/a.css":
+			w.Write([]byte("h1 { color: red; }"))
+		case "/css/b.css":
+			w.Write([]byte("h1 { color: blue; }"))
+		default:
+			w.Write([]byte(`<html><head><link rel="stylesheet" href="` + base + `/css/b.css"/></head><body><div id="sbo-rt-content"><h1>Hi</h1></div></body></html>`))
+		}
+	}, Options{MergeCSS: true})
+	base = server.URL
+
+	bookPath := t.TempDir()
+	oebpsPath := filepath.Join(bookPath, "OEBPS")
+	for _, dir := range []string{"Styles", "Images"} {
+		if err := os.MkdirAll(filepath.Join(oebpsPath, dir), 0755); err != nil {
+			t.Fatalf("Failed to create dirs: %v", err)
+		}
+	}
+	d.state = newBookState(bookPath)
+
+	chapters := []models.Chapter{
+		{Title: "One", Filename: "ch01.html", Content: server.URL + "/ch01.html", AssetBaseURL: server.URL + "/", Stylesheets: []models.ChapterStylesheet{{URL: "css/a.css"}}},
+		{Title: "Two", Filename: "ch02.html", Content: server.URL + "/ch02.html", AssetBaseURL: server.URL + "/", Stylesheets: []models.ChapterStylesheet{{URL: "css/a.css"}}},
+	}
+	if _, err := d.downloadChapters(bookPath, chapters); err != nil {
+		t.Fatalf("downloadChapters failed: %v", err)
+	}
+	if err := d.writeMergedCSS(chapters, oebpsPath); err != nil {
+		t.Fatalf("writeMergedCSS failed: %v", err)
+	}
+
+	styles, err := os.ReadDir(filepath.Join(oebpsPath, "Styles"))
+	if err != nil || len(styles) != 1 || styles[0].Name() != "style.css" {
+		t.Fatalf("Expected only style.css in Styles, got %v (%v)", styles, err)
+	}
+	merged, err := os.ReadFile(filepath.Join(oebpsPath, "Styles", "style.css"))
+	if err != nil {
+		t.Fatalf("Failed to read merged CSS: %v", err)
+	}
+	red, blue := strings.Index(string(merged), "red"), strings.Index(string(merged), "blue")
+	if red < 0 || blue < 0 || red > blue {
+		t.Errorf("Expected a.css before b.css in the merged sheet, got:\n%s", merged)
+	}
+
+	for _, ch := range chapters {
+		page, err := os.ReadFile(filepath.Join(oebpsPath, ch.Filename))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", ch.Filename, err)
+		}
+		if strings.Count(string(page), `rel="stylesheet"`) != 1 || !strings.Contains(string(page), `href="Styles/style.css"`) {
+			t.Errorf("Expected %s to link only Styles/style.css, got:\n%s", ch.Filename, page)
+		}
+	}
+
+	var cssItems []string
+	for _, item := range d.buildPackage(testBookInfo(), chapters, oebpsPath, "").Manifest.Items {
+		if item.MediaType == "text/css" {
+			cssItems = append(cssItems, item.Href)
+		}
+	}
+	if len(cssItems) != 1 || cssItems[0] != "Styles/style.css" {
+		t.Errorf("Expected only Styles/style.css in the manifest, got %v", cssItems)
+	}
+}
+
+func TestPruneStylesheets_KeepsDownloadedSheets(t *testing.T) {
+	oebpsPath := t.TempDir()
+	for _, dir := range []string{"Styles", "part1"} {
+		if err := os.MkdirAll(filepath.Join(oebpsPath, dir), 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	css := ".intro { color: red; }\n.nested { color: blue; }\n.unused { color: green; }\n"
+	files := map[string]string{
+		"Styles/book.css":  css,
+		"ch01.xhtml":       `<html><body><p class="intro">One</p></body></html>`,
+		"part1/ch02.xhtml": `<html><body><p class="nested">Two</p></body></html>`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(oebpsPath, filepath.FromSlash(name)), []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	d := &Downloader{}
+	d.pruneStylesheets(oebpsPath)
+
+	pruned := string(d.prunedCSS["OEBPS/Styles/book.css"])
+	if !strings.Contains(pruned, ".intro") || !strings.Contains(pruned, ".nested") || strings.Contains(pruned, ".unused") {
+		t.Errorf("Expected only .unused dropped, got:\n%s", pruned)
+	}
+	if got, err := os.ReadFile(filepath.Join(oebpsPath, "Styles", "book.css")); err != nil || string(got) != css {
+		t.Errorf("Expected the downloaded sheet left as it is, got %q (%v)", got, err)
+	}
+}
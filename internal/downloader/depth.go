@@ -0,0 +1,126 @@
+package downloader
+
+import (
+	"path"
+	"strings"
+
+	"github.com/dacsang97/safaribooks/internal/html"
+	"github.com/dacsang97/safaribooks/internal/models"
+)
+
+// What --max-chapter-depth does with the chapters it leaves out, accepted by
+// Options.DeepChapters
+const (
+	deepChaptersSkip  = "skip"  // drop them from the book
+	deepChaptersMerge = "merge" // merge them into the page of the chapter they belong to
+)
+
+// chapterDepth returns the depth the API reports for chapter
+func chapterDepth(chapter models.Chapter) (int, bool) {
+	depth, err := chapter.Depth.Int64()
+	if err != nil {
+		return 0, false
+	}
+	return int(depth), true
+}
+
+// limitChapterDepth applies maxChapterDepth to the chapter list, logging
+// what it left out
+func (d *Downloader) limitChapterDepth(chapters []models.Chapter) []models.Chapter {
+	kept, merges, skipped := limitChapterDepth(chapters, d.maxChapterDepth, d.deepChapters == deepChaptersMerge)
+	d.depthMerges = merges
+	merged := 0
+	for _, children := range merges {
+		merged += len(children)
+	}
+	if merged > 0 {
+		d.log.Printf("[*] Merging %d chapters deeper than %d into their parent chapter\n", merged, d.maxChapterDepth)
+	}
+	if skipped > 0 {
+		d.log.Printf("[*] Skipping %d chapters deeper than %d\n", skipped, d.maxChapterDepth)
+	}
+	return kept
+}
+
+// limitChapterDepth returns the chapters at most maxDepth levels deep, the
+// shallowest depth in the list counting as level 1. With merge, each deeper
+// chapter is listed under the .xhtml file of the closest kept chapter before
+// it, for planDepthMerges to merge into that chapter's page; otherwise, or
+// when no chapter comes before it, it is skipped. Chapters without a depth
+// are kept.
+func limitChapterDepth(chapters []models.Chapter, maxDepth int, merge bool) (kept []models.Chapter, merges map[string][]models.Chapter, skipped int) {
+	top, found := 0, false
+	for _, chapter := range chapters {
+		if depth, ok := chapterDepth(chapter); ok && (!found || depth < top) {
+			top, found = depth, true
+		}
+	}
+
+	kept = make([]models.Chapter, 0, len(chapters))
+	merges = make(map[string][]models.Chapter)
+	for _, chapter := range chapters {
+		depth, ok := chapterDepth(chapter)
+		switch {
+		case !ok || depth-top+1 <= maxDepth:
+			kept = append(kept, chapter)
+		case merge && len(kept) > 0:
+			parent := xhtmlName(kept[len(kept)-1].Filename)
+			merges[parent] = append(merges[parent], chapter)
+		default:
+			skipped++
+		}
+	}
+	return kept, merges, skipped
+}
+
+// planDepthMerges adds the chapters limitChapterDepth merged to the nested
+// merges of their parent, keyed by its filename as planNestedMerges does, and
+// maps each merged .xhtml file to the parent's. Like planNestedMerges, it
+// leaves out chapters without content of their own and files already in the
+// book or merged. Either map may be nil.
+func planDepthMerges(chapters []models.Chapter, depthMerges map[string][]models.Chapter, merges map[string][]models.Chapter, files map[string]string) (map[string][]models.Chapter, map[string]string) {
+	if merges == nil {
+		merges = make(map[string][]models.Chapter)
+	}
+	if files == nil {
+		files = make(map[string]string)
+	}
+	seen := make(map[string]bool, len(chapters))
+	for _, chapter := range chapters {
+		seen[path.Base(xhtmlName(chapter.Filename))] = true
+	}
+	for _, chapter := range chapters {
+		parent := xhtmlName(chapter.Filename)
+		for _, child := range depthMerges[parent] {
+			name := path.Base(xhtmlName(child.Filename))
+			if child.Content == "" || child.Filename == "" || seen[name] || files[name] != "" {
+				continue
+			}
+			seen[name] = true
+			merges[chapter.Filename] = append(merges[chapter.Filename], child)
+			files[name] = parent
+		}
+	}
+	return merges, files
+}
+
+// depthLinks returns the TOC links of the chapters limitChapterDepth merged
+// into parent, pointing at their place in the page they were merged into
+func (d *Downloader) depthLinks(parent string, children []models.Chapter, merged map[string]string) []navLink {
+	links := make([]navLink, 0, len(children))
+	for _, child := range children {
+		file := xhtmlName(child.Filename)
+		fragment := strings.TrimPrefix(child.Fragment, "#")
+		page := parent
+		if into, ok := merged[path.Base(file)]; ok {
+			page = into
+			fragment = firstNonEmpty(fragment, html.SectionID(file))
+		}
+		href := page
+		if fragment != "" {
+			href = html.FragmentHref(page, fragment, d.flattenAnchors)
+		}
+		links = append(links, navLink{Href: href, Label: child.Title})
+	}
+	return links
+}
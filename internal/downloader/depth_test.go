@@ -0,0 +1,116 @@
+package downloader
+
+import (
+	"testing"
+
+	"github.com/dacsang97/safaribooks/internal/models"
+)
+
+func testDeepChapters() []models.Chapter {
+	return []models.Chapter{
+		{Title: "Cover", Filename: "cover.html"},
+		{Title: "Chapter 1", Filename: "ch01.html", Content: "/ch01.html", Depth: "1"},
+		{Title: "1.1", Filename: "ch01s01.html", Content: "/ch01s01.html", Depth: "2"},
+		{Title: "1.1.1", Filename: "ch01s01a.html", Content: "/ch01s01a.html", Depth: "3"},
+		{Title: "1.1.1.1", Filename: "ch01s01b.html", Content: "/ch01s01b.html", Depth: "4"},
+		{Title: "Chapter 2", Filename: "ch02.html", Content: "/ch02.html", Depth: "1"},
+		{Title: "2.1", Filename: "ch02s01.html", Content: "/ch02s01.html", Depth: "2"},
+	}
+}
+
+func TestLimitChapterDepth(t *testing.T) {
+	tests := []struct {
+		maxDepth        int
+		merge           bool
+		want            string
+		merged, skipped int
+	}{
+		{maxDepth: 1, want: "cover.html ch01.html ch02.html", skipped: 4},
+		{maxDepth: 2, want: "cover.html ch01.html ch01s01.html ch02.html ch02s01.html", skipped: 2},
+		{maxDepth: 4, want: "cover.html ch01.html ch01s01.html ch01s01a.html ch01s01b.html ch02.html ch02s01.html"},
+		{maxDepth: 1, merge: true, want: "cover.html ch01.html ch02.html", merged: 4},
+	}
+	for _, tt := range tests {
+		kept, merges, skipped := limitChapterDepth(testDeepChapters(), tt.maxDepth, tt.merge)
+		merged := 0
+		for _, children := range merges {
+			merged += len(children)
+		}
+		if got := chapterFilenames(kept); got != tt.want {
+			t.Errorf("depth %d, merge %v: expected %q, got %q", tt.maxDepth, tt.merge, tt.want, got)
+		}
+		if merged != tt.merged || skipped != tt.skipped {
+			t.Errorf("depth %d, merge %v: expected %d merged and %d skipped, got %d and %d",
+				tt.maxDepth, tt.merge, tt.merged, tt.skipped, merged, skipped)
+		}
+	}
+}
+
+func TestLimitChapterDepth_MergeIntoParent(t *testing.T) {
+	kept, depthMerges, _ := limitChapterDepth(testDeepChapters(), 1, true)
+	if got := chapterFilenames(depthMerges["ch01.xhtml"]); got != "ch01s01.html ch01s01a.html ch01s01b.html" {
+		t.Errorf("Expected the sections of chapter 1 merged into it, got %q", got)
+	}
+	if len(kept[1].Children) != 0 {
+		t.Errorf("Expected the merged chapters not to be nested under chapter 1, got %+v", kept[1].Children)
+	}
+
+	// The merged chapters go into their parent's page, and the TOC links there
+	merges, files := planDepthMerges(kept, depthMerges, nil, nil)
+	if got := chapterFilenames(merges["ch02.html"]); got != "ch02s01.html" {
+		t.Errorf("Expected ch02s01.html merged into ch02.html, got %q", got)
+	}
+	if files["ch01s01b.xhtml"] != "ch01.xhtml" {
+		t.Errorf("Expected ch01s01b.xhtml merged into ch01.xhtml, got %q", files["ch01s01b.xhtml"])
+	}
+	d := &Downloader{depthMerges: depthMerges}
+	links := d.tocLinks(kept)
+	if len(links) != 3 || len(links[1].Children) != 3 || links[1].Children[0].Href != "ch01.xhtml#ch01s01" {
+		t.Errorf("Expected chapter 1's sections listed below it in the TOC, got %+v", links)
+	}
+}
+
+func TestPlanDepthMerges_LeavesNestedChaptersAlone(t *testing.T) {
+	chapters := testDeepChapters()[:2]
+	chapters[1].Children = []models.Chapter{{Title: "Aside", Filename: "aside.html", Content: "/aside.html"}}
+	kept, depthMerges, _ := limitChapterDepth(append(chapters, models.Chapter{
+		Title: "Deep", Filename: "deep.html", Content: "/deep.html", Depth: "2",
+	}), 1, true)
+
+	// Without --flatten-nested-chapters, only the deep chapter is merged
+	merges, files := planDepthMerges(kept, depthMerges, nil, nil)
+	if got := chapterFilenames(merges["ch01.html"]); got != "deep.html" {
+		t.Errorf("Expected only deep.html merged into ch01.html, got %q", got)
+	}
+	if _, ok := files["aside.xhtml"]; ok {
+		t.Error("Expected the nested aside.xhtml to keep its own page")
+	}
+	d := &Downloader{depthMerges: depthMerges}
+	if links := d.tocLinks(kept); len(links[1].Children) != 1 || links[1].Children[0].Href != "ch01.xhtml#deep" {
+		t.Errorf("Expected only the merged chapter below chapter 1 in the TOC, got %+v", links[1].Children)
+	}
+}
+
+func TestLimitChapterDepth_RelativeToShallowest(t *testing.T) {
+	chapters := []models.Chapter{
+		{Filename: "ch01.html", Depth: "0"},
+		{Filename: "ch01s01.html", Depth: "1"},
+		{Filename: "notes.html"},
+	}
+	kept, _, skipped := limitChapterDepth(chapters, 1, false)
+	if got := chapterFilenames(kept); got != "ch01.html notes.html" || skipped != 1 {
+		t.Errorf("Expected depth 0 as the top level and chapters without a depth kept, got %q", got)
+	}
+}
+
+func TestNewDownloader_MaxChapterDepth(t *testing.T) {
+	for _, opts := range []Options{
+		{MaxChapterDepth: -1},
+		{MaxChapterDepth: 2, DeepChapters: "drop"},
+		{MaxChapterDepth: 2, StreamChapterList: true},
+	} {
+		if _, err := NewDownloader(opts); err == nil {
+			t.Errorf("Expected %+v to be rejected", opts)
+		}
+	}
+}
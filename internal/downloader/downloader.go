@@ -1,12 +1,24 @@
 package downloader
 
 import (
+	"bytes"
+	"cmp"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/dacsang97/safaribooks/internal/epub"
 	"github.com/dacsang97/safaribooks/internal/html"
 	safarihttp "github.com/dacsang97/safaribooks/internal/http"
 	"github.com/dacsang97/safaribooks/internal/models"
@@ -14,81 +26,703 @@ import (
 )
 
 const (
-	defaultCookiesFile = "cookies.json"
-	defaultBooksDir    = "Books"
-	maxWorkers         = 5 // Simple concurrency limit
+	defaultCookiesFile       = "cookies.json"
+	defaultBooksDir          = "Books"
+	maxWorkers               = 5 // Simple concurrency limit
+	defaultProxyWorkers      = 2 // gentler limit for fragile library proxies
+	defaultCoverScanChapters = 5
+	defaultLanguage          = "en"
+	defaultJPEGQuality       = 85
+	defaultMaxChapterSize    = 50 << 20
+	rawChaptersDir           = "_raw" // under OEBPS, left out of the EPUB
+	htmlDoctype              = "<!DOCTYPE html>"
+	epubMimetype             = "application/epub+zip"
+	defaultCoverSize         = "600w"
+	coverSizeOriginal        = "original"
+	coverPageName            = "cover.xhtml" // generated when the book has no cover chapter
+	coverThumbName           = "cover-thumb.jpg"
+	coverThumbWidth          = 200
 )
 
+// Options configures a Downloader
+type Options struct {
+	BookID            string
+	CookiesPath       string
+	BooksDir          string
+	KindleMode        bool
+	SiteURL           string
+	PreferSVGCover    bool // try vector covers before resized raster variants
+	Resume            bool // skip chapters and assets recorded in the book's state file
+	CoverScanChapters int  // leading chapters searched for a cover when the API has none
+	PrettyXML         bool // indent content.opf and toc.ncx
+	DetectChapterLang bool // tag chapters written in another language than the book
+	LangThreshold     float64
+	SubjectsAsTags    bool // split compound subjects like "A / B" into separate dc:subject tags
+	LogFile           string
+	LogFormat         string    // "text" or "json" (one JSON record per line), text when empty
+	LogOutput         io.Writer // progress output, stdout when nil
+	CookieHeader      string    // raw "name=value; ..." Cookie header used instead of CookiesPath
+	ImageFormat       string    // "jpeg" or "png" to transcode WebP images, empty keeps originals
+	JPEGQuality       int
+	ReadingDirection  string // "ltr", "rtl", or "auto"/empty to follow the book language
+	DumpRaw           bool   // keep each chapter's unparsed HTML for parser debugging
+	CoverSize         string // cover size tried first, e.g. "1200w" or "original"
+	ImageSize         string // size for chapter image URLs with a size token, empty or "original" keeps them
+	StripComments     bool   // drop HTML comments from chapter output
+	NoImages          bool   // skip chapter images and replace them with their alt text
+	NoCover           bool   // skip the cover image and cover page
+	OnComplete        string // command run after the EPUB is written, see runOnComplete
+	HookStrict        bool   // fail the download when the OnComplete command fails
+	EPUB2Compat       bool   // strict EPUB 2.0.1 output for readers that reject EPUB 3 markup
+	FlattenAnchors    bool   // prefix element IDs per chapter so they are unique book-wide
+	NormalizeHeadings bool   // renumber chapter headings to start at <h1> without skipped levels
+	TitleFromHeading  bool   // label chapters with a generic title by their first <h1> or <h2>
+	Proxy             string // proxy URL, may include user:pass@
+	ProxyAuth         string // "user:pass" for the proxy, overriding credentials in Proxy
+	InlineCSS         bool   // embed stylesheets in each chapter instead of linking them
+	MaxChapterSize    int64  // largest chapter body in bytes, defaultMaxChapterSize when zero
+	SkipOversized     bool   // replace oversized chapters with a note instead of truncating them
+	GenerateCover     bool   // render a title/author cover when none is found
+	CoverFont         string // TTF/OTF font for generated covers, bundled Go fonts when empty
+	IfModified        bool   // skip books whose issued date matches the last finished download
+	Force             bool   // rebuild even when IfModified finds the book unchanged; rotate LogFile
+	CoverThumbnail    bool   // add a small JPEG of the cover for library views
+	IncludeFiles      bool   // save the book's code archives and other extras under Files/
+	MergeCSS          bool   // combine all stylesheets into a single Styles/style.css
+	StrictXHTML       string // "warn" or "fail" on chapters that are not well-formed XML, empty skips the check
+	NoPageList        bool   // leave print page markers out of the navigation
+	StreamEPUB        bool   // write chapters straight into the EPUB instead of staging them on disk
+	DirPermissions    string // octal mode for created directories, e.g. "0775"; 0755 when empty
+	FilePermissions   string // octal mode for written files, e.g. "0664"; 0644 when empty
+	FlattenNested     bool   // merge nested chapters into their parent's page, linked by anchors in the TOC
+	ProxyWorkers      int    // concurrent chapter downloads through a library proxy site, defaultProxyWorkers when zero
+	Concurrency       string // concurrent chapter downloads on any site, or "auto" to adapt to rate limiting; overrides ProxyWorkers
+	StreamChapterList bool   // start downloading chapters as each page of the chapter list arrives
+	ValidateLinks     string // "warn" or "fail" on internal links to missing files or anchors, empty skips the check
+	PruneCSS          bool   // drop stylesheet rules that match nothing in the book
+	SortChapters      string // spine order: "api" (default), "toc", "filename", or "natural"
+	CoverPageStyle    string // generated cover page: "svg-viewport" (default), "fit", or "fill"
+	MaxChapterDepth   int    // leave out chapters nested deeper than this, 0 for no limit
+	DeepChapters      string // what to do with them: "skip" (default) or "merge" into their parent
+	EmptyChapters     string // chapters served empty: "skip" (default), or "retry" before skipping
+	ChaptersFile      string // file listing the chapters to include, by ID or filename, in spine order
+	FlatOutput        bool   // write the EPUB straight into BooksDir, building it in a temporary directory
+	KeepHTTP          bool   // fetch http:// asset URLs as given instead of upgrading O'Reilly hosts to https
+	ForceHTTPS        bool   // upgrade http:// URLs on every host to https
+	OPDSEntry         bool   // write an OPDS catalog entry next to each EPUB
+	Colophon          bool   // end the book with a page linking to its web page, with the download date and AppVersion
+	AppVersion        string // version of the tool, shown on the colophon
+	ManifestIDScheme  string // content.opf item IDs: "sequential" (default), "filename", or "hash"
+	// Transforms run on every chapter after the built-in parser passes
+	Transforms []html.Transform
+	// RetryBudget caps the time spent on HTTP retries and their backoff for
+	// the book, unlimited when zero
+	RetryBudget time.Duration
+	// Retries and AssetRetries are the retries of failed API requests and
+	// asset downloads, see safarihttp.ClientOptions.Retries
+	Retries      int
+	AssetRetries int
+	// Jitter is the upper bound of a random delay before each HTTP request,
+	// spreading out the requests of workers started together; none when zero
+	Jitter time.Duration
+	// ConnectTimeout bounds establishing each HTTP connection, the client's
+	// default when zero
+	ConnectTimeout time.Duration
+	// AcceptLanguage overrides the HTTP client's default Accept-Language header
+	AcceptLanguage string
+	// RequiredCookies are the cookies the cookie export must hold, checked
+	// before any request; see safarihttp.ClientOptions.RequiredCookies
+	RequiredCookies []string
+	// NonlinearChapters are glob patterns, matched case-insensitively against
+	// chapter titles and filenames, for chapters kept out of the reading flow
+	NonlinearChapters []string
+	// TitleOverride and AuthorOverrides, when set, replace the title and the
+	// authors the API gives for the book, everywhere they are used
+	TitleOverride   string
+	AuthorOverrides []string
+	// MetadataFile is a JSON file of metadata elements merged into
+	// content.opf, replacing the API's where they collide
+	MetadataFile string
+	// BookCache, when shared by the books of a batch, saves refetching the
+	// info and chapters of a book listed more than once
+	BookCache *safarihttp.BookCache
+}
+
 type Downloader struct {
-	bookID      string
-	cookiesPath string
-	booksDir    string
-	kindleMode  bool
-	siteURL     string
-	client      *safarihttp.Client
+	bookID            string
+	cookiesPath       string
+	booksDir          string
+	kindleMode        bool
+	siteURL           string
+	preferSVGCover    bool
+	resume            bool
+	state             *bookState
+	coverScanChapters int
+	prettyXML         bool
+	detectChapterLang bool
+	langThreshold     float64
+	language          string
+	subjectsAsTags    bool
+	log               *logger
+	imageFormat       string
+	jpegQuality       int
+	readingDirection  string
+	dumpRaw           bool
+	coverSize         string
+	imageSize         string
+	stripComments     bool
+	noImages          bool
+	noCover           bool
+	onComplete        string
+	hookStrict        bool
+	epub2Compat       bool
+	flattenAnchors    bool
+	normalizeHeadings bool
+	titleFromHeading  bool
+	inlineCSS         bool
+	maxChapterSize    int64
+	skipOversized     bool
+	generateCover     bool
+	coverFont         string
+	ifModified        bool
+	force             bool
+	coverThumbnail    bool
+	includeFiles      bool
+	mergeCSS          bool
+	strictXHTML       string
+	noPageList        bool
+	streamEPUB        bool
+	nonlinearChapters []string
+	titleOverride     string
+	authorOverrides   []string
+	extraMetadata     []epub.Element // from MetadataFile
+	dirPerm           os.FileMode
+	filePerm          os.FileMode
+	flattenNested     bool
+	workers           int
+	adaptive          *adaptiveLimit // replaces the fixed workers limit with --concurrency auto
+	streamChapterList bool
+	validateLinksMode string
+	pruneCSS          bool
+	prunedCSS         map[string][]byte // archive path -> stylesheet with unused rules dropped
+	sortChapters      string
+	coverPageStyle    string
+	maxChapterDepth   int
+	deepChapters      string
+	emptyChapters     string
+	emptyRetryWait    time.Duration
+	chapterList       []string // from ChaptersFile
+	flatOutput        bool
+	opdsEntry         bool
+	colophon          bool
+	appVersion        string
+	manifestIDScheme  string
+	stream            *chapterStream // open EPUB archive taking chapters while streamEPUB runs
+	chapterCSS        [][]string     // stylesheet URLs per chapter index, collected for mergeCSS
+	transforms        []html.Transform
+	result            BookResult
+	cssMu             sync.Mutex
+	cssCache          map[string]*cssEntry // fetched stylesheets by URL
+	inlineCache       map[string]*cssEntry // stylesheets ready to inline, see inlineCSSFor
+	assets            *assetIndex
+	images            imageNames                  // saved file of each image URL, unique across chapters
+	orphanImages      map[string]bool             // files in Images/ left out of the book, see findOrphanImages
+	signed            signedURLs                  // signed URL of each asset the CDN refused
+	nestedMerges      map[string][]models.Chapter // nested chapters merged into each parent, by its filename
+	depthMerges       map[string][]models.Chapter // chapters --deep-chapters merges into each parent, by its .xhtml file
+	client            *safarihttp.Client
 }
 
-func NewDownloader(bookID, cookiesPath, booksDir string, kindleMode bool, siteURL string) (*Downloader, error) {
-	if cookiesPath == "" {
-		cookiesPath = defaultCookiesFile
+func NewDownloader(opts Options) (*Downloader, error) {
+	opts.BookID = safarihttp.NormalizeBookID(opts.BookID)
+	if opts.CookiesPath == "" {
+		opts.CookiesPath = defaultCookiesFile
+	}
+	if opts.BooksDir == "" {
+		opts.BooksDir = defaultBooksDir
+	}
+	if opts.CoverScanChapters <= 0 {
+		opts.CoverScanChapters = defaultCoverScanChapters
+	}
+	if opts.MaxChapterSize <= 0 {
+		opts.MaxChapterSize = defaultMaxChapterSize
+	}
+	if opts.JPEGQuality <= 0 || opts.JPEGQuality > 100 {
+		opts.JPEGQuality = defaultJPEGQuality
+	}
+	// WebP is not an EPUB 2 core media type
+	if opts.EPUB2Compat && opts.ImageFormat == "" {
+		opts.ImageFormat = "jpeg"
+	}
+	switch opts.ImageFormat {
+	case "", "jpeg", "png":
+	case "jpg":
+		opts.ImageFormat = "jpeg"
+	default:
+		return nil, fmt.Errorf("unsupported image format %q (use jpeg or png)", opts.ImageFormat)
+	}
+	switch opts.ReadingDirection {
+	case "ltr", "rtl":
+	case "", "auto":
+		opts.ReadingDirection = ""
+	default:
+		return nil, fmt.Errorf("unsupported reading direction %q (use ltr, rtl, or auto)", opts.ReadingDirection)
+	}
+
+	switch opts.StrictXHTML {
+	case "", "warn", "fail":
+	case "off":
+		opts.StrictXHTML = ""
+	default:
+		return nil, fmt.Errorf("unsupported XHTML check %q (use warn, fail, or off)", opts.StrictXHTML)
+	}
+	switch opts.ValidateLinks {
+	case "", "warn", "fail":
+	case "off":
+		opts.ValidateLinks = ""
+	default:
+		return nil, fmt.Errorf("unsupported link check %q (use warn, fail, or off)", opts.ValidateLinks)
+	}
+	if opts.InlineCSS && opts.MergeCSS {
+		return nil, errors.New("inline CSS and merged CSS cannot be combined")
+	}
+	var nonlinear []string
+	for _, pattern := range opts.NonlinearChapters {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid nonlinear chapter pattern %q: %w", pattern, err)
+		}
+		nonlinear = append(nonlinear, strings.ToLower(pattern))
+	}
+	opts.NonlinearChapters = nonlinear
+	var authors []string
+	for _, author := range opts.AuthorOverrides {
+		if author = strings.TrimSpace(author); author != "" {
+			authors = append(authors, author)
+		}
+	}
+	if !validCoverPageStyle(opts.CoverPageStyle) {
+		return nil, fmt.Errorf("unsupported cover page style %q (use svg-viewport, fit, or fill)", opts.CoverPageStyle)
+	}
+	if opts.CoverPageStyle == "" {
+		opts.CoverPageStyle = coverStyleSVGViewport
+	}
+	if !validSortChapters(opts.SortChapters) {
+		return nil, fmt.Errorf("unsupported chapter order %q (use api, toc, filename, or natural)", opts.SortChapters)
+	}
+	if opts.SortChapters == sortAPI {
+		opts.SortChapters = ""
+	}
+	if opts.MaxChapterDepth < 0 {
+		return nil, fmt.Errorf("invalid max chapter depth %d (use 1 or more, or 0 for no limit)", opts.MaxChapterDepth)
+	}
+	switch opts.DeepChapters {
+	case "":
+		opts.DeepChapters = deepChaptersSkip
+	case deepChaptersSkip:
+	case deepChaptersMerge:
+	default:
+		return nil, fmt.Errorf("unsupported deep chapters mode %q (use skip or merge)", opts.DeepChapters)
+	}
+	if !validManifestIDScheme(opts.ManifestIDScheme) {
+		return nil, fmt.Errorf("unsupported manifest ID scheme %q (use sequential, filename, or hash)", opts.ManifestIDScheme)
+	}
+	if !validEmptyChapters(opts.EmptyChapters) {
+		return nil, fmt.Errorf("unsupported empty chapters mode %q (use skip or retry)", opts.EmptyChapters)
+	}
+	// Resuming and pruning CSS rely on the chapter files a streamed run never writes
+	if opts.StreamEPUB && opts.Resume {
+		return nil, errors.New("streamed EPUBs cannot be resumed")
+	}
+	if opts.StreamEPUB && opts.PruneCSS {
+		return nil, errors.New("CSS cannot be pruned in a streamed EPUB")
+	}
+	// These need the whole chapter list before the first chapter is written
+	if opts.StreamChapterList && opts.StreamEPUB {
+		return nil, errors.New("the chapter list cannot be streamed into a streamed EPUB")
+	}
+	if opts.StreamChapterList && opts.FlattenNested {
+		return nil, errors.New("nested chapters cannot be flattened while streaming the chapter list")
+	}
+	if opts.StreamChapterList && opts.SortChapters != "" {
+		return nil, errors.New("chapters cannot be sorted while streaming the chapter list")
+	}
+	if opts.StreamChapterList && opts.MaxChapterDepth > 0 {
+		return nil, errors.New("chapters cannot be limited by depth while streaming the chapter list")
+	}
+	if opts.KeepHTTP && opts.ForceHTTPS {
+		return nil, errors.New("http URLs cannot be both kept as given and forced to https")
+	}
+	// A flat output keeps only the EPUB; the state and extras go with the build directory
+	if opts.FlatOutput && (opts.Resume || opts.IfModified) {
+		return nil, errors.New("a flat output keeps no state to resume or compare against")
+	}
+	if opts.FlatOutput && (opts.IncludeFiles || opts.DumpRaw) {
+		return nil, errors.New("a flat output has no book directory to keep extra files in")
+	}
+	if opts.FlatOutput && strings.Contains(opts.OnComplete, "{dir}") {
+		return nil, errors.New("a flat output has no book directory for {dir} in the on-complete command")
+	}
+	var chapterList []string
+	if opts.ChaptersFile != "" {
+		if opts.StreamChapterList {
+			return nil, errors.New("a chapters file cannot be applied while streaming the chapter list")
+		}
+		if opts.SortChapters != "" {
+			return nil, errors.New("a chapters file already sets the chapter order; drop --sort-chapters")
+		}
+		list, err := readChapterList(opts.ChaptersFile)
+		if err != nil {
+			return nil, fmt.Errorf("chapters file: %w", err)
+		}
+		chapterList = list
+	}
+	var extraMetadata []epub.Element
+	if opts.MetadataFile != "" {
+		elements, err := readMetadataFile(opts.MetadataFile)
+		if err != nil {
+			return nil, fmt.Errorf("metadata file: %w", err)
+		}
+		extraMetadata = elements
+	}
+
+	if opts.CoverSize == "" {
+		opts.CoverSize = defaultCoverSize
+	}
+	if !validCoverSize(opts.CoverSize) {
+		return nil, fmt.Errorf("unsupported cover size %q (use original or a width like 1200w)", opts.CoverSize)
+	}
+	if opts.ImageSize == coverSizeOriginal {
+		opts.ImageSize = ""
+	}
+	if opts.ImageSize != "" && !validCoverSize(opts.ImageSize) {
+		return nil, fmt.Errorf("unsupported image size %q (use original or a width like 600w)", opts.ImageSize)
 	}
-	if booksDir == "" {
-		booksDir = defaultBooksDir
+	if opts.ProxyWorkers < 0 {
+		return nil, fmt.Errorf("invalid proxy workers %d (use 1 or more)", opts.ProxyWorkers)
+	}
+	if opts.Jitter < 0 {
+		return nil, fmt.Errorf("invalid jitter %v (use 0 or more)", opts.Jitter)
+	}
+	if opts.ConnectTimeout < 0 {
+		return nil, fmt.Errorf("invalid connect timeout %v (use 0 or more)", opts.ConnectTimeout)
+	}
+	if _, ok := parseConcurrency(opts.Concurrency, maxWorkers); !ok {
+		return nil, fmt.Errorf("unsupported concurrency %q (use a number of chapters or auto)", opts.Concurrency)
 	}
 
-	if err := os.MkdirAll(booksDir, 0755); err != nil {
+	dirPerm, err := parsePermissions(opts.DirPermissions, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("directory permissions: %w", err)
+	}
+	filePerm, err := parsePermissions(opts.FilePermissions, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("file permissions: %w", err)
+	}
+
+	if err := mkdirMode(opts.BooksDir, dirPerm); err != nil {
 		return nil, fmt.Errorf("create books directory: %w", err)
 	}
 
-	client, err := safarihttp.NewClient(cookiesPath, siteURL)
+	proxy, err := safarihttp.ProxyURL(opts.Proxy, opts.ProxyAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	log, err := newLogger(opts.LogOutput, opts.LogFile, opts.LogFormat, filePerm, opts.Force)
+	if err != nil {
+		return nil, err
+	}
+	log = log.With("book_id", opts.BookID)
+	if proxy != nil {
+		log.Printf("[*] Using proxy: %s\n", proxy.Redacted())
+	}
+	workers := maxWorkers
+	if safarihttp.IsProxyHost(opts.SiteURL) && opts.Concurrency == "" {
+		workers = cmp.Or(opts.ProxyWorkers, defaultProxyWorkers)
+		log.Printf("[*] Library site %s: downloading %d chapters at a time (see --proxy-workers)\n", opts.SiteURL, workers)
+	}
+	workers, _ = parseConcurrency(opts.Concurrency, workers)
+	var adaptive *adaptiveLimit
+	var observeStatus func(int)
+	if workers == 0 {
+		adaptive = newAdaptiveLimit(autoMaxWorkers, log)
+		observeStatus = adaptive.observe
+		log.Printf("[*] Adapting chapter concurrency to rate limiting, up to %d at a time\n", autoMaxWorkers)
+	}
+
+	client, err := newClient(opts, safarihttp.ClientOptions{
+		Proxy:           proxy,
+		RetryBudget:     opts.RetryBudget,
+		Retries:         opts.Retries,
+		AssetRetries:    opts.AssetRetries,
+		Jitter:          opts.Jitter,
+		ConnectTimeout:  opts.ConnectTimeout,
+		AcceptLanguage:  opts.AcceptLanguage,
+		Cache:           opts.BookCache,
+		KeepHTTP:        opts.KeepHTTP,
+		ForceHTTPS:      opts.ForceHTTPS,
+		ObserveStatus:   observeStatus,
+		RequiredCookies: opts.RequiredCookies,
+	})
 	if err != nil {
+		log.Close()
 		return nil, fmt.Errorf("create HTTP client: %w", err)
 	}
 
 	return &Downloader{
-		bookID:      bookID,
-		cookiesPath: cookiesPath,
-		booksDir:    booksDir,
-		kindleMode:  kindleMode,
-		siteURL:     siteURL,
-		client:      client,
+		bookID:            opts.BookID,
+		cookiesPath:       opts.CookiesPath,
+		booksDir:          opts.BooksDir,
+		kindleMode:        opts.KindleMode,
+		siteURL:           opts.SiteURL,
+		preferSVGCover:    opts.PreferSVGCover,
+		resume:            opts.Resume,
+		coverScanChapters: opts.CoverScanChapters,
+		prettyXML:         opts.PrettyXML,
+		detectChapterLang: opts.DetectChapterLang,
+		langThreshold:     opts.LangThreshold,
+		language:          defaultLanguage,
+		subjectsAsTags:    opts.SubjectsAsTags,
+		log:               log,
+		imageFormat:       opts.ImageFormat,
+		jpegQuality:       opts.JPEGQuality,
+		readingDirection:  opts.ReadingDirection,
+		dumpRaw:           opts.DumpRaw,
+		coverSize:         opts.CoverSize,
+		imageSize:         opts.ImageSize,
+		stripComments:     opts.StripComments,
+		noImages:          opts.NoImages,
+		noCover:           opts.NoCover,
+		onComplete:        opts.OnComplete,
+		hookStrict:        opts.HookStrict,
+		epub2Compat:       opts.EPUB2Compat,
+		flattenAnchors:    opts.FlattenAnchors,
+		normalizeHeadings: opts.NormalizeHeadings,
+		titleFromHeading:  opts.TitleFromHeading,
+		inlineCSS:         opts.InlineCSS,
+		maxChapterSize:    opts.MaxChapterSize,
+		skipOversized:     opts.SkipOversized,
+		generateCover:     opts.GenerateCover,
+		coverFont:         opts.CoverFont,
+		ifModified:        opts.IfModified,
+		force:             opts.Force,
+		coverThumbnail:    opts.CoverThumbnail,
+		includeFiles:      opts.IncludeFiles,
+		mergeCSS:          opts.MergeCSS,
+		strictXHTML:       opts.StrictXHTML,
+		noPageList:        opts.NoPageList,
+		streamEPUB:        opts.StreamEPUB,
+		nonlinearChapters: opts.NonlinearChapters,
+		titleOverride:     strings.TrimSpace(opts.TitleOverride),
+		authorOverrides:   authors,
+		extraMetadata:     extraMetadata,
+		dirPerm:           dirPerm,
+		filePerm:          filePerm,
+		flattenNested:     opts.FlattenNested,
+		workers:           workers,
+		adaptive:          adaptive,
+		streamChapterList: opts.StreamChapterList,
+		validateLinksMode: opts.ValidateLinks,
+		pruneCSS:          opts.PruneCSS,
+		sortChapters:      opts.SortChapters,
+		coverPageStyle:    opts.CoverPageStyle,
+		maxChapterDepth:   opts.MaxChapterDepth,
+		deepChapters:      opts.DeepChapters,
+		emptyChapters:     opts.EmptyChapters,
+		emptyRetryWait:    defaultEmptyRetryWait,
+		chapterList:       chapterList,
+		flatOutput:        opts.FlatOutput,
+		opdsEntry:         opts.OPDSEntry,
+		colophon:          opts.Colophon,
+		appVersion:        opts.AppVersion,
+		manifestIDScheme:  opts.ManifestIDScheme,
+		transforms:        opts.Transforms,
+		cssCache:          make(map[string]*cssEntry),
+		inlineCache:       make(map[string]*cssEntry),
+		client:            client,
 	}, nil
 }
 
+// Run downloads the book and builds its EPUB
 func (d *Downloader) Run() error {
-	fmt.Printf("[*] Retrieving book info...\n")
+	err := d.run()
+	if err != nil {
+		// The caller reports the error on the terminal; keep it in the log file too
+		d.log.fileLine(slog.LevelError, fmt.Sprintf("[-] Download failed: %v", err))
+	}
+	return err
+}
+
+// run does the work of Run
+func (d *Downloader) run() error {
+	d.log.Printf("[*] Retrieving book info...\n")
 	bookInfo, err := d.client.GetBookInfo(d.bookID)
 	if err != nil {
 		return err
 	}
+	d.overrideMetadata(&bookInfo)
+
+	// The language is written into lang attributes and dc:language as is
+	if bookInfo.Language != "" && !html.IsLanguageTag(bookInfo.Language) {
+		d.log.Printf("[-] Ignoring invalid language tag %q, using %s\n", bookInfo.Language, defaultLanguage)
+		bookInfo.Language = ""
+	}
+	if bookInfo.Language != "" {
+		d.language = bookInfo.Language
+	}
+	d.result = BookResult{BookID: d.bookID, Title: bookInfo.Title}
+
+	if d.ifModified && !d.force && d.unchanged(bookInfo) {
+		d.log.Printf("[*] Skipping: unchanged since the last download (issued %s)\n", bookInfo.Issued)
+		d.result.Status = StatusSkipped
+		return nil
+	}
+
+	var chapters []models.Chapter
+	if !d.streamChapterList {
+		d.log.Printf("[*] Retrieving book chapters...\n")
+		if chapters, err = d.client.GetBookChapters(d.bookID); err != nil {
+			return err
+		}
+		if chapters, err = d.arrangeChapters(chapters); err != nil {
+			return err
+		}
+	}
+
+	d.loadAssetIndex()
+	d.planImageNames(chapters)
 
-	fmt.Printf("[*] Retrieving book chapters...\n")
-	chapters, err := d.client.GetBookChapters(d.bookID)
+	bookPath, cleanup, err := d.createBuildDirectory(bookInfo)
 	if err != nil {
 		return err
 	}
+	defer cleanup()
 
-	bookPath, err := d.createBookDirectory(bookInfo)
-	if err != nil {
+	if err := d.initState(bookPath); err != nil {
 		return err
 	}
+	// Keep the progress of a failed run for --resume
+	defer func() {
+		if err := d.state.flush(); err != nil {
+			d.log.Printf("[-] Failed to save state: %v\n", err)
+		}
+	}()
 
-	fmt.Printf("[*] Downloading %d chapters...\n", len(chapters))
-	if err := d.downloadChapters(bookPath, chapters); err != nil {
+	if d.streamEPUB {
+		if err := d.openChapterStream(bookPath, chapters); err != nil {
+			return err
+		}
+		defer d.stream.abort()
+	}
+
+	if d.streamChapterList {
+		d.log.Printf("[*] Downloading chapters as the chapter list arrives...\n")
+		chapters, err = d.downloadChapterPages(bookPath)
+		d.result.Chapters = len(chapters)
+		if err != nil {
+			return err
+		}
+	} else {
+		d.log.Printf("[*] Downloading %d chapters...\n", len(chapters))
+		chapters, err = d.downloadChapters(bookPath, chapters)
+		d.result.Chapters = len(chapters)
+		if err != nil {
+			return err
+		}
+	}
+	if d.stream != nil {
+		if err := d.stream.complete(); err != nil {
+			return err
+		}
+		if d.stream.resolved > 0 {
+			d.log.Printf("[*] Pointed %d fragment links at the chapters defining them\n", d.stream.resolved)
+		}
+	} else if err := d.resolveFragmentLinks(filepath.Join(bookPath, "OEBPS"), chapters); err != nil {
 		return err
 	}
 
-	fmt.Printf("[*] Creating EPUB file...\n")
+	d.log.Printf("[*] Creating EPUB file...\n")
 	if err := d.generateEPUB(bookInfo, chapters, bookPath); err != nil {
 		return err
 	}
 
-	epubPath := filepath.Join(bookPath, filepath.Base(bookPath)+".epub")
-	fmt.Printf("[*] Done: %s\n", epubPath)
-	return nil
+	epubPath, err := d.publishEPUB(bookPath)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(epubPath); err == nil {
+		d.result.Size = info.Size()
+	}
+	if err := d.validateLinks(epubPath); err != nil {
+		return err
+	}
+	sum, err := utils.WriteChecksumFile(epubPath, defaultFilePerm)
+	if err == nil {
+		err = chmodFile(epubPath+".sha256", d.filePerm)
+	}
+	if err != nil {
+		return fmt.Errorf("write checksum: %w", err)
+	}
+	if d.opdsEntry {
+		if err := d.writeOPDSEntry(bookInfo, epubPath); err != nil {
+			return fmt.Errorf("write OPDS entry: %w", err)
+		}
+	}
+	if d.includeFiles {
+		d.result.Files = d.downloadSupplementaryFiles(bookPath)
+	}
+	if err := d.state.markComplete(bookInfo.Issued); err != nil {
+		d.log.Printf("[-] Failed to save state: %v\n", err)
+	}
+	d.log.Printf("[*] Done: %s (sha256 %s)\n", epubPath, sum)
+	return d.runOnComplete(epubPath, bookPath)
 }
 
-func (d *Downloader) createBookDirectory(bookInfo models.BookInfo) (string, error) {
+// loadAssetIndex fetches the v2 files listing used to resolve image paths.
+// Books without one fall back to each chapter's asset base URL.
+func (d *Downloader) loadAssetIndex() {
+	d.log.Printf("[*] Retrieving book files...\n")
+	files, err := d.client.GetBookFiles(d.bookID)
+	if err != nil {
+		d.log.Printf("[-] No v2 files listing, resolving images from chapter URLs: %v\n", err)
+		return
+	}
+	d.assets = newAssetIndex(files)
+}
+
+// newClient authenticates with the Cookie header when given, otherwise the cookies file
+func newClient(opts Options, clientOpts safarihttp.ClientOptions) (*safarihttp.Client, error) {
+	if opts.CookieHeader == "" {
+		return safarihttp.NewClient(opts.CookiesPath, opts.SiteURL, clientOpts)
+	}
+
+	cookies, err := utils.ParseCookieHeader(opts.CookieHeader)
+	if err != nil {
+		return nil, fmt.Errorf("parse cookie header: %w", err)
+	}
+	return safarihttp.NewClientWithCookies(cookies, opts.SiteURL, clientOpts)
+}
+
+// Close releases the log file
+func (d *Downloader) Close() error {
+	return d.log.Close()
+}
+
+// overrideMetadata replaces the title and authors from the API with those
+// given in the options, before anything is named or written after them
+func (d *Downloader) overrideMetadata(bookInfo *models.BookInfo) {
+	if d.titleOverride != "" {
+		d.log.Printf("[*] Using title %q instead of %q\n", d.titleOverride, bookInfo.Title)
+		bookInfo.Title = d.titleOverride
+	}
+	if len(d.authorOverrides) > 0 {
+		d.log.Printf("[*] Using authors: %s\n", strings.Join(d.authorOverrides, ", "))
+		bookInfo.SetAuthors(d.authorOverrides)
+	}
+}
+
+// bookDirectory returns the directory the book is written to
+func (d *Downloader) bookDirectory(bookInfo models.BookInfo) string {
 	title := utils.EscapeDirname(bookInfo.Title)
 	if title == "" {
 		title = d.bookID
@@ -96,8 +730,20 @@ func (d *Downloader) createBookDirectory(bookInfo models.BookInfo) (string, erro
 
 	cleanTitle := strings.Split(title, ",")[0]
 	dirName := fmt.Sprintf("%s (%s)", cleanTitle, d.bookID)
-	bookPath := filepath.Join(d.booksDir, dirName)
+	return filepath.Join(d.booksDir, dirName)
+}
 
+func (d *Downloader) createBookDirectory(bookInfo models.BookInfo) (string, error) {
+	bookPath := d.bookDirectory(bookInfo)
+	if err := d.createBookTree(bookPath); err != nil {
+		return "", err
+	}
+	return bookPath, nil
+}
+
+// createBookTree creates bookPath and the OEBPS directories the book is
+// built in
+func (d *Downloader) createBookTree(bookPath string) error {
 	dirs := []string{
 		bookPath,
 		filepath.Join(bookPath, "OEBPS"),
@@ -106,353 +752,1249 @@ func (d *Downloader) createBookDirectory(bookInfo models.BookInfo) (string, erro
 	}
 
 	for _, dir := range dirs {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return "", fmt.Errorf("create directory %s: %w", dir, err)
+		if err := d.mkdir(dir); err != nil {
+			return fmt.Errorf("create directory %s: %w", dir, err)
 		}
 	}
+	return nil
+}
 
-	return bookPath, nil
+// unchanged reports whether a previous run finished this book's EPUB and
+// recorded the same issued date the API reports now
+func (d *Downloader) unchanged(bookInfo models.BookInfo) bool {
+	if bookInfo.Issued == "" {
+		return false
+	}
+
+	bookPath := d.bookDirectory(bookInfo)
+	info, err := os.Stat(filepath.Join(bookPath, filepath.Base(bookPath)+".epub"))
+	if err != nil {
+		return false
+	}
+	state, err := loadBookState(bookPath)
+	if err != nil {
+		d.log.Printf("[-] Ignoring unreadable state: %v\n", err)
+		return false
+	}
+	if state.Issued != bookInfo.Issued {
+		return false
+	}
+	d.result.Size = info.Size()
+	return true
 }
 
-func (d *Downloader) downloadChapters(bookPath string, chapters []models.Chapter) error {
-	oebpsPath := filepath.Join(bookPath, "OEBPS")
+// initState starts a fresh state file, or loads the previous one when resuming
+func (d *Downloader) initState(bookPath string) error {
+	if !d.resume {
+		d.state = newBookState(bookPath)
+		d.state.perm = d.filePerm
+		// Files an earlier run saved under other names are still reused
+		if previous, err := loadBookState(bookPath); err == nil {
+			d.state.Names = previous.Names
+		}
+		return nil
+	}
 
-	// Use simple worker pool for concurrency
-	sem := make(chan struct{}, maxWorkers)
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	var firstError error
+	state, err := loadBookState(bookPath)
+	if err != nil {
+		return err
+	}
+	d.log.Printf("[*] Resuming: %d chapters and %d assets already done, %d assets failed previously\n",
+		len(state.Chapters), len(state.Assets), state.failedAssets())
+	state.perm = d.filePerm
+	d.state = state
+	return nil
+}
+
+// downloadChapters downloads every chapter and returns the list without
+// those served empty
+func (d *Downloader) downloadChapters(bookPath string, chapters []models.Chapter) ([]models.Chapter, error) {
+	var mergedFiles map[string]string
+	if d.flattenNested {
+		d.nestedMerges, mergedFiles = planNestedMerges(chapters)
+	}
+	if len(d.depthMerges) > 0 {
+		d.nestedMerges, mergedFiles = planDepthMerges(chapters, d.depthMerges, d.nestedMerges, mergedFiles)
+	}
+
+	pool := d.newChapterPool(bookPath, mergedFiles)
+	pool.start(chapters, 0)
+	err := pool.wait()
+	return pool.dropEmpty(chapters), err
+}
+
+// downloadChapterPages downloads the chapters of each page of the chapter
+// list as soon as it arrives rather than after the whole list, and returns
+// the list once every chapter is done
+func (d *Downloader) downloadChapterPages(bookPath string) ([]models.Chapter, error) {
+	pool := d.newChapterPool(bookPath, nil)
+	var pages [][]models.Chapter
+	started := 0
+	err := d.client.StreamBookChapters(d.bookID, func(page []models.Chapter) error {
+		pages = append(pages, page)
+		pool.start(page, started)
+		started += len(page)
+		d.log.Printf("[*] Downloading %d more chapters (%d so far)...\n", len(page), started)
+		return nil
+	})
+	// Chapters already started finish either way
+	poolErr := pool.wait()
+	if err != nil {
+		return nil, err
+	}
+	return pool.dropEmpty(slices.Concat(pages...)), poolErr
+}
+
+// chapterPool downloads chapters concurrently, at most d.workers at a time
+type chapterPool struct {
+	d           *Downloader
+	bookPath    string
+	oebpsPath   string
+	mergedFiles map[string]string
+	limit       workerLimit
+	wg          sync.WaitGroup
+	mu          sync.Mutex
+	firstError  error
+	ban         bool         // a chapter got a ban page, see safarihttp.BannedError
+	empty       map[int]bool // book-wide indexes of the chapters served empty
+	css         [][][]string // stylesheet URLs per chapter, per start call, for mergeCSS
+}
+
+func (d *Downloader) newChapterPool(bookPath string, mergedFiles map[string]string) *chapterPool {
+	return &chapterPool{
+		d:           d,
+		bookPath:    bookPath,
+		oebpsPath:   filepath.Join(bookPath, "OEBPS"),
+		mergedFiles: mergedFiles,
+		limit:       d.workerLimit(),
+	}
+}
+
+// workerLimit returns the adaptive limit when there is one, otherwise a
+// fixed one of d.workers
+func (d *Downloader) workerLimit() workerLimit {
+	if d.adaptive != nil {
+		return d.adaptive
+	}
+	return make(semaphore, d.workers)
+}
+
+// start downloads chapters in the background; offset is the book-wide
+// index of the first one
+func (p *chapterPool) start(chapters []models.Chapter, offset int) {
+	d := p.d
+	var css [][]string
+	if d.mergeCSS {
+		css = make([][]string, len(chapters))
+		p.css = append(p.css, css)
+	}
 
 	for idx := range chapters {
-		wg.Add(1)
+		p.wg.Add(1)
 		go func(i int) {
-			defer wg.Done()
-			sem <- struct{}{}        // Acquire
-			defer func() { <-sem }() // Release
+			defer p.wg.Done()
+			p.limit.acquire()
+			defer p.limit.release()
+			// Once the site bans the client every request fails, so the rest
+			// of the chapters are left for a later run
+			if p.banned() {
+				return
+			}
 
 			// Create parser per goroutine to avoid race conditions
-			parser := html.NewParser("https://"+d.siteURL, d.kindleMode)
-
-			if err := d.downloadChapter(oebpsPath, &chapters[i], i == 0, parser, bookPath); err != nil {
-				mu.Lock()
-				if firstError == nil {
-					firstError = err
+			var fetchCSS func(string) (string, error)
+			if d.inlineCSS {
+				fetchCSS = func(url string) (string, error) { return d.inlineCSSFor(url, p.oebpsPath) }
+			}
+			var mergeCSS func(string)
+			if d.mergeCSS {
+				mergeCSS = func(url string) { css[i] = append(css[i], url) }
+			}
+			parser := html.NewParser("https://"+d.siteURL, html.ParserOptions{
+				KindleMode:        d.kindleMode,
+				Language:          d.language,
+				DetectLanguage:    d.detectChapterLang,
+				LanguageThreshold: d.langThreshold,
+				Direction:         d.readingDirection,
+				StripComments:     d.stripComments,
+				DropImages:        d.noImages,
+				EPUB2:             d.epub2Compat,
+				FlattenAnchors:    d.flattenAnchors,
+				NormalizeHeadings: d.normalizeHeadings,
+				TitleFromHeading:  d.titleFromHeading,
+				FetchCSS:          fetchCSS,
+				Transforms:        d.transforms,
+				MergeCSS:          mergeCSS,
+				MergedFiles:       p.mergedFiles,
+			})
+
+			err := d.downloadChapter(p.oebpsPath, &chapters[i], offset+i == 0, parser, p.bookPath)
+			if errors.Is(err, errEmptyChapter) {
+				d.log.With("chapter", chapters[i].Title).Printf("[-] Warning: chapter %s has no content, leaving it out\n", chapters[i].Title)
+				p.mu.Lock()
+				if p.empty == nil {
+					p.empty = make(map[int]bool)
+				}
+				p.empty[offset+i] = true
+				p.mu.Unlock()
+				if d.stream != nil {
+					err = d.stream.skip(xhtmlName(chapters[i].Filename))
+				} else {
+					err = nil
+				}
+			}
+			if err != nil {
+				var banned *safarihttp.BannedError
+				isBan := errors.As(err, &banned)
+				p.mu.Lock()
+				// The ban explains every failure after it, so it is the one reported
+				if p.firstError == nil || isBan && !p.ban {
+					p.firstError = err
 				}
-				mu.Unlock()
-				fmt.Printf("[-] Failed chapter %s: %v\n", chapters[i].Title, err)
+				p.ban = p.ban || isBan
+				p.mu.Unlock()
+				d.log.With("chapter", chapters[i].Title).Printf("[-] Failed chapter %s: %v\n", chapters[i].Title, err)
 			}
 		}(idx)
 	}
+}
+
+// wait blocks until every started chapter is done and returns the first
+// chapter error
+func (p *chapterPool) wait() error {
+	p.wg.Wait()
+	if p.d.mergeCSS {
+		p.d.chapterCSS = slices.Concat(p.css...)
+	}
+	if p.banned() && !p.d.flatOutput {
+		p.d.log.Printf("[-] Stopped downloading: access denied. Finished chapters are recorded, so --resume continues from here\n")
+	}
+	return p.firstError
+}
+
+// banned reports whether a chapter failed because the site banned the client
+func (p *chapterPool) banned() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ban
+}
+
+func (d *Downloader) downloadChapter(oebpsPath string, chapter *models.Chapter, isFirst bool, parser *html.Parser, bookPath string) error {
+	log := d.log.With("chapter", chapter.Title)
+	stateKey := chapter.Filename
+	nested := d.nestedMerges[stateKey]
+	if d.resume && d.state.chapterDone(stateKey) {
+		chapter.Filename = strings.ReplaceAll(chapter.Filename, ".html", ".xhtml")
+		// The title found last time is in the saved page, not the API
+		if d.titleFromHeading && html.IsGenericTitle(chapter.Title, stateKey) {
+			if page, err := os.ReadFile(filepath.Join(oebpsPath, chapter.Filename)); err == nil {
+				chapter.Title = cmp.Or(html.HeadingTitle(string(page)), chapter.Title)
+			}
+		}
+		log.Printf("[+] Chapter already done: %s\n", chapter.Title)
+		// Retry any images that failed last time; finished ones are skipped
+		d.downloadChapterAssets(chapter, nested, bookPath)
+		return nil
+	}
+
+	body, truncated, err := d.nonEmptyChapterBody(chapter)
+	if err != nil {
+		return err
+	}
+
+	if d.dumpRaw {
+		if err := d.dumpRawChapter(oebpsPath, chapter.Filename, body); err != nil {
+			log.Printf("[-] Failed to dump raw chapter %s: %v\n", chapter.Title, err)
+		}
+	}
+
+	if truncated {
+		if d.skipOversized {
+			log.Printf("[-] Warning: chapter %s exceeds %d bytes, skipping its content\n", chapter.Title, d.maxChapterSize)
+			body = []byte(oversizedChapterHTML)
+		} else {
+			log.Printf("[-] Warning: chapter %s exceeds %d bytes, truncating\n", chapter.Title, d.maxChapterSize)
+			body = truncateAtTag(body)
+		}
+	}
+	if body, err = d.mergeNestedChapters(chapter, nested, body); err != nil {
+		return err
+	}
+
+	chapter.Content = string(body)
+	if d.titleFromHeading && html.IsGenericTitle(chapter.Title, chapter.Filename) {
+		if title := html.HeadingTitle(chapter.Content); title != "" {
+			log.Printf("[*] Titling chapter %s %q from its first heading\n", chapter.Filename, title)
+			chapter.Title = title
+		}
+	}
+
+	// Parse chapter HTML
+	_, pageHTML, err := parser.ParseChapter(*chapter, isFirst)
+	if err != nil {
+		return fmt.Errorf("parse chapter: %w", err)
+	}
+
+	// Download chapter assets (CSS/images) and point links at any renamed files
+	renames := d.downloadChapterAssets(chapter, nested, bookPath)
+	pageHTML = applyImageRenames(pageHTML, renames)
+	if err := d.checkXHTML(chapter, pageHTML); err != nil {
+		return err
+	}
+
+	// Save chapter file, or hand it to the EPUB when streaming
+	filename := strings.ReplaceAll(chapter.Filename, ".html", ".xhtml")
+	chapter.Filename = filename
+	if d.stream != nil {
+		if err := d.stream.put(filename, []byte(pageHTML)); err != nil {
+			return fmt.Errorf("write chapter: %w", err)
+		}
+	} else if err := d.writeFile(filepath.Join(oebpsPath, filename), []byte(pageHTML)); err != nil {
+		return fmt.Errorf("write chapter: %w", err)
+	}
+
+	if err := d.state.markChapter(stateKey); err != nil {
+		d.log.Printf("[-] Failed to save state: %v\n", err)
+	}
+	return nil
+}
+
+// chapterBody returns the chapter HTML, at most maxChapterSize bytes of it,
+// and whether it was cut. Some API variants embed the HTML in the chapter
+// JSON instead of giving a content URL, so that is used without a request.
+func (d *Downloader) chapterBody(chapter *models.Chapter) ([]byte, bool, error) {
+	if isInlineContent(chapter.Content) {
+		body := []byte(chapter.Content)
+		if int64(len(body)) > d.maxChapterSize {
+			return body[:d.maxChapterSize], true, nil
+		}
+		return body, false, nil
+	}
+
+	// Bounded so a runaway page can't exhaust memory
+	resp, body, truncated, err := d.client.GetLimited(d.client.ResolveURL(chapter.Content), d.maxChapterSize)
+	if err != nil {
+		return nil, false, fmt.Errorf("download chapter: %w", err)
+	}
+	if !resp.IsSuccess() {
+		return nil, false, fmt.Errorf("status %d for chapter %s", resp.StatusCode(), chapter.Title)
+	}
+	return body, truncated, nil
+}
+
+// isInlineContent reports whether a chapter's content field holds the HTML
+// itself rather than a URL, which never starts with markup
+func isInlineContent(content string) bool {
+	return strings.HasPrefix(strings.TrimSpace(content), "<")
+}
+
+// checkXHTML parses a finished chapter back as strict XML when StrictXHTML is
+// set, logging problems in warn mode and failing the chapter in fail mode
+func (d *Downloader) checkXHTML(chapter *models.Chapter, pageHTML string) error {
+	if d.strictXHTML == "" {
+		return nil
+	}
+	err := html.ValidateXHTML(pageHTML)
+	if err == nil {
+		return nil
+	}
+	err = fmt.Errorf("chapter %q (%s) is not well-formed XHTML: %w", chapter.Title, chapter.Filename, err)
+	if d.strictXHTML == "warn" {
+		d.log.Printf("[-] %v\n", err)
+		return nil
+	}
+	return err
+}
+
+// resolveFragmentLinks points fragment-only links at the chapter defining
+// their target when that is not the linking chapter. All chapters have to be
+// written first, so this runs as a second pass over the chapter files.
+func (d *Downloader) resolveFragmentLinks(oebpsPath string, chapters []models.Chapter) error {
+	index := html.NewIDIndex(d.flattenAnchors)
+	pages := make([]string, len(chapters))
+	for i, chapter := range chapters {
+		data, err := os.ReadFile(filepath.Join(oebpsPath, chapter.Filename))
+		if err != nil {
+			return fmt.Errorf("read chapter %s: %w", chapter.Filename, err)
+		}
+		pages[i] = string(data)
+		index.Add(chapter.Filename, pages[i])
+	}
+
+	total := 0
+	for i, chapter := range chapters {
+		page, changed := index.Resolve(chapter.Filename, pages[i])
+		if changed == 0 {
+			continue
+		}
+		if err := d.writeFile(filepath.Join(oebpsPath, chapter.Filename), []byte(page)); err != nil {
+			return fmt.Errorf("write chapter %s: %w", chapter.Filename, err)
+		}
+		total += changed
+	}
+	if total > 0 {
+		d.log.Printf("[*] Pointed %d fragment links at the chapters defining them\n", total)
+	}
+	return nil
+}
+
+// fetchCSS returns a stylesheet's content, downloading each URL once for the
+// whole book; a failed download is not retried
+func (d *Downloader) fetchCSS(url string) (string, error) {
+	return d.cachedCSS(d.cssCache, url, func() (string, error) {
+		resp, err := d.client.Get(url)
+		if err != nil {
+			return "", err
+		}
+		if !resp.IsSuccess() {
+			return "", fmt.Errorf("status %d for stylesheet %s", resp.StatusCode(), url)
+		}
+		return resp.String(), nil
+	})
+}
+
+// cssEntry is a stylesheet loaded at most once, see cachedCSS
+type cssEntry struct {
+	once sync.Once
+	css  string
+	err  error
+}
+
+// cachedCSS returns the stylesheet cached under url in cache, loading it the
+// first time. Only finding the entry takes cssMu, so workers loading other
+// stylesheets don't wait for the download.
+func (d *Downloader) cachedCSS(cache map[string]*cssEntry, url string, load func() (string, error)) (string, error) {
+	d.cssMu.Lock()
+	entry, ok := cache[url]
+	if !ok {
+		entry = &cssEntry{}
+		cache[url] = entry
+	}
+	d.cssMu.Unlock()
+
+	entry.once.Do(func() { entry.css, entry.err = load() })
+	return entry.css, entry.err
+}
+
+// oversizedChapterHTML stands in for a chapter skipped for exceeding the size limit
+const oversizedChapterHTML = `<div id="sbo-rt-content"><p>This chapter was skipped because it exceeded the maximum chapter size.</p></div>`
+
+// truncateAtTag cuts body after its last complete tag so no tag or multi-byte
+// character is split; the HTML parser closes any elements left open
+func truncateAtTag(body []byte) []byte {
+	if idx := bytes.LastIndexByte(body, '>'); idx >= 0 {
+		return body[:idx+1]
+	}
+	return body
+}
+
+// dumpRawChapter writes the chapter HTML as served, before parsing, to
+// OEBPS/_raw/<filename>.html
+func (d *Downloader) dumpRawChapter(oebpsPath, filename string, body []byte) error {
+	rawPath := filepath.Join(oebpsPath, rawChaptersDir)
+	if err := d.mkdir(rawPath); err != nil {
+		return err
+	}
+	name := filepath.Base(filename)
+	name = strings.TrimSuffix(name, filepath.Ext(name)) + ".html"
+	return d.writeFile(filepath.Join(rawPath, name), body)
+}
+
+// downloadAssets downloads the chapter images and returns the files saved
+// under a different name than their URL suggested, keyed by the URL name
+func (d *Downloader) downloadAssets(chapter *models.Chapter, basePath string) map[string]string {
+	renames := make(map[string]string)
+	if d.noImages {
+		return renames
+	}
+	imagesPath := filepath.Join(basePath, "OEBPS", "Images")
+	log := d.log.With("chapter", chapter.Title)
+
+	if len(chapter.Images) > 0 {
+		log.Printf("[*] Chapter '%s' has %d images\n", chapter.Title, len(chapter.Images))
+	}
+
+	// Download images
+	chapterURLs := make(map[string]string) // base name -> the chapter's first URL with it
+	for _, imgURL := range chapter.Images {
+		url := d.resolveImageURL(chapter, imgURL)
+		if url == "" {
+			log.Printf("[-] Skipping empty image URL from: %s\n", imgURL)
+			continue
+		}
+		filename := utils.FilenameFromURL(url)
+		if filename == "" {
+			log.With("url", url).Printf("[-] Could not get filename from URL: %s\n", url)
+			continue
+		}
+		name := d.images.name(url, filename)
+		// Links in the page only carry the base name, so a second image with
+		// it in the same chapter cannot be told apart from the first
+		linked := true
+		if first, ok := chapterURLs[filename]; ok && first != url {
+			log.Printf("[-] Warning: chapter %s has two images named %s, links to it show the first\n", chapter.Title, filename)
+			linked = false
+		} else {
+			chapterURLs[filename] = url
+		}
+		if linked && name != filename {
+			renames[filename] = name
+		}
+		// A file saved under another name by an earlier run can't be found by
+		// the name its URL suggests, so the state records it
+		previous := d.state.savedName(url)
+		if linked && previous != "" {
+			renames[filename] = previous
+		}
+		if d.resume && d.state.assetDone(url) {
+			continue
+		}
+
+		var saved string
+		var err error
+		if previous != "" && utils.FileExists(filepath.Join(imagesPath, previous)) {
+			log.Printf("[+] Image already exists: %s\n", previous)
+			saved = previous
+		} else {
+			log.With("url", url).Printf("[*] Downloading image: %s -> %s\n", url, name)
+			saved, err = d.downloadImage(url, filepath.Join(imagesPath, name))
+		}
+		renamed := ""
+		if err == nil && saved != filename {
+			renamed = saved
+			if linked {
+				renames[filename] = saved
+			}
+		}
+		if err := d.state.markAsset(url, renamed, err); err != nil {
+			d.log.Printf("[-] Failed to save state: %v\n", err)
+		}
+	}
+	return renames
+}
+
+// downloadImage saves a chapter image to path, at the preferred image size
+// when its URL has a size token, falling back to the URL as given
+func (d *Downloader) downloadImage(url, path string) (string, error) {
+	if sized, ok := urlWithSize(url, d.imageSize); ok && sized != url {
+		if saved, err := d.downloadFile(sized, path); err == nil {
+			return saved, nil
+		}
+		d.log.Printf("[-] No %s rendition of %s, downloading it as given\n", d.imageSize, filepath.Base(path))
+	}
+	return d.downloadFile(url, path)
+}
+
+// downloadFile saves url to path and returns the saved file name. When the
+// path has no extension the name is taken from Content-Disposition, or the
+// extension from Content-Type, so opaque asset URLs still get usable names.
+func (d *Downloader) downloadFile(url, path string) (string, error) {
+	if utils.FileExists(path) {
+		d.log.Printf("[+] Image already exists: %s\n", filepath.Base(path))
+		return filepath.Base(path), nil
+	}
+	if converted := d.convertedPath(path); converted != "" && utils.FileExists(converted) {
+		d.log.Printf("[+] Image already exists: %s\n", filepath.Base(converted))
+		return filepath.Base(converted), nil
+	}
+
+	resp, err := d.client.Get(url)
+	if err != nil {
+		d.log.With("url", url).Printf("[-] Failed to download %s: %v\n", url, err)
+		return "", err
+	}
+	// CDN assets can need a signed URL even with valid cookies
+	if resp.StatusCode() == http.StatusForbidden {
+		if signed, ok := d.signedURL(url); ok {
+			d.log.With("url", url).Printf("[*] Retrying %s with a signed URL\n", filepath.Base(path))
+			if resp, err = d.client.Get(signed); err != nil {
+				d.log.With("url", signed).Printf("[-] Failed to download %s: %v\n", signed, err)
+				return "", err
+			}
+		}
+	}
+	if !resp.IsSuccess() {
+		d.log.With("url", url).Printf("[-] Failed to download %s: status %d\n", url, resp.StatusCode())
+		return "", fmt.Errorf("status %d", resp.StatusCode())
+	}
+
+	if filepath.Ext(path) == "" {
+		if name := utils.FilenameFromContentDisposition(resp.Header().Get("Content-Disposition")); name != "" {
+			path = filepath.Join(filepath.Dir(path), name)
+		} else if ext := utils.ExtensionFromContentType(resp.Header().Get("Content-Type")); ext != "" {
+			path += ext
+		}
+	}
+
+	data := resp.Body()
+	if converted, ext, ok := d.transcodeImage(data); ok {
+		data = converted
+		path = strings.TrimSuffix(path, filepath.Ext(path)) + ext
+	}
+
+	if err := d.writeFile(path, data); err != nil {
+		d.log.Printf("[-] Failed to save %s: %v\n", filepath.Base(path), err)
+		return "", err
+	}
+	d.log.Printf("[+] Downloaded image: %s\n", filepath.Base(path))
+	return filepath.Base(path), nil
+}
+
+// transcodeImage converts WebP data to the configured image format, reporting
+// false when conversion is off, the data isn't WebP, or decoding fails
+func (d *Downloader) transcodeImage(data []byte) ([]byte, string, bool) {
+	if d.imageFormat == "" || !utils.IsWebP(data) {
+		return nil, "", false
+	}
+	converted, ext, err := utils.ConvertWebP(data, d.imageFormat, d.jpegQuality)
+	if err != nil {
+		d.log.Printf("[-] Keeping original WebP image: %v\n", err)
+		return nil, "", false
+	}
+	return converted, ext, true
+}
+
+// convertedPath returns where a .webp asset is stored once transcoded, or ""
+// when conversion doesn't apply
+func (d *Downloader) convertedPath(path string) string {
+	if d.imageFormat == "" || !strings.EqualFold(filepath.Ext(path), ".webp") {
+		return ""
+	}
+	ext := ".jpg"
+	if d.imageFormat == "png" {
+		ext = ".png"
+	}
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ext
+}
+
+// applyImageRenames rewrites Images/ references to files saved under another
+// name, wherever imageRefRe finds them: quoted attributes, srcset, or url()
+func applyImageRenames(pageHTML string, renames map[string]string) string {
+	if len(renames) == 0 {
+		return pageHTML
+	}
+	return imageRefRe.ReplaceAllStringFunc(pageHTML, func(ref string) string {
+		name := strings.TrimPrefix(ref, "Images/")
+		if to, ok := renames[name]; ok {
+			return "Images/" + to
+		}
+		if unescaped, err := url.PathUnescape(name); err == nil {
+			if to, ok := renames[unescaped]; ok {
+				return "Images/" + url.PathEscape(to)
+			}
+		}
+		return ref
+	})
+}
+
+// resolveImageURL returns the download URL for an image, preferring the v2
+// files listing over the chapter's asset base URL
+func (d *Downloader) resolveImageURL(chapter *models.Chapter, img string) string {
+	if url, ok := d.assets.lookup(chapter, img); ok {
+		return url
+	}
+	return utils.ResolveURL(d.assetBaseURL(chapter), img)
+}
+
+// assetBaseURL returns the URL a chapter's relative asset paths resolve
+// against: its asset_base_url, or when the API leaves that out, the book's
+// v2 files path on the site, which serves the book's files by their path
+func (d *Downloader) assetBaseURL(chapter *models.Chapter) string {
+	if chapter.AssetBaseURL != "" {
+		return chapter.AssetBaseURL
+	}
+	site := strings.TrimSuffix(d.siteURL, "/")
+	if !strings.Contains(site, "://") {
+		site = "https://" + site
+	}
+	return fmt.Sprintf("%s/api/v2/epubs/urn:orm:book:%s/files/", site, url.PathEscape(d.bookID))
+}
+
+func (d *Downloader) generateEPUB(bookInfo models.BookInfo, chapters []models.Chapter, bookPath string) error {
+	oebpsPath := filepath.Join(bookPath, "OEBPS")
+	imagesPath := filepath.Join(oebpsPath, "Images")
+
+	// Download cover image - try to get the largest version
+	var coverFilename string
+	if d.noCover {
+		d.log.Printf("[*] Skipping cover\n")
+	} else if bookInfo.Cover != "" {
+		coverFilename = d.downloadLargestCover(bookInfo.Cover, imagesPath)
+		if coverFilename == "" {
+			d.log.Printf("[-] No cover from the cover URL, checking chapters...\n")
+			coverFilename = d.findCoverInChapters(chapters, imagesPath)
+		}
+	} else {
+		d.log.Printf("[-] No cover URL in book info, checking chapters...\n")
+		// Try to find cover in first few chapters
+		coverFilename = d.findCoverInChapters(chapters, imagesPath)
+	}
+	if coverFilename == "" && !d.noCover && d.generateCover {
+		coverFilename = d.writeGeneratedCover(bookInfo, imagesPath)
+	}
+
+	// Create cover page (cover.xhtml) unless the book has its own, so only one cover shows
+	switch coverPage, generated := coverPageHref(chapters, coverFilename); {
+	case generated:
+		if err := d.writeCoverPage(oebpsPath, coverFilename); err != nil {
+			return err
+		}
+	case coverPage != "":
+		d.log.Printf("[*] Using the book's cover chapter %s as the cover page\n", coverPage)
+		if coverPage != coverPageName {
+			os.Remove(filepath.Join(oebpsPath, coverPageName))
+		}
+	}
+	if coverFilename != "" && d.coverThumbnail {
+		d.writeCoverThumbnail(coverFilename, imagesPath)
+	}
 
-	wg.Wait()
-	return firstError
-}
+	// Create mimetype
+	if err := d.writeFile(filepath.Join(bookPath, "mimetype"), []byte(epubMimetype)); err != nil {
+		return fmt.Errorf("write mimetype: %w", err)
+	}
 
-func (d *Downloader) downloadChapter(oebpsPath string, chapter *models.Chapter, isFirst bool, parser *html.Parser, bookPath string) error {
-	// Download chapter content
-	resp, err := d.client.Get(chapter.Content)
-	if err != nil {
-		return fmt.Errorf("download chapter: %w", err)
+	// Create META-INF/container.xml
+	metaInf := filepath.Join(bookPath, "META-INF")
+	if err := d.mkdir(metaInf); err != nil {
+		return fmt.Errorf("create directory %s: %w", metaInf, err)
 	}
-	if !resp.IsSuccess() {
-		return fmt.Errorf("status %d for chapter %s", resp.StatusCode(), chapter.Title)
+	containerXML := `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles>
+<rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml" />
+</rootfiles>
+</container>`
+	if err := d.writeFile(filepath.Join(metaInf, "container.xml"), []byte(containerXML)); err != nil {
+		return fmt.Errorf("write container.xml: %w", err)
 	}
 
-	chapter.Content = string(resp.Body())
+	if d.mergeCSS {
+		if err := d.writeMergedCSS(chapters, oebpsPath); err != nil {
+			return err
+		}
+	}
+	if d.pruneCSS {
+		d.pruneStylesheets(oebpsPath)
+	}
 
-	// Parse chapter HTML
-	_, pageHTML, err := parser.ParseChapter(*chapter, isFirst)
-	if err != nil {
-		return fmt.Errorf("parse chapter: %w", err)
+	if err := d.writePageList(bookInfo, chapters, oebpsPath); err != nil {
+		return err
+	}
+	if d.colophon {
+		if err := d.writeColophon(bookInfo, oebpsPath, time.Now()); err != nil {
+			return err
+		}
 	}
 
-	// Save chapter file
-	filename := strings.ReplaceAll(chapter.Filename, ".html", ".xhtml")
-	chapter.Filename = filename
-	outputPath := filepath.Join(oebpsPath, filename)
-	if err := os.WriteFile(outputPath, []byte(pageHTML), 0644); err != nil {
-		return fmt.Errorf("write chapter: %w", err)
+	// Create content.opf and toc.ncx
+	d.orphanImages = d.findOrphanImages(oebpsPath, coverFilename)
+	if err := d.writeEPUBMetadata(bookInfo, chapters, oebpsPath, coverFilename); err != nil {
+		return err
 	}
 
-	// Download chapter assets (CSS/images)
-	d.downloadAssets(chapter, bookPath)
-	return nil
+	return d.writeArchive(bookPath)
 }
 
-func (d *Downloader) downloadAssets(chapter *models.Chapter, basePath string) {
-	imagesPath := filepath.Join(basePath, "OEBPS", "Images")
-
-	if len(chapter.Images) > 0 {
-		fmt.Printf("[*] Chapter '%s' has %d images\n", chapter.Title, len(chapter.Images))
+// writeGeneratedCover renders a title/author cover for books without one and
+// returns its filename, or "" when rendering fails
+func (d *Downloader) writeGeneratedCover(bookInfo models.BookInfo, imagesPath string) string {
+	var authors []string
+	for _, author := range bookInfo.Authors {
+		authors = append(authors, author.Name)
 	}
 
-	// Download images
-	for _, imgURL := range chapter.Images {
-		url := d.resolveImageURL(chapter, imgURL)
-		if url == "" {
-			fmt.Printf("[-] Skipping empty image URL from: %s\n", imgURL)
-			continue
-		}
-		filename := utils.FilenameFromURL(url)
-		if filename == "" {
-			fmt.Printf("[-] Could not get filename from URL: %s\n", url)
-			continue
-		}
-		fmt.Printf("[*] Downloading image: %s -> %s\n", url, filename)
-		d.downloadFile(url, filepath.Join(imagesPath, filename))
+	d.log.Printf("[*] No cover found, generating one from the title\n")
+	data, err := utils.GenerateCover(firstNonEmpty(bookInfo.Title, d.bookID), strings.Join(authors, ", "), d.coverFont)
+	if err != nil {
+		d.log.Printf("[-] Failed to generate cover: %v\n", err)
+		return ""
+	}
+	if err := d.writeFile(filepath.Join(imagesPath, "cover.jpg"), data); err != nil {
+		d.log.Printf("[-] Failed to save generated cover: %v\n", err)
+		return ""
 	}
+	return "cover.jpg"
 }
 
-func (d *Downloader) downloadFile(url, path string) {
-	if utils.FileExists(path) {
-		fmt.Printf("[+] Image already exists: %s\n", filepath.Base(path))
+// writeCoverThumbnail saves a coverThumbWidth-wide JPEG of the cover as
+// coverThumbName; SVG covers are left without one
+func (d *Downloader) writeCoverThumbnail(coverFilename, imagesPath string) {
+	if strings.EqualFold(filepath.Ext(coverFilename), ".svg") {
+		d.log.Printf("[-] Skipping cover thumbnail for SVG cover\n")
 		return
 	}
-
-	resp, err := d.client.Get(url)
+	data, err := os.ReadFile(filepath.Join(imagesPath, coverFilename))
 	if err != nil {
-		fmt.Printf("[-] Failed to download %s: %v\n", url, err)
+		d.log.Printf("[-] Failed to read cover for thumbnail: %v\n", err)
 		return
 	}
-	if !resp.IsSuccess() {
-		fmt.Printf("[-] Failed to download %s: status %d\n", url, resp.StatusCode())
+	thumb, err := utils.Thumbnail(data, coverThumbWidth, d.jpegQuality)
+	if err != nil {
+		d.log.Printf("[-] Failed to create cover thumbnail: %v\n", err)
 		return
 	}
-
-	if err := os.WriteFile(path, resp.Body(), 0644); err != nil {
-		fmt.Printf("[-] Failed to save %s: %v\n", filepath.Base(path), err)
-		return
+	if err := d.writeFile(filepath.Join(imagesPath, coverThumbName), thumb); err != nil {
+		d.log.Printf("[-] Failed to save cover thumbnail: %v\n", err)
 	}
-	fmt.Printf("[+] Downloaded image: %s\n", filepath.Base(path))
 }
 
-func (d *Downloader) resolveImageURL(chapter *models.Chapter, img string) string {
-	chapterBase := chapter.AssetBaseURL
-	apiV2 := strings.Contains(chapter.Content, "/api/v2/")
-
-	if apiV2 {
-		chapterBase = fmt.Sprintf("https://%s/api/v2/epubs/urn:orm:book:%s/files", d.siteURL, d.bookID)
-		return strings.TrimSuffix(chapterBase, "/") + "/" + strings.TrimPrefix(img, "/")
+// coverPageHref returns the page shown as the cover: the book's own cover
+// chapter when it leads the book, otherwise the generated cover page when
+// there is a cover image, or "" for no cover page. generated reports the
+// generated page, since a cover chapter may also be called cover.xhtml.
+func coverPageHref(chapters []models.Chapter, coverFilename string) (href string, generated bool) {
+	if len(chapters) > 0 && isCoverChapter(chapters[0]) {
+		return chapters[0].Filename, false
+	}
+	if coverFilename != "" {
+		return coverPageName, true
 	}
+	return "", false
+}
 
-	return utils.ResolveURL(chapter.AssetBaseURL, img)
+// coverNameRe matches the filenames of cover pages without the extension,
+// such as "cover", "cover1" or "9781492-cover", but not "discovery"
+var coverNameRe = regexp.MustCompile(`^(cover\d*|.+-cover)$`)
+
+// isCoverChapter reports whether a chapter is a cover page by its filename or
+// title. GetBookChapters moves any chapter mentioning "cover" to the front;
+// this is stricter, so a "Discovery" chapter there is not taken for one.
+func isCoverChapter(chapter models.Chapter) bool {
+	name := strings.ToLower(strings.TrimSuffix(path.Base(chapter.Filename), path.Ext(chapter.Filename)))
+	title := strings.ToLower(strings.TrimSpace(chapter.Title))
+	return coverNameRe.MatchString(name) || title == "cover" || title == "cover page"
 }
 
-func (d *Downloader) generateEPUB(bookInfo models.BookInfo, chapters []models.Chapter, bookPath string) error {
-	oebpsPath := filepath.Join(bookPath, "OEBPS")
-	imagesPath := filepath.Join(oebpsPath, "Images")
+// Cover page styles accepted by Options.CoverPageStyle
+const (
+	coverStyleFit         = "fit"          // the whole image, centered, within the page margins
+	coverStyleFill        = "fill"         // the image cropped to fill the page
+	coverStyleSVGViewport = "svg-viewport" // the whole image scaled edge to edge in an inline SVG
+)
 
-	// Download cover image - try to get the largest version
-	var coverFilename string
-	if bookInfo.Cover != "" {
-		coverFilename = d.downloadLargestCover(bookInfo.Cover, imagesPath)
-	} else {
-		fmt.Printf("[-] No cover URL in book info, checking chapters...\n")
-		// Try to find cover in first few chapters
-		coverFilename = d.findCoverInChapters(chapters, imagesPath)
+// validCoverPageStyle reports whether style is a cover page style
+// NewDownloader accepts
+func validCoverPageStyle(style string) bool {
+	switch style {
+	case "", coverStyleFit, coverStyleFill, coverStyleSVGViewport:
+		return true
 	}
+	return false
+}
 
-	// Create cover page (cover.xhtml)
-	if coverFilename != "" {
-		coverPage := fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?>
-<!DOCTYPE html>
+// coverPageUsesSVG reports whether the cover page wraps the cover in an
+// inline <svg>, which EPUB 3 manifests declare
+func coverPageUsesSVG(coverFilename, style string) bool {
+	return style == coverStyleSVGViewport || strings.EqualFold(filepath.Ext(coverFilename), ".svg")
+}
+
+// coverPageXHTML builds the cover page in the given style. SVG covers are
+// always wrapped in an inline <svg>/<image> pair since many readers won't
+// scale an SVG referenced by <img>; the svg-viewport style does the same for
+// raster covers, using their width and height for the viewBox.
+func coverPageXHTML(coverFilename, doctype, style string, width, height int) string {
+	svgCover := strings.EqualFold(filepath.Ext(coverFilename), ".svg")
+	if svgCover || (style == coverStyleSVGViewport && (width <= 0 || height <= 0)) {
+		return fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?>
+%s
 <html xmlns="http://www.w3.org/1999/xhtml">
 <head>
 <title>Cover</title>
 <style type="text/css">
-img { max-width: 100%%; height: auto; }
+body { margin: 0; padding: 0; }
+svg { width: 100%%; height: 100%%; }
 </style>
 </head>
 <body>
 <div style="text-align:center;">
-<img src="Images/%s" alt="Cover"/>
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" version="1.1" width="100%%" height="100%%" preserveAspectRatio="xMidYMid meet">
+<image width="100%%" height="100%%" xlink:href="Images/%s"/>
+</svg>
 </div>
 </body>
-</html>`, coverFilename)
-		os.WriteFile(filepath.Join(oebpsPath, "cover.xhtml"), []byte(coverPage), 0644)
+</html>`, doctype, coverFilename)
 	}
 
-	// Create mimetype
-	os.WriteFile(filepath.Join(bookPath, "mimetype"), []byte("application/epub+zip"), 0644)
+	switch style {
+	case coverStyleSVGViewport:
+		return fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?>
+%s
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head>
+<title>Cover</title>
+<style type="text/css">
+html, body { margin: 0; padding: 0; height: 100%%; }
+svg { display: block; width: 100%%; height: 100%%; }
+</style>
+</head>
+<body>
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" version="1.1" width="100%%" height="100%%" viewBox="0 0 %d %d" preserveAspectRatio="xMidYMid meet">
+<image width="%d" height="%d" xlink:href="Images/%s"/>
+</svg>
+</body>
+</html>`, doctype, width, height, width, height, coverFilename)
+	case coverStyleFill:
+		return fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?>
+%s
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head>
+<title>Cover</title>
+<style type="text/css">
+html, body { margin: 0; padding: 0; height: 100%%; }
+img { display: block; width: 100%%; height: 100%%; object-fit: cover; }
+</style>
+</head>
+<body>
+<img src="Images/%s" alt="Cover"/>
+</body>
+</html>`, doctype, coverFilename)
+	}
 
-	// Create META-INF/container.xml
-	metaInf := filepath.Join(bookPath, "META-INF")
-	os.MkdirAll(metaInf, 0755)
-	containerXML := `<?xml version="1.0"?>
-<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
-<rootfiles>
-<rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml" />
-</rootfiles>
-</container>`
-	os.WriteFile(filepath.Join(metaInf, "container.xml"), []byte(containerXML), 0644)
+	return fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?>
+%s
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head>
+<title>Cover</title>
+<style type="text/css">
+body { margin: 0; padding: 0; }
+img { max-width: 100%%; max-height: 100%%; height: auto; }
+</style>
+</head>
+<body>
+<div style="text-align:center;">
+<img src="Images/%s" alt="Cover"/>
+</div>
+</body>
+</html>`, doctype, coverFilename)
+}
 
-	// Create content.opf and toc.ncx
-	if err := d.writeEPUBMetadata(bookInfo, chapters, oebpsPath, coverFilename); err != nil {
-		return err
+// writeCoverPage writes the generated cover page for coverFilename
+func (d *Downloader) writeCoverPage(oebpsPath, coverFilename string) error {
+	var width, height int
+	if d.coverPageStyle == coverStyleSVGViewport && !strings.EqualFold(filepath.Ext(coverFilename), ".svg") {
+		data, err := os.ReadFile(filepath.Join(oebpsPath, "Images", coverFilename))
+		if err == nil {
+			width, height, err = utils.ImageSize(data)
+		}
+		if err != nil {
+			d.log.Printf("[-] Cover size unknown, scaling it to the page: %v\n", err)
+		}
 	}
-
-	// Zip to EPUB
-	zipPath := bookPath + ".zip"
-	if err := utils.ZipDirectory(bookPath, zipPath); err != nil {
-		return fmt.Errorf("create zip: %w", err)
+	page := coverPageXHTML(coverFilename, d.doctype(), d.coverPageStyle, width, height)
+	if err := d.writeFile(filepath.Join(oebpsPath, coverPageName), []byte(page)); err != nil {
+		return fmt.Errorf("write cover page: %w", err)
 	}
-
-	epubPath := filepath.Join(bookPath, filepath.Base(bookPath)+".epub")
-	return os.Rename(zipPath, epubPath)
+	return nil
 }
 
 func (d *Downloader) writeEPUBMetadata(bookInfo models.BookInfo, chapters []models.Chapter, oebpsPath string, coverFilename string) error {
 	// Print metadata info
-	fmt.Printf("[*] Book: %s\n", bookInfo.Title)
+	d.log.Printf("[*] Book: %s\n", bookInfo.Title)
 	if len(bookInfo.Authors) > 0 {
-		fmt.Printf("[*] Authors: ")
+		names := make([]string, len(bookInfo.Authors))
 		for i, author := range bookInfo.Authors {
-			if i > 0 {
-				fmt.Printf(", ")
-			}
-			fmt.Printf("%s", author.Name)
+			names[i] = author.Name
 		}
-		fmt.Printf("\n")
+		d.log.Printf("[*] Authors: %s\n", strings.Join(names, ", "))
 	} else {
-		fmt.Printf("[*] Authors: Unknown (no author data from API)\n")
+		d.log.Printf("[*] Authors: Unknown (no author data from API)\n")
 	}
 	if len(bookInfo.Publishers) > 0 {
-		fmt.Printf("[*] Publisher: %s\n", bookInfo.Publishers[0].Name)
+		d.log.Printf("[*] Publisher: %s\n", bookInfo.Publishers[0].Name)
+	}
+
+	coverFilename = d.correctImageTypes(oebpsPath, coverFilename)
+	pkg := d.buildPackage(bookInfo, chapters, oebpsPath, coverFilename)
+	opf, err := pkg.Bytes(d.prettyXML)
+	if err != nil {
+		return fmt.Errorf("encode content.opf: %w", err)
+	}
+	// The NCX names the same book as the package, custom metadata included
+	ncx, err := d.buildNCX(pkg.Metadata, chapters).Bytes(d.prettyXML)
+	if err != nil {
+		return fmt.Errorf("encode toc.ncx: %w", err)
 	}
 
-	// Build chapter manifest and spine
-	manifest := ""
-	spine := ""
+	if err := d.writeFile(filepath.Join(oebpsPath, "content.opf"), opf); err != nil {
+		return fmt.Errorf("write content.opf: %w", err)
+	}
+	if err := d.writeFile(filepath.Join(oebpsPath, "toc.ncx"), ncx); err != nil {
+		return fmt.Errorf("write toc.ncx: %w", err)
+	}
+	return nil
+}
 
-	// Add cover page first if we have a cover
-	if coverFilename != "" {
-		manifest += `<item id="cover" href="cover.xhtml" media-type="application/xhtml+xml" />
-`
-		spine += `<itemref idref="cover"/>
-`
+// buildPackage models content.opf for the book's chapters and downloaded images
+func (d *Downloader) buildPackage(bookInfo models.BookInfo, chapters []models.Chapter, oebpsPath string, coverFilename string) epub.Package {
+	pkg := epub.NewPackage()
+	manifest := []epub.Item{{ID: "ncx", Href: "toc.ncx", MediaType: "application/x-dtbncx+xml"}}
+	// The page list needs an EPUB 3 package for readers to find nav.xhtml
+	hasNav := !d.epub2Compat && utils.FileExists(filepath.Join(oebpsPath, navFileName))
+	// page-progression-direction is EPUB 3 only, so a 2.0 package goes without
+	if hasNav {
+		pkg.Spine.PageProgressionDirection = d.pageProgressionDirection()
 	}
 
+	// Add the generated cover page first; a cover chapter already leads the spine
+	coverPage, generatedCover := coverPageHref(chapters, coverFilename)
+	if generatedCover {
+		item := epub.Item{ID: "cover", Href: coverPageName, MediaType: "application/xhtml+xml"}
+		if hasNav && coverPageUsesSVG(coverFilename, d.coverPageStyle) {
+			item.Properties = "svg"
+		}
+		manifest = append(manifest, item)
+		pkg.Spine.ItemRefs = append(pkg.Spine.ItemRefs, epub.ItemRef{IDRef: "cover"})
+	}
+
+	ids := newManifestIDs(d.manifestIDScheme)
+	nonlinear := d.nonlinearSpine(chapters, generatedCover)
 	for i, ch := range chapters {
-		id := fmt.Sprintf("ch%d", i)
-		manifest += fmt.Sprintf(`<item id="%s" href="%s" media-type="application/xhtml+xml" />
-`, id, ch.Filename)
-		spine += fmt.Sprintf(`<itemref idref="%s"/>
-`, id)
+		id := ids.id("ch", i, ch.Filename)
+		manifest = append(manifest, epub.Item{ID: id, Href: ch.Filename, MediaType: "application/xhtml+xml"})
+		ref := epub.ItemRef{IDRef: id}
+		if nonlinear[i] {
+			ref.Linear = "no"
+		}
+		pkg.Spine.ItemRefs = append(pkg.Spine.ItemRefs, ref)
+	}
+	if d.colophon {
+		manifest = append(manifest, epub.Item{ID: "colophon", Href: colophonName, MediaType: "application/xhtml+xml"})
+		pkg.Spine.ItemRefs = append(pkg.Spine.ItemRefs, epub.ItemRef{IDRef: "colophon"})
+	}
+	if d.mergeCSS {
+		manifest = append(manifest, epub.Item{ID: "css", Href: html.MergedCSSHref, MediaType: "text/css"})
+	}
+	if hasNav {
+		pkg.Version = "3.0"
+		manifest = append(manifest, epub.Item{ID: "nav", Href: navFileName, MediaType: "application/xhtml+xml", Properties: "nav"})
 	}
 
 	// Add images to manifest
-	imagesPath := filepath.Join(oebpsPath, "Images")
 	hasCover := false
-	if entries, err := os.ReadDir(imagesPath); err == nil {
+	if entries, err := os.ReadDir(filepath.Join(oebpsPath, "Images")); err == nil {
 		for idx, entry := range entries {
 			if entry.IsDir() {
 				continue
 			}
 			name := entry.Name()
-			ext := strings.ToLower(filepath.Ext(name))
-			mediaType := getImageMediaType(ext)
-
+			mediaType := imageMediaType(filepath.Join(oebpsPath, "Images", name))
+			if d.epub2Compat && !epub2MediaTypes[mediaType] {
+				d.log.Printf("[-] Leaving %s out of the EPUB 2 manifest (%s)\n", name, mediaType)
+				continue
+			}
+			isThumb := d.coverThumbnail && name == coverThumbName && coverFilename != ""
+			// Images left over from an earlier run are not referenced in text-only books
+			if d.noImages && name != coverFilename && !isThumb || d.orphanImages[name] {
+				continue
+			}
+			item := epub.Item{
+				Href:      "Images/" + name,
+				MediaType: mediaType,
+			}
 			// Mark cover image specially
-			if name == coverFilename {
-				manifest += fmt.Sprintf(`<item id="cover-image" href="Images/%s" media-type="%s" />
-`, name, mediaType)
+			switch {
+			case name == coverFilename:
+				item.ID = "cover-image"
 				hasCover = true
-			} else {
-				manifest += fmt.Sprintf(`<item id="img%d" href="Images/%s" media-type="%s" />
-`, idx, name, mediaType)
+			case isThumb:
+				item.ID = "cover-thumb"
+			default:
+				item.ID = ids.id("img", idx, item.Href)
 			}
+			manifest = append(manifest, item)
 		}
 	}
+	pkg.Manifest.Items = manifest
 
-	// Build authors metadata
-	authors := ""
+	meta := &pkg.Metadata
+	meta.Title = epub.Text{Value: bookInfo.Title}
 	for _, author := range bookInfo.Authors {
-		authors += fmt.Sprintf(`<dc:creator>%s</dc:creator>
-`, escapeXML(author.Name))
+		meta.Creators = append(meta.Creators, author.Name)
 	}
-	if authors == "" {
-		authors = `<dc:creator>Unknown</dc:creator>
-`
+	if len(meta.Creators) == 0 {
+		meta.Creators = []string{"Unknown"}
 	}
-
-	// Build publishers
-	publishers := ""
+	meta.Publisher = "Unknown"
 	for _, pub := range bookInfo.Publishers {
 		if pub.Name != "" {
-			publishers += escapeXML(pub.Name)
+			meta.Publisher = pub.Name
 			break
 		}
 	}
-	if publishers == "" {
-		publishers = "Unknown"
+	meta.Description = firstNonEmpty(bookInfo.Description, "No description available")
+	var subjects []string
+	for _, subject := range bookInfo.Subjects {
+		subjects = append(subjects, subject.Name)
+	}
+	meta.Subjects = subjectTags(subjects, d.subjectsAsTags)
+	meta.Language = epub.Text{Value: firstNonEmpty(bookInfo.Language, defaultLanguage)}
+	meta.Identifier = epub.Identifier{ID: "bookid", Value: firstNonEmpty(bookInfo.ISBN, bookInfo.Identifier, d.bookID)}
+	meta.Date = bookInfo.Issued
+	if hasCover {
+		meta.Meta = append(meta.Meta, epub.Meta{Name: "cover", Content: "cover-image"})
+	}
+	if hasNav {
+		meta.Meta = append(meta.Meta, epub.Meta{Property: "dcterms:modified", Value: modifiedDate(bookInfo.Issued, time.Now())})
 	}
 
-	// Build description
-	description := escapeXML(bookInfo.Description)
-	if description == "" {
-		description = "No description available"
+	pkg.MergeMetadata(d.extraMetadata)
+
+	if d.epub2Compat {
+		pkg.Guide = buildGuide(chapters, coverPage)
 	}
 
-	// Add cover metadata if we have a cover
-	coverMeta := ""
-	if hasCover {
-		coverMeta = `<meta name="cover" content="cover-image"/>
-`
-	}
-
-	contentOPF := fmt.Sprintf(`<?xml version="1.0"?>
-<package xmlns="http://www.idpf.org/2007/opf" version="2.0" unique-identifier="bookid">
-<metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
-<dc:title>%s</dc:title>
-%s<dc:publisher>%s</dc:publisher>
-<dc:description>%s</dc:description>
-<dc:language>en</dc:language>
-<dc:identifier id="bookid">%s</dc:identifier>
-<dc:date>%s</dc:date>
-%s</metadata>
-<manifest>
-<item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml" />
-%s</manifest>
-<spine toc="ncx">%s</spine>
-</package>`, escapeXML(bookInfo.Title), authors, publishers, description,
-		firstNonEmpty(bookInfo.ISBN, bookInfo.Identifier, d.bookID),
-		escapeXML(bookInfo.Issued), coverMeta, manifest, spine)
-
-	// Build authors for TOC
-	tocAuthors := ""
-	for _, author := range bookInfo.Authors {
-		tocAuthors += escapeXML(author.Name) + ", "
+	return pkg
+}
+
+// nonlinearSpine reports which chapters match a NonlinearChapters pattern.
+// The spine needs at least one linear item, so nothing is marked when every
+// chapter matches and there is no generated cover page.
+func (d *Downloader) nonlinearSpine(chapters []models.Chapter, hasCoverPage bool) []bool {
+	nonlinear := make([]bool, len(chapters))
+	if len(d.nonlinearChapters) == 0 {
+		return nonlinear
+	}
+	count := 0
+	for i, ch := range chapters {
+		if matchesAny(d.nonlinearChapters, strings.ToLower(ch.Title), strings.ToLower(path.Base(ch.Filename))) {
+			nonlinear[i] = true
+			count++
+		}
 	}
-	tocAuthors = strings.TrimSuffix(tocAuthors, ", ")
-	if tocAuthors == "" {
-		tocAuthors = "Unknown"
+	if count == len(chapters) && !hasCoverPage {
+		d.log.Printf("[-] Every chapter matches --nonlinear-chapters, keeping them all in the reading flow\n")
+		return make([]bool, len(chapters))
 	}
+	return nonlinear
+}
 
-	// Simple toc.ncx
-	navMap := ""
-	for i, ch := range chapters {
-		navMap += fmt.Sprintf(`<navPoint id="ch%d" playOrder="%d">
-<navLabel><text>%s</text></navLabel>
-<content src="%s"/>
-</navPoint>
-`, i, i+1, escapeXML(ch.Title), ch.Filename)
+// matchesAny reports whether any of the glob patterns matches any of the names
+func matchesAny(patterns []string, names ...string) bool {
+	for _, pattern := range patterns {
+		for _, name := range names {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
 	}
+	return false
+}
 
-	tocNCX := fmt.Sprintf(`<?xml version="1.0"?>
-<!DOCTYPE ncx PUBLIC "-//NISO//DTD ncx 2005-1//EN" "http://www.daisy.org/z3986/2005/ncx-2005-1.dtd">
-<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
-<head>
-<meta name="dtb:uid" content="%s"/>
-</head>
-<docTitle><text>%s</text></docTitle>
-<docAuthor><text>%s</text></docAuthor>
-<navMap>
-%s</navMap>
-</ncx>`, firstNonEmpty(bookInfo.ISBN, d.bookID), escapeXML(bookInfo.Title), tocAuthors, navMap)
-
-	os.WriteFile(filepath.Join(oebpsPath, "content.opf"), []byte(contentOPF), 0644)
-	os.WriteFile(filepath.Join(oebpsPath, "toc.ncx"), []byte(tocNCX), 0644)
-	return nil
+// epub2MediaTypes are the image types EPUB 2 readers must support
+var epub2MediaTypes = map[string]bool{
+	"image/gif":     true,
+	"image/jpeg":    true,
+	"image/png":     true,
+	"image/svg+xml": true,
+}
+
+// modifiedDate formats the book's issued date as dcterms:modified requires,
+// so rebuilding an unchanged book yields the same package. A date without a
+// time is taken as midnight UTC; the current time stands in only when the
+// book has no usable date.
+func modifiedDate(issued string, now time.Time) string {
+	const layout = "2006-01-02T15:04:05Z"
+	for _, in := range []string{time.RFC3339, "2006-01-02T15:04:05", time.DateOnly, "2006-01", "2006"} {
+		if t, err := time.Parse(in, strings.TrimSpace(issued)); err == nil {
+			return t.UTC().Format(layout)
+		}
+	}
+	return now.UTC().Format(layout)
+}
+
+// buildGuide points EPUB 2 readers at the cover page and the first chapter
+// after it
+func buildGuide(chapters []models.Chapter, coverPage string) *epub.Guide {
+	guide := &epub.Guide{}
+	if coverPage != "" {
+		guide.References = append(guide.References, epub.Reference{Type: "cover", Title: "Cover", Href: coverPage})
+	}
+	text := chapters
+	if len(text) > 1 && text[0].Filename == coverPage {
+		text = text[1:]
+	}
+	if len(text) > 0 {
+		guide.References = append(guide.References, epub.Reference{Type: "text", Title: firstNonEmpty(text[0].Title, "Start"), Href: text[0].Filename})
+	}
+	if len(guide.References) == 0 {
+		return nil
+	}
+	return guide
+}
+
+// doctype returns the document type declaration for generated XHTML pages
+func (d *Downloader) doctype() string {
+	if d.epub2Compat {
+		return html.XHTML11Doctype
+	}
+	return htmlDoctype
+}
+
+// buildNCX models toc.ncx for the book described by meta, as content.opf has
+// it after any merge, with one navigation point per chapter
+func (d *Downloader) buildNCX(meta epub.Metadata, chapters []models.Chapter) epub.NCX {
+	ncx := epub.NewNCX()
+	ncx.Head.Meta = []epub.Meta{{Name: "dtb:uid", Content: meta.Identifier.Value}}
+	ncx.DocTitle.Text = meta.Title.Value
+	ncx.DocAuthor.Text = firstNonEmpty(strings.Join(meta.CreatorNames(), ", "), "Unknown")
+
+	order := 0
+	for i, link := range d.tocLinks(chapters) {
+		ncx.NavMap.NavPoints = append(ncx.NavMap.NavPoints, ncxNavPoint(fmt.Sprintf("ch%d", i), link, &order))
+	}
+	return ncx
+}
+
+// ncxNavPoint converts a table of contents link and the links below it to
+// NCX nav points, numbered in reading order
+func ncxNavPoint(id string, link navLink, order *int) epub.NavPoint {
+	*order++
+	point := epub.NavPoint{
+		ID:        id,
+		PlayOrder: *order,
+		NavLabel:  epub.NCXText{Text: link.Label},
+		Content:   epub.NavContent{Src: link.Href},
+	}
+	for i, child := range link.Children {
+		point.Children = append(point.Children, ncxNavPoint(fmt.Sprintf("%s-%d", id, i), child, order))
+	}
+	return point
+}
+
+// pageProgressionDirection returns the spine direction: the configured one, or
+// rtl for right-to-left book languages. Auto-detected ltr is left implicit.
+func (d *Downloader) pageProgressionDirection() string {
+	if d.readingDirection != "" {
+		return d.readingDirection
+	}
+	if html.IsRTLLanguage(d.language) {
+		return "rtl"
+	}
+	return ""
+}
+
+// subjectTags normalizes whitespace in subjects and drops case-insensitive
+// duplicates, optionally splitting slash-delimited subjects into separate tags
+func subjectTags(subjects []string, split bool) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, subject := range subjects {
+		parts := []string{subject}
+		if split {
+			parts = strings.Split(subject, "/")
+		}
+		for _, part := range parts {
+			tag := strings.Join(strings.Fields(part), " ")
+			key := strings.ToLower(tag)
+			if tag == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
 }
 
 func getImageMediaType(ext string) string {
@@ -467,45 +2009,87 @@ func getImageMediaType(ext string) string {
 		return "image/svg+xml"
 	case ".webp":
 		return "image/webp"
+	// Fonts inlined stylesheets refer to are saved with the images
+	case ".woff":
+		return "font/woff"
+	case ".woff2":
+		return "font/woff2"
+	case ".ttf":
+		return "font/ttf"
+	case ".otf":
+		return "font/otf"
 	default:
 		return "image/jpeg"
 	}
 }
 
 func (d *Downloader) findCoverInChapters(chapters []models.Chapter, imagesPath string) string {
-	// Look for cover in first 3 chapters
-	for i := 0; i < len(chapters) && i < 3; i++ {
+	// Look for a cover chapter among the leading chapters
+	for i := 0; i < len(chapters) && i < d.coverScanChapters; i++ {
 		ch := &chapters[i]
-		if strings.Contains(strings.ToLower(ch.Title), "cover") ||
-			strings.Contains(strings.ToLower(ch.Filename), "cover") {
-			fmt.Printf("[*] Found cover chapter: %s\n", ch.Title)
-
-			// Download chapter content to get images
-			resp, err := d.client.Get(ch.Content)
-			if err == nil && resp.IsSuccess() {
-				ch.Content = string(resp.Body())
-
-				// If chapter has multiple images, find the largest
-				if len(ch.Images) > 0 {
-					fmt.Printf("[*] Cover chapter has %d images, finding largest...\n", len(ch.Images))
-					return d.findLargestImageFromList(ch, ch.Images, imagesPath)
+		if isCoverChapter(*ch) {
+			d.log.Printf("[*] Found cover chapter: %s\n", ch.Title)
+
+			// If chapter has multiple images, find the largest
+			if images := coverImages(ch); len(images) > 0 {
+				d.log.Printf("[*] Cover chapter has %d images, finding largest...\n", len(images))
+				if coverFilename := d.findLargestImageFromList(ch, images, imagesPath); coverFilename != "" {
+					return coverFilename
 				}
 			}
 		}
 	}
+	if len(chapters) == 0 {
+		return ""
+	}
+
+	// Fall back to an image the first chapter wraps in an SVG, the usual
+	// markup of a full-page cover, then to its first image
+	first := &chapters[0]
+	if svg := svgImages(first); len(svg) > 0 {
+		d.log.Printf("[*] No cover chapter found, trying the SVG image of %s\n", first.Title)
+		if coverFilename := d.findLargestImageFromList(first, svg[:1], imagesPath); coverFilename != "" {
+			return coverFilename
+		}
+	}
+	if len(first.Images) > 0 {
+		d.log.Printf("[*] No cover chapter found, trying first image of %s\n", first.Title)
+		return d.findLargestImageFromList(first, first.Images[:1], imagesPath)
+	}
 	return ""
 }
 
+// svgImages returns the sources of the SVG <image> elements of a downloaded
+// chapter; a chapter not downloaded this run only has its content URL
+func svgImages(chapter *models.Chapter) []string {
+	if !isInlineContent(chapter.Content) {
+		return nil
+	}
+	return html.SVGImageRefs(chapter.Content)
+}
+
+// coverImages returns the images worth trying as a cover chapter's cover:
+// those it wraps in an SVG first, then those the API lists
+func coverImages(chapter *models.Chapter) []string {
+	images := svgImages(chapter)
+	for _, img := range chapter.Images {
+		if !slices.Contains(images, img) {
+			images = append(images, img)
+		}
+	}
+	return images
+}
+
 func (d *Downloader) findLargestImageFromList(chapter *models.Chapter, imageURLs []string, imagesPath string) string {
-	// Try first image with 600w variant
+	// Try first image with the preferred size variant
 	for _, imgURL := range imageURLs {
 		url := d.resolveImageURL(chapter, imgURL)
 		if url == "" {
 			continue
 		}
 
-		// Try to download 600w variant
-		variants := d.generateCoverURLVariants(url)
+		// Try to download the preferred size variant
+		variants := d.coverURLCandidates(url)
 		for _, variantURL := range variants {
 			resp, err := d.client.Get(variantURL)
 			if err != nil || !resp.IsSuccess() {
@@ -515,19 +2099,25 @@ func (d *Downloader) findLargestImageFromList(chapter *models.Chapter, imageURLs
 			data := resp.Body()
 			size := len(data)
 
+			// Guessed variants can land on an HTML error page served with 200
+			if sniffImageType(data) == "" {
+				d.log.Printf("[-] Skipping cover variant %s: not an image (%s)\n", variantURL, cmp.Or(resp.Header().Get("Content-Type"), "unknown type"))
+				continue
+			}
+
 			// Save first successful download
-			ext := ".jpg"
-			if strings.Contains(variantURL, ".png") {
-				ext = ".png"
+			ext := coverExtension(variantURL, resp.Header().Get("Content-Type"), data)
+			if converted, convertedExt, ok := d.transcodeImage(data); ok {
+				data, ext, size = converted, convertedExt, len(converted)
 			}
 
 			coverFilename := "cover" + ext
 			coverFile := filepath.Join(imagesPath, coverFilename)
-			if err := os.WriteFile(coverFile, data, 0644); err != nil {
+			if err := d.writeFile(coverFile, data); err != nil {
 				continue
 			}
 
-			fmt.Printf("[+] Saved cover (%d KB): %s\n", size/1024, coverFilename)
+			d.log.Printf("[+] Saved cover (%d KB): %s\n", size/1024, coverFilename)
 			return coverFilename
 		}
 	}
@@ -535,36 +2125,141 @@ func (d *Downloader) findLargestImageFromList(chapter *models.Chapter, imageURLs
 	return ""
 }
 
+// coverSizeVariants are the size tokens recognised in cover and image URLs
+var coverSizeVariants = []string{
+	"1200w", "800w", "600w", "500w", "400w", "200w",
+	"large", "medium", "small", "thumb",
+}
+
+// generateCoverURLVariants returns the cover URL rewritten to the preferred
+// size first, then 600w (best quality/size balance), then the original URL
 func (d *Downloader) generateCoverURLVariants(coverURL string) []string {
-	// Always use 600w - best quality/size balance
-	sizeVariants := []string{
-		"1200w", "800w", "600w", "500w", "400w", "200w",
-		"large", "medium", "small", "thumb",
+	preferred := firstNonEmpty(d.coverSize, defaultCoverSize)
+
+	var variants []string
+	for _, size := range []string{preferred, defaultCoverSize, coverSizeOriginal} {
+		url := coverURL
+		if size != coverSizeOriginal {
+			url = coverURLWithSize(coverURL, size)
+		}
+		if !slices.Contains(variants, url) {
+			variants = append(variants, url)
+		}
+	}
+	return variants
+}
+
+// coverURLWithSize replaces the size token in coverURL, or appends /<size>/
+// when the URL has none
+func coverURLWithSize(coverURL, size string) string {
+	if sized, ok := urlWithSize(coverURL, size); ok {
+		return sized
+	}
+	return strings.TrimSuffix(coverURL, "/") + "/" + size + "/"
+}
+
+// urlWithSize replaces the last path segment of rawURL that is a size token
+// with size, reporting false when there is none or size is empty. Only whole
+// segments match, so names like "thumbnails/" or "600w-chart.png", and the
+// host and query, are left alone.
+func urlWithSize(rawURL, size string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if size == "" || err != nil {
+		return rawURL, false
+	}
+	segments := strings.Split(u.EscapedPath(), "/")
+	for i := len(segments) - 1; i >= 0; i-- {
+		if !slices.Contains(coverSizeVariants, segments[i]) {
+			continue
+		}
+		segments[i] = size
+		escaped := strings.Join(segments, "/")
+		if u.Path, err = url.PathUnescape(escaped); err != nil {
+			return rawURL, false
+		}
+		u.RawPath = escaped
+		return u.String(), true
+	}
+	return rawURL, false
+}
+
+// coverURLCandidates returns the cover URLs to try in order. Size variants are
+// raster renditions, so with preferSVGCover the original URL and any .svg URLs
+// are tried first.
+func (d *Downloader) coverURLCandidates(coverURL string) []string {
+	variants := d.generateCoverURLVariants(coverURL)
+	if !d.preferSVGCover {
+		return variants
 	}
 
-	// Try replacing any existing size with 600w
-	for _, oldSize := range sizeVariants {
-		if strings.Contains(coverURL, oldSize) {
-			url600w := strings.ReplaceAll(coverURL, oldSize, "600w")
-			return []string{url600w, coverURL} // Try 600w first, then original
+	ordered := []string{coverURL}
+	for _, u := range variants {
+		if u != coverURL && isSVGURL(u) {
+			ordered = append(ordered, u)
 		}
 	}
+	for _, u := range variants {
+		if u != coverURL && !isSVGURL(u) {
+			ordered = append(ordered, u)
+		}
+	}
+	return ordered
+}
+
+// coverExtension picks the cover file extension from the response content type,
+// falling back to sniffing the body and finally the URL
+func coverExtension(url, contentType string, data []byte) string {
+	contentType = strings.ToLower(contentType)
+	switch {
+	case strings.Contains(contentType, "image/svg+xml"):
+		return ".svg"
+	case strings.Contains(contentType, "image/png"):
+		return ".png"
+	case strings.Contains(contentType, "image/jpeg"):
+		return ".jpg"
+	}
+
+	head := strings.ToLower(strings.TrimSpace(string(data[:min(len(data), 512)])))
+	if strings.HasPrefix(head, "<svg") || (strings.HasPrefix(head, "<?xml") && strings.Contains(head, "<svg")) {
+		return ".svg"
+	}
+	if isSVGURL(url) {
+		return ".svg"
+	}
+	if strings.Contains(url, ".png") {
+		return ".png"
+	}
+	return ".jpg"
+}
 
-	// If no size found in URL, try appending /600w/
-	baseURL := strings.TrimSuffix(coverURL, "/")
-	return []string{
-		baseURL + "/600w/",
-		coverURL,
+// validCoverSize reports whether size is "original", a named size, or a width like "1200w"
+func validCoverSize(size string) bool {
+	if size == coverSizeOriginal || slices.Contains(coverSizeVariants, size) {
+		return true
+	}
+	width, ok := strings.CutSuffix(size, "w")
+	if !ok || width == "" {
+		return false
+	}
+	for _, r := range width {
+		if r < '0' || r > '9' {
+			return false
+		}
 	}
+	return true
+}
+
+func isSVGURL(url string) bool {
+	return strings.HasSuffix(strings.ToLower(utils.StripQueryFragment(url)), ".svg")
 }
 
 func (d *Downloader) downloadLargestCover(coverURL, imagesPath string) string {
-	fmt.Printf("[*] Original cover URL: %s\n", coverURL)
+	d.log.Printf("[*] Original cover URL: %s\n", coverURL)
 
-	// Generate possible cover URLs (prefer 600w)
-	possibleURLs := d.generateCoverURLVariants(coverURL)
+	// Generate possible cover URLs (preferred size first)
+	possibleURLs := d.coverURLCandidates(coverURL)
 
-	// Try downloading in order (600w first)
+	// Try downloading in order
 	for _, url := range possibleURLs {
 		resp, err := d.client.Get(url)
 		if err != nil || !resp.IsSuccess() {
@@ -574,36 +2269,33 @@ func (d *Downloader) downloadLargestCover(coverURL, imagesPath string) string {
 		data := resp.Body()
 		size := len(data)
 
+		// Guessed variants can land on an HTML error page served with 200
+		if sniffImageType(data) == "" {
+			d.log.Printf("[-] Skipping cover variant %s: not an image (%s)\n", url, cmp.Or(resp.Header().Get("Content-Type"), "unknown type"))
+			continue
+		}
+
 		// Detect image type
-		ext := ".jpg"
-		if strings.Contains(url, ".png") {
-			ext = ".png"
+		ext := coverExtension(url, resp.Header().Get("Content-Type"), data)
+		if converted, convertedExt, ok := d.transcodeImage(data); ok {
+			data, ext, size = converted, convertedExt, len(converted)
 		}
 
 		coverFilename := "cover" + ext
 		coverFile := filepath.Join(imagesPath, coverFilename)
-		if err := os.WriteFile(coverFile, data, 0644); err != nil {
-			fmt.Printf("[-] Failed to save cover: %v\n", err)
+		if err := d.writeFile(coverFile, data); err != nil {
+			d.log.Printf("[-] Failed to save cover: %v\n", err)
 			continue
 		}
 
-		fmt.Printf("[+] Saved cover (%d KB): %s\n", size/1024, coverFilename)
+		d.log.Printf("[+] Saved cover (%d KB): %s\n", size/1024, coverFilename)
 		return coverFilename
 	}
 
-	fmt.Printf("[-] Failed to download cover from any variant\n")
+	d.log.Printf("[-] Failed to download cover from any variant\n")
 	return ""
 }
 
-func escapeXML(s string) string {
-	s = strings.ReplaceAll(s, "&", "&amp;")
-	s = strings.ReplaceAll(s, "<", "&lt;")
-	s = strings.ReplaceAll(s, ">", "&gt;")
-	s = strings.ReplaceAll(s, "\"", "&quot;")
-	s = strings.ReplaceAll(s, "'", "&apos;")
-	return s
-}
-
 func firstNonEmpty(strs ...string) string {
 	for _, s := range strs {
 		if s != "" {
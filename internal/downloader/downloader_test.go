@@ -0,0 +1,1049 @@
+package downloader
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dacsang97/safaribooks/internal/html"
+	safarihttp "github.com/dacsang97/safaribooks/internal/http"
+	"github.com/dacsang97/safaribooks/internal/models"
+	"github.com/dacsang97/safaribooks/pkg/utils"
+)
+
+// newTestDownloader starts a test server that accepts the auth check and
+// delegates everything else to handler
+func newTestDownloader(t *testing.T, handler http.HandlerFunc, opts Options) (*Downloader, *httptest.Server) {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/profile/" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	tmpDir := t.TempDir()
+	cookiesPath := filepath.Join(tmpDir, "cookies.json")
+	if err := os.WriteFile(cookiesPath, []byte(`{"orm-jwt": "test"}`), 0644); err != nil {
+		t.Fatalf("Failed to write cookies file: %v", err)
+	}
+
+	if opts.BookID == "" {
+		opts.BookID = "123"
+	}
+	opts.CookiesPath = cookiesPath
+	opts.BooksDir = filepath.Join(tmpDir, "Books")
+	opts.SiteURL = server.URL
+
+	d, err := NewDownloader(opts)
+	if err != nil {
+		t.Fatalf("NewDownloader failed: %v", err)
+	}
+	return d, server
+}
+
+func testBookInfo() models.BookInfo {
+	return models.BookInfo{Title: "Test Book", Identifier: "123"}
+}
+
+func TestDownloadLargestCover_SVG(t *testing.T) {
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><rect width="10" height="10"/></svg>`
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write([]byte(svg))
+	}, Options{PreferSVGCover: true})
+
+	oebpsPath := t.TempDir()
+	imagesPath := filepath.Join(oebpsPath, "Images")
+	if err := os.MkdirAll(imagesPath, 0755); err != nil {
+		t.Fatalf("Failed to create images dir: %v", err)
+	}
+
+	coverFilename := d.downloadLargestCover(server.URL+"/covers/123", imagesPath)
+	if coverFilename != "cover.svg" {
+		t.Fatalf("Expected cover.svg, got %q", coverFilename)
+	}
+
+	page := coverPageXHTML(coverFilename, htmlDoctype, coverStyleSVGViewport, 0, 0)
+	if !strings.Contains(page, `<image width="100%" height="100%" xlink:href="Images/cover.svg"/>`) {
+		t.Errorf("Expected cover page to wrap the SVG in <svg>/<image>, got:\n%s", page)
+	}
+	if strings.Contains(page, "<img ") {
+		t.Errorf("Expected no <img> element for SVG cover, got:\n%s", page)
+	}
+
+	if err := d.writeEPUBMetadata(testBookInfo(), nil, oebpsPath, coverFilename); err != nil {
+		t.Fatalf("writeEPUBMetadata failed: %v", err)
+	}
+	opf, err := os.ReadFile(filepath.Join(oebpsPath, "content.opf"))
+	if err != nil {
+		t.Fatalf("Failed to read content.opf: %v", err)
+	}
+	if !strings.Contains(string(opf), `<item id="cover-image" href="Images/cover.svg" media-type="image/svg+xml"></item>`) {
+		t.Errorf("Expected SVG cover manifest item, got:\n%s", opf)
+	}
+}
+
+func TestDownloadLargestCover_HTMLVariants(t *testing.T) {
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<!DOCTYPE html><html><body>Sign in</body></html>"))
+	}, Options{})
+
+	imagesPath := filepath.Join(t.TempDir(), "Images")
+	if err := os.MkdirAll(imagesPath, 0755); err != nil {
+		t.Fatalf("Failed to create images dir: %v", err)
+	}
+
+	if got := d.downloadLargestCover(server.URL+"/covers/123/400w/", imagesPath); got != "" {
+		t.Errorf("Expected no cover from HTML variants, got %q", got)
+	}
+	chapters := []models.Chapter{
+		{Title: "Cover", Filename: "cover.html", AssetBaseURL: server.URL + "/files/", Images: []string{"cover.jpg"}},
+	}
+	if got := d.findCoverInChapters(chapters, imagesPath); got != "" {
+		t.Errorf("Expected no cover from HTML chapter images, got %q", got)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(imagesPath, "cover*")); len(matches) > 0 {
+		t.Errorf("Expected no cover file written, got %v", matches)
+	}
+}
+
+func TestCoverURLCandidates_PreferSVG(t *testing.T) {
+	d := &Downloader{preferSVGCover: true}
+
+	got := d.coverURLCandidates("https://example.com/covers/123/400w/")
+	if got[0] != "https://example.com/covers/123/400w/" {
+		t.Errorf("Expected original URL first, got %v", got)
+	}
+
+	d.preferSVGCover = false
+	got = d.coverURLCandidates("https://example.com/covers/123/400w/")
+	if got[0] != "https://example.com/covers/123/600w/" {
+		t.Errorf("Expected 600w variant first, got %v", got)
+	}
+}
+
+func TestFindCoverInChapters_ScanLimit(t *testing.T) {
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("\xff\xd8\xffjpeg-data"))
+	}, Options{})
+
+	chapters := []models.Chapter{
+		{Title: "Series Page", Filename: "series.html"},
+		{Title: "Praise", Filename: "praise.html"},
+		{Title: "Title Page", Filename: "title.html"},
+		{Title: "Copyright", Filename: "copyright.html"},
+		{Title: "Cover", Filename: "cover.html", AssetBaseURL: server.URL + "/files/", Images: []string{"cover.jpg"}},
+	}
+
+	imagesPath := t.TempDir()
+
+	d.coverScanChapters = 3
+	if got := d.findCoverInChapters(chapters, imagesPath); got != "" {
+		t.Errorf("Expected no cover with a limit of 3, got %q", got)
+	}
+
+	d.coverScanChapters = 5
+	if got := d.findCoverInChapters(chapters, imagesPath); got != "cover.jpg" {
+		t.Errorf("Expected cover.jpg with a limit of 5, got %q", got)
+	}
+}
+
+func TestFindCoverInChapters_FirstImageFallback(t *testing.T) {
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG\r\n\x1a\npng-data"))
+	}, Options{})
+
+	chapters := []models.Chapter{
+		{Title: "Title Page", Filename: "title.html", AssetBaseURL: server.URL + "/files/", Images: []string{"title.png", "logo.png"}},
+	}
+
+	if got := d.findCoverInChapters(chapters, t.TempDir()); got != "cover.png" {
+		t.Errorf("Expected first image fallback to produce cover.png, got %q", got)
+	}
+}
+
+func TestFindCoverInChapters_SVGImage(t *testing.T) {
+	var requested []string
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("\xff\xd8\xffjpeg-data"))
+	}, Options{})
+
+	content := `<html><body><div id="sbo-rt-content"><svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink">` +
+		`<image width="600" height="800" xlink:href="art/front.jpg"/></svg><img src="logo.png"/></div></body></html>`
+	chapters := []models.Chapter{
+		{Title: "Title Page", Filename: "title.html", AssetBaseURL: server.URL + "/files/", Images: []string{"logo.png"}, Content: content},
+	}
+
+	if got := d.findCoverInChapters(chapters, t.TempDir()); got != "cover.jpg" {
+		t.Fatalf("Expected the SVG image to produce cover.jpg, got %q", got)
+	}
+	if len(requested) == 0 || !strings.HasPrefix(requested[0], "/files/art/front.jpg") {
+		t.Errorf("Expected the SVG image to be tried first, requested %v", requested)
+	}
+}
+
+func TestDownloadFile_ContentDisposition(t *testing.T) {
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="figure1.png"`)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png-data"))
+	}, Options{})
+
+	imagesPath := t.TempDir()
+	saved, err := d.downloadFile(server.URL+"/files/abc123", filepath.Join(imagesPath, "abc123"))
+	if err != nil {
+		t.Fatalf("downloadFile failed: %v", err)
+	}
+	if saved != "figure1.png" {
+		t.Fatalf("Expected figure1.png, got %q", saved)
+	}
+	if _, err := os.Stat(filepath.Join(imagesPath, "figure1.png")); err != nil {
+		t.Errorf("Expected figure1.png on disk: %v", err)
+	}
+
+	page := applyImageRenames(`<img src="Images/abc123"/>`, map[string]string{"abc123": saved})
+	if page != `<img src="Images/figure1.png"/>` {
+		t.Errorf("Expected image link to be renamed, got %s", page)
+	}
+}
+
+func TestDownloadFile_ContentTypeExtension(t *testing.T) {
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("jpeg-data"))
+	}, Options{})
+
+	saved, err := d.downloadFile(server.URL+"/files/abc123", filepath.Join(t.TempDir(), "abc123"))
+	if err != nil {
+		t.Fatalf("downloadFile failed: %v", err)
+	}
+	if saved != "abc123.jpg" {
+		t.Errorf("Expected abc123.jpg, got %q", saved)
+	}
+}
+
+func TestDownloadAssets_ReusesRenamedAsset(t *testing.T) {
+	var requests atomic.Int32
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Disposition", `attachment; filename="figure1.png"`)
+		w.Write([]byte("png-data"))
+	}, Options{})
+
+	bookPath := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(bookPath, "OEBPS", "Images"), 0755); err != nil {
+		t.Fatalf("Failed to create dirs: %v", err)
+	}
+	chapter := &models.Chapter{Title: "One", AssetBaseURL: server.URL + "/files/", Images: []string{"abc123"}}
+	want := map[string]string{"abc123": "figure1.png"}
+
+	// A fresh run, a rerun, and a resumed run all link the saved name
+	for _, resume := range []bool{false, false, true} {
+		d.resume = resume
+		if err := d.initState(bookPath); err != nil {
+			t.Fatalf("initState failed: %v", err)
+		}
+		if got := d.downloadAssets(chapter, bookPath); !maps.Equal(got, want) {
+			t.Errorf("resume=%v: expected renames %v, got %v", resume, want, got)
+		}
+		if err := d.state.flush(); err != nil {
+			t.Fatalf("flush failed: %v", err)
+		}
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("Expected the asset downloaded once, got %d requests", got)
+	}
+}
+
+func TestApplyImageRenames_ReferenceForms(t *testing.T) {
+	renames := map[string]string{"abc123": "figure1.png", "a b.webp": "a b.jpg"}
+	page := `<img src="Images/abc123"/><img src='Images/abc123'/><img src="Images/a%20b.webp"/>` +
+		`<img srcset="Images/abc123 2x"/><div style="background: url(Images/abc123)"></div><img src="Images/abc1234"/>`
+	want := `<img src="Images/figure1.png"/><img src='Images/figure1.png'/><img src="Images/a%20b.jpg"/>` +
+		`<img srcset="Images/figure1.png 2x"/><div style="background: url(Images/figure1.png)"></div><img src="Images/abc1234"/>`
+	if got := applyImageRenames(page, renames); got != want {
+		t.Errorf("Unexpected renamed page:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDownloadAssets_ConvertsWebP(t *testing.T) {
+	webpData, err := os.ReadFile(filepath.Join("testdata", "pixel.webp"))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/webp")
+		w.Write(webpData)
+	}, Options{ImageFormat: "jpeg"})
+
+	bookPath := t.TempDir()
+	oebpsPath := filepath.Join(bookPath, "OEBPS")
+	if err := os.MkdirAll(filepath.Join(oebpsPath, "Images"), 0755); err != nil {
+		t.Fatalf("Failed to create dirs: %v", err)
+	}
+	d.state = newBookState(bookPath)
+
+	chapter := &models.Chapter{Title: "One", AssetBaseURL: server.URL + "/files/", Images: []string{"images/fig.webp"}}
+	renames := d.downloadAssets(chapter, bookPath)
+	if renames["fig.webp"] != "fig.jpg" {
+		t.Fatalf("Expected fig.webp to be renamed to fig.jpg, got %v", renames)
+	}
+
+	page := applyImageRenames(`<img src="Images/fig.webp" srcset="Images/fig.webp, Images/fig.webp 2x"/>`, renames)
+	if page != `<img src="Images/fig.jpg" srcset="Images/fig.jpg, Images/fig.jpg 2x"/>` {
+		t.Errorf("Expected img src and srcset to use the jpg, got %s", page)
+	}
+
+	if err := d.writeEPUBMetadata(testBookInfo(), nil, oebpsPath, ""); err != nil {
+		t.Fatalf("writeEPUBMetadata failed: %v", err)
+	}
+	opf, err := os.ReadFile(filepath.Join(oebpsPath, "content.opf"))
+	if err != nil {
+		t.Fatalf("Failed to read content.opf: %v", err)
+	}
+	if !strings.Contains(string(opf), `href="Images/fig.jpg" media-type="image/jpeg"`) {
+		t.Errorf("Expected manifest to list fig.jpg as image/jpeg, got:\n%s", opf)
+	}
+	if strings.Contains(string(opf), "webp") {
+		t.Errorf("Expected no WebP entries in the manifest, got:\n%s", opf)
+	}
+}
+
+func TestDownloadChapter_DumpRaw(t *testing.T) {
+	raw := "<html><body><div id=\"sbo-rt-content\"><p>Raw &amp; <b>unparsed</b></p></div></body></html>\n"
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(raw))
+	}, Options{DumpRaw: true})
+
+	bookPath := t.TempDir()
+	oebpsPath := filepath.Join(bookPath, "OEBPS")
+	if err := os.MkdirAll(oebpsPath, 0755); err != nil {
+		t.Fatalf("Failed to create OEBPS dir: %v", err)
+	}
+	d.state = newBookState(bookPath)
+
+	chapter := models.Chapter{Title: "One", Filename: "ch01.html", Content: server.URL + "/ch01.html"}
+	parser := html.NewParser(server.URL, html.ParserOptions{Language: "en"})
+	if err := d.downloadChapter(oebpsPath, &chapter, false, parser, bookPath); err != nil {
+		t.Fatalf("downloadChapter failed: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(oebpsPath, rawChaptersDir, "ch01.html"))
+	if err != nil {
+		t.Fatalf("Expected raw chapter file: %v", err)
+	}
+	if string(got) != raw {
+		t.Errorf("Expected raw file to hold the original bytes, got:\n%s", got)
+	}
+	if !utils.FileExists(filepath.Join(oebpsPath, "ch01.xhtml")) {
+		t.Errorf("Expected parsed chapter to be written too")
+	}
+}
+
+func TestDownloadChapter_NoDumpByDefault(t *testing.T) {
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<div id="sbo-rt-content"><p>Text</p></div>`))
+	}, Options{})
+
+	bookPath := t.TempDir()
+	oebpsPath := filepath.Join(bookPath, "OEBPS")
+	if err := os.MkdirAll(oebpsPath, 0755); err != nil {
+		t.Fatalf("Failed to create OEBPS dir: %v", err)
+	}
+	d.state = newBookState(bookPath)
+
+	chapter := models.Chapter{Title: "One", Filename: "ch01.html", Content: server.URL + "/ch01.html"}
+	parser := html.NewParser(server.URL, html.ParserOptions{Language: "en"})
+	if err := d.downloadChapter(oebpsPath, &chapter, false, parser, bookPath); err != nil {
+		t.Fatalf("downloadChapter failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(oebpsPath, rawChaptersDir)); !os.IsNotExist(err) {
+		t.Errorf("Expected no raw directory without DumpRaw, got err=%v", err)
+	}
+}
+
+// akamaiChallenge is a trimmed Akamai Bot Manager interstitial
+const akamaiChallenge = `<!DOCTYPE html><html><head><title>Challenge</title></head>` +
+	`<body><div id="sec-if-cpt-container"><script src="/_sec/cp_challenge/ak-challenge-4-3.js"></script></div></body></html>`
+
+func TestDownloadChapter_BotChallenge(t *testing.T) {
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(akamaiChallenge))
+	}, Options{})
+
+	bookPath := t.TempDir()
+	oebpsPath := filepath.Join(bookPath, "OEBPS")
+	imagesPath := filepath.Join(oebpsPath, "Images")
+	if err := os.MkdirAll(imagesPath, 0755); err != nil {
+		t.Fatalf("Failed to create Images dir: %v", err)
+	}
+	d.state = newBookState(bookPath)
+
+	chapter := models.Chapter{Title: "One", Filename: "ch01.html", Content: server.URL + "/ch01.html"}
+	parser := html.NewParser(server.URL, html.ParserOptions{Language: "en"})
+	err := d.downloadChapter(oebpsPath, &chapter, false, parser, bookPath)
+	var challenge *safarihttp.BotChallengeError
+	if !errors.As(err, &challenge) {
+		t.Fatalf("Expected a bot challenge error, got %v", err)
+	}
+	if utils.FileExists(filepath.Join(oebpsPath, "ch01.xhtml")) {
+		t.Error("Expected no chapter file for a challenge page")
+	}
+
+	if _, err := d.downloadFile(server.URL+"/fig1.png", filepath.Join(imagesPath, "fig1.png")); !errors.As(err, &challenge) {
+		t.Errorf("Expected a bot challenge error for the image, got %v", err)
+	}
+	if entries, _ := os.ReadDir(imagesPath); len(entries) != 0 {
+		t.Errorf("Expected no image file for a challenge page, got %v", entries)
+	}
+}
+
+func TestGenerateCoverURLVariants_Preference(t *testing.T) {
+	cases := []struct {
+		size string
+		want []string
+	}{
+		{"", []string{"https://example.com/covers/123/600w/", "https://example.com/covers/123/400w/"}},
+		{"1200w", []string{"https://example.com/covers/123/1200w/", "https://example.com/covers/123/600w/", "https://example.com/covers/123/400w/"}},
+		{"original", []string{"https://example.com/covers/123/400w/", "https://example.com/covers/123/600w/"}},
+		{"200w", []string{"https://example.com/covers/123/200w/", "https://example.com/covers/123/600w/", "https://example.com/covers/123/400w/"}},
+	}
+	for _, c := range cases {
+		d := &Downloader{coverSize: c.size}
+		got := d.generateCoverURLVariants("https://example.com/covers/123/400w/")
+		if strings.Join(got, " ") != strings.Join(c.want, " ") {
+			t.Errorf("Cover size %q: expected %v, got %v", c.size, c.want, got)
+		}
+	}
+}
+
+func TestGenerateCoverURLVariants_NoSizeInURL(t *testing.T) {
+	d := &Downloader{coverSize: "1200w"}
+	got := d.generateCoverURLVariants("https://example.com/covers/123")
+	want := []string{"https://example.com/covers/123/1200w/", "https://example.com/covers/123/600w/", "https://example.com/covers/123"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestURLWithSize(t *testing.T) {
+	cases := []struct {
+		url  string
+		want string
+		ok   bool
+	}{
+		{"https://example.com/images/123/1200w/fig1.png", "https://example.com/images/123/600w/fig1.png", true},
+		{"https://example.com/images/large/fig%201.png?v=2", "https://example.com/images/600w/fig%201.png?v=2", true},
+		{"https://example.com/images/123/fig1.png", "https://example.com/images/123/fig1.png", false},
+		{"https://example.com/thumbnails/600w-chart.png", "https://example.com/thumbnails/600w-chart.png", false},
+		{"https://thumb.example.com/images/fig.png?size=large", "https://thumb.example.com/images/fig.png?size=large", false},
+	}
+	for _, c := range cases {
+		got, ok := urlWithSize(c.url, "600w")
+		if got != c.want || ok != c.ok {
+			t.Errorf("urlWithSize(%q): expected %q, %v, got %q, %v", c.url, c.want, c.ok, got, ok)
+		}
+	}
+	if got, ok := urlWithSize("https://example.com/images/1200w/fig1.png", ""); ok || got != "https://example.com/images/1200w/fig1.png" {
+		t.Errorf("Expected no rewrite without a size, got %q", got)
+	}
+}
+
+func TestDownloadAssets_ImageSize(t *testing.T) {
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/files/600w/small.png":
+			w.Write([]byte("small-600w"))
+		case "/files/1200w/small.png", "/files/1200w/only.png", "/files/plain.png":
+			w.Write([]byte("as-given"))
+		default:
+			http.NotFound(w, r)
+		}
+	}, Options{ImageSize: "600w"})
+
+	bookPath := t.TempDir()
+	imagesPath := filepath.Join(bookPath, "OEBPS", "Images")
+	if err := os.MkdirAll(imagesPath, 0755); err != nil {
+		t.Fatalf("Failed to create dirs: %v", err)
+	}
+	d.state = newBookState(bookPath)
+
+	chapter := &models.Chapter{Title: "One", AssetBaseURL: server.URL + "/files/", Images: []string{"1200w/small.png", "1200w/only.png", "plain.png"}}
+	d.downloadAssets(chapter, bookPath)
+
+	want := map[string]string{
+		"small.png": "small-600w", // rewritten to the preferred size
+		"only.png":  "as-given",   // no 600w rendition, falls back
+		"plain.png": "as-given",   // no size token
+	}
+	for name, content := range want {
+		data, err := os.ReadFile(filepath.Join(imagesPath, name))
+		if err != nil {
+			t.Errorf("Expected %s to be downloaded: %v", name, err)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("Expected %s to contain %q, got %q", name, content, data)
+		}
+	}
+}
+
+func TestNewDownloader_RejectsBadImageSize(t *testing.T) {
+	if _, err := NewDownloader(Options{ImageSize: "huge"}); err == nil {
+		t.Error("Expected an unsupported image size to be rejected")
+	}
+}
+
+func TestNewDownloader_CookieHeaderWithoutSession(t *testing.T) {
+	_, err := NewDownloader(Options{BookID: "123", CookieHeader: "theme=dark", BooksDir: t.TempDir()})
+	var missing *safarihttp.MissingCookiesError
+	if !errors.As(err, &missing) {
+		t.Errorf("Expected a missing cookies error, got %v", err)
+	}
+}
+
+func TestNewDownloader_RejectsNegativeConnectTimeout(t *testing.T) {
+	if _, err := NewDownloader(Options{ConnectTimeout: -time.Second}); err == nil {
+		t.Error("Expected a negative connect timeout to be rejected")
+	}
+}
+
+func TestNewDownloader_ProxyWorkers(t *testing.T) {
+	// The test server is not learning.oreilly.com, so it counts as a proxy
+	d, _ := newTestDownloader(t, http.NotFound, Options{})
+	if d.workers != defaultProxyWorkers {
+		t.Errorf("Expected %d workers through a proxy, got %d", defaultProxyWorkers, d.workers)
+	}
+	d, _ = newTestDownloader(t, http.NotFound, Options{ProxyWorkers: 4})
+	if d.workers != 4 {
+		t.Errorf("Expected --proxy-workers to override the proxy default, got %d", d.workers)
+	}
+	if _, err := NewDownloader(Options{ProxyWorkers: -1}); err == nil {
+		t.Error("Expected negative proxy workers to be rejected")
+	}
+}
+
+func TestValidCoverSize(t *testing.T) {
+	for _, size := range []string{"600w", "1200w", "original", "large"} {
+		if !validCoverSize(size) {
+			t.Errorf("Expected %q to be valid", size)
+		}
+	}
+	for _, size := range []string{"w", "big", "12x0w", "600"} {
+		if validCoverSize(size) {
+			t.Errorf("Expected %q to be invalid", size)
+		}
+	}
+}
+
+func TestDownloadChapter_NoImages(t *testing.T) {
+	var imageRequests int
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".png") {
+			imageRequests++
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("png-data"))
+			return
+		}
+		w.Write([]byte(`<div id="sbo-rt-content"><p>Before</p><img src="Images/fig1.png" alt="Figure 1"/><img src="Images/fig2.png"/></div>`))
+	}, Options{NoImages: true})
+
+	bookPath := t.TempDir()
+	oebpsPath := filepath.Join(bookPath, "OEBPS")
+	if err := os.MkdirAll(filepath.Join(oebpsPath, "Images"), 0755); err != nil {
+		t.Fatalf("Failed to create Images dir: %v", err)
+	}
+	d.state = newBookState(bookPath)
+
+	chapter := models.Chapter{
+		Title:        "One",
+		Filename:     "ch01.html",
+		Content:      server.URL + "/ch01.html",
+		AssetBaseURL: server.URL + "/",
+		Images:       []string{"Images/fig1.png", "Images/fig2.png"},
+	}
+	parser := html.NewParser(server.URL, html.ParserOptions{Language: "en", DropImages: true})
+	if err := d.downloadChapter(oebpsPath, &chapter, false, parser, bookPath); err != nil {
+		t.Fatalf("downloadChapter failed: %v", err)
+	}
+
+	if imageRequests != 0 {
+		t.Errorf("Expected no image requests, got %d", imageRequests)
+	}
+	entries, _ := os.ReadDir(filepath.Join(oebpsPath, "Images"))
+	if len(entries) != 0 {
+		t.Errorf("Expected no images written, got %d", len(entries))
+	}
+
+	page, err := os.ReadFile(filepath.Join(oebpsPath, "ch01.xhtml"))
+	if err != nil {
+		t.Fatalf("Failed to read chapter: %v", err)
+	}
+	if strings.Contains(string(page), "<img") {
+		t.Errorf("Expected image references removed, got:\n%s", page)
+	}
+	if !strings.Contains(string(page), "Figure 1") {
+		t.Errorf("Expected alt text in place of the image, got:\n%s", page)
+	}
+}
+
+func TestBuildPackage_NoImagesKeepsCoverOnly(t *testing.T) {
+	d := &Downloader{bookID: "123", noImages: true}
+
+	oebpsPath := t.TempDir()
+	imagesPath := filepath.Join(oebpsPath, "Images")
+	if err := os.MkdirAll(imagesPath, 0755); err != nil {
+		t.Fatalf("Failed to create Images dir: %v", err)
+	}
+	for _, name := range []string{"cover.jpg", "stale.png"} {
+		if err := os.WriteFile(filepath.Join(imagesPath, name), []byte("data"), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	pkg := d.buildPackage(testBookInfo(), nil, oebpsPath, "cover.jpg")
+	for _, item := range pkg.Manifest.Items {
+		if item.Href == "Images/stale.png" {
+			t.Errorf("Expected no manifest entry for chapter images, got %+v", item)
+		}
+	}
+}
+
+func TestFetchCSS_FetchesOnce(t *testing.T) {
+	var requests int
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "text/css")
+		w.Write([]byte("body { margin: 0; }"))
+	}, Options{InlineCSS: true})
+
+	for range 3 {
+		css, err := d.fetchCSS(server.URL + "/book.css")
+		if err != nil {
+			t.Fatalf("fetchCSS failed: %v", err)
+		}
+		if css != "body { margin: 0; }" {
+			t.Errorf("Unexpected CSS: %q", css)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("Expected 1 request for a cached stylesheet, got %d", requests)
+	}
+}
+
+func TestFetchCSS_SlowSheetDoesNotBlockOthers(t *testing.T) {
+	release := make(chan struct{})
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow.css" {
+			<-release
+		}
+		w.Write([]byte("p { margin: 0; }"))
+	}, Options{InlineCSS: true})
+
+	done := make(chan error)
+	go func() {
+		_, err := d.fetchCSS(server.URL + "/slow.css")
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if _, err := d.fetchCSS(server.URL + "/fast.css"); err != nil {
+		t.Fatalf("fetchCSS failed: %v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("fetchCSS failed: %v", err)
+	}
+}
+
+func TestDownloadChapter_RelativeContentURL(t *testing.T) {
+	var requested string
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.String()
+		w.Write([]byte(`<div id="sbo-rt-content"><p>Text</p></div>`))
+	}, Options{})
+
+	bookPath := t.TempDir()
+	oebpsPath := filepath.Join(bookPath, "OEBPS")
+	if err := os.MkdirAll(oebpsPath, 0755); err != nil {
+		t.Fatalf("Failed to create OEBPS dir: %v", err)
+	}
+	d.state = newBookState(bookPath)
+
+	chapter := models.Chapter{Title: "One", Filename: "ch01.html", Content: "/api/v1/book/123/chapter-content/ch01.html"}
+	if got, want := d.client.ResolveURL(chapter.Content), server.URL+chapter.Content; got != want {
+		t.Errorf("Expected resolved URL %s, got %s", want, got)
+	}
+
+	parser := html.NewParser(server.URL, html.ParserOptions{Language: "en"})
+	if err := d.downloadChapter(oebpsPath, &chapter, false, parser, bookPath); err != nil {
+		t.Fatalf("downloadChapter failed: %v", err)
+	}
+	if requested != "/api/v1/book/123/chapter-content/ch01.html" {
+		t.Errorf("Expected chapter fetched from the site, got request for %q", requested)
+	}
+}
+
+func TestDownloadChapter_InlineContent(t *testing.T) {
+	var requests []string
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.String())
+		http.NotFound(w, r)
+	}, Options{})
+
+	bookPath := t.TempDir()
+	oebpsPath := filepath.Join(bookPath, "OEBPS")
+	if err := os.MkdirAll(oebpsPath, 0755); err != nil {
+		t.Fatalf("Failed to create OEBPS dir: %v", err)
+	}
+	d.state = newBookState(bookPath)
+
+	chapter := models.Chapter{Title: "One", Filename: "ch01.html", Content: "\n  <div id=\"sbo-rt-content\"><p>Inline text</p></div>"}
+	parser := html.NewParser(server.URL, html.ParserOptions{Language: "en"})
+	if err := d.downloadChapter(oebpsPath, &chapter, false, parser, bookPath); err != nil {
+		t.Fatalf("downloadChapter failed: %v", err)
+	}
+	if len(requests) != 0 {
+		t.Errorf("Expected no request for inline content, got %v", requests)
+	}
+	page, err := os.ReadFile(filepath.Join(oebpsPath, "ch01.xhtml"))
+	if err != nil {
+		t.Fatalf("Failed to read chapter: %v", err)
+	}
+	if !strings.Contains(string(page), "<p>Inline text</p>") {
+		t.Errorf("Expected the inline content parsed into the chapter, got:\n%s", page)
+	}
+}
+
+func TestDownloadChapterPages_StartsBeforeListEnds(t *testing.T) {
+	firstChapter := make(chan struct{})
+	var once sync.Once
+	var server *httptest.Server
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/book/123/chapter/" && r.URL.Query().Get("page") == "1":
+			fmt.Fprintf(w, `{"count": 3, "next": "%s/api/v1/book/123/chapter/?page=2", "results": [
+				{"id": "1", "title": "One", "filename": "ch01.html", "content": "%s/ch01.html"},
+				{"id": "0", "title": "Cover", "filename": "cover.html", "content": "%s/cover.html"}]}`, server.URL, server.URL, server.URL)
+		case r.URL.Path == "/api/v1/book/123/chapter/":
+			// The last page only arrives once a chapter of the first is being downloaded
+			select {
+			case <-firstChapter:
+			case <-time.After(5 * time.Second):
+				http.Error(w, "no chapter requested before the last page", http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprintf(w, `{"count": 3, "next": null, "results": [
+				{"id": "2", "title": "Two", "filename": "ch02.html", "content": "%s/ch02.html"}]}`, server.URL)
+		case strings.HasSuffix(r.URL.Path, ".html"):
+			once.Do(func() { close(firstChapter) })
+			fmt.Fprintf(w, `<div id="sbo-rt-content"><p>%s</p></div>`, r.URL.Path)
+		default:
+			http.NotFound(w, r)
+		}
+	}, Options{StreamChapterList: true})
+
+	bookPath := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(bookPath, "OEBPS"), 0755); err != nil {
+		t.Fatalf("Failed to create OEBPS dir: %v", err)
+	}
+	d.state = newBookState(bookPath)
+
+	chapters, err := d.downloadChapterPages(bookPath)
+	if err != nil {
+		t.Fatalf("downloadChapterPages failed: %v", err)
+	}
+	var names []string
+	for _, chapter := range chapters {
+		names = append(names, chapter.Filename)
+	}
+	if want := "cover.xhtml ch01.xhtml ch02.xhtml"; strings.Join(names, " ") != want {
+		t.Errorf("Expected chapters %s with the cover first, got %v", want, names)
+	}
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(bookPath, "OEBPS", name)); err != nil {
+			t.Errorf("Expected %s to be written: %v", name, err)
+		}
+	}
+}
+
+func TestNewDownloader_StreamChapterListConflicts(t *testing.T) {
+	for _, opts := range []Options{
+		{StreamChapterList: true, StreamEPUB: true},
+		{StreamChapterList: true, FlattenNested: true},
+		{StreamChapterList: true, SortChapters: sortNatural},
+	} {
+		if _, err := NewDownloader(opts); err == nil {
+			t.Errorf("Expected %+v to be rejected", opts)
+		}
+	}
+}
+
+func TestNewDownloader_CoverPageStyle(t *testing.T) {
+	if _, err := NewDownloader(Options{BookID: "123", CoverPageStyle: "stretch"}); err == nil {
+		t.Error("Expected an unsupported cover page style to be rejected")
+	}
+}
+
+func TestDownloadChapter_MaxChapterSize(t *testing.T) {
+	huge := `<div id="sbo-rt-content"><p>Start</p>` + strings.Repeat("<p>filler paragraph</p>", 1000) + `<p>End</p></div>`
+
+	for _, skip := range []bool{false, true} {
+		d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(huge))
+		}, Options{MaxChapterSize: 1024, SkipOversized: skip})
+
+		bookPath := t.TempDir()
+		oebpsPath := filepath.Join(bookPath, "OEBPS")
+		if err := os.MkdirAll(oebpsPath, 0755); err != nil {
+			t.Fatalf("Failed to create OEBPS dir: %v", err)
+		}
+		d.state = newBookState(bookPath)
+
+		chapter := models.Chapter{Title: "Huge", Filename: "huge.html", Content: server.URL + "/huge.html"}
+		parser := html.NewParser(server.URL, html.ParserOptions{Language: "en"})
+		if err := d.downloadChapter(oebpsPath, &chapter, false, parser, bookPath); err != nil {
+			t.Fatalf("downloadChapter failed: %v", err)
+		}
+
+		if len(chapter.Content) > 1024 {
+			t.Errorf("Expected chapter body capped at 1024 bytes, got %d", len(chapter.Content))
+		}
+		page, err := os.ReadFile(filepath.Join(oebpsPath, "huge.xhtml"))
+		if err != nil {
+			t.Fatalf("Failed to read chapter: %v", err)
+		}
+		if strings.Contains(string(page), "<p>End</p>") {
+			t.Errorf("Expected the end of an oversized chapter to be cut, got:\n%s", page)
+		}
+		if skip && !strings.Contains(string(page), "exceeded the maximum chapter size") {
+			t.Errorf("Expected skip note, got:\n%s", page)
+		}
+		if !skip && !strings.Contains(string(page), "<p>Start</p>") {
+			t.Errorf("Expected truncated chapter to keep its start, got:\n%s", page)
+		}
+	}
+}
+
+func TestTruncateAtTag(t *testing.T) {
+	if got := string(truncateAtTag([]byte("<p>one</p><di"))); got != "<p>one</p>" {
+		t.Errorf("Expected cut after the last tag, got %q", got)
+	}
+}
+
+func TestWriteGeneratedCover(t *testing.T) {
+	d := &Downloader{bookID: "123", generateCover: true}
+	imagesPath := t.TempDir()
+
+	info := testBookInfo()
+	if err := json.Unmarshal([]byte(`{"authors": [{"name": "Jane Doe"}]}`), &info); err != nil {
+		t.Fatalf("Failed to decode authors: %v", err)
+	}
+	if got := d.writeGeneratedCover(info, imagesPath); got != "cover.jpg" {
+		t.Fatalf("Expected cover.jpg, got %q", got)
+	}
+
+	file, err := os.Open(filepath.Join(imagesPath, "cover.jpg"))
+	if err != nil {
+		t.Fatalf("Expected cover file: %v", err)
+	}
+	defer file.Close()
+	cfg, err := jpeg.DecodeConfig(file)
+	if err != nil {
+		t.Fatalf("Expected a valid JPEG: %v", err)
+	}
+	if cfg.Width != utils.CoverWidth || cfg.Height != utils.CoverHeight {
+		t.Errorf("Expected %dx%d, got %dx%d", utils.CoverWidth, utils.CoverHeight, cfg.Width, cfg.Height)
+	}
+}
+
+func TestWriteCoverThumbnail(t *testing.T) {
+	d := &Downloader{bookID: "123", coverThumbnail: true, jpegQuality: defaultJPEGQuality}
+
+	oebpsPath := t.TempDir()
+	imagesPath := filepath.Join(oebpsPath, "Images")
+	if err := os.MkdirAll(imagesPath, 0755); err != nil {
+		t.Fatalf("Failed to create Images dir: %v", err)
+	}
+	var cover bytes.Buffer
+	if err := png.Encode(&cover, image.NewRGBA(image.Rect(0, 0, 600, 900))); err != nil {
+		t.Fatalf("Failed to encode cover: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(imagesPath, "cover.png"), cover.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write cover: %v", err)
+	}
+
+	d.writeCoverThumbnail("cover.png", imagesPath)
+
+	file, err := os.Open(filepath.Join(imagesPath, coverThumbName))
+	if err != nil {
+		t.Fatalf("Expected thumbnail file: %v", err)
+	}
+	defer file.Close()
+	cfg, err := jpeg.DecodeConfig(file)
+	if err != nil {
+		t.Fatalf("Expected a valid JPEG: %v", err)
+	}
+	if cfg.Width != coverThumbWidth || cfg.Height != 300 {
+		t.Errorf("Expected %dx300, got %dx%d", coverThumbWidth, cfg.Width, cfg.Height)
+	}
+
+	pkg := d.buildPackage(testBookInfo(), nil, oebpsPath, "cover.png")
+	var found bool
+	for _, item := range pkg.Manifest.Items {
+		if item.Href == "Images/"+coverThumbName {
+			found = true
+			if item.ID != "cover-thumb" || item.MediaType != "image/jpeg" {
+				t.Errorf("Unexpected thumbnail manifest item %+v", item)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Expected %s in the manifest, got %+v", coverThumbName, pkg.Manifest.Items)
+	}
+}
+
+func TestCheckXHTML_Modes(t *testing.T) {
+	chapter := &models.Chapter{Title: "One", Filename: "ch01.html"}
+	good := `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>a<br/>b</p></body></html>`
+	bad := "<html>\n<body><p>a<br>b</p></body></html>"
+
+	for _, mode := range []string{"", "warn", "fail"} {
+		d := &Downloader{strictXHTML: mode}
+		if err := d.checkXHTML(chapter, good); err != nil {
+			t.Errorf("%q: expected well-formed chapter to pass, got %v", mode, err)
+		}
+		err := d.checkXHTML(chapter, bad)
+		if mode != "fail" {
+			if err != nil {
+				t.Errorf("%q: expected malformed chapter not to fail, got %v", mode, err)
+			}
+			continue
+		}
+		var xhtmlErr *html.XHTMLError
+		if !errors.As(err, &xhtmlErr) || xhtmlErr.Line != 2 {
+			t.Fatalf("Expected an XHTMLError on line 2, got %v", err)
+		}
+		if !strings.Contains(err.Error(), `"One" (ch01.html)`) {
+			t.Errorf("Expected the chapter in the error, got %v", err)
+		}
+	}
+}
+
+func TestRun_RestrictedTitle(t *testing.T) {
+	d, _ := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/chapter") {
+			http.Error(w, `{"detail": "You do not have permission to perform this action."}`, http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"title": "Locked"}`))
+	}, Options{})
+
+	if err := d.Run(); !errors.Is(err, safarihttp.ErrRestricted) {
+		t.Fatalf("Expected a restricted error, got %v", err)
+	}
+	entries, err := os.ReadDir(d.booksDir)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Expected no book directory for a restricted title, got %v (%v)", entries, err)
+	}
+}
+
+func TestDownloadChapters_EmptyContent(t *testing.T) {
+	for _, mode := range []string{emptyChaptersSkip, emptyChaptersRetry} {
+		t.Run(mode, func(t *testing.T) {
+			var emptyRequests int
+			d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/ch02.html":
+					emptyRequests++
+					w.WriteHeader(http.StatusNoContent)
+				case "/ch03.html":
+					w.Write([]byte("  \n"))
+				default:
+					fmt.Fprintf(w, `<div id="sbo-rt-content"><p>%s</p></div>`, r.URL.Path)
+				}
+			}, Options{EmptyChapters: mode})
+			d.emptyRetryWait = 0
+
+			bookPath := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(bookPath, "OEBPS"), 0755); err != nil {
+				t.Fatalf("Failed to create OEBPS dir: %v", err)
+			}
+			d.state = newBookState(bookPath)
+
+			chapters := []models.Chapter{
+				{Title: "One", Filename: "ch01.html", Content: server.URL + "/ch01.html"},
+				{Title: "Two", Filename: "ch02.html", Content: server.URL + "/ch02.html"},
+				{Title: "Three", Filename: "ch03.html", Content: server.URL + "/ch03.html"},
+				{Title: "Four", Filename: "ch04.html", Content: server.URL + "/ch04.html"},
+			}
+			chapters, err := d.downloadChapters(bookPath, chapters)
+			if err != nil {
+				t.Fatalf("Expected empty chapters not to fail the download, got %v", err)
+			}
+			if got := chapterFilenames(chapters); got != "ch01.xhtml ch04.xhtml" {
+				t.Errorf("Expected the empty chapters left out, got %q", got)
+			}
+			for _, name := range []string{"ch02.xhtml", "ch03.xhtml"} {
+				if _, err := os.Stat(filepath.Join(bookPath, "OEBPS", name)); !os.IsNotExist(err) {
+					t.Errorf("Expected no %s to be written, got %v", name, err)
+				}
+			}
+			if want := map[string]int{emptyChaptersSkip: 1, emptyChaptersRetry: 1 + emptyChapterRetries}[mode]; emptyRequests != want {
+				t.Errorf("Expected %d requests for the empty chapter, got %d", want, emptyRequests)
+			}
+
+			links := d.tocLinks(chapters)
+			if len(links) != 2 || links[1].Href != "ch04.xhtml" {
+				t.Errorf("Expected the TOC without the empty chapters, got %+v", links)
+			}
+		})
+	}
+}
+
+func TestChapterStream_SkipsEmptyChapter(t *testing.T) {
+	bookPath := filepath.Join(t.TempDir(), "book")
+	d := &Downloader{}
+	if err := d.openChapterStream(bookPath, []models.Chapter{{Filename: "ch01.html"}, {Filename: "ch02.html"}, {Filename: "ch03.html"}}); err != nil {
+		t.Fatalf("openChapterStream failed: %v", err)
+	}
+	defer d.stream.abort()
+
+	if err := d.stream.put("ch03.xhtml", []byte("<p>3</p>")); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	if err := d.stream.put("ch01.xhtml", []byte("<p>1</p>")); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	if err := d.stream.skip("ch02.xhtml"); err != nil {
+		t.Fatalf("skip failed: %v", err)
+	}
+	if err := d.stream.complete(); err != nil {
+		t.Errorf("Expected every chapter written or skipped, got %v", err)
+	}
+}
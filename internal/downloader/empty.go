@@ -0,0 +1,86 @@
+package downloader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/dacsang97/safaribooks/internal/models"
+)
+
+// What to do with a chapter whose content comes back empty, accepted by
+// Options.EmptyChapters
+const (
+	emptyChaptersSkip  = "skip"  // leave it out of the book
+	emptyChaptersRetry = "retry" // fetch it again a few times, then leave it out
+)
+
+const (
+	// emptyChapterRetries is how many more times the retry mode fetches an
+	// empty chapter
+	emptyChapterRetries = 3
+
+	// defaultEmptyRetryWait is the pause before each of those fetches
+	defaultEmptyRetryWait = 2 * time.Second
+)
+
+// errEmptyChapter reports a chapter whose content URL answered with no body,
+// e.g. 204 No Content; the chapter pool leaves such chapters out of the book
+var errEmptyChapter = errors.New("chapter content is empty")
+
+// validEmptyChapters reports whether mode is an empty chapter mode
+// NewDownloader accepts
+func validEmptyChapters(mode string) bool {
+	switch mode {
+	case "", emptyChaptersSkip, emptyChaptersRetry:
+		return true
+	}
+	return false
+}
+
+// nonEmptyChapterBody is chapterBody, failing with errEmptyChapter when the
+// content is empty or only whitespace. In the retry mode the content is
+// fetched again first, since the API sometimes serves a chapter empty once.
+func (d *Downloader) nonEmptyChapterBody(chapter *models.Chapter) ([]byte, bool, error) {
+	body, truncated, err := d.chapterBody(chapter)
+	for attempt := 1; err == nil && isEmptyBody(body) && d.emptyChapters == emptyChaptersRetry && attempt <= emptyChapterRetries; attempt++ {
+		d.log.With("chapter", chapter.Title).Printf("[-] Chapter %s is empty, fetching it again (%d/%d)\n", chapter.Title, attempt, emptyChapterRetries)
+		time.Sleep(d.emptyRetryWait)
+		body, truncated, err = d.chapterBody(chapter)
+	}
+	if err == nil && isEmptyBody(body) {
+		return nil, false, fmt.Errorf("%s: %w", chapter.Title, errEmptyChapter)
+	}
+	return body, truncated, err
+}
+
+// isEmptyBody reports whether a chapter body has no content at all
+func isEmptyBody(body []byte) bool {
+	return len(bytes.TrimSpace(body)) == 0
+}
+
+// dropEmpty returns chapters without those the pool found empty, along with
+// their stylesheets collected for mergeCSS. It is called after wait.
+func (p *chapterPool) dropEmpty(chapters []models.Chapter) []models.Chapter {
+	if len(p.empty) == 0 {
+		return chapters
+	}
+	kept := make([]models.Chapter, 0, len(chapters)-len(p.empty))
+	var css [][]string
+	for i, chapter := range chapters {
+		if p.empty[i] {
+			continue
+		}
+		kept = append(kept, chapter)
+		if i < len(p.d.chapterCSS) {
+			css = append(css, p.d.chapterCSS[i])
+		}
+	}
+	if p.d.chapterCSS != nil {
+		p.d.chapterCSS = slices.Clip(css)
+	}
+	p.d.log.Printf("[-] Left %d empty chapters out of the book\n", len(p.empty))
+	return kept
+}
@@ -0,0 +1,70 @@
+package downloader
+
+import (
+	"path"
+	"strings"
+
+	"github.com/dacsang97/safaribooks/internal/models"
+	"github.com/dacsang97/safaribooks/pkg/utils"
+)
+
+// assetIndex maps in-book asset paths from the v2 files listing to their
+// download URLs. A nil index resolves nothing.
+type assetIndex struct {
+	urls  map[string]string
+	paths []string // listing order, for suffix matches
+}
+
+// newAssetIndex builds an index from the v2 files listing
+func newAssetIndex(files []models.BookFile) *assetIndex {
+	idx := &assetIndex{urls: make(map[string]string, len(files))}
+	for _, file := range files {
+		key := cleanAssetPath(file.FullPath)
+		if key == "" || file.URL == "" {
+			continue
+		}
+		if _, ok := idx.urls[key]; ok {
+			continue
+		}
+		idx.urls[key] = file.URL
+		idx.paths = append(idx.paths, key)
+	}
+	return idx
+}
+
+// lookup returns the download URL for an image referenced from chapter,
+// trying the path as given, relative to the chapter, and finally any listed
+// path ending with it
+func (idx *assetIndex) lookup(chapter *models.Chapter, img string) (string, bool) {
+	if idx == nil || len(idx.urls) == 0 || img == "" || utils.IsAbsoluteURL(img) {
+		return "", false
+	}
+
+	ref := cleanAssetPath(img)
+	candidates := []string{ref}
+	if dir := path.Dir(chapter.Filename); dir != "." && !strings.HasPrefix(img, "/") {
+		candidates = append(candidates, cleanAssetPath(path.Join(dir, utils.StripQueryFragment(img))))
+	}
+	for _, candidate := range candidates {
+		if url, ok := idx.urls[candidate]; ok {
+			return url, true
+		}
+	}
+
+	suffix := "/" + strings.TrimLeft(ref, "./")
+	for _, p := range idx.paths {
+		if strings.HasSuffix(p, suffix) {
+			return idx.urls[p], true
+		}
+	}
+	return "", false
+}
+
+// cleanAssetPath normalizes an in-book path for lookups
+func cleanAssetPath(p string) string {
+	p = strings.TrimPrefix(utils.StripQueryFragment(p), "/")
+	if p == "" {
+		return ""
+	}
+	return path.Clean(p)
+}
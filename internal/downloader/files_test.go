@@ -0,0 +1,72 @@
+package downloader
+
+import (
+	"testing"
+
+	"github.com/dacsang97/safaribooks/internal/models"
+	"github.com/dacsang97/safaribooks/pkg/utils"
+)
+
+func testAssetIndex() *assetIndex {
+	return newAssetIndex([]models.BookFile{
+		{FullPath: "OEBPS/ch01.html", URL: "https://example.com/api/v2/epubs/urn:orm:book:123/files/OEBPS/ch01.html"},
+		{FullPath: "OEBPS/assets/fig1.png", URL: "https://cdn.example.com/files/fig1.png"},
+		{FullPath: "OEBPS/Images/cover.jpg", URL: "https://cdn.example.com/files/cover.jpg"},
+	})
+}
+
+func TestResolveImageURL_V2FilesMap(t *testing.T) {
+	d := &Downloader{assets: testAssetIndex()}
+
+	cases := []struct {
+		chapter models.Chapter
+		img     string
+		want    string
+	}{
+		{models.Chapter{Filename: "OEBPS/ch01.html"}, "assets/fig1.png", "https://cdn.example.com/files/fig1.png"},
+		{models.Chapter{Filename: "ch01.html"}, "assets/fig1.png", "https://cdn.example.com/files/fig1.png"},
+		{models.Chapter{Filename: "OEBPS/text/ch02.html"}, "../Images/cover.jpg", "https://cdn.example.com/files/cover.jpg"},
+		{models.Chapter{Filename: "ch01.html"}, "/OEBPS/assets/fig1.png", "https://cdn.example.com/files/fig1.png"},
+	}
+	for _, c := range cases {
+		if got := d.resolveImageURL(&c.chapter, c.img); got != c.want {
+			t.Errorf("resolveImageURL(%s, %s) = %s, want %s", c.chapter.Filename, c.img, got, c.want)
+		}
+	}
+}
+
+func TestResolveImageURL_FallsBackToAssetBase(t *testing.T) {
+	chapter := &models.Chapter{
+		Filename:     "ch01.html",
+		AssetBaseURL: "https://example.com/library/view/book/",
+		// Content mentioning the v2 API used to force v2 resolution
+		Content: `<a href="/api/v2/epubs/">`,
+	}
+
+	for _, d := range []*Downloader{{}, {assets: testAssetIndex()}} {
+		if got := d.resolveImageURL(chapter, "graphics/missing.png"); got != "https://example.com/library/view/book/graphics/missing.png" {
+			t.Errorf("Expected asset base URL fallback, got %s", got)
+		}
+	}
+}
+
+func TestResolveImageURL_MissingAssetBase(t *testing.T) {
+	chapter := &models.Chapter{Filename: "ch01.html"}
+	cases := []struct {
+		siteURL string
+		want    string
+	}{
+		{"learning.oreilly.com", "https://learning.oreilly.com/api/v2/epubs/urn:orm:book:9781234567890/files/graphics/fig1.png"},
+		{"http://127.0.0.1:8080/", "http://127.0.0.1:8080/api/v2/epubs/urn:orm:book:9781234567890/files/graphics/fig1.png"},
+	}
+	for _, c := range cases {
+		d := &Downloader{bookID: "9781234567890", siteURL: c.siteURL}
+		got := d.resolveImageURL(chapter, "graphics/fig1.png")
+		if got != c.want {
+			t.Errorf("resolveImageURL on %s = %s, want %s", c.siteURL, got, c.want)
+		}
+		if !utils.IsAbsoluteURL(got) {
+			t.Errorf("Expected an absolute URL, got %s", got)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package downloader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dacsang97/safaribooks/internal/models"
+	"github.com/dacsang97/safaribooks/pkg/utils"
+)
+
+// flatBuildPrefix starts the name of the temporary directories a flat
+// output builds books in, hidden so library tools watching BooksDir skip them
+const flatBuildPrefix = ".safaribooks-build-"
+
+// createBuildDirectory creates the directory the book is built in and
+// returns it with a function removing what is left of it once the EPUB is
+// published. That is the book directory, kept as is, unless flatOutput is
+// set: then it is a temporary directory inside BooksDir, so publishEPUB only
+// renames the EPUB within one file system.
+func (d *Downloader) createBuildDirectory(bookInfo models.BookInfo) (string, func(), error) {
+	if !d.flatOutput {
+		bookPath, err := d.createBookDirectory(bookInfo)
+		return bookPath, func() {}, err
+	}
+
+	if err := d.mkdir(d.booksDir); err != nil {
+		return "", nil, fmt.Errorf("create directory %s: %w", d.booksDir, err)
+	}
+	buildDir, err := os.MkdirTemp(d.booksDir, flatBuildPrefix+d.bookID+"-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("create build directory: %w", err)
+	}
+	cleanup := func() {
+		if err := os.RemoveAll(buildDir); err != nil {
+			d.log.Printf("[-] Failed to remove build directory: %v\n", err)
+		}
+	}
+
+	bookPath := filepath.Join(buildDir, filepath.Base(d.bookDirectory(bookInfo)))
+	if err := d.createBookTree(bookPath); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return bookPath, cleanup, nil
+}
+
+// publishEPUB returns the path of the EPUB built in bookPath, first moving
+// it into BooksDir when flatOutput is set
+func (d *Downloader) publishEPUB(bookPath string) (string, error) {
+	name := filepath.Base(bookPath) + ".epub"
+	epubPath := filepath.Join(bookPath, name)
+	if !d.flatOutput {
+		return epubPath, nil
+	}
+
+	dest := filepath.Join(d.booksDir, name)
+	if err := utils.RenameDurable(epubPath, dest); err != nil {
+		return "", fmt.Errorf("move EPUB into %s: %w", d.booksDir, err)
+	}
+	return dest, nil
+}
@@ -0,0 +1,59 @@
+package downloader
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRun_FlatOutput(t *testing.T) {
+	var server *httptest.Server
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/book/123/":
+			w.Write([]byte(`{"title": "Test Book", "identifier": "123"}`))
+		case r.URL.Path == "/api/v1/book/123/chapter/":
+			fmt.Fprintf(w, `{"count": 1, "next": null, "results": [
+				{"id": "1", "title": "One", "filename": "ch01.html", "content": "%s/ch01.html"}]}`, server.URL)
+		case strings.HasSuffix(r.URL.Path, ".html"):
+			w.Write([]byte(`<div id="sbo-rt-content"><p>One</p></div>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}, Options{FlatOutput: true})
+
+	if err := d.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	entries, err := os.ReadDir(d.booksDir)
+	if err != nil {
+		t.Fatalf("Failed to read output dir: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	want := []string{"Test Book (123).epub", "Test Book (123).epub.sha256"}
+	if !slices.Equal(names, want) {
+		t.Errorf("Expected only %v in the output dir, got %v", want, names)
+	}
+}
+
+func TestNewDownloader_FlatOutputConflicts(t *testing.T) {
+	for _, opts := range []Options{
+		{FlatOutput: true, Resume: true},
+		{FlatOutput: true, IfModified: true},
+		{FlatOutput: true, IncludeFiles: true},
+		{FlatOutput: true, DumpRaw: true},
+		{FlatOutput: true, OnComplete: "ls {dir}"},
+	} {
+		if _, err := NewDownloader(opts); err == nil {
+			t.Errorf("Expected an error for %+v", opts)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package downloader
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/dacsang97/safaribooks/internal/models"
+)
+
+// mockBook is a book the mock O'Reilly server serves: its info, chapter
+// list and chapter pages through the API paths Run uses, and any other
+// file, such as images and stylesheets, under /assets/. Features extend it
+// by adding chapters and files, or by handling requests ahead of it.
+type mockBook struct {
+	ID       string            // "123" when empty
+	Info     map[string]any    // book info; title "Test Book" and the ID are filled in
+	Chapters []mockChapter     // in API order
+	Files    map[string]string // body per path under /assets/, typed by extension
+	Cover    []byte            // served for any /covers/ path when set
+
+	// Handle serves a request ahead of the mock when it returns true
+	Handle func(w http.ResponseWriter, r *http.Request) bool
+}
+
+// mockChapter is a chapter of a mockBook; its page is served at
+// /content/<Filename> and its images and stylesheets are relative to /assets/
+type mockChapter struct {
+	Title       string
+	Filename    string
+	Body        string // the book content, wrapped in div#sbo-rt-content
+	Images      []string
+	Stylesheets []string
+}
+
+// imageSrcRe matches the Images/ files a page shows
+var imageSrcRe = regexp.MustCompile(`src="(Images/[^"]+)"`)
+
+// mockPNG returns a w by h PNG image
+func mockPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// handler serves the book from server
+func (b *mockBook) handler(t *testing.T, server **httptest.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if b.Handle != nil && b.Handle(w, r) {
+			return
+		}
+		base := (*server).URL
+		id := b.bookID()
+		switch p := r.URL.Path; {
+		case p == "/api/v1/book/"+id+"/":
+			info := map[string]any{"title": "Test Book", "identifier": id}
+			for k, v := range b.Info {
+				info[k] = v
+			}
+			if b.Cover != nil {
+				info["cover"] = base + "/covers/" + id + "/"
+			}
+			writeJSON(t, w, info)
+		case p == "/api/v1/book/"+id+"/chapter/":
+			results := make([]map[string]any, len(b.Chapters))
+			for i, ch := range b.Chapters {
+				sheets := make([]map[string]string, len(ch.Stylesheets))
+				for j, sheet := range ch.Stylesheets {
+					sheets[j] = map[string]string{"url": sheet}
+				}
+				results[i] = map[string]any{
+					"id":             ch.Filename,
+					"title":          ch.Title,
+					"filename":       ch.Filename,
+					"content":        base + "/content/" + ch.Filename,
+					"asset_base_url": base + "/assets/",
+					"images":         ch.Images,
+					"stylesheets":    sheets,
+				}
+			}
+			writeJSON(t, w, map[string]any{"count": len(results), "next": nil, "results": results})
+		case strings.HasPrefix(p, "/content/"):
+			for _, ch := range b.Chapters {
+				if ch.Filename == strings.TrimPrefix(p, "/content/") {
+					io.WriteString(w, `<html><body><div id="sbo-rt-content">`+ch.Body+`</div></body></html>`)
+					return
+				}
+			}
+			http.NotFound(w, r)
+		case strings.HasPrefix(p, "/covers/") && b.Cover != nil:
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(b.Cover)
+		case strings.HasPrefix(p, "/assets/"):
+			body, ok := b.Files[strings.TrimPrefix(p, "/assets/")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			if path.Ext(p) == ".css" {
+				w.Header().Set("Content-Type", "text/css")
+			}
+			io.WriteString(w, body)
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
+
+func (b *mockBook) bookID() string {
+	return firstNonEmpty(b.ID, "123")
+}
+
+// bookInfo returns the title and identifier the book is saved under
+func (b *mockBook) bookInfo() models.BookInfo {
+	title, _ := b.Info["title"].(string)
+	return models.BookInfo{Title: firstNonEmpty(title, "Test Book"), Identifier: b.bookID()}
+}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, v any) {
+	t.Helper()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		t.Errorf("Failed to encode mock response: %v", err)
+	}
+}
+
+// newMockDownloader returns a downloader of book from a mock server with opts
+func newMockDownloader(t *testing.T, book *mockBook, opts Options) *Downloader {
+	t.Helper()
+	var server *httptest.Server
+	opts.BookID = book.bookID()
+	d, server := newTestDownloader(t, book.handler(t, &server), opts)
+	return d
+}
+
+// runMockBook downloads book from a mock server with opts and returns the
+// downloader and the path of the EPUB it wrote in the book's directory
+func runMockBook(t *testing.T, book *mockBook, opts Options) (*Downloader, string) {
+	t.Helper()
+	d := newMockDownloader(t, book, opts)
+	if err := d.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	bookPath := d.bookDirectory(book.bookInfo())
+	return d, filepath.Join(bookPath, filepath.Base(bookPath)+".epub")
+}
+
+// epubArchive is an EPUB read back for assertions: its files by name, in
+// archive order, and its package document
+type epubArchive struct {
+	Names    []string
+	Files    map[string][]byte
+	Manifest []mockManifestItem
+	Spine    []string
+}
+
+// mockManifestItem is a manifest item of an epubArchive
+type mockManifestItem struct {
+	ID        string `xml:"id,attr"`
+	Href      string `xml:"href,attr"`
+	MediaType string `xml:"media-type,attr"`
+}
+
+// readValidEPUB reads the EPUB at epubPath, failing t unless its structure
+// holds up: the stored mimetype first, a container pointing at
+// content.opf, a manifest of unique IDs naming files that exist, a spine of
+// manifest items, well-formed XHTML, and every Images/ file a page shows
+func readValidEPUB(t *testing.T, epubPath string) *epubArchive {
+	t.Helper()
+	r, err := zip.OpenReader(epubPath)
+	if err != nil {
+		t.Fatalf("Failed to open EPUB: %v", err)
+	}
+	defer r.Close()
+
+	book := &epubArchive{Files: make(map[string][]byte)}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", f.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", f.Name, err)
+		}
+		book.Names = append(book.Names, f.Name)
+		book.Files[f.Name] = data
+	}
+
+	if len(r.File) == 0 || r.File[0].Name != "mimetype" || r.File[0].Method != zip.Store {
+		t.Fatalf("Expected a stored mimetype entry first, got %v", book.Names)
+	}
+	if got := string(book.Files["mimetype"]); got != epubMimetype {
+		t.Errorf("Expected mimetype %q, got %q", epubMimetype, got)
+	}
+	if !bytes.Contains(book.Files["META-INF/container.xml"], []byte(`full-path="OEBPS/content.opf"`)) {
+		t.Errorf("Expected META-INF/container.xml to point at OEBPS/content.opf, got %q", book.Files["META-INF/container.xml"])
+	}
+
+	var opf struct {
+		Manifest struct {
+			Items []mockManifestItem `xml:"item"`
+		} `xml:"manifest"`
+		Spine struct {
+			ItemRefs []struct {
+				IDRef string `xml:"idref,attr"`
+			} `xml:"itemref"`
+		} `xml:"spine"`
+	}
+	if err := xml.Unmarshal(book.Files["OEBPS/content.opf"], &opf); err != nil {
+		t.Fatalf("Failed to parse content.opf: %v", err)
+	}
+	ids := make(map[string]bool)
+	for _, item := range opf.Manifest.Items {
+		if ids[item.ID] {
+			t.Errorf("Manifest ID %q is used twice", item.ID)
+		}
+		ids[item.ID] = true
+		if _, ok := book.Files["OEBPS/"+item.Href]; !ok {
+			t.Errorf("Manifest item %s names missing file %s", item.ID, item.Href)
+		}
+	}
+	book.Manifest = opf.Manifest.Items
+	for _, ref := range opf.Spine.ItemRefs {
+		if !ids[ref.IDRef] {
+			t.Errorf("Spine refers to unknown manifest item %q", ref.IDRef)
+		}
+		book.Spine = append(book.Spine, ref.IDRef)
+	}
+
+	for name, data := range book.Files {
+		if !strings.HasSuffix(name, ".xhtml") {
+			continue
+		}
+		dec := xml.NewDecoder(bytes.NewReader(data))
+		for {
+			if _, err := dec.Token(); err != nil {
+				if !errors.Is(err, io.EOF) {
+					t.Errorf("%s is not well-formed: %v", name, err)
+				}
+				break
+			}
+		}
+		for _, src := range imageSrcRe.FindAllStringSubmatch(string(data), -1) {
+			if _, ok := book.Files["OEBPS/"+src[1]]; !ok {
+				t.Errorf("%s shows missing image %s", name, src[1])
+			}
+		}
+	}
+	return book
+}
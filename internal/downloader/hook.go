@@ -0,0 +1,53 @@
+package downloader
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// runOnComplete runs the --on-complete command for a finished book, with
+// {epub} and {dir} replaced by the quoted EPUB and book directory paths.
+// A failing command is only a warning unless hookStrict is set.
+func (d *Downloader) runOnComplete(epubPath, bookPath string) error {
+	if d.onComplete == "" {
+		return nil
+	}
+
+	command := strings.NewReplacer(
+		"{epub}", shellQuote(epubPath),
+		"{dir}", shellQuote(bookPath),
+	).Replace(d.onComplete)
+
+	d.log.Printf("[*] Running on-complete hook: %s\n", command)
+	output, err := hookCommand(command).CombinedOutput()
+	if len(output) > 0 {
+		d.log.Printf("%s\n", strings.TrimSuffix(string(output), "\n"))
+	}
+	if err == nil {
+		return nil
+	}
+
+	if d.hookStrict {
+		return fmt.Errorf("on-complete hook: %w", err)
+	}
+	d.log.Printf("[-] Warning: on-complete hook failed: %v\n", err)
+	return nil
+}
+
+// hookCommand runs command through the platform shell
+func hookCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
+
+// shellQuote quotes a path for the platform shell
+func shellQuote(s string) string {
+	if runtime.GOOS == "windows" {
+		return `"` + s + `"`
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,57 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestRunOnComplete_Placeholders(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Hook test uses a POSIX shell")
+	}
+
+	dir := filepath.Join(t.TempDir(), "Book's Title (123)")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create book dir: %v", err)
+	}
+	epubPath := filepath.Join(dir, "Book's Title (123).epub")
+	out := filepath.Join(dir, "hook.out")
+
+	d := &Downloader{onComplete: "printf '%s|%s' {epub} {dir} > " + shellQuote(out)}
+	if err := d.runOnComplete(epubPath, dir); err != nil {
+		t.Fatalf("runOnComplete failed: %v", err)
+	}
+
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("Expected hook to run: %v", err)
+	}
+	if want := epubPath + "|" + dir; string(got) != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestRunOnComplete_FailureIsWarningUnlessStrict(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Hook test uses a POSIX shell")
+	}
+
+	d := &Downloader{onComplete: "exit 3"}
+	if err := d.runOnComplete("book.epub", "."); err != nil {
+		t.Errorf("Expected failing hook to be a warning, got %v", err)
+	}
+
+	d.hookStrict = true
+	if err := d.runOnComplete("book.epub", "."); err == nil {
+		t.Error("Expected failing hook to be an error with hookStrict")
+	}
+}
+
+func TestRunOnComplete_Disabled(t *testing.T) {
+	d := &Downloader{}
+	if err := d.runOnComplete("book.epub", "."); err != nil {
+		t.Errorf("Expected no-op without a hook, got %v", err)
+	}
+}
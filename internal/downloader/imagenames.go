@@ -0,0 +1,81 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/dacsang97/safaribooks/internal/models"
+	"github.com/dacsang97/safaribooks/pkg/utils"
+)
+
+// imageNames assigns each image URL the file it is saved as under Images/:
+// its base name, unless another URL already has that name, in which case
+// the name gets a hash of the URL so different images never share a file
+type imageNames struct {
+	mu     sync.Mutex
+	byName map[string]string // file name -> URL saved under it
+	byURL  map[string]string // URL -> file name
+}
+
+// name returns the file url is saved as, claiming base for it when free
+func (n *imageNames) name(url, base string) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if name, ok := n.byURL[url]; ok {
+		return name
+	}
+	if n.byName == nil {
+		n.byName = make(map[string]string)
+		n.byURL = make(map[string]string)
+	}
+
+	name := base
+	if owner, taken := n.byName[name]; taken && owner != url {
+		name = hashedImageName(base, url)
+	}
+	n.byName[name] = url
+	n.byURL[url] = name
+	return name
+}
+
+// hashedImageName inserts a short hash of url before the extension of base
+func hashedImageName(base, url string) string {
+	sum := sha256.Sum256([]byte(url))
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "-" + hex.EncodeToString(sum[:4]) + ext
+}
+
+// planImageNames names the images of every chapter in spine order before
+// any is downloaded, so which of two images sharing a name keeps it does not
+// depend on which chapter finishes first, and resumed runs agree
+func (d *Downloader) planImageNames(chapters []models.Chapter) {
+	if d.noImages {
+		return
+	}
+	for i := range chapters {
+		d.nameChapterImages(&chapters[i])
+		merged := d.depthMerges[xhtmlName(chapters[i].Filename)]
+		for j := range merged {
+			d.nameChapterImages(&merged[j])
+		}
+	}
+}
+
+// nameChapterImages names the images of chapter and, with flattenNested, of
+// the nested chapters merged into its page
+func (d *Downloader) nameChapterImages(chapter *models.Chapter) {
+	for _, img := range chapter.Images {
+		url := d.resolveImageURL(chapter, img)
+		if base := utils.FilenameFromURL(url); base != "" {
+			d.images.name(url, base)
+		}
+	}
+	if d.flattenNested {
+		for i := range chapter.Children {
+			d.nameChapterImages(&chapter.Children[i])
+		}
+	}
+}
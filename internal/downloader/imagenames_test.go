@@ -0,0 +1,75 @@
+package downloader
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImageNames(t *testing.T) {
+	var names imageNames
+	first := names.name("https://cdn.example.com/a/fig1.png", "fig1.png")
+	second := names.name("https://cdn.example.com/b/fig1.png", "fig1.png")
+	if first != "fig1.png" {
+		t.Errorf("Expected the first URL to keep its name, got %q", first)
+	}
+	if second == first || !strings.HasPrefix(second, "fig1-") || filepath.Ext(second) != ".png" {
+		t.Errorf("Expected a hashed name for the second URL, got %q", second)
+	}
+	if again := names.name("https://cdn.example.com/b/fig1.png", "fig1.png"); again != second {
+		t.Errorf("Expected the same URL to keep its name, got %q then %q", second, again)
+	}
+}
+
+func TestRun_SameImageNameAcrossChapters(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\n"
+	var server *httptest.Server
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/book/123/":
+			w.Write([]byte(`{"title": "Test Book", "identifier": "123"}`))
+		case "/api/v1/book/123/chapter/":
+			fmt.Fprintf(w, `{"count": 2, "next": null, "results": [
+				{"id": "1", "title": "One", "filename": "ch01.html", "content": "%[1]s/a/ch01.html", "asset_base_url": "%[1]s/a/", "images": ["fig1.png"]},
+				{"id": "2", "title": "Two", "filename": "ch02.html", "content": "%[1]s/b/ch02.html", "asset_base_url": "%[1]s/b/", "images": ["fig1.png"]}]}`, server.URL)
+		case "/a/ch01.html", "/b/ch02.html":
+			w.Write([]byte(`<div id="sbo-rt-content"><p><img src="fig1.png" alt=""/></p></div>`))
+		case "/a/fig1.png":
+			w.Write([]byte(png + "first"))
+		case "/b/fig1.png":
+			w.Write([]byte(png + "second"))
+		default:
+			http.NotFound(w, r)
+		}
+	}, Options{})
+
+	if err := d.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	oebpsPath := filepath.Join(d.bookDirectory(testBookInfo()), "OEBPS")
+	second := hashedImageName("fig1.png", server.URL+"/b/fig1.png")
+
+	for _, c := range []struct{ chapter, image, content string }{
+		{"ch01.xhtml", "fig1.png", "first"},
+		{"ch02.xhtml", second, "second"},
+	} {
+		page, err := os.ReadFile(filepath.Join(oebpsPath, c.chapter))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", c.chapter, err)
+		}
+		if !strings.Contains(string(page), `src="Images/`+c.image+`"`) {
+			t.Errorf("Expected %s to show Images/%s, got:\n%s", c.chapter, c.image, page)
+		}
+		data, err := os.ReadFile(filepath.Join(oebpsPath, "Images", c.image))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", c.image, err)
+		}
+		if string(data) != png+c.content {
+			t.Errorf("Expected Images/%s to hold the %s image, got %q", c.image, c.content, data)
+		}
+	}
+}
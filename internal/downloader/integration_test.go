@@ -0,0 +1,143 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dacsang97/safaribooks/internal/html"
+)
+
+// integrationBook is a small book with a cover, two chapters, an image
+// shared between them and a stylesheet
+func integrationBook(t *testing.T) *mockBook {
+	return &mockBook{
+		Info: map[string]any{
+			"authors":    []map[string]string{{"name": "Jane Doe"}},
+			"publishers": []map[string]string{{"name": "Test Press"}},
+			"isbn":       "9780000000000",
+		},
+		Cover: mockPNG(t, 600, 800),
+		Chapters: []mockChapter{
+			{
+				Title:       "Chapter 1",
+				Filename:    "ch01.html",
+				Body:        `<h1>Chapter 1</h1><p>Text</p><img src="figs/one.png" alt="One"/>`,
+				Images:      []string{"figs/one.png"},
+				Stylesheets: []string{"styles/book.css"},
+			},
+			{
+				Title:       "Chapter 2",
+				Filename:    "ch02.html",
+				Body:        `<h1>Chapter 2</h1><p>More</p><img src="figs/one.png" alt="One again"/><img src="figs/two.png" alt="Two"/>`,
+				Images:      []string{"figs/one.png", "figs/two.png"},
+				Stylesheets: []string{"styles/book.css"},
+			},
+		},
+		Files: map[string]string{
+			"figs/one.png":    string(mockPNG(t, 10, 10)),
+			"figs/two.png":    string(mockPNG(t, 20, 20)),
+			"styles/book.css": "h1 { color: #333; }\np { margin: 0; }\n",
+		},
+	}
+}
+
+func TestIntegration_Run(t *testing.T) {
+	_, epubPath := runMockBook(t, integrationBook(t), Options{})
+	book := readValidEPUB(t, epubPath)
+
+	for _, name := range []string{"OEBPS/ch01.xhtml", "OEBPS/ch02.xhtml", "OEBPS/Images/one.png", "OEBPS/Images/two.png"} {
+		if _, ok := book.Files[name]; !ok {
+			t.Errorf("Expected %s in the EPUB, got %v", name, book.Names)
+		}
+	}
+	if !strings.Contains(string(book.Files["OEBPS/ch02.xhtml"]), `src="Images/one.png"`) {
+		t.Errorf("Expected chapter 2 to show Images/one.png, got %s", book.Files["OEBPS/ch02.xhtml"])
+	}
+
+	hrefs := make(map[string]string, len(book.Manifest))
+	for _, item := range book.Manifest {
+		hrefs[item.ID] = item.Href
+	}
+	var pages []string
+	for _, idref := range book.Spine {
+		pages = append(pages, hrefs[idref])
+	}
+	if i, j := slices.Index(pages, "ch01.xhtml"), slices.Index(pages, "ch02.xhtml"); i < 0 || j < i {
+		t.Errorf("Expected the chapters in order in the spine, got %v", pages)
+	}
+}
+
+func TestIntegration_MergeCSS(t *testing.T) {
+	_, epubPath := runMockBook(t, integrationBook(t), Options{MergeCSS: true})
+	book := readValidEPUB(t, epubPath)
+
+	css, ok := book.Files["OEBPS/Styles/style.css"]
+	if !ok {
+		t.Fatalf("Expected OEBPS/Styles/style.css in the EPUB, got %v", book.Names)
+	}
+	if !strings.Contains(string(css), "color: #333") {
+		t.Errorf("Expected the merged stylesheet to hold the book's rules, got %q", css)
+	}
+	if !slices.ContainsFunc(book.Manifest, func(item mockManifestItem) bool {
+		return item.Href == "Styles/style.css" && item.MediaType == "text/css"
+	}) {
+		t.Errorf("Expected Styles/style.css in the manifest, got %v", book.Manifest)
+	}
+}
+
+func TestIntegration_InlineCSSLocalizesURLs(t *testing.T) {
+	book := integrationBook(t)
+	book.Files["styles/book.css"] = "@font-face { font-family: Body; src: url(fonts/body.woff); }\n" +
+		"h1 { background: url('../figs/two.png'); }\n"
+	book.Files["styles/fonts/body.woff"] = "wOFF-data"
+	_, epubPath := runMockBook(t, book, Options{InlineCSS: true})
+	epub := readValidEPUB(t, epubPath)
+
+	page := string(epub.Files["OEBPS/ch01.xhtml"])
+	for _, want := range []string{`url("Images/body.woff")`, `url("Images/two.png")`} {
+		if !strings.Contains(page, want) {
+			t.Errorf("Expected %s in the inlined stylesheet, got:\n%s", want, page)
+		}
+	}
+	if !slices.ContainsFunc(epub.Manifest, func(item mockManifestItem) bool {
+		return item.Href == "Images/body.woff" && item.MediaType == "font/woff"
+	}) {
+		t.Errorf("Expected the font in the manifest, got %v", epub.Manifest)
+	}
+}
+
+func TestIntegration_PruneCSS(t *testing.T) {
+	book := integrationBook(t)
+	book.Files["styles/book.css"] += ".sidebar { float: right; }\n"
+	d, epubPath := runMockBook(t, book, Options{MergeCSS: true, PruneCSS: true})
+	epub := readValidEPUB(t, epubPath)
+
+	css := string(epub.Files["OEBPS/"+html.MergedCSSHref])
+	if !strings.Contains(css, "h1 {") || strings.Contains(css, ".sidebar") {
+		t.Errorf("Expected the unused rule pruned from the EPUB, got:\n%s", css)
+	}
+	onDisk, err := os.ReadFile(filepath.Join(d.bookDirectory(book.bookInfo()), "OEBPS", filepath.FromSlash(html.MergedCSSHref)))
+	if err != nil {
+		t.Fatalf("Failed to read the stylesheet: %v", err)
+	}
+	if !strings.Contains(string(onDisk), ".sidebar") {
+		t.Errorf("Expected the stylesheet on disk left whole, got:\n%s", onDisk)
+	}
+}
+
+func TestIntegration_InvalidLanguage(t *testing.T) {
+	book := integrationBook(t)
+	book.Info["language"] = `en" onload="alert(1)`
+	_, epubPath := runMockBook(t, book, Options{})
+	epub := readValidEPUB(t, epubPath)
+
+	if opf := string(epub.Files["OEBPS/content.opf"]); !strings.Contains(opf, "<dc:language>en</dc:language>") {
+		t.Errorf("Expected the invalid language replaced by en, got:\n%s", opf)
+	}
+	if page := string(epub.Files["OEBPS/ch01.xhtml"]); !strings.Contains(page, `lang="en"`) || strings.Contains(page, "onload") {
+		t.Errorf("Expected the chapter tagged en, got:\n%s", page)
+	}
+}
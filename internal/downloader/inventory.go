@@ -0,0 +1,156 @@
+package downloader
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/dacsang97/safaribooks/internal/html"
+	"github.com/dacsang97/safaribooks/internal/models"
+	"github.com/dacsang97/safaribooks/pkg/utils"
+)
+
+// AssetUse is one asset a book refers to and how many of its chapters do
+type AssetUse struct {
+	URL      string `json:"url"`
+	Kind     string `json:"kind"` // html.AssetImage, html.AssetStylesheet, or html.AssetFont
+	Chapters int    `json:"chapters"`
+}
+
+// ListAssets fetches the chapters of the book, text only, and returns every
+// asset they refer to, resolved as a download would resolve it, without
+// downloading any but the linked stylesheets, read for the fonts and images
+// they refer to. Stylesheets come first, then fonts and images, each by URL.
+func (d *Downloader) ListAssets() ([]AssetUse, error) {
+	d.log.Printf("[*] Retrieving book chapters...\n")
+	chapters, err := d.client.GetBookChapters(d.bookID)
+	if err != nil {
+		return nil, err
+	}
+	if chapters, err = d.arrangeChapters(chapters); err != nil {
+		return nil, err
+	}
+	d.loadAssetIndex()
+
+	uses := make(map[string]*AssetUse)
+	add := func(kind, url string) {
+		if url == "" {
+			return
+		}
+		if use, ok := uses[url]; ok {
+			use.Chapters++
+			return
+		}
+		uses[url] = &AssetUse{URL: url, Kind: kind, Chapters: 1}
+	}
+	for i := range chapters {
+		chapter := &chapters[i]
+		d.log.With("chapter", chapter.Title).Printf("[*] Scanning chapter %d/%d: %s\n", i+1, len(chapters), chapter.Title)
+		assets, err := d.chapterAssets(chapter)
+		if err != nil {
+			d.log.With("chapter", chapter.Title).Printf("[-] Skipping chapter %s: %v\n", chapter.Title, err)
+			continue
+		}
+		for _, asset := range assets {
+			add(asset.Kind, asset.Ref)
+		}
+	}
+
+	list := make([]AssetUse, 0, len(uses))
+	for _, use := range uses {
+		list = append(list, *use)
+	}
+	kindOrder := map[string]int{html.AssetStylesheet: 0, html.AssetFont: 1, html.AssetImage: 2}
+	slices.SortFunc(list, func(a, b AssetUse) int {
+		return cmp.Or(cmp.Compare(kindOrder[a.Kind], kindOrder[b.Kind]), cmp.Compare(a.URL, b.URL))
+	})
+	return list, nil
+}
+
+// chapterAssets returns the assets of one chapter with their Ref resolved to
+// a URL: those the API lists for it, then those its HTML refers to. An asset
+// both list is returned once.
+func (d *Downloader) chapterAssets(chapter *models.Chapter) ([]html.Asset, error) {
+	body, _, err := d.chapterBody(chapter)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := html.ChapterAssets(string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var assets []html.Asset
+	seen := make(map[string]bool)
+	add := func(kind, url string) {
+		if url != "" && !seen[url] {
+			seen[url] = true
+			assets = append(assets, html.Asset{Kind: kind, Ref: url})
+		}
+	}
+	for _, url := range chapterStylesheets(*chapter) {
+		add(html.AssetStylesheet, url)
+	}
+	if !d.noImages {
+		for _, img := range chapter.Images {
+			add(html.AssetImage, d.resolveImageURL(chapter, img))
+		}
+	}
+	for _, ref := range refs {
+		switch ref.Kind {
+		case html.AssetImage:
+			if !d.noImages {
+				add(ref.Kind, d.resolveImageURL(chapter, ref.Ref))
+			}
+		case html.AssetStylesheet:
+			// As ParseChapter resolves linked stylesheets
+			add(ref.Kind, utils.ResolveURL("https://"+d.siteURL, ref.Ref))
+		default:
+			add(ref.Kind, utils.ResolveURL(d.assetBaseURL(chapter), ref.Ref))
+		}
+	}
+
+	// The fonts and images of the linked stylesheets, relative to each sheet
+	for _, sheet := range slices.Clone(assets) {
+		if sheet.Kind != html.AssetStylesheet {
+			continue
+		}
+		css, err := d.fetchCSS(sheet.Ref)
+		if err != nil {
+			continue // listed all the same, with nothing it refers to
+		}
+		for _, ref := range html.CSSAssets(css) {
+			if ref.Kind == html.AssetFont || ref.Kind == html.AssetImage && !d.noImages {
+				add(ref.Kind, utils.ResolveURL(sheet.Ref, ref.Ref))
+			}
+		}
+	}
+	return assets, nil
+}
+
+// WriteAssetList prints an aligned table of assets followed by totals per kind
+func WriteAssetList(w io.Writer, assets []AssetUse) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tCHAPTERS\tURL")
+	totals := make(map[string]int)
+	for _, a := range assets {
+		totals[a.Kind]++
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", a.Kind, a.Chapters, a.URL)
+	}
+	fmt.Fprintf(tw, "\t\t%d assets (stylesheets: %d, fonts: %d, images: %d)\n",
+		len(assets), totals[html.AssetStylesheet], totals[html.AssetFont], totals[html.AssetImage])
+	return tw.Flush()
+}
+
+// WriteAssetListJSON prints assets as an indented JSON array
+func WriteAssetListJSON(w io.Writer, assets []AssetUse) error {
+	if assets == nil {
+		assets = []AssetUse{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(assets)
+}
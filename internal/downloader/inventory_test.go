@@ -0,0 +1,80 @@
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dacsang97/safaribooks/internal/html"
+)
+
+func TestListAssets(t *testing.T) {
+	var server *httptest.Server
+	var assetRequests []string
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/book/123/chapter/":
+			fmt.Fprintf(w, `{"count": 2, "next": null, "results": [
+				{"title": "One", "filename": "ch01.html", "content": "%[1]s/ch01.html", "asset_base_url": "%[1]s/library/123/",
+				 "images": ["assets/fig01.png"], "stylesheets": [{"url": "%[1]s/css/book.css"}]},
+				{"title": "Two", "filename": "ch02.html", "content": "%[1]s/ch02.html", "asset_base_url": "%[1]s/library/123/",
+				 "images": ["assets/fig01.png", "assets/fig02.png"], "stylesheets": [{"url": "%[1]s/css/book.css"}]}]}`, server.URL)
+		case r.URL.Path == "/ch01.html":
+			w.Write([]byte(`<html><head><style>@font-face { src: url(fonts/Body.woff2); }</style></head>
+				<body><div id="sbo-rt-content"><img src="assets/fig01.png"/><img src="assets/inline.jpg"/></div></body></html>`))
+		case r.URL.Path == "/ch02.html":
+			fmt.Fprintf(w, `<html><head><link rel="stylesheet" href="%s/css/extra.css"/></head>
+				<body><div id="sbo-rt-content"><img src="assets/fig02.png"/></div></body></html>`, server.URL)
+		case r.URL.Path == "/css/book.css":
+			w.Write([]byte(`@font-face { src: url("../fonts/Serif.woff") format("woff"); }
+				body { background: url(bg.png); } .x { src: url(data:font/woff2;base64,AAAA); }`))
+		case strings.HasPrefix(r.URL.Path, "/library/"):
+			assetRequests = append(assetRequests, r.URL.Path)
+			http.NotFound(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}, Options{})
+
+	assets, err := d.ListAssets()
+	if err != nil {
+		t.Fatalf("ListAssets failed: %v", err)
+	}
+	if len(assetRequests) > 0 {
+		t.Errorf("Expected no downloads but stylesheets, got %v", assetRequests)
+	}
+
+	base := server.URL + "/library/123/"
+	want := []AssetUse{
+		{URL: server.URL + "/css/book.css", Kind: html.AssetStylesheet, Chapters: 2},
+		{URL: server.URL + "/css/extra.css", Kind: html.AssetStylesheet, Chapters: 1},
+		{URL: server.URL + "/fonts/Serif.woff", Kind: html.AssetFont, Chapters: 2},
+		{URL: base + "fonts/Body.woff2", Kind: html.AssetFont, Chapters: 1},
+		{URL: server.URL + "/css/bg.png", Kind: html.AssetImage, Chapters: 2},
+		{URL: base + "assets/fig01.png", Kind: html.AssetImage, Chapters: 2},
+		{URL: base + "assets/fig02.png", Kind: html.AssetImage, Chapters: 1},
+		{URL: base + "assets/inline.jpg", Kind: html.AssetImage, Chapters: 1},
+	}
+	if !slices.Equal(assets, want) {
+		t.Errorf("Expected assets\n%v\ngot\n%v", want, assets)
+	}
+
+	var out bytes.Buffer
+	if err := WriteAssetList(&out, assets); err != nil {
+		t.Fatalf("WriteAssetList failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "8 assets (stylesheets: 2, fonts: 2, images: 4)") {
+		t.Errorf("Expected totals in the table, got:\n%s", out.String())
+	}
+	out.Reset()
+	if err := WriteAssetListJSON(&out, assets); err != nil {
+		t.Fatalf("WriteAssetListJSON failed: %v", err)
+	}
+	if !strings.Contains(out.String(), `"url": "`+base+`assets/fig01.png",`) || !strings.Contains(out.String(), `"chapters": 2`) {
+		t.Errorf("Expected the assets as JSON, got:\n%s", out.String())
+	}
+}
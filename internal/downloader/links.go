@@ -0,0 +1,73 @@
+package downloader
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/dacsang97/safaribooks/internal/html"
+)
+
+// maxReportedLinks caps the dangling links listed in a failure message
+const maxReportedLinks = 5
+
+// validateLinks checks that every internal link of the pages in the finished
+// EPUB points at a file and anchor inside it, logging dangling links in warn
+// mode and failing the download in fail mode
+func (d *Downloader) validateLinks(epubPath string) error {
+	if d.validateLinksMode == "" {
+		return nil
+	}
+	dangling, err := epubDanglingLinks(epubPath)
+	if err != nil {
+		return fmt.Errorf("validate links: %w", err)
+	}
+	if len(dangling) == 0 {
+		d.log.Printf("[*] All internal links resolve\n")
+		return nil
+	}
+
+	reported := make([]string, 0, min(len(dangling), maxReportedLinks))
+	for _, link := range dangling {
+		d.log.Printf("[-] Dangling link in %s\n", link)
+		if len(reported) < maxReportedLinks {
+			reported = append(reported, link.String())
+		}
+	}
+	if d.validateLinksMode == "warn" {
+		return nil
+	}
+	return fmt.Errorf("%d dangling internal links: %s", len(dangling), strings.Join(reported, "; "))
+}
+
+// epubDanglingLinks reads the pages of an EPUB and returns their links to
+// entries or anchors missing from it
+func epubDanglingLinks(epubPath string) ([]html.DanglingLink, error) {
+	r, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	files := make(map[string]bool, len(r.File))
+	pages := make(map[string]string)
+	for _, f := range r.File {
+		files[f.Name] = true
+		if ext := path.Ext(f.Name); ext != ".xhtml" && ext != ".html" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("open %s: %w", f.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", f.Name, err)
+		}
+		pages[f.Name] = string(data)
+	}
+	return html.CheckLinks(pages, files), nil
+}
@@ -0,0 +1,50 @@
+package downloader
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestEPUB(t *testing.T, entries map[string]string) string {
+	t.Helper()
+	epubPath := filepath.Join(t.TempDir(), "book.epub")
+	f, err := os.Create(epubPath)
+	if err != nil {
+		t.Fatalf("Failed to create EPUB: %v", err)
+	}
+	zw := zip.NewWriter(f)
+	for name, content := range entries {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to add %s: %v", name, err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to write EPUB: %v", err)
+	}
+	f.Close()
+	return epubPath
+}
+
+func TestValidateLinks_DanglingLink(t *testing.T) {
+	epubPath := writeTestEPUB(t, map[string]string{
+		"mimetype":         epubMimetype,
+		"OEBPS/ch01.xhtml": `<body><p id="start"><a href="ch02.xhtml#start">next</a></p></body>`,
+		"OEBPS/ch02.xhtml": `<body><p id="start"><a href="ch09.xhtml">dangling</a></p></body>`,
+	})
+
+	d := &Downloader{validateLinksMode: "warn"}
+	if err := d.validateLinks(epubPath); err != nil {
+		t.Errorf("Expected warn mode to only log, got %v", err)
+	}
+
+	d.validateLinksMode = "fail"
+	err := d.validateLinks(epubPath)
+	if err == nil || !strings.Contains(err.Error(), "OEBPS/ch02.xhtml: ch09.xhtml (missing file)") {
+		t.Errorf("Expected the dangling link to fail the download, got %v", err)
+	}
+}
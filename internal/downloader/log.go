@@ -0,0 +1,204 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log formats accepted by Options.LogFormat
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// logger serializes progress output from concurrent workers and optionally
+// tees it to a log file. A nil logger prints to stdout.
+type logger struct {
+	*logSink
+	fields []any // key/value pairs added to JSON records, see With
+}
+
+// logSink is the output shared by a logger and the loggers derived from it
+type logSink struct {
+	mu      sync.Mutex
+	out     io.Writer
+	file    *os.File
+	json    *slog.Logger // set for the JSON format
+	fileLog *slog.Logger // JSON format: records for the log file alone
+}
+
+// newLogger returns a logger writing to out (stdout when nil) in the given
+// format ("text" when empty), and also appending to path when set, after a
+// line marking the start of the run. With rotate, an existing log file is
+// first moved to path.1, replacing the one kept from an earlier run. The log
+// file gets perm as chmodFile gives it, or the default mode when perm is 0.
+func newLogger(out io.Writer, path, format string, perm os.FileMode, rotate bool) (*logger, error) {
+	if out == nil {
+		out = os.Stdout
+	}
+	if path == "" {
+		return newFormatLogger(out, format)
+	}
+
+	if rotate {
+		if err := os.Rename(path, path+".1"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("rotate log file: %w", err)
+		}
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, defaultFilePerm)
+	if err != nil {
+		return nil, fmt.Errorf("open log file: %w", err)
+	}
+	if err := chmodFile(path, perm); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("set log file permissions: %w", err)
+	}
+	l, err := newFormatLogger(io.MultiWriter(out, file), format)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	l.file = file
+	if l.json != nil {
+		l.fileLog = slog.New(slog.NewJSONHandler(file, &slog.HandlerOptions{ReplaceAttr: jsonLogAttr}))
+	}
+	l.fileLine(slog.LevelInfo, "=== Run started "+time.Now().Format(time.RFC3339)+" ===")
+	return l, nil
+}
+
+// fileLine writes a line to the log file alone, for what the terminal shows
+// otherwise, such as the error a failed run returns to its caller
+func (l *logger) fileLine(level slog.Level, line string) {
+	if l == nil || l.file == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.fileLog == nil {
+		fmt.Fprintln(l.file, line)
+		return
+	}
+	msg := strings.Trim(line, "= ")
+	if len(msg) >= 3 && msg[0] == '[' && msg[2] == ']' {
+		msg = strings.TrimSpace(msg[3:])
+	}
+	l.fileLog.Log(context.Background(), level, msg, l.fields...)
+}
+
+// Progress prints the progress lines of the downloader's callers, such as a
+// playlist run, in the downloader's log format
+type Progress struct {
+	log *logger
+}
+
+// NewProgress returns a Progress writing to out (stdout when nil) in the
+// given log format ("text" when empty)
+func NewProgress(out io.Writer, format string) (*Progress, error) {
+	if out == nil {
+		out = os.Stdout
+	}
+	l, err := newFormatLogger(out, format)
+	if err != nil {
+		return nil, err
+	}
+	return &Progress{log: l}, nil
+}
+
+// Printf formats and writes progress lines, which have to be printed whole
+func (p *Progress) Printf(format string, args ...any) {
+	p.log.Printf(format, args...)
+}
+
+// newFormatLogger returns a logger writing to out in the given format
+func newFormatLogger(out io.Writer, format string) (*logger, error) {
+	l := &logger{logSink: &logSink{out: out}}
+	switch format {
+	case "", logFormatText:
+	case logFormatJSON:
+		l.json = slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{ReplaceAttr: jsonLogAttr}))
+	default:
+		return nil, fmt.Errorf("unsupported log format %q (use text or json)", format)
+	}
+	return l, nil
+}
+
+// jsonLogAttr names the built-in JSON record keys level, timestamp, and message
+func jsonLogAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return a
+	}
+	switch a.Key {
+	case slog.TimeKey:
+		a.Key = "timestamp"
+	case slog.LevelKey:
+		a.Value = slog.StringValue(strings.ToLower(a.Value.String()))
+	case slog.MessageKey:
+		a.Key = "message"
+	}
+	return a
+}
+
+// With returns a logger adding the key/value pairs, such as "chapter" and
+// "url", to its JSON records; text output is unchanged
+func (l *logger) With(fields ...any) *logger {
+	if l == nil {
+		return nil
+	}
+	return &logger{logSink: l.logSink, fields: append(slices.Clip(l.fields), fields...)}
+}
+
+// Printf formats and writes progress lines. In the JSON format every line of
+// a call becomes one record, so lines have to be printed whole: concurrent
+// workers would splice the pieces of a line together.
+func (l *logger) Printf(format string, args ...any) {
+	if l == nil {
+		fmt.Printf(format, args...)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.json == nil {
+		fmt.Fprintf(l.out, format, args...)
+		return
+	}
+	for line := range strings.Lines(fmt.Sprintf(format, args...)) {
+		l.record(strings.TrimSuffix(line, "\n"))
+	}
+}
+
+// record writes a line as a JSON record. The "[-]" marker of failures and
+// warnings maps to the warn level, the other markers to info.
+func (l *logger) record(line string) {
+	level := slog.LevelInfo
+	if strings.HasPrefix(line, "[-]") {
+		level = slog.LevelWarn
+	}
+	if len(line) >= 3 && line[0] == '[' && line[2] == ']' {
+		line = line[3:]
+	}
+	if msg := strings.TrimSpace(line); msg != "" {
+		l.json.Log(context.Background(), level, msg, l.fields...)
+	}
+}
+
+// Close closes the log file, if any
+func (l *logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,200 @@
+package downloader
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLogger_WritesToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "download.log")
+	l, err := newLogger(io.Discard, path, "", 0, false)
+	if err != nil {
+		t.Fatalf("newLogger failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l.Printf("[+] Downloaded image: img%d.png\n", i)
+		}(i)
+	}
+	wg.Wait()
+	l.Printf("[*] Done: %s\n", "book.epub")
+
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 12 || !strings.HasPrefix(lines[0], "=== Run started ") {
+		t.Fatalf("Expected a run separator and 11 log lines, got %d:\n%s", len(lines), data)
+	}
+	lines = lines[1:]
+	for _, line := range lines[:10] {
+		if !strings.HasPrefix(line, "[+] Downloaded image: img") {
+			t.Errorf("Unexpected log line: %q", line)
+		}
+	}
+	if lines[10] != "[*] Done: book.epub" {
+		t.Errorf("Unexpected last log line: %q", lines[10])
+	}
+}
+
+func TestLogger_JSONFormat(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := newFormatLogger(&buf, logFormatJSON)
+	if err != nil {
+		t.Fatalf("newFormatLogger failed: %v", err)
+	}
+	book := l.With("book_id", "123")
+
+	book.Printf("[*] Downloading %d chapters...\n", 3)
+	book.With("chapter", "One", "url", "https://example.com/fig1.png").Printf("[-] Failed to download %s: %v\n", "https://example.com/fig1.png", "status 404")
+	book.Printf("[*] Authors: %s\n[*] Publisher: %s\n", "Jane Doe", "O'Reilly")
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	var records []map[string]any
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var record map[string]any
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("Expected one JSON object per line: %v", err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 4 {
+		t.Fatalf("Expected 4 records, got %d: %v", len(records), records)
+	}
+
+	for _, record := range records {
+		if _, err := time.Parse(time.RFC3339Nano, fmt.Sprint(record["timestamp"])); err != nil {
+			t.Errorf("Expected an RFC 3339 timestamp, got %v", record["timestamp"])
+		}
+		if record["book_id"] != "123" {
+			t.Errorf("Expected book_id on every record, got %v", record)
+		}
+	}
+	if got := records[0]; got["level"] != "info" || got["message"] != "Downloading 3 chapters..." {
+		t.Errorf("Unexpected progress record: %v", got)
+	}
+	if got := records[1]; got["level"] != "warn" || got["chapter"] != "One" || got["url"] != "https://example.com/fig1.png" ||
+		got["message"] != "Failed to download https://example.com/fig1.png: status 404" {
+		t.Errorf("Unexpected failure record: %v", got)
+	}
+	if records[2]["message"] != "Authors: Jane Doe" || records[3]["message"] != "Publisher: O'Reilly" || records[2]["chapter"] != nil {
+		t.Errorf("Expected a record per line of a call, got %v and %v", records[2], records[3])
+	}
+}
+
+func TestNewFormatLogger_RejectsUnknownFormat(t *testing.T) {
+	if _, err := newFormatLogger(&bytes.Buffer{}, "xml"); err == nil {
+		t.Error("Expected an unknown log format to be rejected")
+	}
+}
+
+func TestRun_WritesFailureToLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "download.log")
+	d, _ := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}, Options{LogFile: path})
+
+	if err := d.Run(); err == nil {
+		t.Fatal("Expected Run to fail for a missing book")
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if !strings.HasPrefix(lines[0], "=== Run started ") {
+		t.Errorf("Expected the log to open with a run separator, got %q", lines[0])
+	}
+	if last := lines[len(lines)-1]; !strings.HasPrefix(last, "[-] Download failed: ") {
+		t.Errorf("Expected the log to end with the failure, got %q", last)
+	}
+}
+
+func TestNewLogger_WritesToOutput(t *testing.T) {
+	var out bytes.Buffer
+	path := filepath.Join(t.TempDir(), "download.log")
+	l, err := newLogger(&out, path, "", 0, false)
+	if err != nil {
+		t.Fatalf("newLogger failed: %v", err)
+	}
+	l.Printf("[*] Retrieving book info...\n")
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if out.String() != "[*] Retrieving book info...\n" {
+		t.Errorf("Expected the progress line on the given output, got %q", out.String())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	if !strings.HasSuffix(string(data), "[*] Retrieving book info...\n") {
+		t.Errorf("Expected the progress line in the log file too, got %q", data)
+	}
+}
+
+func TestNewLogger_RotatesWithForce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "download.log")
+	if err := os.WriteFile(path, []byte("[*] earlier run\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := newLogger(io.Discard, path, "", 0600, true)
+	if err != nil {
+		t.Fatalf("newLogger failed: %v", err)
+	}
+	l.Printf("[*] Retrieving book info...\n")
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	if strings.Contains(string(data), "earlier run") {
+		t.Errorf("Expected a fresh log file with force, got %q", data)
+	}
+	if !strings.HasSuffix(string(data), "[*] Retrieving book info...\n") {
+		t.Errorf("Expected this run's lines in the log file, got %q", data)
+	}
+	old, err := os.ReadFile(path + ".1")
+	if err != nil {
+		t.Fatalf("Expected the earlier log to be kept: %v", err)
+	}
+	if string(old) != "[*] earlier run\n" {
+		t.Errorf("Expected the earlier log unchanged, got %q", old)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("Expected the log file mode 0600, got %04o", perm)
+	}
+}
@@ -0,0 +1,89 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Manifest ID schemes accepted by Options.ManifestIDScheme
+const (
+	idSchemeSequential = "sequential" // ch0, ch1, ... and img<n>
+	idSchemeFilename   = "filename"   // the file name, made a valid XML name
+	idSchemeHash       = "hash"       // a short hash of the file's path in the book
+)
+
+// validManifestIDScheme reports whether scheme is a manifest ID scheme
+// NewDownloader accepts
+func validManifestIDScheme(scheme string) bool {
+	switch scheme {
+	case "", idSchemeSequential, idSchemeFilename, idSchemeHash:
+		return true
+	}
+	return false
+}
+
+// fixedManifestIDs are the IDs of the files the package always names the
+// same way, kept out of reach of generated ones
+var fixedManifestIDs = []string{"ncx", "cover", "colophon", "css", "nav", "cover-image", "cover-thumb"}
+
+// manifestIDs generates unique manifest IDs for chapters and images in the
+// chosen scheme
+type manifestIDs struct {
+	scheme string
+	used   map[string]bool
+}
+
+func newManifestIDs(scheme string) *manifestIDs {
+	ids := &manifestIDs{scheme: scheme, used: make(map[string]bool)}
+	for _, id := range fixedManifestIDs {
+		ids.used[id] = true
+	}
+	return ids
+}
+
+// id returns the ID of the file at href in the package: prefix and index in
+// the sequential scheme, otherwise derived from href. A taken ID gets a
+// numeric suffix.
+func (m *manifestIDs) id(prefix string, index int, href string) string {
+	var id string
+	switch m.scheme {
+	case idSchemeFilename:
+		id = ncName(href[strings.LastIndex(href, "/")+1:])
+	case idSchemeHash:
+		sum := sha256.Sum256([]byte(href))
+		id = "id-" + hex.EncodeToString(sum[:5])
+	default:
+		id = fmt.Sprintf("%s%d", prefix, index)
+	}
+
+	unique := id
+	for n := 2; m.used[unique]; n++ {
+		unique = fmt.Sprintf("%s-%d", id, n)
+	}
+	m.used[unique] = true
+	return unique
+}
+
+// ncName turns name into an XML NCName: characters other than ASCII letters,
+// digits, '.', '-' and '_' become '_', and a name not starting with a letter
+// or '_' gets a leading '_'
+func ncName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		letter := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_'
+		if i == 0 && !letter {
+			b.WriteByte('_')
+		}
+		if letter || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			b.WriteRune(r)
+		} else if i > 0 {
+			b.WriteByte('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
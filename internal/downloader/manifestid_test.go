@@ -0,0 +1,94 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/dacsang97/safaribooks/internal/models"
+)
+
+var ncNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9._-]*$`)
+
+func TestNCName(t *testing.T) {
+	cases := map[string]string{
+		"ch01.xhtml":     "ch01.xhtml",
+		"9781234.png":    "_9781234.png",
+		"fig 1 (a).png":  "fig_1__a_.png",
+		"-dash.png":      "_-dash.png",
+		"résumé.jpg":     "r_sum_.jpg",
+		"":               "_",
+		"_private.xhtml": "_private.xhtml",
+	}
+	for name, want := range cases {
+		if got := ncName(name); got != want {
+			t.Errorf("ncName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestBuildPackage_ManifestIDSchemes(t *testing.T) {
+	oebpsPath := t.TempDir()
+	imagesPath := filepath.Join(oebpsPath, "Images")
+	if err := os.MkdirAll(imagesPath, 0755); err != nil {
+		t.Fatalf("Failed to create images dir: %v", err)
+	}
+	for _, name := range []string{"1.png", "fig 1.png", "fig_1.png", "cover.png"} {
+		if err := os.WriteFile(filepath.Join(imagesPath, name), []byte("\x89PNG\r\n\x1a\n"), 0644); err != nil {
+			t.Fatalf("Failed to write image: %v", err)
+		}
+	}
+	chapters := []models.Chapter{
+		{Title: "Cover", Filename: "cover.xhtml"},
+		{Title: "One", Filename: "1-intro.xhtml"},
+		{Title: "Nav", Filename: "nav"},
+	}
+
+	for _, scheme := range []string{idSchemeSequential, idSchemeFilename, idSchemeHash} {
+		d := &Downloader{bookID: "123", manifestIDScheme: scheme}
+		pkg := d.buildPackage(testBookInfo(), chapters, oebpsPath, "")
+
+		seen := make(map[string]bool)
+		for _, item := range pkg.Manifest.Items {
+			if !ncNameRe.MatchString(item.ID) {
+				t.Errorf("%s: ID %q of %s is not an NCName", scheme, item.ID, item.Href)
+			}
+			if seen[item.ID] {
+				t.Errorf("%s: ID %q is used twice", scheme, item.ID)
+			}
+			seen[item.ID] = true
+		}
+		for _, ref := range pkg.Spine.ItemRefs {
+			if !seen[ref.IDRef] {
+				t.Errorf("%s: spine refers to unknown ID %q", scheme, ref.IDRef)
+			}
+		}
+		if _, err := pkg.Bytes(false); err != nil {
+			t.Errorf("%s: encode content.opf: %v", scheme, err)
+		}
+	}
+
+	d := &Downloader{bookID: "123", manifestIDScheme: idSchemeFilename}
+	pkg := d.buildPackage(testBookInfo(), chapters, oebpsPath, "")
+	var ids []string
+	for _, item := range pkg.Manifest.Items {
+		ids = append(ids, item.ID)
+	}
+	want := []string{"ncx", "cover.xhtml", "_1-intro.xhtml", "nav-2", "_1.png", "cover.png", "fig_1.png", "fig_1.png-2"}
+	if len(ids) != len(want) {
+		t.Fatalf("Expected IDs %v, got %v", want, ids)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Errorf("Expected IDs %v, got %v", want, ids)
+			break
+		}
+	}
+}
+
+func TestNewDownloader_RejectsBadManifestIDScheme(t *testing.T) {
+	if _, err := NewDownloader(Options{ManifestIDScheme: "uuid"}); err == nil {
+		t.Error("Expected an unsupported manifest ID scheme to be rejected")
+	}
+}
@@ -0,0 +1,142 @@
+package downloader
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dacsang97/safaribooks/pkg/utils"
+)
+
+// sniffLen is how much of a file content sniffing looks at
+const sniffLen = 512
+
+// sniffImageType returns the image media type of data, or "" when it isn't
+// an image type the EPUB manifest can declare
+func sniffImageType(data []byte) string {
+	data = data[:min(len(data), sniffLen)]
+	if mediaType := http.DetectContentType(data); strings.HasPrefix(mediaType, "image/") && utils.ExtensionFromContentType(mediaType) != "" {
+		return mediaType
+	}
+	head := strings.ToLower(strings.TrimSpace(string(data)))
+	if strings.HasPrefix(head, "<svg") || (strings.HasPrefix(head, "<?xml") || strings.HasPrefix(head, "<!doctype svg")) && strings.Contains(head, "<svg") {
+		return "image/svg+xml"
+	}
+	return ""
+}
+
+// sniffImageFile returns the image media type of the file at path, or "" when
+// it can't be read or isn't a recognized image
+func sniffImageFile(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return ""
+	}
+	return sniffImageType(head[:n])
+}
+
+// imageMediaType returns the manifest media type for an image file: the
+// sniffed type, falling back to the one its extension implies
+func imageMediaType(path string) string {
+	if mediaType := sniffImageFile(path); mediaType != "" {
+		return mediaType
+	}
+	return getImageMediaType(strings.ToLower(filepath.Ext(path)))
+}
+
+// correctImageTypes renames images whose bytes don't match the media type of
+// their extension, which readers reject, and points the pages in oebpsPath at
+// the new names. Streamed chapters are already in the archive, so there the
+// files keep their names and only the manifest entry is corrected. It returns
+// coverFilename after any rename.
+func (d *Downloader) correctImageTypes(oebpsPath, coverFilename string) string {
+	imagesPath := filepath.Join(oebpsPath, "Images")
+	entries, err := os.ReadDir(imagesPath)
+	if err != nil {
+		return coverFilename
+	}
+
+	renames := make(map[string]string)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		declared := getImageMediaType(strings.ToLower(filepath.Ext(name)))
+		actual := sniffImageFile(filepath.Join(imagesPath, name))
+		if actual == "" || actual == declared {
+			continue
+		}
+		if d.stream != nil {
+			d.log.Printf("[-] %s is %s, not %s; listing it as %s\n", name, actual, declared, actual)
+			continue
+		}
+
+		fixed := strings.TrimSuffix(name, filepath.Ext(name)) + utils.ExtensionFromContentType(actual)
+		// Never overwrite another image that already has the name
+		if _, err := os.Lstat(filepath.Join(imagesPath, fixed)); err == nil {
+			fixed = hashedImageName(fixed, name)
+		}
+		if err := os.Rename(filepath.Join(imagesPath, name), filepath.Join(imagesPath, fixed)); err != nil {
+			d.log.Printf("[-] %s is %s, not %s, and could not be renamed: %v\n", name, actual, declared, err)
+			continue
+		}
+		d.log.Printf("[*] %s is %s, not %s; renamed it to %s\n", name, actual, declared, fixed)
+		renames[name] = fixed
+	}
+	if len(renames) == 0 {
+		return coverFilename
+	}
+
+	pages, err := chapterPages(oebpsPath)
+	if err != nil {
+		d.log.Printf("[-] Failed to list pages for renamed images: %v\n", err)
+	}
+	for _, page := range pages {
+		data, err := os.ReadFile(page)
+		if err != nil {
+			d.log.Printf("[-] Failed to update image links in %s: %v\n", filepath.Base(page), err)
+			continue
+		}
+		if updated := applyImageRenames(string(data), renames); updated != string(data) {
+			if err := d.writeFile(page, []byte(updated)); err != nil {
+				d.log.Printf("[-] Failed to update image links in %s: %v\n", filepath.Base(page), err)
+			}
+		}
+	}
+	if fixed, ok := renames[coverFilename]; ok {
+		return fixed
+	}
+	return coverFilename
+}
+
+// chapterPages returns the XHTML pages in oebpsPath, including those in
+// subdirectories, leaving out images and the raw chapters of --dump-raw
+func chapterPages(oebpsPath string) ([]string, error) {
+	var pages []string
+	err := filepath.WalkDir(oebpsPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path == filepath.Join(oebpsPath, "Images") || entry.Name() == rawChaptersDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) == ".xhtml" {
+			pages = append(pages, path)
+		}
+		return nil
+	})
+	return pages, err
+}
@@ -0,0 +1,563 @@
+package downloader
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dacsang97/safaribooks/internal/models"
+	"github.com/dacsang97/safaribooks/pkg/utils"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// goldenBookInfo exercises the characters the old string building mis-escaped
+func goldenBookInfo(t *testing.T) models.BookInfo {
+	t.Helper()
+
+	var info models.BookInfo
+	data := `{
+		"title": "Tips & Tricks for <Go> \"Gophers\"",
+		"description": "Covers a < b && c > d",
+		"identifier": "123",
+		"isbn": "9781234567890",
+		"issued": "2024-01-01",
+		"authors": [{"name": "Jane O'Doe"}, {"name": "John Roe"}],
+		"publishers": [{"name": "O'Reilly Media, Inc."}],
+		"subjects": [{"name": "Programming  Languages"}, {"name": "Go & Rust"}]
+	}`
+	if err := json.Unmarshal([]byte(data), &info); err != nil {
+		t.Fatalf("Failed to decode book info: %v", err)
+	}
+	return info
+}
+
+func assertGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	golden := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.WriteFile(golden, got, 0644); err != nil {
+			t.Fatalf("Failed to update golden file: %v", err)
+		}
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("Failed to read golden file: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("%s does not match golden file:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func TestWriteEPUBMetadata_Golden(t *testing.T) {
+	for _, pretty := range []bool{false, true} {
+		suffix := ""
+		if pretty {
+			suffix = ".pretty"
+		}
+
+		oebpsPath := t.TempDir()
+		imagesPath := filepath.Join(oebpsPath, "Images")
+		if err := os.MkdirAll(imagesPath, 0755); err != nil {
+			t.Fatalf("Failed to create images dir: %v", err)
+		}
+		for _, name := range []string{"cover.jpg", "fig&1.png"} {
+			if err := os.WriteFile(filepath.Join(imagesPath, name), []byte("x"), 0644); err != nil {
+				t.Fatalf("Failed to write image: %v", err)
+			}
+		}
+
+		d := &Downloader{bookID: "123", prettyXML: pretty}
+		chapters := []models.Chapter{
+			{Title: "Preface & Intro", Filename: "preface.xhtml"},
+			{Title: "Chapter 1. <Hello>", Filename: "ch01.xhtml"},
+		}
+		if err := d.writeEPUBMetadata(goldenBookInfo(t), chapters, oebpsPath, "cover.jpg"); err != nil {
+			t.Fatalf("writeEPUBMetadata failed: %v", err)
+		}
+
+		for _, name := range []string{"content.opf", "toc.ncx"} {
+			got, err := os.ReadFile(filepath.Join(oebpsPath, name))
+			if err != nil {
+				t.Fatalf("Failed to read %s: %v", name, err)
+			}
+			assertGolden(t, name+suffix+".golden", got)
+		}
+	}
+}
+
+func TestSubjectTags_SplitsSlashDelimited(t *testing.T) {
+	subjects := []string{"Computers / Programming / Python", "  programming ", "Computers/Data  Science"}
+
+	got := subjectTags(subjects, true)
+	want := []string{"Computers", "Programming", "Python", "Data Science"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	got = subjectTags(subjects, false)
+	want = []string{"Computers / Programming / Python", "programming", "Computers/Data Science"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestBuildPackage_ReadingDirection(t *testing.T) {
+	chapters := []models.Chapter{{Title: "One", Filename: "ch01.xhtml"}}
+	oebpsPath := t.TempDir()
+	if err := os.WriteFile(filepath.Join(oebpsPath, navFileName), []byte("<html/>"), 0644); err != nil {
+		t.Fatalf("Failed to write nav: %v", err)
+	}
+
+	d := &Downloader{bookID: "123", language: "en", readingDirection: "rtl"}
+	opf, err := d.buildPackage(testBookInfo(), chapters, oebpsPath, "").Bytes(false)
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	if !strings.Contains(string(opf), `<spine toc="ncx" page-progression-direction="rtl">`) {
+		t.Errorf("Expected rtl spine, got:\n%s", opf)
+	}
+
+	// Auto follows the book language
+	d = &Downloader{bookID: "123", language: "ar"}
+	if got := d.buildPackage(testBookInfo(), chapters, oebpsPath, "").Spine.PageProgressionDirection; got != "rtl" {
+		t.Errorf("Expected rtl for an Arabic book, got %q", got)
+	}
+	d = &Downloader{bookID: "123", language: "en"}
+	if got := d.buildPackage(testBookInfo(), chapters, oebpsPath, "").Spine.PageProgressionDirection; got != "" {
+		t.Errorf("Expected implicit ltr for an English book, got %q", got)
+	}
+}
+
+func TestBuildPackage_ReadingDirectionNeedsEPUB3(t *testing.T) {
+	chapters := []models.Chapter{{Title: "One", Filename: "ch01.xhtml"}}
+	d := &Downloader{bookID: "123", language: "ar", readingDirection: "rtl"}
+	pkg := d.buildPackage(testBookInfo(), chapters, t.TempDir(), "")
+	if pkg.Version != "2.0" || pkg.Spine.PageProgressionDirection != "" {
+		t.Errorf("Expected a 2.0 package without nav.xhtml to have no page-progression-direction, got version %s and %q",
+			pkg.Version, pkg.Spine.PageProgressionDirection)
+	}
+}
+
+func TestBuildPackage_EPUB2Compat(t *testing.T) {
+	oebpsPath := t.TempDir()
+	imagesPath := filepath.Join(oebpsPath, "Images")
+	if err := os.MkdirAll(imagesPath, 0755); err != nil {
+		t.Fatalf("Failed to create images dir: %v", err)
+	}
+	for _, name := range []string{"cover.jpg", "fig1.webp"} {
+		if err := os.WriteFile(filepath.Join(imagesPath, name), []byte("x"), 0644); err != nil {
+			t.Fatalf("Failed to write image: %v", err)
+		}
+	}
+
+	chapters := []models.Chapter{{Title: "One", Filename: "ch01.xhtml"}}
+	d := &Downloader{bookID: "123", language: "ar", epub2Compat: true}
+	opf, err := d.buildPackage(testBookInfo(), chapters, oebpsPath, "cover.jpg").Bytes(false)
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+
+	for _, want := range []string{
+		`version="2.0"`,
+		`<guide><reference type="cover" title="Cover" href="cover.xhtml"></reference><reference type="text" title="One" href="ch01.xhtml"></reference></guide>`,
+	} {
+		if !strings.Contains(string(opf), want) {
+			t.Errorf("Expected %s in content.opf, got:\n%s", want, opf)
+		}
+	}
+	for _, marker := range []string{"page-progression-direction", "properties=", "nav.xhtml", "image/webp"} {
+		if strings.Contains(string(opf), marker) {
+			t.Errorf("Expected no EPUB 3 marker %q in content.opf, got:\n%s", marker, opf)
+		}
+	}
+
+	page := coverPageXHTML("cover.jpg", d.doctype(), coverStyleFit, 0, 0)
+	if strings.Contains(page, "<!DOCTYPE html>") {
+		t.Errorf("Expected XHTML 1.1 doctype on the cover page, got:\n%s", page)
+	}
+}
+
+func TestBuildPackage_SingleCoverPage(t *testing.T) {
+	oebpsPath := t.TempDir()
+	imagesPath := filepath.Join(oebpsPath, "Images")
+	if err := os.MkdirAll(imagesPath, 0755); err != nil {
+		t.Fatalf("Failed to create images dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(imagesPath, "cover.jpg"), []byte("x"), 0644); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+
+	d := &Downloader{bookID: "123", language: "en", epub2Compat: true}
+	for _, tt := range []struct {
+		name      string
+		chapters  []models.Chapter
+		generated bool
+		spine     []string
+		guideHref []string
+	}{
+		{
+			name:      "book cover chapter",
+			chapters:  []models.Chapter{{Title: "Cover", Filename: "cover.xhtml"}, {Title: "One", Filename: "ch01.xhtml"}},
+			spine:     []string{"cover.xhtml", "ch01.xhtml"},
+			guideHref: []string{"cover.xhtml", "ch01.xhtml"},
+		},
+		{
+			name:      "cover chapter by title",
+			chapters:  []models.Chapter{{Title: "Cover Page", Filename: "front.xhtml"}, {Title: "One", Filename: "ch01.xhtml"}},
+			spine:     []string{"front.xhtml", "ch01.xhtml"},
+			guideHref: []string{"front.xhtml", "ch01.xhtml"},
+		},
+		{
+			name:      "generated cover page",
+			chapters:  []models.Chapter{{Title: "One", Filename: "ch01.xhtml"}},
+			generated: true,
+			spine:     []string{"cover.xhtml", "ch01.xhtml"},
+			guideHref: []string{"cover.xhtml", "ch01.xhtml"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			pkg := d.buildPackage(testBookInfo(), tt.chapters, oebpsPath, "cover.jpg")
+
+			hrefs := make(map[string]string)
+			for _, item := range pkg.Manifest.Items {
+				hrefs[item.ID] = item.Href
+			}
+			if _, ok := hrefs["cover"]; ok != tt.generated {
+				t.Errorf("Expected generated cover page in manifest: %v, got %v", tt.generated, ok)
+			}
+
+			var spine []string
+			for _, ref := range pkg.Spine.ItemRefs {
+				spine = append(spine, hrefs[ref.IDRef])
+			}
+			if !slices.Equal(spine, tt.spine) {
+				t.Errorf("Expected spine %v, got %v", tt.spine, spine)
+			}
+
+			var guide []string
+			for _, ref := range pkg.Guide.References {
+				guide = append(guide, ref.Href)
+			}
+			if !slices.Equal(guide, tt.guideHref) {
+				t.Errorf("Expected guide %v, got %v", tt.guideHref, guide)
+			}
+		})
+	}
+}
+
+func TestBuildPackage_NonlinearChapters(t *testing.T) {
+	chapters := []models.Chapter{
+		{Title: "Cover", Filename: "cover.xhtml"},
+		{Title: "Chapter 1", Filename: "ch01.xhtml"},
+		{Title: "About the Publisher", Filename: "colophon.xhtml"},
+		{Title: "More Books", Filename: "ads01.xhtml"},
+	}
+	d, _ := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {}, Options{
+		NonlinearChapters: []string{"*about the publisher*", "ADS*.xhtml"},
+	})
+
+	opf, err := d.buildPackage(testBookInfo(), chapters, t.TempDir(), "").Bytes(false)
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	want := `<spine toc="ncx"><itemref idref="ch0"></itemref><itemref idref="ch1"></itemref>` +
+		`<itemref idref="ch2" linear="no"></itemref><itemref idref="ch3" linear="no"></itemref></spine>`
+	if !strings.Contains(string(opf), want) {
+		t.Errorf("Expected %s in content.opf, got:\n%s", want, opf)
+	}
+}
+
+func TestBuildPackage_NonlinearKeepsOneLinearItem(t *testing.T) {
+	d := &Downloader{bookID: "123", nonlinearChapters: []string{"*"}}
+	pkg := d.buildPackage(testBookInfo(), []models.Chapter{{Title: "Ads", Filename: "ads.xhtml"}}, t.TempDir(), "")
+	if got := pkg.Spine.ItemRefs[0].Linear; got != "" {
+		t.Errorf("Expected the only spine item to stay linear, got linear=%q", got)
+	}
+}
+
+func TestNewDownloader_RejectsBadNonlinearPattern(t *testing.T) {
+	if _, err := NewDownloader(Options{NonlinearChapters: []string{"[ads"}}); err == nil {
+		t.Error("Expected a malformed pattern to be rejected")
+	}
+}
+
+func TestBuildPackage_NoGuideByDefault(t *testing.T) {
+	d := &Downloader{bookID: "123", language: "en"}
+	if pkg := d.buildPackage(testBookInfo(), []models.Chapter{{Title: "One", Filename: "ch01.xhtml"}}, t.TempDir(), ""); pkg.Guide != nil {
+		t.Errorf("Expected no guide outside EPUB 2 compat mode, got %+v", pkg.Guide)
+	}
+}
+
+func TestWriteEPUBMetadata_CorrectsMislabeledImages(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	for _, streaming := range []bool{false, true} {
+		oebpsPath := t.TempDir()
+		imagesPath := filepath.Join(oebpsPath, "Images")
+		if err := os.MkdirAll(imagesPath, 0755); err != nil {
+			t.Fatalf("Failed to create images dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(imagesPath, "fig.jpg"), png, 0644); err != nil {
+			t.Fatalf("Failed to write image: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(oebpsPath, "ch01.xhtml"), []byte(`<img src="Images/fig.jpg"/>`), 0644); err != nil {
+			t.Fatalf("Failed to write chapter: %v", err)
+		}
+
+		d := &Downloader{bookID: "123"}
+		if streaming {
+			d.stream = &chapterStream{}
+		}
+		chapters := []models.Chapter{{Title: "One", Filename: "ch01.xhtml"}}
+		if err := d.writeEPUBMetadata(testBookInfo(), chapters, oebpsPath, ""); err != nil {
+			t.Fatalf("writeEPUBMetadata failed: %v", err)
+		}
+		opf, err := os.ReadFile(filepath.Join(oebpsPath, "content.opf"))
+		if err != nil {
+			t.Fatalf("Failed to read content.opf: %v", err)
+		}
+		page, err := os.ReadFile(filepath.Join(oebpsPath, "ch01.xhtml"))
+		if err != nil {
+			t.Fatalf("Failed to read chapter: %v", err)
+		}
+
+		wantName := "fig.png"
+		if streaming {
+			// Streamed chapters can't be rewritten, so the file keeps its name
+			wantName = "fig.jpg"
+		}
+		if want := `href="Images/` + wantName + `" media-type="image/png"`; !strings.Contains(string(opf), want) {
+			t.Errorf("streaming=%v: expected manifest entry %s, got:\n%s", streaming, want, opf)
+		}
+		if !utils.FileExists(filepath.Join(imagesPath, wantName)) {
+			t.Errorf("streaming=%v: expected image saved as %s", streaming, wantName)
+		}
+		if want := `"Images/` + wantName + `"`; !streaming && !strings.Contains(string(page), want) {
+			t.Errorf("Expected chapter to link %s, got %s", want, page)
+		}
+	}
+}
+
+func TestCoverPageXHTML_Styles(t *testing.T) {
+	for _, tt := range []struct {
+		style, cover string
+		want         []string
+		absent       []string
+	}{
+		{
+			style: coverStyleSVGViewport,
+			cover: "cover.jpg",
+			want: []string{
+				`viewBox="0 0 600 900" preserveAspectRatio="xMidYMid meet"`,
+				`<image width="600" height="900" xlink:href="Images/cover.jpg"/>`,
+			},
+			absent: []string{"<img ", "<div"},
+		},
+		{
+			style:  coverStyleFit,
+			cover:  "cover.jpg",
+			want:   []string{`<img src="Images/cover.jpg" alt="Cover"/>`, "max-width: 100%", "text-align:center"},
+			absent: []string{"<svg", "object-fit"},
+		},
+		{
+			style:  coverStyleFill,
+			cover:  "cover.jpg",
+			want:   []string{`<img src="Images/cover.jpg" alt="Cover"/>`, "object-fit: cover"},
+			absent: []string{"<svg", "<div"},
+		},
+		{
+			style:  coverStyleFit,
+			cover:  "cover.svg",
+			want:   []string{`<image width="100%" height="100%" xlink:href="Images/cover.svg"/>`},
+			absent: []string{"<img "},
+		},
+	} {
+		t.Run(tt.style+"/"+tt.cover, func(t *testing.T) {
+			page := coverPageXHTML(tt.cover, htmlDoctype, tt.style, 600, 900)
+			for _, want := range tt.want {
+				if !strings.Contains(page, want) {
+					t.Errorf("Expected %q in the cover page, got:\n%s", want, page)
+				}
+			}
+			for _, absent := range tt.absent {
+				if strings.Contains(page, absent) {
+					t.Errorf("Expected no %q in the cover page, got:\n%s", absent, page)
+				}
+			}
+		})
+	}
+
+	// Without the image size, svg-viewport scales the image to the page
+	page := coverPageXHTML("cover.jpg", htmlDoctype, coverStyleSVGViewport, 0, 0)
+	if !strings.Contains(page, `<image width="100%" height="100%" xlink:href="Images/cover.jpg"/>`) || strings.Contains(page, "viewBox") {
+		t.Errorf("Expected a 100%% <image> without a viewBox, got:\n%s", page)
+	}
+}
+
+func TestWriteCoverPage_SVGViewport(t *testing.T) {
+	oebpsPath := t.TempDir()
+	imagesPath := filepath.Join(oebpsPath, "Images")
+	if err := os.MkdirAll(imagesPath, 0755); err != nil {
+		t.Fatalf("Failed to create images dir: %v", err)
+	}
+	cover, err := utils.GenerateCover("Title", "Author", "")
+	if err != nil {
+		t.Fatalf("GenerateCover failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(imagesPath, "cover.jpg"), cover, 0644); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(oebpsPath, navFileName), []byte("<html/>"), 0644); err != nil {
+		t.Fatalf("Failed to write nav: %v", err)
+	}
+
+	d := &Downloader{bookID: "123", language: "en", coverPageStyle: coverStyleSVGViewport}
+	if err := d.writeCoverPage(oebpsPath, "cover.jpg"); err != nil {
+		t.Fatalf("writeCoverPage failed: %v", err)
+	}
+	page, err := os.ReadFile(filepath.Join(oebpsPath, coverPageName))
+	if err != nil {
+		t.Fatalf("Failed to read cover page: %v", err)
+	}
+	if !strings.Contains(string(page), `viewBox="0 0 1200 1800"`) {
+		t.Errorf("Expected the cover's own size as the viewBox, got:\n%s", page)
+	}
+
+	pkg := d.buildPackage(testBookInfo(), []models.Chapter{{Title: "One", Filename: "ch01.xhtml"}}, oebpsPath, "cover.jpg")
+	for _, item := range pkg.Manifest.Items {
+		if item.ID == "cover" && item.Properties != "svg" {
+			t.Errorf("Expected the cover page declared with svg properties, got %q", item.Properties)
+		}
+	}
+}
+
+func TestBuildPackage_MetadataFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "metadata.json")
+	data := `[
+		{"name": "dc:identifier", "value": "urn:doi:10.1000/182", "attributes": {"id": "doi", "opf:scheme": "DOI"}},
+		{"name": "dc:contributor", "value": "Ann \"The Editor\" & Co", "attributes": {"opf:role": "edt"}},
+		{"name": "dc:publisher", "value": "Self-published"}
+	]`
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write metadata file: %v", err)
+	}
+	elements, err := readMetadataFile(file)
+	if err != nil {
+		t.Fatalf("readMetadataFile failed: %v", err)
+	}
+
+	chapters := []models.Chapter{{Title: "One", Filename: "ch01.xhtml"}}
+	d := &Downloader{bookID: "123", language: "en", extraMetadata: elements}
+	opf, err := d.buildPackage(goldenBookInfo(t), chapters, t.TempDir(), "").Bytes(false)
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	for _, want := range []string{
+		`<dc:identifier id="bookid">9781234567890</dc:identifier>`,
+		`<dc:identifier id="doi" opf:scheme="DOI">urn:doi:10.1000/182</dc:identifier>`,
+		`<dc:contributor opf:role="edt">Ann &#34;The Editor&#34; &amp; Co</dc:contributor>`,
+		`<dc:publisher>Self-published</dc:publisher>`,
+	} {
+		if !strings.Contains(string(opf), want) {
+			t.Errorf("Expected %s in content.opf, got:\n%s", want, opf)
+		}
+	}
+	if strings.Contains(string(opf), "O&#39;Reilly") {
+		t.Errorf("Expected the API publisher to be replaced, got:\n%s", opf)
+	}
+}
+
+func TestReadMetadataFile_Invalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"not json":       `dc:title = Go`,
+		"empty":          `[]`,
+		"unknown name":   `[{"name": "dc:colour", "value": "red"}]`,
+		"bad attribute":  `[{"name": "dc:contributor", "value": "Ann", "attributes": {"a b": "x"}}]`,
+		"control char":   `[{"name": "dc:rights", "value": "\u0001"}]`,
+		"duplicate id":   `[{"name": "dc:identifier", "value": "a", "attributes": {"id": "x"}}, {"name": "dc:source", "value": "b", "attributes": {"id": "x"}}]`,
+		"second title":   `[{"name": "dc:title", "value": "A"}, {"name": "dc:title", "value": "B"}]`,
+		"anonymous meta": `[{"name": "meta", "attributes": {"content": "x"}}]`,
+	} {
+		file := filepath.Join(t.TempDir(), "metadata.json")
+		if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write metadata file: %v", err)
+		}
+		if _, err := readMetadataFile(file); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestCorrectImageTypes_KeepsExistingImageAndNestedPages(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	oebpsPath := t.TempDir()
+	imagesPath := filepath.Join(oebpsPath, "Images")
+	if err := os.MkdirAll(imagesPath, 0755); err != nil {
+		t.Fatalf("Failed to create images dir: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(oebpsPath, "part1"), 0755); err != nil {
+		t.Fatalf("Failed to create part dir: %v", err)
+	}
+	files := map[string][]byte{
+		"Images/fig.jpg":   png,
+		"Images/fig.png":   append(slices.Clone(png), "other"...),
+		"part1/ch01.xhtml": []byte(`<img src="../Images/fig.jpg"/>`),
+		"part1/ch02.xhtml": []byte(`<img src="../Images/fig.png"/>`),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(oebpsPath, filepath.FromSlash(name)), data, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	d := &Downloader{bookID: "123"}
+	d.correctImageTypes(oebpsPath, "")
+
+	fixed := hashedImageName("fig.png", "fig.jpg")
+	if got, err := os.ReadFile(filepath.Join(imagesPath, "fig.png")); err != nil || string(got) != string(files["Images/fig.png"]) {
+		t.Errorf("Expected the existing fig.png untouched, got %q (%v)", got, err)
+	}
+	if !utils.FileExists(filepath.Join(imagesPath, fixed)) {
+		t.Errorf("Expected fig.jpg renamed to %s", fixed)
+	}
+	page, err := os.ReadFile(filepath.Join(oebpsPath, "part1", "ch01.xhtml"))
+	if err != nil {
+		t.Fatalf("Failed to read chapter: %v", err)
+	}
+	if want := `"../Images/` + fixed + `"`; !strings.Contains(string(page), want) {
+		t.Errorf("Expected the nested chapter to link %s, got %s", want, page)
+	}
+}
+
+func TestRun_MetadataFileIdentifiesNCX(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "metadata.json")
+	data := `[
+		{"name": "dc:identifier", "value": "urn:uuid:0f9a", "attributes": {"id": "bookid"}},
+		{"name": "dc:title", "value": "Custom Title", "attributes": {"xml:lang": "en"}},
+		{"name": "dc:creator", "value": "Ann Author"}
+	]`
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write metadata file: %v", err)
+	}
+	_, epubPath := runMockBook(t, integrationBook(t), Options{MetadataFile: file})
+	archive := readValidEPUB(t, epubPath)
+
+	ncx := string(archive.Files["OEBPS/toc.ncx"])
+	if !strings.Contains(ncx, `<meta name="dtb:uid" content="urn:uuid:0f9a">`) {
+		t.Errorf("Expected the NCX uid to match the merged identifier, got:\n%s", ncx)
+	}
+	if !strings.Contains(ncx, "<text>Custom Title</text>") || !strings.Contains(ncx, "<text>Ann Author</text>") {
+		t.Errorf("Expected the NCX title and author from the merged metadata, got:\n%s", ncx)
+	}
+	opf := string(archive.Files["OEBPS/content.opf"])
+	if strings.Count(opf, "<dc:title") != 1 || !strings.Contains(opf, `<dc:title xml:lang="en">Custom Title</dc:title>`) {
+		t.Errorf("Expected a single merged dc:title, got:\n%s", opf)
+	}
+}
@@ -0,0 +1,56 @@
+package downloader
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/dacsang97/safaribooks/internal/epub"
+	"github.com/dacsang97/safaribooks/pkg/utils"
+)
+
+// metadataEntry is one element of a --epub-metadata-file, e.g.
+// {"name": "dc:contributor", "value": "Ann Editor", "attributes": {"opf:role": "edt"}}
+type metadataEntry struct {
+	Name       string            `json:"name"`
+	Value      string            `json:"value"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+// readMetadataFile reads a --epub-metadata-file: a JSON array of metadata
+// elements to merge into content.opf, see epub.Package.MergeMetadata.
+// Attributes are written in name order.
+func readMetadataFile(name string) ([]epub.Element, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var entries []metadataEntry
+	if err := json.Unmarshal(utils.TrimBOM(data), &entries); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", name, err)
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("no metadata elements listed")
+	}
+
+	elements := make([]epub.Element, 0, len(entries))
+	for i, entry := range entries {
+		var attrs []xml.Attr
+		for _, key := range slices.Sorted(maps.Keys(entry.Attributes)) {
+			attrs = append(attrs, xml.Attr{Name: xml.Name{Local: key}, Value: entry.Attributes[key]})
+		}
+		el, err := epub.NewElement(entry.Name, entry.Value, attrs)
+		if err != nil {
+			return nil, fmt.Errorf("element %d: %w", i+1, err)
+		}
+		elements = append(elements, el)
+	}
+	if err := epub.CheckElements(elements); err != nil {
+		return nil, err
+	}
+	return elements, nil
+}
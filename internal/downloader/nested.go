@@ -0,0 +1,149 @@
+package downloader
+
+import (
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/dacsang97/safaribooks/internal/html"
+	"github.com/dacsang97/safaribooks/internal/models"
+)
+
+// xhtmlName returns the file a chapter is written to
+func xhtmlName(filename string) string {
+	return strings.ReplaceAll(filename, ".html", ".xhtml")
+}
+
+// planNestedMerges returns, per top-level chapter file, the nested chapters
+// with content of their own to merge into its page, depth first, and each
+// merged .xhtml file mapped to the parent's. Nested chapters also listed at
+// the top level are left out with their children, as are those whose file is
+// already merged; those in the parent's own file only need a TOC entry.
+func planNestedMerges(chapters []models.Chapter) (map[string][]models.Chapter, map[string]string) {
+	topLevel := make(map[string]bool, len(chapters))
+	for _, chapter := range chapters {
+		topLevel[path.Base(xhtmlName(chapter.Filename))] = true
+	}
+	seen := maps.Clone(topLevel)
+
+	merges := make(map[string][]models.Chapter)
+	files := make(map[string]string)
+	var walk func(parent string, children []models.Chapter)
+	walk = func(parent string, children []models.Chapter) {
+		for _, child := range children {
+			name := path.Base(xhtmlName(child.Filename))
+			if child.Filename != "" && topLevel[name] && name != path.Base(xhtmlName(parent)) {
+				continue
+			}
+			if child.Content != "" && child.Filename != "" && !seen[name] {
+				seen[name] = true
+				merges[parent] = append(merges[parent], child)
+				files[name] = xhtmlName(parent)
+			}
+			walk(parent, child.Children)
+		}
+	}
+	for _, chapter := range chapters {
+		walk(chapter.Filename, chapter.Children)
+	}
+	return merges, files
+}
+
+// mergeNestedChapters fetches the nested chapters planned for chapter and
+// appends them to its body as sections, adding their stylesheets to its own
+func (d *Downloader) mergeNestedChapters(chapter *models.Chapter, children []models.Chapter, body []byte) ([]byte, error) {
+	if len(children) == 0 {
+		return body, nil
+	}
+
+	sections := make([]html.Section, 0, len(children))
+	for i := range children {
+		child := &children[i]
+		content, truncated, err := d.chapterBody(child)
+		if err != nil {
+			return nil, fmt.Errorf("nested chapter %s: %w", child.Title, err)
+		}
+		if truncated {
+			d.log.With("chapter", chapter.Title).Printf("[-] Warning: nested chapter %s exceeds %d bytes, truncating\n", child.Title, d.maxChapterSize)
+			content = truncateAtTag(content)
+		}
+		sections = append(sections, html.Section{Filename: child.Filename, Content: string(content)})
+		chapter.Stylesheets = slices.Concat(chapter.Stylesheets, child.Stylesheets)
+		chapter.SiteStyles = slices.Concat(chapter.SiteStyles, child.SiteStyles)
+	}
+
+	merged, err := html.MergeSections(string(body), sections)
+	if err != nil {
+		return nil, fmt.Errorf("merge nested chapters: %w", err)
+	}
+	d.log.With("chapter", chapter.Title).Printf("[*] Merged %d nested chapters into %s\n", len(sections), chapter.Title)
+	return []byte(merged), nil
+}
+
+// downloadChapterAssets downloads the images of a chapter and of the nested
+// chapters merged into it, returning the renamed files as downloadAssets does
+func (d *Downloader) downloadChapterAssets(chapter *models.Chapter, children []models.Chapter, bookPath string) map[string]string {
+	renames := d.downloadAssets(chapter, bookPath)
+	for i := range children {
+		maps.Copy(renames, d.downloadAssets(&children[i], bookPath))
+	}
+	return renames
+}
+
+// tocLinks lists the chapters for the table of contents. With
+// flattenNested, nested chapters are listed below their parent, linking to
+// their place in the parent's page, as are the chapters merged by
+// --deep-chapters.
+func (d *Downloader) tocLinks(chapters []models.Chapter) []navLink {
+	var merges map[string][]models.Chapter
+	var merged map[string]string
+	if d.flattenNested {
+		merges, merged = planNestedMerges(chapters)
+	}
+	if len(d.depthMerges) > 0 {
+		_, merged = planDepthMerges(chapters, d.depthMerges, merges, merged)
+	}
+
+	links := make([]navLink, len(chapters))
+	for i, chapter := range chapters {
+		links[i] = navLink{Href: chapter.Filename, Label: chapter.Title}
+		parent := xhtmlName(chapter.Filename)
+		if d.flattenNested {
+			links[i].Children = d.nestedLinks(parent, chapter.Children, merged)
+		}
+		links[i].Children = append(links[i].Children, d.depthLinks(parent, d.depthMerges[parent], merged)...)
+	}
+	return links
+}
+
+// nestedLinks returns the TOC links of nested chapters: into the parent's
+// page for merged chapters and sections of the parent's own file, otherwise
+// to the chapter's own file
+func (d *Downloader) nestedLinks(parent string, children []models.Chapter, merged map[string]string) []navLink {
+	var links []navLink
+	for _, child := range children {
+		file := xhtmlName(child.Filename)
+		fragment := strings.TrimPrefix(child.Fragment, "#")
+		href, page := parent, parent
+		switch _, isMerged := merged[path.Base(file)]; {
+		case isMerged:
+			href = html.FragmentHref(parent, firstNonEmpty(fragment, html.SectionID(file)), d.flattenAnchors)
+		case file != "" && file != parent:
+			// A chapter of its own, which its children are sections of
+			href, page = file, file
+			if fragment != "" {
+				href = html.FragmentHref(file, fragment, d.flattenAnchors)
+			}
+		case fragment != "":
+			href = html.FragmentHref(parent, fragment, d.flattenAnchors)
+		}
+		links = append(links, navLink{
+			Href:     href,
+			Label:    child.Title,
+			Children: d.nestedLinks(page, child.Children, merged),
+		})
+	}
+	return links
+}
@@ -0,0 +1,101 @@
+package downloader
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dacsang97/safaribooks/internal/epub"
+	"github.com/dacsang97/safaribooks/internal/html"
+	"github.com/dacsang97/safaribooks/internal/models"
+)
+
+func TestDownloadChapter_FlattenNested(t *testing.T) {
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ch01.html":
+			w.Write([]byte(`<div id="sbo-rt-content"><h1>Chapter</h1><p><a href="ch01s01.html#detail">See the section</a></p></div>`))
+		case "/ch01s01.html":
+			w.Write([]byte(`<div id="sbo-rt-content"><h2>Section</h2><p id="detail">Section text</p></div>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}, Options{FlattenNested: true})
+
+	bookPath := t.TempDir()
+	oebpsPath := filepath.Join(bookPath, "OEBPS")
+	if err := os.MkdirAll(oebpsPath, 0755); err != nil {
+		t.Fatalf("Failed to create OEBPS dir: %v", err)
+	}
+	d.state = newBookState(bookPath)
+
+	chapters := []models.Chapter{{
+		Title:    "Chapter",
+		Filename: "ch01.html",
+		Content:  server.URL + "/ch01.html",
+		Children: []models.Chapter{{Title: "Section", Filename: "ch01s01.html", Content: server.URL + "/ch01s01.html"}},
+	}}
+	var mergedFiles map[string]string
+	d.nestedMerges, mergedFiles = planNestedMerges(chapters)
+	if got := mergedFiles["ch01s01.xhtml"]; got != "ch01.xhtml" {
+		t.Fatalf("Expected ch01s01.xhtml merged into ch01.xhtml, got %q", got)
+	}
+
+	parser := html.NewParser(server.URL, html.ParserOptions{Language: "en", MergedFiles: mergedFiles})
+	if err := d.downloadChapter(oebpsPath, &chapters[0], false, parser, bookPath); err != nil {
+		t.Fatalf("downloadChapter failed: %v", err)
+	}
+
+	page, err := os.ReadFile(filepath.Join(oebpsPath, "ch01.xhtml"))
+	if err != nil {
+		t.Fatalf("Failed to read chapter: %v", err)
+	}
+	for _, want := range []string{`<section id="ch01s01">`, `Section text`, `href="ch01.xhtml#detail"`} {
+		if !strings.Contains(string(page), want) {
+			t.Errorf("Expected %s in the merged chapter, got:\n%s", want, page)
+		}
+	}
+	if strings.Index(string(page), "<h1>Chapter</h1>") > strings.Index(string(page), "<h2>Section</h2>") {
+		t.Errorf("Expected the section after its parent's content, got:\n%s", page)
+	}
+	if _, err := os.Stat(filepath.Join(oebpsPath, "ch01s01.xhtml")); !os.IsNotExist(err) {
+		t.Errorf("Expected no page for the merged chapter, got err %v", err)
+	}
+
+	ncx := d.buildNCX(epub.Metadata{Identifier: epub.Identifier{Value: "123"}}, chapters)
+	points := ncx.NavMap.NavPoints
+	if len(points) != 1 || len(points[0].Children) != 1 {
+		t.Fatalf("Expected one nav point with one child, got %+v", points)
+	}
+	child := points[0].Children[0]
+	if child.Content.Src != "ch01.xhtml#ch01s01" || child.NavLabel.Text != "Section" || child.PlayOrder != 2 {
+		t.Errorf("Expected the section linked by its anchor in ch01.xhtml, got %+v", child)
+	}
+}
+
+func TestPlanNestedMerges_SkipsTopLevelChapters(t *testing.T) {
+	chapters := []models.Chapter{
+		{Filename: "ch01.html", Children: []models.Chapter{
+			{Filename: "ch01.html", Fragment: "intro", Content: "ch01.html"},
+			{Filename: "ch02.html", Content: "ch02.html"},
+			{Filename: "ch01s01.html", Content: "ch01s01.html", Children: []models.Chapter{
+				{Filename: "ch01s02.html", Content: "ch01s02.html"},
+			}},
+		}},
+		{Filename: "ch02.html"},
+	}
+	merges, files := planNestedMerges(chapters)
+
+	var got []string
+	for _, child := range merges["ch01.html"] {
+		got = append(got, child.Filename)
+	}
+	if strings.Join(got, ",") != "ch01s01.html,ch01s02.html" {
+		t.Errorf("Expected ch01s01 and ch01s02 merged into ch01, got %v", got)
+	}
+	if len(files) != 2 || files["ch01s02.xhtml"] != "ch01.xhtml" {
+		t.Errorf("Unexpected merged files %v", files)
+	}
+}
@@ -0,0 +1,73 @@
+package downloader
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dacsang97/safaribooks/internal/epub"
+	"github.com/dacsang97/safaribooks/internal/models"
+	"github.com/dacsang97/safaribooks/pkg/utils"
+)
+
+// opdsEntryPath returns the file the OPDS entry of the EPUB at epubPath is
+// written to, next to it
+func opdsEntryPath(epubPath string) string {
+	return strings.TrimSuffix(epubPath, ".epub") + ".opds.xml"
+}
+
+// writeOPDSEntry writes the OPDS catalog entry of the finished EPUB at
+// epubPath next to it
+func (d *Downloader) writeOPDSEntry(bookInfo models.BookInfo, epubPath string) error {
+	entry, err := d.buildOPDSEntry(bookInfo, filepath.Base(epubPath), time.Now()).Bytes(d.prettyXML)
+	if err != nil {
+		return fmt.Errorf("encode OPDS entry: %w", err)
+	}
+	return d.writeFile(opdsEntryPath(epubPath), entry)
+}
+
+// buildOPDSEntry models the Atom entry of a book for an OPDS catalog, its
+// acquisition link pointing at epubName next to the entry and its image link
+// at the cover the API lists
+func (d *Downloader) buildOPDSEntry(bookInfo models.BookInfo, epubName string, updated time.Time) epub.Entry {
+	entry := epub.NewEntry()
+	entry.Title = firstNonEmpty(bookInfo.Title, d.bookID)
+	entry.ID = "urn:orm:book:" + firstNonEmpty(bookInfo.Identifier, d.bookID)
+	if bookInfo.ISBN != "" {
+		entry.ID = "urn:isbn:" + bookInfo.ISBN
+	}
+	entry.Updated = updated.UTC().Format(time.RFC3339)
+	if issued, err := time.Parse(time.DateOnly, bookInfo.Issued); err == nil {
+		entry.Published = issued.Format(time.RFC3339)
+	}
+	entry.Issued = bookInfo.Issued
+
+	for _, author := range bookInfo.Authors {
+		if author.Name != "" {
+			entry.Authors = append(entry.Authors, epub.Author{Name: author.Name})
+		}
+	}
+	for _, pub := range bookInfo.Publishers {
+		if pub.Name != "" {
+			entry.Publisher = pub.Name
+			break
+		}
+	}
+	entry.Language = firstNonEmpty(bookInfo.Language, defaultLanguage)
+	entry.Summary = bookInfo.Description
+	var subjects []string
+	for _, subject := range bookInfo.Subjects {
+		subjects = append(subjects, subject.Name)
+	}
+	for _, tag := range subjectTags(subjects, d.subjectsAsTags) {
+		entry.Categories = append(entry.Categories, epub.Category{Term: tag, Label: tag})
+	}
+
+	if bookInfo.Cover != "" {
+		entry.Links = append(entry.Links, epub.Link{Rel: epub.RelImage, Href: utils.ResolveURL("https://"+d.siteURL, bookInfo.Cover)})
+	}
+	entry.Links = append(entry.Links, epub.Link{Rel: epub.RelAcquisition, Href: url.PathEscape(epubName), Type: epubMimetype})
+	return entry
+}
@@ -0,0 +1,74 @@
+package downloader
+
+import (
+	"encoding/xml"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dacsang97/safaribooks/internal/epub"
+)
+
+func TestWriteOPDSEntry(t *testing.T) {
+	d := &Downloader{bookID: "123", siteURL: "learning.oreilly.com"}
+	info := goldenBookInfo(t)
+	info.Cover = "/library/cover/9781234567890/"
+
+	epubPath := filepath.Join(t.TempDir(), "Tips & Tricks for Go (123).epub")
+	if err := d.writeOPDSEntry(info, epubPath); err != nil {
+		t.Fatalf("writeOPDSEntry failed: %v", err)
+	}
+	data, err := os.ReadFile(opdsEntryPath(epubPath))
+	if err != nil {
+		t.Fatalf("Failed to read OPDS entry: %v", err)
+	}
+
+	var entry struct {
+		XMLName   xml.Name `xml:"http://www.w3.org/2005/Atom entry"`
+		Title     string   `xml:"title"`
+		ID        string   `xml:"id"`
+		Updated   string   `xml:"updated"`
+		Published string   `xml:"published"`
+		Authors   []string `xml:"author>name"`
+		Summary   string   `xml:"summary"`
+		Publisher string   `xml:"http://purl.org/dc/terms/ publisher"`
+		Issued    string   `xml:"http://purl.org/dc/terms/ issued"`
+		Links     []struct {
+			Rel  string `xml:"rel,attr"`
+			Href string `xml:"href,attr"`
+			Type string `xml:"type,attr"`
+		} `xml:"link"`
+	}
+	if err := xml.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("OPDS entry is not well-formed: %v\n%s", err, data)
+	}
+
+	if entry.Title != info.Title || entry.ID != "urn:isbn:9781234567890" || entry.Summary != info.Description {
+		t.Errorf("Unexpected title, id or summary:\n%s", data)
+	}
+	if len(entry.Authors) != 2 || entry.Authors[0] != "Jane O'Doe" || entry.Authors[1] != "John Roe" {
+		t.Errorf("Expected both authors, got %q", entry.Authors)
+	}
+	if entry.Published != "2024-01-01T00:00:00Z" || entry.Issued != "2024-01-01" || entry.Publisher != "O'Reilly Media, Inc." {
+		t.Errorf("Unexpected dates or publisher:\n%s", data)
+	}
+	if _, err := time.Parse(time.RFC3339, entry.Updated); err != nil {
+		t.Errorf("Expected an RFC 3339 updated time, got %q", entry.Updated)
+	}
+
+	if len(entry.Links) != 2 {
+		t.Fatalf("Expected image and acquisition links, got %+v", entry.Links)
+	}
+	if image := entry.Links[0]; image.Rel != epub.RelImage || image.Href != "https://learning.oreilly.com/library/cover/9781234567890/" {
+		t.Errorf("Unexpected image link %+v", image)
+	}
+	acquisition := entry.Links[1]
+	if acquisition.Rel != epub.RelAcquisition || acquisition.Type != "application/epub+zip" {
+		t.Errorf("Unexpected acquisition link %+v", acquisition)
+	}
+	if href, err := url.PathUnescape(acquisition.Href); err != nil || href != filepath.Base(epubPath) {
+		t.Errorf("Expected the acquisition link to point at the EPUB beside the entry, got %q", acquisition.Href)
+	}
+}
@@ -0,0 +1,223 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/dacsang97/safaribooks/internal/models"
+	"github.com/dacsang97/safaribooks/pkg/utils"
+)
+
+// Chapter orders accepted by Options.SortChapters
+const (
+	sortAPI      = "api"      // as the API lists them, covers first
+	sortTOC      = "toc"      // as the book's table of contents lists them
+	sortFilename = "filename" // by filename
+	sortNatural  = "natural"  // by filename, comparing runs of digits as numbers
+)
+
+// validSortChapters reports whether mode is a chapter order NewDownloader accepts
+func validSortChapters(mode string) bool {
+	switch mode {
+	case "", sortAPI, sortTOC, sortFilename, sortNatural:
+		return true
+	}
+	return false
+}
+
+// arrangeChapters applies the chapters file, the depth limit, and the sort
+// order, in that order, to the chapter list from the API
+func (d *Downloader) arrangeChapters(chapters []models.Chapter) ([]models.Chapter, error) {
+	if d.chapterList != nil {
+		var err error
+		if chapters, err = selectChapters(chapters, d.chapterList); err != nil {
+			return nil, err
+		}
+		d.log.Printf("[*] Using %d chapters in the order of the chapters file\n", len(chapters))
+	}
+	if d.maxChapterDepth > 0 {
+		chapters = d.limitChapterDepth(chapters)
+	}
+	if d.sortChapters != "" {
+		chapters = d.orderChapters(chapters)
+	}
+	return chapters, nil
+}
+
+// orderChapters puts chapters in the sortChapters order. The TOC is fetched
+// for the toc order; when that fails the API order is kept.
+func (d *Downloader) orderChapters(chapters []models.Chapter) []models.Chapter {
+	var toc []models.TocItem
+	if d.sortChapters == sortTOC {
+		var err error
+		if toc, err = d.client.GetBookTOC(d.bookID); err != nil {
+			d.log.Printf("[-] Keeping the API chapter order, the table of contents is unavailable: %v\n", err)
+			return chapters
+		}
+	}
+	return sortChapters(chapters, d.sortChapters, toc)
+}
+
+// sortChapters returns chapters in the given order, keeping cover chapters
+// first as GetBookChapters does. For the toc order, chapters missing from the
+// TOC, such as a copyright page, stay right after the chapter they follow in
+// the API order.
+func sortChapters(chapters []models.Chapter, mode string, toc []models.TocItem) []models.Chapter {
+	sorted := slices.Clone(chapters)
+	switch mode {
+	case sortTOC:
+		position := tocPositions(toc)
+		keys := make(map[string]int, len(sorted))
+		key := -1
+		for _, chapter := range sorted {
+			if pos, ok := position[path.Base(chapter.Filename)]; ok {
+				key = pos
+			}
+			keys[chapter.Filename] = key
+		}
+		slices.SortStableFunc(sorted, func(a, b models.Chapter) int {
+			return keys[a.Filename] - keys[b.Filename]
+		})
+	case sortFilename:
+		slices.SortStableFunc(sorted, func(a, b models.Chapter) int {
+			return strings.Compare(a.Filename, b.Filename)
+		})
+	case sortNatural:
+		slices.SortStableFunc(sorted, func(a, b models.Chapter) int {
+			return naturalCompare(a.Filename, b.Filename)
+		})
+	default:
+		return sorted
+	}
+
+	slices.SortStableFunc(sorted, func(a, b models.Chapter) int {
+		switch ac, bc := isCoverChapter(a), isCoverChapter(b); {
+		case ac && !bc:
+			return -1
+		case bc && !ac:
+			return 1
+		}
+		return 0
+	})
+	return sorted
+}
+
+// tocPositions maps each file the TOC links to, by base name, to the
+// position of its first entry, depth first
+func tocPositions(toc []models.TocItem) map[string]int {
+	positions := make(map[string]int)
+	var walk func(items []models.TocItem)
+	walk = func(items []models.TocItem) {
+		for _, item := range items {
+			file, _, _ := strings.Cut(item.Href, "#")
+			if name := path.Base(file); file != "" {
+				if _, ok := positions[name]; !ok {
+					positions[name] = len(positions)
+				}
+			}
+			walk(item.Children)
+		}
+	}
+	walk(toc)
+	return positions
+}
+
+// naturalCompare compares strings like strings.Compare, except that runs of
+// digits compare by their numeric value, so "ch2" sorts before "ch10"
+func naturalCompare(a, b string) int {
+	for a != "" && b != "" {
+		da, db := digitPrefix(a), digitPrefix(b)
+		if da == "" || db == "" {
+			if a[0] != b[0] {
+				return strings.Compare(a[:1], b[:1])
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		na, nb := strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+		if c := len(na) - len(nb); c != 0 {
+			return c
+		}
+		if c := strings.Compare(na, nb); c != 0 {
+			return c
+		}
+		a, b = a[len(da):], b[len(db):]
+	}
+	return len(a) - len(b)
+}
+
+// digitPrefix returns the run of ASCII digits s starts with
+func digitPrefix(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+// readChapterList reads a --chapters-file: one chapter ID or filename per
+// line, in the order wanted. Blank lines and lines starting with # are
+// skipped, and an entry listed twice is an error.
+func readChapterList(name string) ([]string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var list []string
+	seen := make(map[string]bool)
+	for i, line := range strings.Split(string(utils.TrimBOM(data)), "\n") {
+		entry := strings.TrimSpace(line)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		if seen[entry] {
+			return nil, fmt.Errorf("line %d: %s is listed twice", i+1, entry)
+		}
+		seen[entry] = true
+		list = append(list, entry)
+	}
+	if len(list) == 0 {
+		return nil, errors.New("no chapters listed")
+	}
+	return list, nil
+}
+
+// selectChapters returns the chapters named in list, in its order. An entry
+// names a chapter by its ID or by the base name of its file, with either an
+// .html or .xhtml extension; an entry naming no chapter is an error.
+func selectChapters(chapters []models.Chapter, list []string) ([]models.Chapter, error) {
+	byName := make(map[string]int, 2*len(chapters))
+	for i, chapter := range chapters {
+		if chapter.ID != "" {
+			byName[chapter.ID] = i
+		}
+		if chapter.Filename != "" {
+			byName[path.Base(chapter.Filename)] = i
+			byName[path.Base(xhtmlName(chapter.Filename))] = i
+		}
+	}
+
+	selected := make([]models.Chapter, 0, len(list))
+	picked := make(map[int]bool, len(list))
+	var unknown []string
+	for _, entry := range list {
+		i, ok := byName[entry]
+		switch {
+		case !ok:
+			unknown = append(unknown, entry)
+		case picked[i]:
+			return nil, fmt.Errorf("chapters file names %s twice (by ID and by filename)", chapters[i].Filename)
+		default:
+			picked[i] = true
+			selected = append(selected, chapters[i])
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("chapters file lists %d unknown chapters: %s", len(unknown), strings.Join(unknown, ", "))
+	}
+	return selected, nil
+}
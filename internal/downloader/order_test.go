@@ -0,0 +1,199 @@
+package downloader
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dacsang97/safaribooks/internal/models"
+)
+
+func testChapterSet() []models.Chapter {
+	return []models.Chapter{
+		{Title: "Cover", Filename: "cover.html"},
+		{Title: "Chapter 10", Filename: "ch10.html"},
+		{Title: "Copyright", Filename: "copyright.html"},
+		{Title: "Chapter 2", Filename: "ch2.html"},
+		{Title: "Chapter 1", Filename: "ch1.html"},
+	}
+}
+
+func chapterFilenames(chapters []models.Chapter) string {
+	names := make([]string, len(chapters))
+	for i, chapter := range chapters {
+		names[i] = chapter.Filename
+	}
+	return strings.Join(names, " ")
+}
+
+func TestSortChapters(t *testing.T) {
+	toc := []models.TocItem{
+		{Href: "ch1.html", Children: []models.TocItem{{Href: "ch1.html#s1"}, {Href: "ch2.html"}}},
+		{Href: "ch10.html#start"},
+	}
+	tests := map[string]string{
+		sortAPI:      "cover.html ch10.html copyright.html ch2.html ch1.html",
+		sortTOC:      "cover.html ch1.html ch2.html ch10.html copyright.html",
+		sortFilename: "cover.html ch1.html ch10.html ch2.html copyright.html",
+		sortNatural:  "cover.html ch1.html ch2.html ch10.html copyright.html",
+	}
+	for mode, want := range tests {
+		chapters := testChapterSet()
+		if got := chapterFilenames(sortChapters(chapters, mode, toc)); got != want {
+			t.Errorf("%s order = %s, want %s", mode, got, want)
+		}
+		if got := chapterFilenames(chapters); got != chapterFilenames(testChapterSet()) {
+			t.Errorf("%s order changed the input slice to %s", mode, got)
+		}
+	}
+}
+
+func TestSortChapters_TOCKeepsUnlistedChaptersInPlace(t *testing.T) {
+	chapters := []models.Chapter{
+		{Filename: "preface.html"},
+		{Filename: "ch02.html"},
+		{Filename: "notes02.html"},
+		{Filename: "ch01.html"},
+		{Filename: "back-cover.html"},
+	}
+	toc := []models.TocItem{{Href: "preface.html"}, {Href: "ch01.html"}, {Href: "ch02.html"}}
+	want := "back-cover.html preface.html ch01.html ch02.html notes02.html"
+	if got := chapterFilenames(sortChapters(chapters, sortTOC, toc)); got != want {
+		t.Errorf("toc order = %s, want %s", got, want)
+	}
+}
+
+func TestNewDownloader_RejectsBadChapterOrder(t *testing.T) {
+	if _, err := NewDownloader(Options{SortChapters: "random"}); err == nil {
+		t.Error("Expected an unsupported chapter order to be rejected")
+	}
+}
+
+func TestIsCoverChapter(t *testing.T) {
+	cases := []struct {
+		chapter models.Chapter
+		want    bool
+	}{
+		{models.Chapter{Filename: "cover.xhtml"}, true},
+		{models.Chapter{Filename: "Cover1.html"}, true},
+		{models.Chapter{Filename: "9781492-cover.html"}, true},
+		{models.Chapter{Title: "Cover Page", Filename: "front.html"}, true},
+		{models.Chapter{Title: "Discovery", Filename: "discovery.html"}, false},
+		{models.Chapter{Title: "Covering Letters", Filename: "ch03-covering.html"}, false},
+		{models.Chapter{Filename: "coverage.html"}, false},
+	}
+	for _, c := range cases {
+		if got := isCoverChapter(c.chapter); got != c.want {
+			t.Errorf("isCoverChapter(%+v) = %v, want %v", c.chapter, got, c.want)
+		}
+	}
+}
+
+func TestNaturalCompare(t *testing.T) {
+	for _, c := range []struct{ a, b string }{
+		{"ch2", "ch10"},
+		{"ch02", "ch10"},
+		{"ch1s2", "ch1s10"},
+		{"appa", "appb"},
+		{"ch1", "ch1a"},
+	} {
+		if naturalCompare(c.a, c.b) >= 0 || naturalCompare(c.b, c.a) <= 0 {
+			t.Errorf("Expected %s before %s", c.a, c.b)
+		}
+	}
+}
+
+func TestOrderChapters_TOCFromAPI(t *testing.T) {
+	d, _ := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/book/123/toc/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`[{"href": "ch1.html"}, {"href": "ch2.html"}, {"href": "ch10.html"}]`))
+	}, Options{SortChapters: sortTOC})
+
+	want := "cover.html ch1.html ch2.html ch10.html copyright.html"
+	if got := chapterFilenames(d.orderChapters(testChapterSet())); got != want {
+		t.Errorf("toc order = %s, want %s", got, want)
+	}
+}
+
+func TestSelectChapters(t *testing.T) {
+	chapters := testChapterSet()
+	chapters[3].ID = "id-2"
+
+	listPath := filepath.Join(t.TempDir(), "chapters.txt")
+	list := "\ufeff# custom build\nch10.xhtml\n\n  id-2  \ncover.html\n"
+	if err := os.WriteFile(listPath, []byte(list), 0644); err != nil {
+		t.Fatalf("Failed to write chapters file: %v", err)
+	}
+	entries, err := readChapterList(listPath)
+	if err != nil {
+		t.Fatalf("readChapterList failed: %v", err)
+	}
+	selected, err := selectChapters(chapters, entries)
+	if err != nil {
+		t.Fatalf("selectChapters failed: %v", err)
+	}
+	if got, want := chapterFilenames(selected), "ch10.html ch2.html cover.html"; got != want {
+		t.Errorf("Expected spine %q, got %q", want, got)
+	}
+
+	if _, err := selectChapters(chapters, []string{"ch1.html", "ch99.html", "appendix"}); err == nil ||
+		!strings.Contains(err.Error(), "ch99.html, appendix") {
+		t.Errorf("Expected the unknown entries reported, got %v", err)
+	}
+	if _, err := selectChapters(chapters, []string{"id-2", "ch2.html"}); err == nil {
+		t.Error("Expected a chapter named twice to be rejected")
+	}
+	if err := os.WriteFile(listPath, []byte("ch1.html\nch1.html\n"), 0644); err != nil {
+		t.Fatalf("Failed to write chapters file: %v", err)
+	}
+	if _, err := readChapterList(listPath); err == nil {
+		t.Error("Expected a repeated line to be rejected")
+	}
+}
+
+func TestRun_ChaptersFile(t *testing.T) {
+	listPath := filepath.Join(t.TempDir(), "chapters.txt")
+	if err := os.WriteFile(listPath, []byte("ch02.html\nch01.html\n"), 0644); err != nil {
+		t.Fatalf("Failed to write chapters file: %v", err)
+	}
+	var server *httptest.Server
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/book/123/":
+			w.Write([]byte(`{"title": "Test Book", "identifier": "123"}`))
+		case r.URL.Path == "/api/v1/book/123/chapter/":
+			fmt.Fprintf(w, `{"count": 3, "next": null, "results": [
+				{"id": "1", "title": "One", "filename": "ch01.html", "content": "%[1]s/ch01.html"},
+				{"id": "2", "title": "Two", "filename": "ch02.html", "content": "%[1]s/ch02.html"},
+				{"id": "3", "title": "Three", "filename": "ch03.html", "content": "%[1]s/ch03.html"}]}`, server.URL)
+		case strings.HasSuffix(r.URL.Path, ".html"):
+			fmt.Fprintf(w, `<div id="sbo-rt-content"><p>%s</p></div>`, r.URL.Path)
+		default:
+			http.NotFound(w, r)
+		}
+	}, Options{ChaptersFile: listPath})
+
+	if err := d.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	bookPath := d.bookDirectory(testBookInfo())
+	opf, err := os.ReadFile(filepath.Join(bookPath, "OEBPS", "content.opf"))
+	if err != nil {
+		t.Fatalf("Failed to read content.opf: %v", err)
+	}
+	// Spine items are numbered in manifest order
+	two, one := strings.Index(string(opf), `href="ch02.xhtml"`), strings.Index(string(opf), `href="ch01.xhtml"`)
+	if two < 0 || one < 0 || two > one || strings.Contains(string(opf), "ch03") {
+		t.Errorf("Expected ch02 then ch01 only, got:\n%s", opf)
+	}
+	if _, err := os.Stat(filepath.Join(bookPath, "OEBPS", "ch03.xhtml")); !os.IsNotExist(err) {
+		t.Errorf("Expected ch03.xhtml not to be downloaded, got %v", err)
+	}
+}
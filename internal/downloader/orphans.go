@@ -0,0 +1,80 @@
+package downloader
+
+import (
+	"io/fs"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// imageRefRe matches a reference to a file under Images/, in a page's src,
+// href or srcset or in a stylesheet's url(). Commas end it, as they separate
+// srcset candidates.
+var imageRefRe = regexp.MustCompile(`Images/([^"'()\s?#<>,]+)`)
+
+// findOrphanImages returns the files in Images/ that no page, stylesheet or
+// TOC under oebpsPath refers to, such as images an earlier run downloaded
+// for chapters since dropped or rendered text-only. The cover and its
+// thumbnail always count as referenced. Streamed chapters never reach the
+// disk, so nothing is reported while streaming.
+func (d *Downloader) findOrphanImages(oebpsPath, coverFilename string) map[string]bool {
+	if d.stream != nil {
+		return nil
+	}
+	imagesPath := filepath.Join(oebpsPath, "Images")
+	entries, err := os.ReadDir(imagesPath)
+	if err != nil || len(entries) == 0 {
+		return nil
+	}
+
+	referenced := map[string]bool{coverFilename: true, coverThumbName: coverFilename != ""}
+	err = filepath.WalkDir(oebpsPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path == imagesPath || entry.Name() == rawChaptersDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch filepath.Ext(path) {
+		case ".xhtml", ".html", ".css", ".ncx":
+		default:
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, match := range imageRefRe.FindAllStringSubmatch(string(data), -1) {
+			name := match[1]
+			if unescaped, err := url.PathUnescape(name); err == nil {
+				name = unescaped
+			}
+			referenced[name] = true
+		}
+		return nil
+	})
+	if err != nil {
+		// Better an unused image in the book than a missing one
+		d.log.Printf("[-] Could not check for unused images: %v\n", err)
+		return nil
+	}
+
+	orphans := make(map[string]bool)
+	for _, entry := range entries {
+		if !entry.IsDir() && !referenced[entry.Name()] {
+			orphans[entry.Name()] = true
+		}
+	}
+	if len(orphans) > 0 {
+		names := slices.Sorted(maps.Keys(orphans))
+		d.log.Printf("[*] Leaving out %d images no page refers to: %s\n", len(orphans), strings.Join(names, ", "))
+	}
+	return orphans
+}
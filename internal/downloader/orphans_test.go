@@ -0,0 +1,41 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRun_OrphanImagesLeftOut(t *testing.T) {
+	book := integrationBook(t)
+	d := newMockDownloader(t, book, Options{Resume: true})
+
+	// An image an earlier run downloaded for a chapter that no longer shows it
+	imagesPath := filepath.Join(d.bookDirectory(book.bookInfo()), "OEBPS", "Images")
+	if err := os.MkdirAll(imagesPath, 0755); err != nil {
+		t.Fatalf("Failed to create Images dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(imagesPath, "stale.png"), mockPNG(t, 5, 5), 0644); err != nil {
+		t.Fatalf("Failed to write stale image: %v", err)
+	}
+
+	if err := d.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	bookPath := d.bookDirectory(book.bookInfo())
+	archive := readValidEPUB(t, filepath.Join(bookPath, filepath.Base(bookPath)+".epub"))
+
+	if _, ok := archive.Files["OEBPS/Images/stale.png"]; ok {
+		t.Error("Expected the orphan image left out of the EPUB")
+	}
+	for _, item := range archive.Manifest {
+		if item.Href == "Images/stale.png" {
+			t.Errorf("Expected the orphan image left out of the manifest, got %+v", item)
+		}
+	}
+	for _, name := range []string{"OEBPS/Images/one.png", "OEBPS/Images/two.png", "OEBPS/Images/cover.png"} {
+		if _, ok := archive.Files[name]; !ok {
+			t.Errorf("Expected %s kept, got %v", name, archive.Names)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package downloader
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun_MetadataOverrides(t *testing.T) {
+	book := &mockBook{
+		Info: map[string]any{
+			"title":   "Learning Go: An Idiomatic Appro",
+			"authors": []map[string]string{{"name": "Wrong Author"}},
+		},
+		Chapters: []mockChapter{{Title: "One", Filename: "ch01.html", Body: "<p>One</p>"}},
+	}
+	d := newMockDownloader(t, book, Options{
+		TitleOverride:   "Learning Go",
+		AuthorOverrides: []string{"Jon Bodner", " "},
+	})
+	if err := d.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	epubPath := filepath.Join(d.booksDir, "Learning Go (123)", "Learning Go (123).epub")
+	archive := readValidEPUB(t, epubPath)
+	opf := string(archive.Files["OEBPS/content.opf"])
+	for _, want := range []string{">Learning Go</dc:title>", ">Jon Bodner</dc:creator>"} {
+		if !strings.Contains(opf, want) {
+			t.Errorf("Expected %s in content.opf, got:\n%s", want, opf)
+		}
+	}
+	if strings.Contains(opf, "Wrong Author") || strings.Contains(opf, "Appro") {
+		t.Errorf("Expected the API title and authors replaced, got:\n%s", opf)
+	}
+	if ncx := string(archive.Files["OEBPS/toc.ncx"]); !strings.Contains(ncx, "<text>Learning Go</text>") {
+		t.Errorf("Expected the TOC titled Learning Go, got:\n%s", ncx)
+	}
+}
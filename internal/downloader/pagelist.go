@@ -0,0 +1,110 @@
+package downloader
+
+import (
+	"fmt"
+	stdhtml "html"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dacsang97/safaribooks/internal/html"
+	"github.com/dacsang97/safaribooks/internal/models"
+)
+
+// navFileName is the EPUB 3 navigation document, written unless --no-page-list
+// when chapters carry print page markers so readers can go to a print page
+const navFileName = "nav.xhtml"
+
+// navLink is an entry in the navigation document
+type navLink struct {
+	Href     string
+	Label    string
+	Children []navLink // nested entries, for the table of contents
+}
+
+// writePageList writes nav.xhtml with a page-list built from the chapters'
+// page-break markers, removing one left by an earlier run when there are
+// none; buildPackage adds it to the manifest when present
+func (d *Downloader) writePageList(bookInfo models.BookInfo, chapters []models.Chapter, oebpsPath string) error {
+	navPath := filepath.Join(oebpsPath, navFileName)
+	if d.epub2Compat || d.noPageList {
+		os.Remove(navPath)
+		return nil
+	}
+
+	var pages []navLink
+	for _, chapter := range chapters {
+		breaks, err := d.chapterPageBreaks(oebpsPath, chapter)
+		if err != nil {
+			return err
+		}
+		for _, pb := range breaks {
+			pages = append(pages, navLink{Href: chapter.Filename + "#" + pb.ID, Label: pb.Label})
+		}
+	}
+	if len(pages) == 0 {
+		os.Remove(navPath)
+		return nil
+	}
+
+	page := navXHTML(firstNonEmpty(bookInfo.Title, d.bookID), firstNonEmpty(bookInfo.Language, defaultLanguage), d.tocLinks(chapters), pages)
+	if err := d.writeFile(navPath, []byte(page)); err != nil {
+		return fmt.Errorf("write %s: %w", navFileName, err)
+	}
+	d.log.Printf("[*] Added a page list with %d print pages\n", len(pages))
+	return nil
+}
+
+// chapterPageBreaks returns a chapter's print page markers, from the chapter
+// file or as recorded while streaming it into the EPUB
+func (d *Downloader) chapterPageBreaks(oebpsPath string, chapter models.Chapter) ([]html.PageBreak, error) {
+	if d.stream != nil {
+		d.stream.mu.Lock()
+		defer d.stream.mu.Unlock()
+		return d.stream.pageBreaks[chapter.Filename], nil
+	}
+	data, err := os.ReadFile(filepath.Join(oebpsPath, chapter.Filename))
+	if err != nil {
+		return nil, fmt.Errorf("read chapter %s: %w", chapter.Filename, err)
+	}
+	return html.PageBreaks(string(data)), nil
+}
+
+// navXHTML builds the EPUB 3 navigation document. It has to hold a table of
+// contents too; both lists are hidden since readers show them in their own UI.
+func navXHTML(title, lang string, toc, pages []navLink) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="%s" xml:lang="%s">
+<head>
+<title>%s</title>
+</head>
+<body>
+`, stdhtml.EscapeString(lang), stdhtml.EscapeString(lang), stdhtml.EscapeString(title))
+	writeNavList(&b, "toc", "Contents", toc)
+	writeNavList(&b, "page-list", "Pages", pages)
+	b.WriteString("</body>\n</html>\n")
+	return b.String()
+}
+
+// writeNavList writes a hidden nav element listing links
+func writeNavList(b *strings.Builder, navType, heading string, links []navLink) {
+	fmt.Fprintf(b, "<nav epub:type=\"%s\" hidden=\"hidden\">\n<h1>%s</h1>\n", navType, heading)
+	writeNavItems(b, links)
+	b.WriteString("</nav>\n")
+}
+
+// writeNavItems writes links as an ordered list, nesting their children
+func writeNavItems(b *strings.Builder, links []navLink) {
+	b.WriteString("<ol>\n")
+	for _, link := range links {
+		fmt.Fprintf(b, "<li><a href=\"%s\">%s</a>", stdhtml.EscapeString(link.Href), stdhtml.EscapeString(link.Label))
+		if len(link.Children) > 0 {
+			b.WriteString("\n")
+			writeNavItems(b, link.Children)
+		}
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</ol>\n")
+}
@@ -0,0 +1,115 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dacsang97/safaribooks/internal/models"
+)
+
+// writeTestChapters writes chapter pages into oebpsPath keyed by filename
+func writeTestChapters(t *testing.T, oebpsPath string, pages map[string]string) {
+	t.Helper()
+	for name, page := range pages {
+		if err := os.WriteFile(filepath.Join(oebpsPath, name), []byte(page), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+}
+
+func TestWritePageList(t *testing.T) {
+	oebpsPath := t.TempDir()
+	chapters := []models.Chapter{
+		{Title: "Preface", Filename: "preface.xhtml"},
+		{Title: "Chapter 1", Filename: "ch01.xhtml"},
+	}
+	writeTestChapters(t, oebpsPath, map[string]string{
+		"preface.xhtml": `<html><body><p>No print pages here.</p></body></html>`,
+		"ch01.xhtml": `<html><body><p>One<span epub:type="pagebreak" id="page_1" title="1"></span></p>` +
+			`<p>Two<span epub:type="pagebreak" id="page_2" title="2"></span></p></body></html>`,
+	})
+
+	d := &Downloader{bookID: "123"}
+	bookInfo := testBookInfo()
+	bookInfo.Issued = "2021-03-15"
+	if err := d.writePageList(bookInfo, chapters, oebpsPath); err != nil {
+		t.Fatalf("writePageList failed: %v", err)
+	}
+	nav, err := os.ReadFile(filepath.Join(oebpsPath, navFileName))
+	if err != nil {
+		t.Fatalf("Expected %s to be written: %v", navFileName, err)
+	}
+	for _, want := range []string{
+		`<nav epub:type="toc" hidden="hidden">`,
+		`<li><a href="preface.xhtml">Preface</a></li>`,
+		`<nav epub:type="page-list" hidden="hidden">`,
+		`<li><a href="ch01.xhtml#page_1">1</a></li>`,
+		`<li><a href="ch01.xhtml#page_2">2</a></li>`,
+	} {
+		if !strings.Contains(string(nav), want) {
+			t.Errorf("Expected %s in %s, got:\n%s", want, navFileName, nav)
+		}
+	}
+
+	opf, err := d.buildPackage(bookInfo, chapters, oebpsPath, "").Bytes(false)
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	for _, want := range []string{
+		`version="3.0"`,
+		`<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"></item>`,
+		`<meta property="dcterms:modified">2021-03-15T00:00:00Z</meta>`,
+	} {
+		if !strings.Contains(string(opf), want) {
+			t.Errorf("Expected %s in content.opf, got:\n%s", want, opf)
+		}
+	}
+	if strings.Contains(string(opf), `<itemref idref="nav">`) {
+		t.Errorf("Expected nav.xhtml to stay out of the spine, got:\n%s", opf)
+	}
+
+	// --no-page-list removes the nav document from an earlier run
+	d.noPageList = true
+	if err := d.writePageList(testBookInfo(), chapters, oebpsPath); err != nil {
+		t.Fatalf("writePageList failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(oebpsPath, navFileName)); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed with noPageList, got %v", navFileName, err)
+	}
+	if pkg := d.buildPackage(testBookInfo(), chapters, oebpsPath, ""); pkg.Version != "2.0" {
+		t.Errorf("Expected an EPUB 2 package without a page list, got version %s", pkg.Version)
+	}
+}
+
+func TestWritePageList_NoMarkers(t *testing.T) {
+	oebpsPath := t.TempDir()
+	chapters := []models.Chapter{{Title: "Chapter 1", Filename: "ch01.xhtml"}}
+	writeTestChapters(t, oebpsPath, map[string]string{"ch01.xhtml": `<html><body><p id="intro">Text</p></body></html>`})
+
+	d := &Downloader{bookID: "123"}
+	if err := d.writePageList(testBookInfo(), chapters, oebpsPath); err != nil {
+		t.Fatalf("writePageList failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(oebpsPath, navFileName)); !os.IsNotExist(err) {
+		t.Errorf("Expected no %s without page-break markers, got %v", navFileName, err)
+	}
+}
+
+func TestModifiedDate(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 30, 0, 0, time.UTC)
+	cases := map[string]string{
+		"2021-03-15":                "2021-03-15T00:00:00Z",
+		"2021-03-15T10:20:30+02:00": "2021-03-15T08:20:30Z",
+		"2021":                      "2021-01-01T00:00:00Z",
+		"":                          "2024-06-01T12:30:00Z",
+		"soon":                      "2024-06-01T12:30:00Z",
+	}
+	for issued, want := range cases {
+		if got := modifiedDate(issued, now); got != want {
+			t.Errorf("modifiedDate(%q) = %q, want %q", issued, got, want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package downloader
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dacsang97/safaribooks/pkg/utils"
+)
+
+// Modes for created directories and files unless Options overrides them;
+// the umask applies to these, but not to the modes Options sets
+const (
+	defaultDirPerm  os.FileMode = 0755
+	defaultFilePerm os.FileMode = 0644
+)
+
+// parsePermissions parses an octal mode option, returning 0, for the default
+// mode under the umask, when it is empty. The owner must keep the access the
+// downloader itself needs, want.
+func parsePermissions(s string, want os.FileMode) (os.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+	perm, err := utils.ParsePermissions(s)
+	if err != nil {
+		return 0, err
+	}
+	if perm&want != want {
+		return 0, fmt.Errorf("permissions %q must include %04o for the owner", s, want)
+	}
+	return perm, nil
+}
+
+// mkdirMode creates dir and any missing parents with perm exactly, or with
+// the default mode under the umask when perm is 0
+func mkdirMode(dir string, perm os.FileMode) error {
+	if perm == 0 {
+		return os.MkdirAll(dir, defaultDirPerm)
+	}
+	return utils.MkdirAllMode(dir, perm)
+}
+
+// mkdir creates dir and any missing parents with the directory mode
+func (d *Downloader) mkdir(dir string) error {
+	return mkdirMode(dir, d.dirPerm)
+}
+
+// writeFile atomically writes a file with the file mode
+func (d *Downloader) writeFile(path string, data []byte) error {
+	if err := utils.WriteFileAtomic(path, data, defaultFilePerm); err != nil {
+		return err
+	}
+	return chmodFile(path, d.filePerm)
+}
+
+// chmodFile gives a file the downloader wrote perm, the --file-permissions
+// mode the umask would have masked; a perm of 0 keeps the default mode
+func chmodFile(path string, perm os.FileMode) error {
+	if perm == 0 {
+		return nil
+	}
+	return os.Chmod(path, perm)
+}
@@ -0,0 +1,50 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRun_CustomPermissions(t *testing.T) {
+	d, _ := newTestDownloader(t, serveTestBook(), Options{DirPermissions: "0750", FilePermissions: "0640"})
+	if err := d.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	bookPath := d.bookDirectory(testBookInfo())
+	epubPath := filepath.Join(bookPath, filepath.Base(bookPath)+".epub")
+	modes := map[string]os.FileMode{
+		d.booksDir: 0750,
+		bookPath:   0750,
+		filepath.Join(bookPath, "OEBPS", "Images"):             0750,
+		filepath.Join(bookPath, "OEBPS", "ch01.xhtml"):         0640,
+		filepath.Join(bookPath, "OEBPS", "Images", "fig1.png"): 0640,
+		filepath.Join(bookPath, stateFileName):                 0640,
+		epubPath:                                               0640,
+		epubPath + ".sha256":                                   0640,
+	}
+	for path, want := range modes {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Errorf("Stat failed: %v", err)
+			continue
+		}
+		if got := info.Mode().Perm(); got != want {
+			t.Errorf("Expected %s to have mode %04o, got %04o", path, want, got)
+		}
+	}
+}
+
+func TestNewDownloader_RejectsBadPermissions(t *testing.T) {
+	for _, opts := range []Options{
+		{DirPermissions: "rwx"},
+		{DirPermissions: "0644"}, // directories the owner can't enter
+		{FilePermissions: "0999"},
+		{FilePermissions: "0444"}, // files the owner can't rewrite on resume
+	} {
+		if _, err := NewDownloader(opts); err == nil {
+			t.Errorf("Expected %+v to be rejected", opts)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestRun_UnsetPermissionsFollowUmask(t *testing.T) {
+	old := syscall.Umask(0077)
+	t.Cleanup(func() { syscall.Umask(old) })
+
+	// No --dir-permissions or --file-permissions, as main passes them unset
+	_, epubPath := runMockBook(t, integrationBook(t), Options{})
+	for path, want := range map[string]os.FileMode{
+		epubPath:               0600,
+		epubPath + ".sha256":   0600,
+		filepath.Dir(epubPath): 0700,
+	} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		if got := info.Mode().Perm(); got != want {
+			t.Errorf("Expected %s to get %04o under the umask, got %04o", filepath.Base(path), want, got)
+		}
+	}
+}
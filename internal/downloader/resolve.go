@@ -0,0 +1,108 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"text/tabwriter"
+
+	safarihttp "github.com/dacsang97/safaribooks/internal/http"
+	"github.com/dacsang97/safaribooks/internal/models"
+	"github.com/dacsang97/safaribooks/pkg/utils"
+)
+
+// Where ResolveImage found an image's URL
+const (
+	resolvedByFiles    = "v2 files listing"
+	resolvedAbsolute   = "absolute URL, used as given"
+	resolvedByBaseURL  = "chapter asset base URL"
+	resolvedUnresolved = "unresolved"
+)
+
+// ImageResolution explains how an image reference in a chapter resolves
+type ImageResolution struct {
+	Chapter    string   // file of the chapter the reference was resolved for
+	Ref        string   // the reference as given
+	BaseURL    string   // the chapter's asset base URL
+	FilesError string   // why the v2 files listing is unavailable, if it is
+	Source     string   // which branch resolved the reference
+	URLs       []string // the URLs a download tries, in order
+}
+
+// ResolveImage resolves an image reference the way downloadAssets does for
+// the chapter of bookID with the given file name, the first chapter when it
+// is empty, and reports the URLs a download would try, fetching only the
+// chapter list and the v2 files listing. imageSize is as in
+// Options.ImageSize.
+func ResolveImage(client *safarihttp.Client, bookID, chapterFile, ref, imageSize string) (ImageResolution, error) {
+	chapters, err := client.GetBookChapters(bookID)
+	if err != nil {
+		return ImageResolution{}, err
+	}
+	chapter, err := findChapter(chapters, chapterFile)
+	if err != nil {
+		return ImageResolution{}, err
+	}
+
+	d := &Downloader{client: client, bookID: bookID, siteURL: client.SiteURL(), imageSize: imageSize}
+	res := ImageResolution{Chapter: chapter.Filename, Ref: ref, BaseURL: d.assetBaseURL(chapter)}
+	if files, err := client.GetBookFiles(bookID); err != nil {
+		res.FilesError = err.Error()
+	} else {
+		d.assets = newAssetIndex(files)
+	}
+
+	// The branches of resolveImageURL
+	url, ok := d.assets.lookup(chapter, ref)
+	switch {
+	case ok:
+		res.Source = resolvedByFiles
+	case utils.IsAbsoluteURL(ref):
+		url, res.Source = utils.ResolveURL(res.BaseURL, ref), resolvedAbsolute
+	default:
+		url, res.Source = utils.ResolveURL(res.BaseURL, ref), resolvedByBaseURL
+	}
+	if url == "" {
+		res.Source = resolvedUnresolved
+		return res, nil
+	}
+	if sized, ok := urlWithSize(url, d.imageSize); ok && sized != url {
+		res.URLs = append(res.URLs, sized)
+	}
+	res.URLs = append(res.URLs, url)
+	return res, nil
+}
+
+// findChapter returns the chapter whose file has the base name of name, or
+// the first chapter when name is empty
+func findChapter(chapters []models.Chapter, name string) (*models.Chapter, error) {
+	if len(chapters) == 0 {
+		return nil, fmt.Errorf("book has no chapters")
+	}
+	if name == "" {
+		return &chapters[0], nil
+	}
+	for i := range chapters {
+		if base := path.Base(chapters[i].Filename); base == path.Base(name) || xhtmlName(base) == path.Base(name) {
+			return &chapters[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no chapter with file %s", name)
+}
+
+// WriteImageResolution prints a resolution for the resolve command as an
+// aligned list of fields, one line per URL tried
+func WriteImageResolution(w io.Writer, res ImageResolution) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Reference:\t%s\n", res.Ref)
+	fmt.Fprintf(tw, "Chapter:\t%s\n", res.Chapter)
+	fmt.Fprintf(tw, "Asset base URL:\t%s\n", res.BaseURL)
+	if res.FilesError != "" {
+		fmt.Fprintf(tw, "v2 files:\tunavailable (%s)\n", res.FilesError)
+	}
+	fmt.Fprintf(tw, "Resolved by:\t%s\n", res.Source)
+	for i, url := range res.URLs {
+		fmt.Fprintf(tw, "Fetch %d:\t%s\n", i+1, url)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,81 @@
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestResolveImage(t *testing.T) {
+	var server *httptest.Server
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/book/123/chapter/":
+			fmt.Fprintf(w, `{"count": 2, "next": null, "results": [
+				{"title": "One", "filename": "ch01.html", "asset_base_url": "%[1]s/library/view/123/"},
+				{"title": "Two", "filename": "text/ch02.html", "asset_base_url": "%[1]s/library/view/123/"}]}`, server.URL)
+		case strings.HasPrefix(r.URL.Path, "/api/v2/epubs/"):
+			fmt.Fprint(w, `{"count": 1, "next": null, "results": [
+				{"full_path": "OEBPS/text/assets/fig02.png", "url": "https://cdn.example.com/600w/fig02.png"}]}`)
+		case strings.HasSuffix(r.URL.Path, ".png"):
+			t.Errorf("Expected no image download, got a request for %s", r.URL.Path)
+		default:
+			http.NotFound(w, r)
+		}
+	}, Options{})
+
+	tests := []struct {
+		name, chapter, ref, size string
+		source                   string
+		urls                     []string
+	}{
+		{
+			name:   "relative",
+			ref:    "assets/fig01.png",
+			source: resolvedByBaseURL,
+			urls:   []string{server.URL + "/library/view/123/assets/fig01.png"},
+		},
+		{
+			name:   "absolute canonical",
+			ref:    "https://learning.oreilly.com/library/view/123/assets/fig01.png",
+			source: resolvedAbsolute,
+			urls:   []string{"https://learning.oreilly.com/library/view/123/assets/fig01.png"},
+		},
+		{
+			name:    "v2 files",
+			chapter: "ch02.xhtml",
+			ref:     "assets/fig02.png",
+			size:    "1200w",
+			source:  resolvedByFiles,
+			urls:    []string{"https://cdn.example.com/1200w/fig02.png", "https://cdn.example.com/600w/fig02.png"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := ResolveImage(d.client, "123", tt.chapter, tt.ref, tt.size)
+			if err != nil {
+				t.Fatalf("ResolveImage failed: %v", err)
+			}
+			if res.Source != tt.source || !slices.Equal(res.URLs, tt.urls) {
+				t.Errorf("Expected %s %v, got %s %v", tt.source, tt.urls, res.Source, res.URLs)
+			}
+		})
+	}
+
+	if _, err := ResolveImage(d.client, "123", "ch99.html", "fig.png", ""); err == nil {
+		t.Error("Expected an unknown chapter to be rejected")
+	}
+
+	res, _ := ResolveImage(d.client, "123", "", "assets/fig01.png", "")
+	var out bytes.Buffer
+	if err := WriteImageResolution(&out, res); err != nil {
+		t.Fatalf("WriteImageResolution failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "Resolved by:     "+resolvedByBaseURL) {
+		t.Errorf("Expected the branch in the output, got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,30 @@
+package downloader
+
+import "sync"
+
+// signedURLs caches the signed URL of each asset the CDN refused, for the
+// run; an empty URL records that signing failed, so it isn't asked again
+type signedURLs struct {
+	mu    sync.Mutex
+	byURL map[string]string
+}
+
+// signedURL returns a signed URL to retry a refused asset with, asking the
+// API once per asset, and false when there is none
+func (d *Downloader) signedURL(assetURL string) (string, bool) {
+	d.signed.mu.Lock()
+	defer d.signed.mu.Unlock()
+	if signed, ok := d.signed.byURL[assetURL]; ok {
+		return signed, signed != ""
+	}
+	if d.signed.byURL == nil {
+		d.signed.byURL = make(map[string]string)
+	}
+
+	signed, err := d.client.GetSignedURL(d.bookID, assetURL)
+	if err != nil {
+		d.log.With("url", assetURL).Printf("[-] Could not sign %s: %v\n", assetURL, err)
+	}
+	d.signed.byURL[assetURL] = signed
+	return signed, signed != ""
+}
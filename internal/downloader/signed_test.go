@@ -0,0 +1,42 @@
+package downloader
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRun_SignedAssetURL(t *testing.T) {
+	var signs atomic.Int32
+	book := integrationBook(t)
+	book.Handle = func(w http.ResponseWriter, r *http.Request) bool {
+		switch {
+		case r.URL.Path == "/api/v2/epubs/urn:orm:book:123/signed-url/":
+			signs.Add(1)
+			fmt.Fprintf(w, `{"url": "%s?sig=ok"}`, r.URL.Query().Get("url"))
+			return true
+		case r.URL.Path == "/assets/figs/one.png" && r.URL.Query().Get("sig") != "ok":
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return true
+		}
+		return false
+	}
+
+	d, epubPath := runMockBook(t, book, Options{})
+	archive := readValidEPUB(t, epubPath)
+	if _, ok := archive.Files["OEBPS/Images/one.png"]; !ok {
+		t.Errorf("Expected the refused image in the EPUB, got %v", archive.Names)
+	}
+	if got := signs.Load(); got != 1 {
+		t.Errorf("Expected one signing request, got %d", got)
+	}
+
+	// The signed URL is cached for the rest of the run
+	if _, ok := d.signedURL(d.client.SiteURL() + "/assets/figs/one.png"); !ok {
+		t.Error("Expected a cached signed URL")
+	}
+	if got := signs.Load(); got != 1 {
+		t.Errorf("Expected the signed URL to be cached, got %d signing requests", got)
+	}
+}
@@ -0,0 +1,195 @@
+package downloader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dacsang97/safaribooks/pkg/utils"
+)
+
+const (
+	stateFileName = ".safaribooks-state.json"
+	// stateSaveInterval spaces out state saves: every save rewrites the whole
+	// file, so saving on each of a large book's assets would be quadratic
+	stateSaveInterval = 2 * time.Second
+)
+
+// bookState records which chapters and assets finished so a later run can
+// skip them without inspecting the files on disk. Issued is the book's issued
+// date as of the last run that finished the EPUB.
+type bookState struct {
+	mu       sync.Mutex
+	path     string
+	perm     os.FileMode       // --file-permissions, 0 for the default
+	gen      uint64            // count of marks, so a stale save can't overwrite a newer one
+	dirty    bool              // marks not saved yet, see flush
+	saved    time.Time         // time of the last save
+	writeMu  sync.Mutex        // serializes file writes, held without mu
+	written  uint64            // gen of the state on disk, guarded by writeMu
+	Issued   string            `json:"issued,omitempty"`
+	Chapters map[string]bool   `json:"chapters"`
+	Assets   map[string]bool   `json:"assets"`
+	Failed   map[string]string `json:"failed_assets"`
+	Names    map[string]string `json:"saved_names,omitempty"` // asset URL -> file saved under another name
+}
+
+// newBookState creates an empty state stored in bookPath
+func newBookState(bookPath string) *bookState {
+	return &bookState{
+		path:     filepath.Join(bookPath, stateFileName),
+		Chapters: make(map[string]bool),
+		Assets:   make(map[string]bool),
+		Failed:   make(map[string]string),
+		Names:    make(map[string]string),
+	}
+}
+
+// loadBookState reads the state stored in bookPath, returning an empty state
+// when no previous run left one behind
+func loadBookState(bookPath string) (*bookState, error) {
+	state := newBookState(bookPath)
+	data, err := os.ReadFile(state.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read state: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("parse state: %w", err)
+	}
+
+	// Older or hand-edited files may omit sections
+	if state.Chapters == nil {
+		state.Chapters = make(map[string]bool)
+	}
+	if state.Assets == nil {
+		state.Assets = make(map[string]bool)
+	}
+	if state.Failed == nil {
+		state.Failed = make(map[string]string)
+	}
+	if state.Names == nil {
+		state.Names = make(map[string]string)
+	}
+	return state, nil
+}
+
+func (s *bookState) chapterDone(filename string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Chapters[filename]
+}
+
+func (s *bookState) assetDone(url string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Assets[url]
+}
+
+// savedName returns the file an asset was saved as when that differs from
+// the name its URL suggests, or ""
+func (s *bookState) savedName(url string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Names[url]
+}
+
+// failedAssets returns the number of assets that failed in a previous run
+func (s *bookState) failedAssets() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.Failed)
+}
+
+// markChapter records a finished chapter, persisting the state when the last
+// save is older than stateSaveInterval
+func (s *bookState) markChapter(filename string) error {
+	return s.update(func() {
+		s.Chapters[filename] = true
+	}, false)
+}
+
+// markAsset records the outcome of an asset download, and the file it was
+// saved as when renamed, persisting the state when the last save is older
+// than stateSaveInterval
+func (s *bookState) markAsset(url, renamed string, downloadErr error) error {
+	return s.update(func() {
+		if downloadErr != nil {
+			s.Failed[url] = downloadErr.Error()
+		} else {
+			s.Assets[url] = true
+			delete(s.Failed, url)
+		}
+		if renamed != "" {
+			s.Names[url] = renamed
+		}
+	}, false)
+}
+
+// flush persists marks the interval held back
+func (s *bookState) flush() error {
+	return s.update(nil, true)
+}
+
+// markComplete records the issued date of a finished EPUB and persists the state
+func (s *bookState) markComplete(issued string) error {
+	return s.update(func() {
+		s.Issued = issued
+	}, true)
+}
+
+// update applies change under s.mu, then saves the state when now is set or
+// the last save is older than stateSaveInterval. The state is encoded under
+// the lock but written outside it, so workers marking chapters and assets
+// never wait on the disk.
+func (s *bookState) update(change func(), now bool) error {
+	s.mu.Lock()
+	if change != nil {
+		change()
+		s.gen++
+		s.dirty = true
+	}
+	if !s.dirty || (!now && time.Since(s.saved) < stateSaveInterval) {
+		s.mu.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	gen := s.gen
+	if err == nil {
+		s.dirty = false
+		s.saved = time.Now()
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("encode state: %w", err)
+	}
+	return s.write(data, gen)
+}
+
+// write saves the state encoded at generation gen atomically, unless a save
+// of a later generation got to the file first
+func (s *bookState) write(data []byte, gen uint64) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if gen <= s.written {
+		return nil
+	}
+	err := utils.WriteFileAtomic(s.path, data, defaultFilePerm)
+	if err == nil {
+		err = chmodFile(s.path, s.perm)
+	}
+	if err != nil {
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+		return fmt.Errorf("write state: %w", err)
+	}
+	s.written = gen
+	return nil
+}
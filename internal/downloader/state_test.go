@@ -0,0 +1,236 @@
+package downloader
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/dacsang97/safaribooks/internal/html"
+	"github.com/dacsang97/safaribooks/internal/models"
+)
+
+func TestBookState_RoundTrip(t *testing.T) {
+	bookPath := t.TempDir()
+
+	state := newBookState(bookPath)
+	if err := state.markChapter("ch01.html"); err != nil {
+		t.Fatalf("markChapter failed: %v", err)
+	}
+	if err := state.markAsset("https://example.com/a.png", "", nil); err != nil {
+		t.Fatalf("markAsset failed: %v", err)
+	}
+	if err := state.markAsset("https://example.com/b.png", "", errors.New("status 500")); err != nil {
+		t.Fatalf("markAsset failed: %v", err)
+	}
+	if err := state.flush(); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(bookPath, stateFileName+".tmp")); !os.IsNotExist(err) {
+		t.Errorf("Expected temporary state file to be renamed away")
+	}
+
+	loaded, err := loadBookState(bookPath)
+	if err != nil {
+		t.Fatalf("loadBookState failed: %v", err)
+	}
+	if !loaded.chapterDone("ch01.html") {
+		t.Error("Expected ch01.html to be done")
+	}
+	if loaded.chapterDone("ch02.html") {
+		t.Error("Expected ch02.html not to be done")
+	}
+	if !loaded.assetDone("https://example.com/a.png") {
+		t.Error("Expected a.png to be done")
+	}
+	if loaded.assetDone("https://example.com/b.png") {
+		t.Error("Expected b.png not to be done")
+	}
+	if loaded.failedAssets() != 1 {
+		t.Errorf("Expected 1 failed asset, got %d", loaded.failedAssets())
+	}
+
+	// A later success clears the failure
+	if err := loaded.markAsset("https://example.com/b.png", "", nil); err != nil {
+		t.Fatalf("markAsset failed: %v", err)
+	}
+	if loaded.failedAssets() != 0 {
+		t.Errorf("Expected no failed assets, got %d", loaded.failedAssets())
+	}
+}
+
+func TestBookState_SavesPeriodically(t *testing.T) {
+	bookPath := t.TempDir()
+
+	state := newBookState(bookPath)
+	if err := state.markChapter("ch01.html"); err != nil {
+		t.Fatalf("markChapter failed: %v", err)
+	}
+	// Within the interval, marks wait for the next save or a flush
+	if err := state.markChapter("ch02.html"); err != nil {
+		t.Fatalf("markChapter failed: %v", err)
+	}
+	loaded, err := loadBookState(bookPath)
+	if err != nil {
+		t.Fatalf("loadBookState failed: %v", err)
+	}
+	if !loaded.chapterDone("ch01.html") || loaded.chapterDone("ch02.html") {
+		t.Errorf("Expected only the first mark saved, got %v", loaded.Chapters)
+	}
+
+	if err := state.flush(); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	if loaded, err = loadBookState(bookPath); err != nil {
+		t.Fatalf("loadBookState failed: %v", err)
+	}
+	if !loaded.chapterDone("ch02.html") {
+		t.Errorf("Expected the flush to save ch02.html, got %v", loaded.Chapters)
+	}
+}
+
+func TestBookState_StaleSaveKeepsNewerFile(t *testing.T) {
+	bookPath := t.TempDir()
+	state := newBookState(bookPath)
+
+	// A worker that encoded the state earlier reaches the disk last
+	if err := state.write([]byte(`{"chapters":{"ch01.html":true,"ch02.html":true}}`), 2); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if err := state.write([]byte(`{"chapters":{"ch01.html":true}}`), 1); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	loaded, err := loadBookState(bookPath)
+	if err != nil {
+		t.Fatalf("loadBookState failed: %v", err)
+	}
+	if !loaded.chapterDone("ch02.html") {
+		t.Errorf("Expected the older save to be dropped, got %v", loaded.Chapters)
+	}
+}
+
+func TestLoadBookState_Missing(t *testing.T) {
+	state, err := loadBookState(t.TempDir())
+	if err != nil {
+		t.Fatalf("loadBookState failed: %v", err)
+	}
+	if len(state.Chapters) != 0 || len(state.Assets) != 0 {
+		t.Errorf("Expected empty state, got %+v", state)
+	}
+}
+
+func TestDownloadChapter_ResumeSkipsDoneChapter(t *testing.T) {
+	var requests int32
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(`<html><body><div id="sbo-rt-content"><p>Hi</p></div></body></html>`))
+	}, Options{Resume: true})
+
+	bookPath := t.TempDir()
+	oebpsPath := filepath.Join(bookPath, "OEBPS")
+	if err := os.MkdirAll(filepath.Join(oebpsPath, "Images"), 0755); err != nil {
+		t.Fatalf("Failed to create dirs: %v", err)
+	}
+
+	state := newBookState(bookPath)
+	if err := state.markChapter("ch01.html"); err != nil {
+		t.Fatalf("markChapter failed: %v", err)
+	}
+	if err := d.initState(bookPath); err != nil {
+		t.Fatalf("initState failed: %v", err)
+	}
+
+	parser := html.NewParser(server.URL, html.ParserOptions{})
+	done := models.Chapter{Title: "One", Filename: "ch01.html", Content: server.URL + "/ch01.html"}
+	if err := d.downloadChapter(oebpsPath, &done, false, parser, bookPath); err != nil {
+		t.Fatalf("downloadChapter failed: %v", err)
+	}
+	if done.Filename != "ch01.xhtml" {
+		t.Errorf("Expected skipped chapter filename to be ch01.xhtml, got %s", done.Filename)
+	}
+	if got := atomic.LoadInt32(&requests); got != 0 {
+		t.Errorf("Expected no requests for a done chapter, got %d", got)
+	}
+
+	pending := models.Chapter{Title: "Two", Filename: "ch02.html", Content: server.URL + "/ch02.html"}
+	if err := d.downloadChapter(oebpsPath, &pending, false, parser, bookPath); err != nil {
+		t.Fatalf("downloadChapter failed: %v", err)
+	}
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Errorf("Expected one request for a pending chapter, got %d", got)
+	}
+	if !d.state.chapterDone("ch02.html") {
+		t.Error("Expected ch02.html to be recorded as done")
+	}
+}
+
+// writeFinishedBook leaves an EPUB and a state file recording issued, as a
+// completed run of the test book would
+func writeFinishedBook(t *testing.T, d *Downloader, bookInfo models.BookInfo, issued string) {
+	t.Helper()
+	bookPath, err := d.createBookDirectory(bookInfo)
+	if err != nil {
+		t.Fatalf("createBookDirectory failed: %v", err)
+	}
+	epubPath := filepath.Join(bookPath, filepath.Base(bookPath)+".epub")
+	if err := os.WriteFile(epubPath, []byte("epub"), 0644); err != nil {
+		t.Fatalf("Failed to write EPUB: %v", err)
+	}
+	if err := newBookState(bookPath).markComplete(issued); err != nil {
+		t.Fatalf("markComplete failed: %v", err)
+	}
+}
+
+func TestRun_IfModifiedSkipsUnchangedBook(t *testing.T) {
+	var chapterRequests int32
+	d, _ := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/book/123/" {
+			w.Write([]byte(`{"title": "Test Book", "issued": "2024-03-01"}`))
+			return
+		}
+		atomic.AddInt32(&chapterRequests, 1)
+		http.NotFound(w, r)
+	}, Options{IfModified: true})
+
+	writeFinishedBook(t, d, models.BookInfo{Title: "Test Book"}, "2024-03-01")
+
+	if err := d.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if n := atomic.LoadInt32(&chapterRequests); n != 0 {
+		t.Errorf("Expected no requests past book info, got %d", n)
+	}
+	if got := d.Result(); got.Status != StatusSkipped || got.Size != 4 {
+		t.Errorf("Expected skipped result with the EPUB size, got %+v", got)
+	}
+}
+
+func TestUnchanged(t *testing.T) {
+	d, _ := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {}, Options{})
+	bookInfo := models.BookInfo{Title: "Test Book", Issued: "2024-03-01"}
+
+	if d.unchanged(bookInfo) {
+		t.Error("Expected a book never downloaded to count as changed")
+	}
+
+	writeFinishedBook(t, d, bookInfo, "2024-03-01")
+	if !d.unchanged(bookInfo) {
+		t.Error("Expected matching issued date to count as unchanged")
+	}
+
+	newer := bookInfo
+	newer.Issued = "2024-06-01"
+	if d.unchanged(newer) {
+		t.Error("Expected a new issued date to count as changed")
+	}
+
+	undated := bookInfo
+	undated.Issued = ""
+	if d.unchanged(undated) {
+		t.Error("Expected a book without an issued date to count as changed")
+	}
+}
@@ -0,0 +1,215 @@
+package downloader
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/dacsang97/safaribooks/internal/html"
+	"github.com/dacsang97/safaribooks/internal/models"
+	"github.com/dacsang97/safaribooks/pkg/utils"
+)
+
+// chapterStream writes finished chapters straight into the EPUB archive in
+// spine order, so chapter pages never touch the disk. Chapters finishing
+// ahead of an earlier one are held until it arrives, and chapters linking to
+// an ID no earlier chapter defines are held until complete, so their
+// fragment links can be pointed at the later chapter defining it.
+type chapterStream struct {
+	out      *os.File
+	zip      *utils.ZipStream
+	pageList bool // record page-break markers for writePageList
+
+	mu         sync.Mutex
+	order      []string          // chapter filenames in spine order
+	next       int               // index in order of the next chapter to write
+	pending    map[string][]byte // finished chapters waiting on an earlier one
+	skipped    map[string]bool   // chapters left out of the book, see skip
+	ids        *html.IDIndex     // IDs of the chapters up to next
+	held       map[string][]byte // chapters waiting on a later chapter's IDs
+	resolved   int               // fragment links pointed at other chapters
+	pageBreaks map[string][]html.PageBreak
+}
+
+// openChapterStream starts bookPath.zip with the mimetype entry first, as
+// EPUB requires, ready to take chapters as they are parsed
+func (d *Downloader) openChapterStream(bookPath string, chapters []models.Chapter) error {
+	out, err := os.OpenFile(bookPath+".zip", os.O_RDWR|os.O_CREATE|os.O_TRUNC, defaultFilePerm)
+	if err != nil {
+		return fmt.Errorf("create zip: %w", err)
+	}
+	s := &chapterStream{
+		out:        out,
+		zip:        utils.NewZipStream(out),
+		pageList:   !d.epub2Compat && !d.noPageList,
+		pending:    make(map[string][]byte),
+		ids:        html.NewIDIndex(d.flattenAnchors),
+		held:       make(map[string][]byte),
+		pageBreaks: make(map[string][]html.PageBreak),
+	}
+	for _, chapter := range chapters {
+		s.order = append(s.order, strings.ReplaceAll(chapter.Filename, ".html", ".xhtml"))
+	}
+	if err := s.zip.Store("mimetype", []byte(epubMimetype)); err != nil {
+		s.abort()
+		return fmt.Errorf("create zip: %w", err)
+	}
+	d.stream = s
+	return nil
+}
+
+// put hands over a finished chapter page and writes every chapter that is
+// now next in spine order
+func (s *chapterStream) put(filename string, page []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending[filename] = page
+	return s.flush()
+}
+
+// skip drops a chapter from the spine order, such as one served empty, and
+// writes every chapter that is now next in spine order
+func (s *chapterStream) skip(filename string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.skipped == nil {
+		s.skipped = make(map[string]bool)
+	}
+	s.skipped[filename] = true
+	return s.flush()
+}
+
+// flush writes the pending chapters that are next in spine order; s.mu is held
+func (s *chapterStream) flush() error {
+	for s.next < len(s.order) {
+		name := s.order[s.next]
+		if s.skipped[name] {
+			s.next++
+			continue
+		}
+		page, ok := s.pending[name]
+		if !ok {
+			break
+		}
+		delete(s.pending, name)
+		s.next++
+		// Indexing in spine order gives a duplicate ID to its first chapter,
+		// as resolveFragmentLinks does
+		s.ids.Add(name, string(page))
+		if s.ids.Pending(name, string(page)) {
+			s.held[name] = page
+			continue
+		}
+		if err := s.write(name, page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write points a chapter's fragment links at the chapters defining their
+// targets and adds it to the archive; s.mu is held
+func (s *chapterStream) write(name string, page []byte) error {
+	resolved, changed := s.ids.Resolve(name, string(page))
+	if err := s.zip.Write("OEBPS/"+name, []byte(resolved)); err != nil {
+		return err
+	}
+	s.resolved += changed
+	if s.pageList {
+		s.pageBreaks[name] = html.PageBreaks(resolved)
+	}
+	return nil
+}
+
+// complete writes the chapters held for a later chapter's IDs, now that
+// every chapter is indexed, and reports an error when a chapter never made
+// it into the archive
+func (s *chapterStream) complete() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.next < len(s.order) {
+		return fmt.Errorf("chapter %s was not written to the EPUB", s.order[s.next])
+	}
+	for _, name := range s.order {
+		page, ok := s.held[name]
+		if !ok {
+			continue
+		}
+		if err := s.write(name, page); err != nil {
+			return err
+		}
+		delete(s.held, name)
+	}
+	return nil
+}
+
+// abort discards the partial archive; it does nothing once the archive was
+// finished by writeArchive
+func (s *chapterStream) abort() {
+	if s.out == nil {
+		return
+	}
+	s.out.Close()
+	os.Remove(s.out.Name())
+	s.out = nil
+}
+
+// writeArchive zips the book directory into its EPUB, leaving out the output
+// of any previous run. Streamed chapters are already in the archive; the
+// rest of the book is added after them.
+func (d *Downloader) writeArchive(bookPath string) error {
+	epubName := filepath.Base(bookPath) + ".epub"
+	exclude := []string{stateFileName, "OEBPS/" + rawChaptersDir, supplementaryDir, epubName, epubName + ".sha256"}
+	for name := range d.orphanImages {
+		exclude = append(exclude, "OEBPS/Images/"+name)
+	}
+
+	s := d.stream
+	if s == nil {
+		out, err := os.OpenFile(bookPath+".zip", os.O_RDWR|os.O_CREATE|os.O_TRUNC, defaultFilePerm)
+		if err != nil {
+			return fmt.Errorf("create zip: %w", err)
+		}
+		s = &chapterStream{out: out, zip: utils.NewZipStream(out)}
+		defer s.abort()
+		if err := s.zip.Store("mimetype", []byte(epubMimetype)); err != nil {
+			return fmt.Errorf("create zip: %w", err)
+		}
+	}
+
+	// Pruned stylesheets take the place of the downloaded ones
+	var err error
+	for _, name := range slices.Sorted(maps.Keys(d.prunedCSS)) {
+		if err = s.zip.Write(name, d.prunedCSS[name]); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = s.zip.AddDirectory(bookPath, exclude...)
+	}
+	if err == nil {
+		err = s.zip.Close()
+	}
+	if err == nil && d.filePerm != 0 {
+		err = s.out.Chmod(d.filePerm)
+	}
+	if err == nil {
+		err = s.out.Sync()
+	}
+	if closeErr := s.out.Close(); err == nil {
+		err = closeErr
+	}
+	zipPath := s.out.Name()
+	s.out = nil
+	if err != nil {
+		os.Remove(zipPath)
+		return fmt.Errorf("create zip: %w", err)
+	}
+	return utils.RenameDurable(zipPath, filepath.Join(bookPath, epubName))
+}
@@ -0,0 +1,170 @@
+package downloader
+
+import (
+	"archive/zip"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dacsang97/safaribooks/internal/models"
+)
+
+// serveTestBook answers the API calls for a three-chapter book with one
+// image. The first chapter is slow so later ones finish before it.
+func serveTestBook() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/book/123/":
+			w.Write([]byte(`{"title": "Test Book", "identifier": "123", "issued": "2024-01-01", "language": "en"}`))
+		case "/api/v1/book/123/chapter/":
+			w.Write([]byte(`{"count": 3, "results": [
+				{"title": "One", "filename": "ch01.html", "content": "/chapters/ch01.html", "asset_base_url": "http://` + r.Host + `/assets/", "images": ["fig1.png"]},
+				{"title": "Two", "filename": "ch02.html", "content": "/chapters/ch02.html"},
+				{"title": "Three", "filename": "ch03.html", "content": "/chapters/ch03.html"}
+			]}`))
+		case "/chapters/ch01.html":
+			time.Sleep(50 * time.Millisecond)
+			w.Write([]byte(`<html><body><div id="sbo-rt-content"><h1>One</h1><img id="fig-one" src="fig1.png" alt="Figure"/></div></body></html>`))
+		case "/chapters/ch02.html":
+			w.Write([]byte(`<html><body><div id="sbo-rt-content"><p id="ch02">ch02</p></div></body></html>`))
+		case "/chapters/ch03.html":
+			// A fragment-only link to a target in another chapter
+			w.Write([]byte(`<html><body><div id="sbo-rt-content"><p id="ch03">ch03, <a href="#fig-one">back to one</a></p></div></body></html>`))
+		case "/assets/fig1.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("png-data"))
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
+
+// readEPUB returns an EPUB's entries in archive order and their contents
+func readEPUB(t *testing.T, d *Downloader) ([]*zip.File, map[string]string) {
+	t.Helper()
+	bookPath := d.bookDirectory(testBookInfo())
+	reader, err := zip.OpenReader(filepath.Join(bookPath, filepath.Base(bookPath)+".epub"))
+	if err != nil {
+		t.Fatalf("Failed to open EPUB: %v", err)
+	}
+	t.Cleanup(func() { reader.Close() })
+
+	contents := make(map[string]string)
+	for _, f := range reader.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", f.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", f.Name, err)
+		}
+		contents[f.Name] = string(data)
+	}
+	return reader.File, contents
+}
+
+func TestRun_StreamedEPUBMatchesZippedDirectory(t *testing.T) {
+	onDisk, _ := newTestDownloader(t, serveTestBook(), Options{})
+	if err := onDisk.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	streamed, _ := newTestDownloader(t, serveTestBook(), Options{StreamEPUB: true})
+	if err := streamed.Run(); err != nil {
+		t.Fatalf("Streamed run failed: %v", err)
+	}
+
+	diskFiles, want := readEPUB(t, onDisk)
+	streamFiles, got := readEPUB(t, streamed)
+	if !maps.Equal(got, want) {
+		t.Errorf("Expected the streamed EPUB to match the zipped directory\ngot:  %v\nwant: %v", slices.Sorted(maps.Keys(got)), slices.Sorted(maps.Keys(want)))
+	}
+
+	for _, files := range [][]*zip.File{diskFiles, streamFiles} {
+		if files[0].Name != "mimetype" || files[0].Method != zip.Store {
+			t.Errorf("Expected an uncompressed mimetype entry first, got %s (method %d)", files[0].Name, files[0].Method)
+		}
+	}
+	var order []string
+	for _, f := range streamFiles[1:4] {
+		order = append(order, f.Name)
+	}
+	if want := "OEBPS/ch01.xhtml OEBPS/ch02.xhtml OEBPS/ch03.xhtml"; strings.Join(order, " ") != want {
+		t.Errorf("Expected chapters streamed in spine order %s, got %v", want, order)
+	}
+
+	if !strings.Contains(got["OEBPS/ch03.xhtml"], `href="ch01.xhtml#fig-one"`) {
+		t.Errorf("Expected the link into ch01 resolved in the streamed EPUB, got:\n%s", got["OEBPS/ch03.xhtml"])
+	}
+
+	bookPath := streamed.bookDirectory(testBookInfo())
+	if _, err := os.Stat(filepath.Join(bookPath, "OEBPS", "ch01.xhtml")); !os.IsNotExist(err) {
+		t.Errorf("Expected no chapter files on disk when streaming, got %v", err)
+	}
+	if _, err := os.Stat(bookPath + ".zip"); !os.IsNotExist(err) {
+		t.Errorf("Expected the partial archive to be renamed into place, got %v", err)
+	}
+}
+
+func TestChapterStream_HoldsChapterLinkingAhead(t *testing.T) {
+	bookPath := filepath.Join(t.TempDir(), "book")
+	if err := os.Mkdir(bookPath, 0755); err != nil {
+		t.Fatalf("Failed to create book directory: %v", err)
+	}
+	d := &Downloader{}
+	if err := d.openChapterStream(bookPath, []models.Chapter{{Filename: "ch01.html"}, {Filename: "ch02.html"}}); err != nil {
+		t.Fatalf("openChapterStream failed: %v", err)
+	}
+	defer d.stream.abort()
+
+	if err := d.stream.put("ch01.xhtml", []byte(`<p><a href="#later">ahead</a></p>`)); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	if err := d.stream.put("ch02.xhtml", []byte(`<p id="later">2</p>`)); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	if err := d.stream.complete(); err != nil {
+		t.Fatalf("complete failed: %v", err)
+	}
+	if d.stream.resolved != 1 {
+		t.Errorf("Expected the link into ch02 resolved, got %d resolved links", d.stream.resolved)
+	}
+	if err := d.writeArchive(bookPath); err != nil {
+		t.Fatalf("writeArchive failed: %v", err)
+	}
+
+	reader, err := zip.OpenReader(filepath.Join(bookPath, "book.epub"))
+	if err != nil {
+		t.Fatalf("Failed to open EPUB: %v", err)
+	}
+	defer reader.Close()
+	for _, f := range reader.File {
+		if f.Name != "OEBPS/ch01.xhtml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", f.Name, err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		if want := `<a href="ch02.xhtml#later">`; !strings.Contains(string(data), want) {
+			t.Errorf("Expected %s in ch01.xhtml, got %s", want, data)
+		}
+		return
+	}
+	t.Error("Expected ch01.xhtml in the EPUB")
+}
+
+func TestNewDownloader_StreamRejectsResume(t *testing.T) {
+	if _, err := NewDownloader(Options{StreamEPUB: true, Resume: true}); err == nil {
+		t.Error("Expected streaming with resume to be rejected")
+	}
+}
@@ -0,0 +1,95 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// Book result statuses
+const (
+	StatusOK      = "ok"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+)
+
+// BookResult summarizes one book of a multi-book run
+type BookResult struct {
+	BookID   string        `json:"book_id"`
+	Title    string        `json:"title"`
+	Status   string        `json:"status"`
+	Chapters int           `json:"chapters"`
+	Size     int64         `json:"size_bytes"`
+	Elapsed  time.Duration `json:"-"`
+	Error    string        `json:"error,omitempty"`
+	Files    []string      `json:"files,omitempty"` // supplementary files saved with --include-files
+}
+
+// MarshalJSON reports Elapsed in seconds
+func (r BookResult) MarshalJSON() ([]byte, error) {
+	type plain BookResult
+	return json.Marshal(struct {
+		plain
+		ElapsedSeconds float64 `json:"elapsed_seconds"`
+	}{plain(r), r.Elapsed.Round(time.Millisecond).Seconds()})
+}
+
+// Result returns what the last Run produced; Status, Elapsed, and Error are
+// left for the caller
+func (d *Downloader) Result() BookResult {
+	return d.result
+}
+
+// WriteSummary prints an aligned table of results followed by totals
+func WriteSummary(w io.Writer, results []BookResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "BOOK ID\tTITLE\tSTATUS\tCHAPTERS\tFILES\tSIZE\tTIME")
+
+	var ok int
+	var total time.Duration
+	for _, r := range results {
+		if r.Status == StatusOK {
+			ok++
+		}
+		total += r.Elapsed
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\t%s\n",
+			r.BookID, truncateTitle(r.Title, 40), r.Status, r.Chapters, len(r.Files), formatSize(r.Size), r.Elapsed.Round(100*time.Millisecond))
+	}
+	fmt.Fprintf(tw, "\t%d of %d ok\t\t\t\t\t%s\n", ok, len(results), total.Round(100*time.Millisecond))
+	return tw.Flush()
+}
+
+// WriteSummaryJSON prints results as an indented JSON array
+func WriteSummaryJSON(w io.Writer, results []BookResult) error {
+	if results == nil {
+		results = []BookResult{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
+
+// truncateTitle shortens long titles so the table stays readable
+func truncateTitle(title string, n int) string {
+	runes := []rune(title)
+	if len(runes) <= n {
+		return title
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// formatSize renders a byte count with a binary unit
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
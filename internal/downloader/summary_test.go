@@ -0,0 +1,107 @@
+package downloader
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mixedResults() []BookResult {
+	return []BookResult{
+		{BookID: "9781491950357", Title: "Learning Go", Status: StatusOK, Chapters: 12, Size: 3 * 1024 * 1024, Elapsed: 42 * time.Second, Files: []string{"code.zip"}},
+		{BookID: "9780000000001", Title: "Broken Book", Status: StatusFailed, Elapsed: 1500 * time.Millisecond, Error: "download failed: 404"},
+		{BookID: "urn:orm:video:123", Title: "Some Video", Status: StatusSkipped},
+	}
+}
+
+func TestWriteSummary_Table(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteSummary(&buf, mixedResults()); err != nil {
+		t.Fatalf("WriteSummary failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("Expected header, 3 rows and totals, got %d lines:\n%s", len(lines), buf.String())
+	}
+	for i, want := range [][]string{
+		{"BOOK ID", "TITLE", "STATUS", "CHAPTERS", "FILES", "SIZE", "TIME"},
+		{"9781491950357", "Learning Go", "ok", "12", "3.0 MiB", "42s"},
+		{"9780000000001", "Broken Book", "failed", "0", "0 B", "1.5s"},
+		{"urn:orm:video:123", "Some Video", "skipped", "0 B", "0s"},
+		{"1 of 3 ok", "43.5s"},
+	} {
+		for _, field := range want {
+			if !strings.Contains(lines[i], field) {
+				t.Errorf("Line %d: expected %q in %q", i, field, lines[i])
+			}
+		}
+	}
+
+	// Columns line up: every row starts its STATUS column at the same offset.
+	col := strings.Index(lines[0], "STATUS")
+	for i, status := range []string{"ok", "failed", "skipped"} {
+		if got := strings.Index(lines[i+1], status); got != col {
+			t.Errorf("Row %d: expected status at column %d, got %d", i+1, col, got)
+		}
+	}
+}
+
+func TestWriteSummary_TruncatesLongTitles(t *testing.T) {
+	var buf bytes.Buffer
+	results := []BookResult{{BookID: "1", Title: strings.Repeat("x", 60), Status: StatusOK}}
+	if err := WriteSummary(&buf, results); err != nil {
+		t.Fatalf("WriteSummary failed: %v", err)
+	}
+	if want := strings.Repeat("x", 39) + "…"; !strings.Contains(buf.String(), want) {
+		t.Errorf("Expected truncated title %q in:\n%s", want, buf.String())
+	}
+}
+
+func TestWriteSummaryJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteSummaryJSON(&buf, mixedResults()); err != nil {
+		t.Fatalf("WriteSummaryJSON failed: %v", err)
+	}
+
+	var got []map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Expected valid JSON: %v\n%s", err, buf.String())
+	}
+	if len(got) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(got))
+	}
+
+	ok := got[0]
+	if ok["book_id"] != "9781491950357" || ok["title"] != "Learning Go" || ok["status"] != "ok" {
+		t.Errorf("Unexpected ok entry: %v", ok)
+	}
+	if ok["chapters"] != float64(12) || ok["size_bytes"] != float64(3*1024*1024) || ok["elapsed_seconds"] != float64(42) {
+		t.Errorf("Unexpected ok counts: %v", ok)
+	}
+	if files, _ := ok["files"].([]any); len(files) != 1 || files[0] != "code.zip" {
+		t.Errorf("Expected files [code.zip], got %v", ok["files"])
+	}
+	if _, has := ok["error"]; has {
+		t.Errorf("Expected no error field on success, got %v", ok["error"])
+	}
+
+	if got[1]["status"] != "failed" || got[1]["error"] != "download failed: 404" || got[1]["elapsed_seconds"] != 1.5 {
+		t.Errorf("Unexpected failed entry: %v", got[1])
+	}
+	if got[2]["status"] != "skipped" {
+		t.Errorf("Unexpected skipped entry: %v", got[2])
+	}
+}
+
+func TestWriteSummaryJSON_Empty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteSummaryJSON(&buf, nil); err != nil {
+		t.Fatalf("WriteSummaryJSON failed: %v", err)
+	}
+	if got := strings.TrimSpace(buf.String()); got != "[]" {
+		t.Errorf("Expected empty array, got %q", got)
+	}
+}
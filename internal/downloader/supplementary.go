@@ -0,0 +1,86 @@
+package downloader
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/dacsang97/safaribooks/internal/models"
+	"github.com/dacsang97/safaribooks/pkg/utils"
+)
+
+// supplementaryDir holds a book's code archives and other extras next to the
+// EPUB; it is left out of the EPUB itself
+const supplementaryDir = "Files"
+
+// downloadSupplementaryFiles saves the book's extras into bookPath/Files and
+// returns the names of the files it has. Failures are logged and skipped
+// since the extras are not needed for the EPUB.
+func (d *Downloader) downloadSupplementaryFiles(bookPath string) []string {
+	d.log.Printf("[*] Retrieving supplementary files...\n")
+	files, err := d.client.GetSupplementaryFiles(d.bookID)
+	if err != nil {
+		d.log.Printf("[-] Failed to list supplementary files: %v\n", err)
+		return nil
+	}
+	if len(files) == 0 {
+		d.log.Printf("[*] No supplementary files for this book\n")
+		return nil
+	}
+
+	dir := filepath.Join(bookPath, supplementaryDir)
+	if err := d.mkdir(dir); err != nil {
+		d.log.Printf("[-] Failed to create %s: %v\n", supplementaryDir, err)
+		return nil
+	}
+
+	var saved []string
+	for _, file := range files {
+		name, err := d.downloadSupplementaryFile(file, dir)
+		if err != nil {
+			d.log.Printf("[-] Failed to download %s: %v\n", firstNonEmpty(file.Filename, file.URL), err)
+			continue
+		}
+		saved = append(saved, name)
+	}
+	if len(saved) > 0 {
+		d.log.Printf("[*] Supplementary files in %s: %s\n", dir, strings.Join(saved, ", "))
+	}
+	return saved
+}
+
+// downloadSupplementaryFile streams one extra into dir, keeping a copy from
+// an earlier run, and returns its filename
+func (d *Downloader) downloadSupplementaryFile(file models.BookFile, dir string) (string, error) {
+	name := filepath.Base(firstNonEmpty(file.Filename, utils.FilenameFromURL(file.URL)))
+	switch name {
+	case "":
+		return "", fmt.Errorf("no filename for %s", file.URL)
+	case ".", "..", string(filepath.Separator):
+		return "", fmt.Errorf("unsafe filename %q for %s", name, file.URL)
+	}
+	path := filepath.Join(dir, name)
+	if utils.FileExists(path) {
+		d.log.Printf("[+] Supplementary file already exists: %s\n", name)
+		return name, nil
+	}
+
+	resp, err := d.client.GetStream(file.URL)
+	if err != nil {
+		return "", err
+	}
+	body := resp.RawBody()
+	defer body.Close()
+	if !resp.IsSuccess() {
+		return "", fmt.Errorf("status %d", resp.StatusCode())
+	}
+	size, err := utils.WriteFileAtomicFrom(path, body, defaultFilePerm)
+	if err == nil {
+		err = chmodFile(path, d.filePerm)
+	}
+	if err != nil {
+		return "", err
+	}
+	d.log.Printf("[+] Downloaded supplementary file: %s (%d KB)\n", name, size/1024)
+	return name, nil
+}
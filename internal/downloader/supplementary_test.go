@@ -0,0 +1,54 @@
+package downloader
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDownloadSupplementaryFiles(t *testing.T) {
+	d, _ := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/epubs/urn:orm:book:123/files/":
+			w.Write([]byte(`{"count": 3, "next": null, "results": [` +
+				`{"filename": "code.zip", "url": "/files/code.zip", "media_type": "application/zip"},` +
+				`{"filename": "gone.zip", "url": "/files/gone.zip", "media_type": "application/zip"},` +
+				`{"filename": "..", "url": "/files/up.zip", "media_type": "application/zip"}]}`))
+		case "/files/code.zip", "/files/up.zip":
+			w.Write([]byte("PK zip bytes"))
+		default:
+			http.NotFound(w, r)
+		}
+	}, Options{IncludeFiles: true})
+
+	bookPath := t.TempDir()
+	saved := d.downloadSupplementaryFiles(bookPath)
+	if !slices.Equal(saved, []string{"code.zip"}) {
+		t.Errorf("Expected only code.zip to be saved, got %v", saved)
+	}
+
+	got, err := os.ReadFile(filepath.Join(bookPath, supplementaryDir, "code.zip"))
+	if err != nil {
+		t.Fatalf("Expected code.zip in %s: %v", supplementaryDir, err)
+	}
+	if string(got) != "PK zip bytes" {
+		t.Errorf("Unexpected content %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(bookPath, supplementaryDir, "gone.zip")); !os.IsNotExist(err) {
+		t.Errorf("Expected no file for a failed download, got %v", err)
+	}
+}
+
+func TestDownloadSupplementaryFiles_None(t *testing.T) {
+	d, _ := newTestDownloader(t, http.NotFound, Options{IncludeFiles: true})
+
+	bookPath := t.TempDir()
+	if saved := d.downloadSupplementaryFiles(bookPath); len(saved) != 0 {
+		t.Errorf("Expected no files, got %v", saved)
+	}
+	if _, err := os.Stat(filepath.Join(bookPath, supplementaryDir)); !os.IsNotExist(err) {
+		t.Errorf("Expected no %s directory for a book without files", supplementaryDir)
+	}
+}
@@ -0,0 +1,60 @@
+package downloader
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dacsang97/safaribooks/internal/html"
+	"github.com/dacsang97/safaribooks/internal/models"
+)
+
+func TestRun_TitleFromHeading(t *testing.T) {
+	book := &mockBook{Chapters: []mockChapter{
+		{Title: "Untitled", Filename: "ch01.html", Body: "<h1>Getting Started</h1><p>One</p>"},
+		{Title: "Next Steps", Filename: "ch02.html", Body: "<h1>Something Else</h1><p>Two</p>"},
+	}}
+	_, epubPath := runMockBook(t, book, Options{TitleFromHeading: true})
+	ncx := string(readValidEPUB(t, epubPath).Files["OEBPS/toc.ncx"])
+
+	for _, want := range []string{"<text>Getting Started</text>", "<text>Next Steps</text>"} {
+		if !strings.Contains(ncx, want) {
+			t.Errorf("Expected %s in the TOC, got:\n%s", want, ncx)
+		}
+	}
+	if strings.Contains(ncx, "Untitled") || strings.Contains(ncx, "Something Else") {
+		t.Errorf("Expected only the generic title replaced, got:\n%s", ncx)
+	}
+}
+
+func TestDownloadChapter_ResumeKeepsHeadingTitle(t *testing.T) {
+	d, server := newTestDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Expected no requests for a done chapter, got %s", r.URL.Path)
+	}, Options{Resume: true, TitleFromHeading: true})
+
+	bookPath := t.TempDir()
+	oebpsPath := filepath.Join(bookPath, "OEBPS")
+	if err := os.MkdirAll(filepath.Join(oebpsPath, "Images"), 0755); err != nil {
+		t.Fatalf("Failed to create dirs: %v", err)
+	}
+	page := `<html><head><title>Getting Started</title></head><body><div id="sbo-rt-content"><h1>Getting Started</h1></div></body></html>`
+	if err := os.WriteFile(filepath.Join(oebpsPath, "ch01.xhtml"), []byte(page), 0644); err != nil {
+		t.Fatalf("Failed to write chapter: %v", err)
+	}
+	if err := newBookState(bookPath).markChapter("ch01.html"); err != nil {
+		t.Fatalf("markChapter failed: %v", err)
+	}
+	if err := d.initState(bookPath); err != nil {
+		t.Fatalf("initState failed: %v", err)
+	}
+
+	chapter := models.Chapter{Title: "Untitled", Filename: "ch01.html", Content: server.URL + "/ch01.html"}
+	if err := d.downloadChapter(oebpsPath, &chapter, false, html.NewParser(server.URL, html.ParserOptions{}), bookPath); err != nil {
+		t.Fatalf("downloadChapter failed: %v", err)
+	}
+	if chapter.Title != "Getting Started" {
+		t.Errorf("Expected the resumed chapter titled from its saved page, got %q", chapter.Title)
+	}
+}
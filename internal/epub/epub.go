@@ -0,0 +1,195 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/xml"
+)
+
+const (
+	ncxDoctype = `<!DOCTYPE ncx PUBLIC "-//NISO//DTD ncx 2005-1//EN" "http://www.daisy.org/z3986/2005/ncx-2005-1.dtd">`
+	indent     = "  "
+)
+
+// Package represents the OPF package document (content.opf)
+type Package struct {
+	XMLName          xml.Name `xml:"package"`
+	Xmlns            string   `xml:"xmlns,attr"`
+	Version          string   `xml:"version,attr"`
+	UniqueIdentifier string   `xml:"unique-identifier,attr"`
+	Metadata         Metadata `xml:"metadata"`
+	Manifest         Manifest `xml:"manifest"`
+	Spine            Spine    `xml:"spine"`
+	Guide            *Guide   `xml:"guide,omitempty"`
+}
+
+// Metadata represents the OPF metadata block with Dublin Core elements
+type Metadata struct {
+	XmlnsDC     string     `xml:"xmlns:dc,attr"`
+	XmlnsOPF    string     `xml:"xmlns:opf,attr"`
+	Title       Text       `xml:"dc:title"`
+	Creators    []string   `xml:"dc:creator"`
+	Publisher   string     `xml:"dc:publisher,omitempty"`
+	Description string     `xml:"dc:description,omitempty"`
+	Subjects    []string   `xml:"dc:subject"`
+	Language    Text       `xml:"dc:language"`
+	Identifier  Identifier `xml:"dc:identifier"`
+	Date        string     `xml:"dc:date,omitempty"`
+	Elements    []Element  `xml:",any"` // merged in by Package.MergeMetadata
+	Meta        []Meta     `xml:"meta"`
+}
+
+// Identifier represents a dc:identifier element
+type Identifier struct {
+	ID    string     `xml:"id,attr"`
+	Attrs []xml.Attr `xml:",any,attr"` // e.g. opf:scheme
+	Value string     `xml:",chardata"`
+}
+
+// Text represents a required dc: element holding text, such as dc:title,
+// with any attributes it was given, e.g. xml:lang
+type Text struct {
+	Attrs []xml.Attr `xml:",any,attr"`
+	Value string     `xml:",chardata"`
+}
+
+// Meta represents a name/content meta element, or an EPUB 3 property meta
+// whose value is its text
+type Meta struct {
+	Name     string `xml:"name,attr,omitempty"`
+	Content  string `xml:"content,attr,omitempty"`
+	Property string `xml:"property,attr,omitempty"`
+	Value    string `xml:",chardata"`
+}
+
+// Manifest lists every file in the publication
+type Manifest struct {
+	Items []Item `xml:"item"`
+}
+
+// Item represents a manifest entry
+type Item struct {
+	ID         string `xml:"id,attr"`
+	Href       string `xml:"href,attr"`
+	MediaType  string `xml:"media-type,attr"`
+	Properties string `xml:"properties,attr,omitempty"` // EPUB 3 only, e.g. "nav"
+}
+
+// Spine defines the reading order
+type Spine struct {
+	Toc                      string    `xml:"toc,attr"`
+	PageProgressionDirection string    `xml:"page-progression-direction,attr,omitempty"`
+	ItemRefs                 []ItemRef `xml:"itemref"`
+}
+
+// ItemRef references a manifest item from the spine
+type ItemRef struct {
+	IDRef  string `xml:"idref,attr"`
+	Linear string `xml:"linear,attr,omitempty"` // "no" for content outside the reading flow
+}
+
+// Guide lists structural components such as the cover and start of text
+type Guide struct {
+	References []Reference `xml:"reference"`
+}
+
+// Reference represents a guide entry
+type Reference struct {
+	Type  string `xml:"type,attr"`
+	Title string `xml:"title,attr"`
+	Href  string `xml:"href,attr"`
+}
+
+// NCX represents the EPUB 2 navigation document (toc.ncx)
+type NCX struct {
+	XMLName   xml.Name `xml:"ncx"`
+	Xmlns     string   `xml:"xmlns,attr"`
+	Version   string   `xml:"version,attr"`
+	Head      NCXHead  `xml:"head"`
+	DocTitle  NCXText  `xml:"docTitle"`
+	DocAuthor NCXText  `xml:"docAuthor"`
+	NavMap    NavMap   `xml:"navMap"`
+}
+
+// NCXHead holds the NCX meta elements
+type NCXHead struct {
+	Meta []Meta `xml:"meta"`
+}
+
+// NCXText wraps a text element
+type NCXText struct {
+	Text string `xml:"text"`
+}
+
+// NavMap holds the top-level navigation points
+type NavMap struct {
+	NavPoints []NavPoint `xml:"navPoint"`
+}
+
+// NavPoint represents a table of contents entry
+type NavPoint struct {
+	ID        string     `xml:"id,attr"`
+	PlayOrder int        `xml:"playOrder,attr"`
+	NavLabel  NCXText    `xml:"navLabel"`
+	Content   NavContent `xml:"content"`
+	Children  []NavPoint `xml:"navPoint"`
+}
+
+// NavContent points a navigation entry at a file
+type NavContent struct {
+	Src string `xml:"src,attr"`
+}
+
+// NewPackage returns an OPF 2.0 package with the standard namespaces set
+func NewPackage() Package {
+	return Package{
+		Xmlns:            "http://www.idpf.org/2007/opf",
+		Version:          "2.0",
+		UniqueIdentifier: "bookid",
+		Metadata: Metadata{
+			XmlnsDC:  "http://purl.org/dc/elements/1.1/",
+			XmlnsOPF: "http://www.idpf.org/2007/opf",
+		},
+		Spine: Spine{Toc: "ncx"},
+	}
+}
+
+// NewNCX returns an NCX document with the standard namespace set
+func NewNCX() NCX {
+	return NCX{
+		Xmlns:   "http://www.daisy.org/z3986/2005/ncx/",
+		Version: "2005-1",
+	}
+}
+
+// Bytes serializes the package document, indented when pretty is set
+func (p Package) Bytes(pretty bool) ([]byte, error) {
+	return marshal(p, "", pretty)
+}
+
+// Bytes serializes the NCX document, indented when pretty is set
+func (n NCX) Bytes(pretty bool) ([]byte, error) {
+	return marshal(n, ncxDoctype, pretty)
+}
+
+// marshal writes the XML declaration, an optional doctype, and the document
+func marshal(v any, doctype string, pretty bool) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if doctype != "" {
+		buf.WriteString(doctype)
+		buf.WriteByte('\n')
+	}
+
+	enc := xml.NewEncoder(&buf)
+	if pretty {
+		enc.Indent("", indent)
+	}
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,198 @@
+package epub
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestPackageBytes_Pretty(t *testing.T) {
+	pkg := NewPackage()
+	pkg.Metadata.Title = Text{Value: "Go & <XML>"}
+	pkg.Metadata.Creators = []string{"Jane Doe", "John Roe"}
+	pkg.Metadata.Publisher = "O'Reilly Media, Inc."
+	pkg.Metadata.Description = "A book"
+	pkg.Metadata.Language = Text{Value: "en"}
+	pkg.Metadata.Identifier = Identifier{ID: "bookid", Value: "9781234567890"}
+	pkg.Metadata.Date = "2024-01-01"
+	pkg.Metadata.Meta = []Meta{{Name: "cover", Content: "cover-image"}}
+	pkg.Manifest.Items = []Item{
+		{ID: "ncx", Href: "toc.ncx", MediaType: "application/x-dtbncx+xml"},
+		{ID: "ch0", Href: "ch01.xhtml", MediaType: "application/xhtml+xml"},
+		{ID: "cover-image", Href: "Images/cover.jpg", MediaType: "image/jpeg"},
+	}
+	pkg.Spine.ItemRefs = []ItemRef{{IDRef: "ch0"}}
+
+	got, err := pkg.Bytes(true)
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0" unique-identifier="bookid">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+    <dc:title>Go &amp; &lt;XML&gt;</dc:title>
+    <dc:creator>Jane Doe</dc:creator>
+    <dc:creator>John Roe</dc:creator>
+    <dc:publisher>O&#39;Reilly Media, Inc.</dc:publisher>
+    <dc:description>A book</dc:description>
+    <dc:language>en</dc:language>
+    <dc:identifier id="bookid">9781234567890</dc:identifier>
+    <dc:date>2024-01-01</dc:date>
+    <meta name="cover" content="cover-image"></meta>
+  </metadata>
+  <manifest>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"></item>
+    <item id="ch0" href="ch01.xhtml" media-type="application/xhtml+xml"></item>
+    <item id="cover-image" href="Images/cover.jpg" media-type="image/jpeg"></item>
+  </manifest>
+  <spine toc="ncx">
+    <itemref idref="ch0"></itemref>
+  </spine>
+</package>
+`
+	if string(got) != want {
+		t.Errorf("Unexpected content.opf:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestNCXBytes_Pretty(t *testing.T) {
+	ncx := NewNCX()
+	ncx.Head.Meta = []Meta{{Name: "dtb:uid", Content: "9781234567890"}}
+	ncx.DocTitle.Text = "Go & XML"
+	ncx.DocAuthor.Text = "Jane Doe"
+	ncx.NavMap.NavPoints = []NavPoint{{
+		ID:        "ch0",
+		PlayOrder: 1,
+		NavLabel:  NCXText{Text: "Chapter \"1\""},
+		Content:   NavContent{Src: "ch01.xhtml"},
+	}}
+
+	got, err := ncx.Bytes(true)
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE ncx PUBLIC "-//NISO//DTD ncx 2005-1//EN" "http://www.daisy.org/z3986/2005/ncx-2005-1.dtd">
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+  <head>
+    <meta name="dtb:uid" content="9781234567890"></meta>
+  </head>
+  <docTitle>
+    <text>Go &amp; XML</text>
+  </docTitle>
+  <docAuthor>
+    <text>Jane Doe</text>
+  </docAuthor>
+  <navMap>
+    <navPoint id="ch0" playOrder="1">
+      <navLabel>
+        <text>Chapter &#34;1&#34;</text>
+      </navLabel>
+      <content src="ch01.xhtml"></content>
+    </navPoint>
+  </navMap>
+</ncx>
+`
+	if string(got) != want {
+		t.Errorf("Unexpected toc.ncx:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestNCXBytes_Compact(t *testing.T) {
+	got, err := NewNCX().Bytes(false)
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE ncx PUBLIC "-//NISO//DTD ncx 2005-1//EN" "http://www.daisy.org/z3986/2005/ncx-2005-1.dtd">
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1"><head></head><docTitle><text></text></docTitle><docAuthor><text></text></docAuthor><navMap></navMap></ncx>
+`
+	if string(got) != want {
+		t.Errorf("Unexpected compact toc.ncx:\n%s", got)
+	}
+}
+
+func TestPackageMergeMetadata(t *testing.T) {
+	pkg := NewPackage()
+	pkg.Metadata.Title = Text{Value: "Go"}
+	pkg.Metadata.Creators = []string{"Jane Doe"}
+	pkg.Metadata.Language = Text{Value: "en"}
+	pkg.Metadata.Identifier = Identifier{ID: "bookid", Value: "9781234567890"}
+	pkg.Metadata.Meta = []Meta{{Name: "cover", Content: "cover-image"}}
+
+	var elements []Element
+	for _, e := range []struct {
+		name, value string
+		attrs       []xml.Attr
+	}{
+		{"dc:identifier", "urn:doi:10.1000/182", []xml.Attr{{Name: xml.Name{Local: "id"}, Value: "doi"}, {Name: xml.Name{Local: "opf:scheme"}, Value: "DOI"}}},
+		{"dc:contributor", "Ann <Editor> & Co", []xml.Attr{{Name: xml.Name{Local: "opf:role"}, Value: "edt"}}},
+		{"dc:title", "Go, Revised", nil},
+		{"meta", "", []xml.Attr{{Name: xml.Name{Local: "name"}, Value: "cover"}, {Name: xml.Name{Local: "content"}, Value: "art"}}},
+	} {
+		el, err := NewElement(e.name, e.value, e.attrs)
+		if err != nil {
+			t.Fatalf("NewElement(%s) failed: %v", e.name, err)
+		}
+		elements = append(elements, el)
+	}
+	if err := CheckElements(elements); err != nil {
+		t.Fatalf("CheckElements failed: %v", err)
+	}
+	pkg.MergeMetadata(elements)
+
+	got, err := pkg.Bytes(true)
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	want := `  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+    <dc:title>Go, Revised</dc:title>
+    <dc:creator>Jane Doe</dc:creator>
+    <dc:language>en</dc:language>
+    <dc:identifier id="bookid">9781234567890</dc:identifier>
+    <dc:identifier id="doi" opf:scheme="DOI">urn:doi:10.1000/182</dc:identifier>
+    <dc:contributor opf:role="edt">Ann &lt;Editor&gt; &amp; Co</dc:contributor>
+    <meta name="cover" content="art"></meta>
+  </metadata>
+`
+	if !strings.Contains(string(got), want) {
+		t.Errorf("Unexpected merged metadata:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The unique identifier is replaced in place
+	el, err := NewElement("dc:identifier", "urn:isbn:9780000000000", []xml.Attr{{Name: xml.Name{Local: "id"}, Value: "bookid"}})
+	if err != nil {
+		t.Fatalf("NewElement failed: %v", err)
+	}
+	pkg.MergeMetadata([]Element{el})
+	if got := pkg.Metadata.Identifier; got.ID != "bookid" || got.Value != "urn:isbn:9780000000000" || len(got.Attrs) != 0 {
+		t.Errorf("Expected the book identifier to be replaced, got %+v", got)
+	}
+}
+
+func TestNewElement_Invalid(t *testing.T) {
+	for _, tt := range []struct {
+		name, value string
+		attrs       []xml.Attr
+	}{
+		{"dc:colour", "red", nil},
+		{"script", "x", nil},
+		{"dc:contributor", "", nil},
+		{"dc:contributor", "bell\a", nil},
+		{"dc:contributor", "Ann", []xml.Attr{{Name: xml.Name{Local: "on click"}, Value: "x"}}},
+		{"dc:contributor", "Ann", []xml.Attr{{Name: xml.Name{Local: "xlink:href"}, Value: "x"}}},
+		{"meta", "", []xml.Attr{{Name: xml.Name{Local: "content"}, Value: "x"}}},
+	} {
+		if _, err := NewElement(tt.name, tt.value, tt.attrs); err == nil {
+			t.Errorf("Expected NewElement(%q, %q, %v) to fail", tt.name, tt.value, tt.attrs)
+		}
+	}
+
+	title, _ := NewElement("dc:title", "A", nil)
+	if err := CheckElements([]Element{title, title}); err == nil {
+		t.Error("Expected a second dc:title to be rejected")
+	}
+}
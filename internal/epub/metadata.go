@@ -0,0 +1,173 @@
+package epub
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// dcElements are the Dublin Core elements an OPF metadata block may hold
+var dcElements = []string{
+	"contributor", "coverage", "creator", "date", "description", "format", "identifier",
+	"language", "publisher", "relation", "rights", "source", "subject", "title", "type",
+}
+
+// attrNameRe matches the attribute names Element accepts: plain names and
+// those in the opf and xml namespaces, which the package already declares
+var attrNameRe = regexp.MustCompile(`^(?:(?:opf|xml):)?[A-Za-z_][A-Za-z0-9._-]*$`)
+
+// Element is a metadata element with no field of its own, such as a
+// dc:contributor or a second dc:identifier, or one replacing a field
+type Element struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Value   string     `xml:",chardata"`
+}
+
+// NewElement returns the metadata element name, "meta" or a "dc:" element,
+// with the given attributes in order. Values are escaped when the package is
+// encoded; names and characters XML cannot hold are an error.
+func NewElement(name, value string, attrs []xml.Attr) (Element, error) {
+	local, isDC := strings.CutPrefix(name, "dc:")
+	if name != "meta" && !(isDC && slices.Contains(dcElements, local)) {
+		return Element{}, fmt.Errorf("unsupported element %q (use meta or a dc: element like dc:contributor)", name)
+	}
+	if err := checkText(value); err != nil {
+		return Element{}, fmt.Errorf("%s: %w", name, err)
+	}
+	el := Element{XMLName: xml.Name{Local: name}, Value: value}
+	for _, attr := range attrs {
+		if !attrNameRe.MatchString(attr.Name.Local) || attr.Name.Space != "" {
+			return Element{}, fmt.Errorf("%s: unsupported attribute %q", name, attr.Name.Local)
+		}
+		if err := checkText(attr.Value); err != nil {
+			return Element{}, fmt.Errorf("%s: attribute %s: %w", name, attr.Name.Local, err)
+		}
+		el.Attrs = append(el.Attrs, attr)
+	}
+	if name == "meta" && el.Attr("name") == "" && el.Attr("property") == "" {
+		return Element{}, errors.New("meta: needs a name or property attribute")
+	}
+	if name != "meta" && strings.TrimSpace(value) == "" {
+		return Element{}, fmt.Errorf("%s: empty value", name)
+	}
+	return el, nil
+}
+
+// Attr returns the value of the element's attribute name, or ""
+func (e Element) Attr(name string) string {
+	for _, attr := range e.Attrs {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// checkText rejects text XML 1.0 cannot represent, which encoding/xml would
+// otherwise replace silently
+func checkText(s string) error {
+	if !utf8.ValidString(s) {
+		return errors.New("invalid UTF-8")
+	}
+	for _, r := range s {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' || r == 0xFFFE || r == 0xFFFF {
+			return fmt.Errorf("character %U is not allowed in XML", r)
+		}
+	}
+	return nil
+}
+
+// CheckElements rejects a list of elements that would make an invalid
+// package once merged: a single-valued element such as dc:title given twice,
+// or two elements with the same id
+func CheckElements(elements []Element) error {
+	seen := make(map[string]bool)
+	ids := make(map[string]bool)
+	for _, el := range elements {
+		name := el.XMLName.Local
+		if singleValued(name) {
+			if seen[name] {
+				return fmt.Errorf("%s is given twice", name)
+			}
+			seen[name] = true
+		}
+		if id := el.Attr("id"); id != "" {
+			if ids[id] {
+				return fmt.Errorf("id %q is given twice", id)
+			}
+			ids[id] = true
+		}
+	}
+	return nil
+}
+
+// singleValued reports whether a package holds one name element at most
+func singleValued(name string) bool {
+	switch name {
+	case "dc:title", "dc:publisher", "dc:description", "dc:language", "dc:date":
+		return true
+	}
+	return false
+}
+
+// MergeMetadata adds elements to the package metadata, replacing the fields
+// they collide with: a dc:identifier with the package's unique identifier ID
+// replaces the book's identifier and dc:title and dc:language replace theirs
+// in place, as the package always needs them; the other single-valued
+// elements replace their field, any dc:creator or dc:subject replaces the
+// whole list, and a meta replaces those with the same name or property.
+// Anything else is added.
+func (p *Package) MergeMetadata(elements []Element) {
+	meta := &p.Metadata
+	for _, el := range elements {
+		switch el.XMLName.Local {
+		case "dc:identifier":
+			if el.Attr("id") == p.UniqueIdentifier {
+				meta.Identifier.Value = el.Value
+				meta.Identifier.Attrs = slices.DeleteFunc(slices.Clone(el.Attrs), func(attr xml.Attr) bool {
+					return attr.Name.Local == "id"
+				})
+				continue
+			}
+		case "dc:title":
+			meta.Title = Text{Attrs: el.Attrs, Value: el.Value}
+			continue
+		case "dc:publisher":
+			meta.Publisher = ""
+		case "dc:description":
+			meta.Description = ""
+		case "dc:language":
+			meta.Language = Text{Attrs: el.Attrs, Value: el.Value}
+			continue
+		case "dc:date":
+			meta.Date = ""
+		case "dc:creator":
+			meta.Creators = nil
+		case "dc:subject":
+			meta.Subjects = nil
+		case "meta":
+			name, property := el.Attr("name"), el.Attr("property")
+			meta.Meta = slices.DeleteFunc(meta.Meta, func(m Meta) bool {
+				return m.Name != "" && m.Name == name || m.Property != "" && m.Property == property
+			})
+		}
+		meta.Elements = append(meta.Elements, el)
+	}
+}
+
+// CreatorNames returns the book's creators, those merged in by MergeMetadata
+// included
+func (m Metadata) CreatorNames() []string {
+	names := slices.Clone(m.Creators)
+	for _, el := range m.Elements {
+		if el.XMLName.Local == "dc:creator" {
+			names = append(names, el.Value)
+		}
+	}
+	return names
+}
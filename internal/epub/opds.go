@@ -0,0 +1,59 @@
+package epub
+
+import "encoding/xml"
+
+// OPDS link relations
+const (
+	RelAcquisition = "http://opds-spec.org/acquisition"
+	RelImage       = "http://opds-spec.org/image"
+)
+
+// Entry represents an Atom entry describing a book in an OPDS catalog
+type Entry struct {
+	XMLName    xml.Name   `xml:"entry"`
+	Xmlns      string     `xml:"xmlns,attr"`
+	XmlnsDC    string     `xml:"xmlns:dc,attr"`
+	Title      string     `xml:"title"`
+	ID         string     `xml:"id"`
+	Updated    string     `xml:"updated"`
+	Published  string     `xml:"published,omitempty"`
+	Authors    []Author   `xml:"author"`
+	Language   string     `xml:"dc:language,omitempty"`
+	Publisher  string     `xml:"dc:publisher,omitempty"`
+	Issued     string     `xml:"dc:issued,omitempty"`
+	Categories []Category `xml:"category"`
+	Summary    string     `xml:"summary,omitempty"`
+	Links      []Link     `xml:"link"`
+}
+
+// Author represents an Atom author
+type Author struct {
+	Name string `xml:"name"`
+}
+
+// Category represents an Atom category, such as a subject
+type Category struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr,omitempty"`
+}
+
+// Link represents an Atom link, e.g. an OPDS acquisition or image link
+type Link struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+// NewEntry returns an empty Atom entry with Dublin Core terms declared
+func NewEntry() Entry {
+	return Entry{
+		Xmlns:   "http://www.w3.org/2005/Atom",
+		XmlnsDC: "http://purl.org/dc/terms/",
+	}
+}
+
+// Bytes serializes the entry as a standalone document, indented when pretty
+// is set
+func (e Entry) Bytes(pretty bool) ([]byte, error) {
+	return marshal(e, "", pretty)
+}
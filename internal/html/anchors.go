@@ -0,0 +1,179 @@
+package html
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/dacsang97/safaribooks/pkg/utils"
+	nethtml "golang.org/x/net/html"
+)
+
+// idRefAttrs hold space-separated lists of element IDs
+var idRefAttrs = map[string]bool{
+	"for":              true,
+	"headers":          true,
+	"aria-labelledby":  true,
+	"aria-describedby": true,
+	"aria-controls":    true,
+}
+
+// anchorPrefix returns the token prepended to element IDs from a chapter file
+// when anchors are flattened, e.g. "ch01-" for "ch01.html"
+func anchorPrefix(filename string) string {
+	name := path.Base(filename)
+	name = strings.TrimSuffix(name, path.Ext(name))
+
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	token := b.String()
+	// IDs must start with a letter
+	if token == "" || !(token[0] >= 'a' && token[0] <= 'z' || token[0] >= 'A' && token[0] <= 'Z') {
+		token = "c" + token
+	}
+	return token + "-"
+}
+
+// flattenAnchors prefixes element IDs below root with the chapter's anchor
+// prefix and rewrites fragment links to match, so IDs stay unique across the
+// whole book. Links into other chapters get the target chapter's prefix.
+// root's own ID is kept since stylesheets target #sbo-rt-content.
+func flattenAnchors(root *nethtml.Node, filename string) {
+	prefix := anchorPrefix(filename)
+	for child := root.FirstChild; child != nil; child = child.NextSibling {
+		flattenNode(child, prefix)
+	}
+}
+
+func flattenNode(node *nethtml.Node, prefix string) {
+	if node.Type == nethtml.ElementNode {
+		for i := range node.Attr {
+			attr := &node.Attr[i]
+			switch {
+			case attr.Key == "id" && attr.Val != "":
+				attr.Val = prefix + attr.Val
+			case attr.Key == "href":
+				attr.Val = prefixFragment(attr.Val, prefix)
+			case idRefAttrs[attr.Key]:
+				ids := strings.Fields(attr.Val)
+				for j, id := range ids {
+					ids[j] = prefix + id
+				}
+				attr.Val = strings.Join(ids, " ")
+			}
+		}
+	}
+
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		flattenNode(child, prefix)
+	}
+}
+
+// prefixFragment rewrites the fragment of an in-book chapter link. Same-file
+// links use prefix; links into other chapters use that chapter's prefix.
+func prefixFragment(link, prefix string) string {
+	file, fragment, ok := strings.Cut(link, "#")
+	if !ok || fragment == "" || utils.IsAbsoluteURL(link) || strings.HasPrefix(link, "mailto:") {
+		return link
+	}
+	if file == "" {
+		return "#" + prefix + fragment
+	}
+	if strings.HasPrefix(file, "Images/") || path.Ext(file) != ".xhtml" {
+		return link
+	}
+	return file + "#" + anchorPrefix(file) + fragment
+}
+
+var (
+	idAttrRe       = regexp.MustCompile(`\sid="([^"]*)"`)
+	fragmentHrefRe = regexp.MustCompile(`(\shref=")#([^"]+)"`)
+)
+
+// IDIndex maps element IDs to the chapter defining them, so fragment-only
+// links whose target lives in another chapter file can be pointed there.
+// Chapters are indexed as serialized by ParseChapter.
+type IDIndex struct {
+	owners    map[string]string
+	flattened bool
+}
+
+// NewIDIndex creates an empty index; flattened reports whether chapters were
+// parsed with FlattenAnchors, whose per-chapter ID prefixes are looked through
+func NewIDIndex(flattened bool) *IDIndex {
+	return &IDIndex{owners: make(map[string]string), flattened: flattened}
+}
+
+// Add records the IDs defined in a chapter page; the first chapter defining
+// an ID owns it
+func (x *IDIndex) Add(filename, page string) {
+	for _, m := range idAttrRe.FindAllStringSubmatch(page, -1) {
+		id := x.key(filename, m[1])
+		if _, ok := x.owners[id]; !ok && id != "" {
+			x.owners[id] = filename
+		}
+	}
+}
+
+// Resolve rewrites fragment-only links in page whose ID is not defined in the
+// page itself but in another indexed chapter, returning the new page and the
+// number of links changed
+func (x *IDIndex) Resolve(filename, page string) (string, int) {
+	local := make(map[string]bool)
+	for _, m := range idAttrRe.FindAllStringSubmatch(page, -1) {
+		local[m[1]] = true
+	}
+
+	changed := 0
+	page = fragmentHrefRe.ReplaceAllStringFunc(page, func(attr string) string {
+		m := fragmentHrefRe.FindStringSubmatch(attr)
+		if local[m[2]] {
+			return attr
+		}
+		id := x.key(filename, m[2])
+		target, ok := x.owners[id]
+		if !ok || target == filename {
+			return attr
+		}
+		if x.flattened {
+			id = anchorPrefix(target) + id
+		}
+		changed++
+		return m[1] + target + "#" + id + `"`
+	})
+	return page, changed
+}
+
+// Pending reports whether page has fragment-only links to IDs defined
+// neither in the page nor in any chapter indexed so far, which a chapter
+// indexed later may define
+func (x *IDIndex) Pending(filename, page string) bool {
+	local := make(map[string]bool)
+	for _, m := range idAttrRe.FindAllStringSubmatch(page, -1) {
+		local[m[1]] = true
+	}
+	for _, m := range fragmentHrefRe.FindAllStringSubmatch(page, -1) {
+		if local[m[2]] {
+			continue
+		}
+		if _, ok := x.owners[x.key(filename, m[2])]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// key strips the chapter's anchor prefix from flattened IDs
+func (x *IDIndex) key(filename, id string) string {
+	if x.flattened {
+		return strings.TrimPrefix(id, anchorPrefix(filename))
+	}
+	return id
+}
@@ -0,0 +1,142 @@
+package html
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dacsang97/safaribooks/internal/models"
+)
+
+func TestParseChapter_FlattenAnchors(t *testing.T) {
+	parser := NewParser("https://learning.oreilly.com", ParserOptions{FlattenAnchors: true})
+	pageHTML := parseTestChapter(t, parser, `<h1 id="intro">Intro</h1>`+
+		`<p><a href="#intro">top</a> <a href="ch01.html#intro">self</a> <a href="ch02.html#setup">next</a> <a href="https://example.com/#x">web</a></p>`+
+		`<label for="field">Label</label><input id="field"/>`)
+
+	wants := []string{
+		`<div id="sbo-rt-content">`,
+		`<h1 id="ch01-intro">`,
+		`<a href="#ch01-intro">top</a>`,
+		`<a href="ch01.xhtml#ch01-intro">self</a>`,
+		`<a href="ch02.xhtml#ch02-setup">next</a>`,
+		`<a href="https://example.com/#x">web</a>`,
+		`<label for="ch01-field">`,
+		`<input id="ch01-field"/>`,
+	}
+	for _, want := range wants {
+		if !strings.Contains(pageHTML, want) {
+			t.Errorf("Expected %s in output, got:\n%s", want, pageHTML)
+		}
+	}
+}
+
+func TestParseChapter_FlattenAnchorsUnique(t *testing.T) {
+	parser := NewParser("https://learning.oreilly.com", ParserOptions{FlattenAnchors: true})
+
+	var pages []string
+	for _, filename := range []string{"ch01.html", "ch02.html"} {
+		chapter := models.Chapter{
+			Title:    filename,
+			Filename: filename,
+			Content:  `<html><body><div id="sbo-rt-content"><section id="summary"><p>Text</p></section></div></body></html>`,
+		}
+		_, pageHTML, err := parser.ParseChapter(chapter, false)
+		if err != nil {
+			t.Fatalf("ParseChapter failed: %v", err)
+		}
+		pages = append(pages, pageHTML)
+	}
+
+	if !strings.Contains(pages[0], `id="ch01-summary"`) || !strings.Contains(pages[1], `id="ch02-summary"`) {
+		t.Errorf("Expected per-chapter IDs, got:\n%s\n%s", pages[0], pages[1])
+	}
+}
+
+func TestParseChapter_AnchorsKeptByDefault(t *testing.T) {
+	parser := NewParser("https://learning.oreilly.com", ParserOptions{})
+	pageHTML := parseTestChapter(t, parser, `<h1 id="intro">Intro</h1><a href="#intro">top</a>`)
+	if !strings.Contains(pageHTML, `<h1 id="intro">`) || !strings.Contains(pageHTML, `href="#intro"`) {
+		t.Errorf("Expected IDs unchanged without FlattenAnchors, got:\n%s", pageHTML)
+	}
+}
+
+func TestAnchorPrefix(t *testing.T) {
+	cases := map[string]string{
+		"ch01.html":             "ch01-",
+		"OEBPS/text/ch02.xhtml": "ch02-",
+		"9781491950357.html":    "c9781491950357-",
+		"part 1.html":           "part_1-",
+	}
+	for filename, want := range cases {
+		if got := anchorPrefix(filename); got != want {
+			t.Errorf("anchorPrefix(%q) = %q, want %q", filename, got, want)
+		}
+	}
+}
+
+// parseNamedChapter parses body as the chapter in filename
+func parseNamedChapter(t *testing.T, parser *Parser, filename, body string) string {
+	t.Helper()
+
+	chapter := models.Chapter{
+		Title:    filename,
+		Filename: filename,
+		Content:  `<html><head></head><body><div id="sbo-rt-content">` + body + `</div></body></html>`,
+	}
+	_, pageHTML, err := parser.ParseChapter(chapter, false)
+	if err != nil {
+		t.Fatalf("ParseChapter failed: %v", err)
+	}
+	return pageHTML
+}
+
+func TestIDIndex_ResolvesCrossChapterFragments(t *testing.T) {
+	cases := []struct {
+		name      string
+		flattened bool
+		wants     []string
+	}{
+		{"plain", false, []string{`<a href="ch02.xhtml#fig-3-1">Figure 3-1</a>`, `<a href="#local">here</a>`, `<a href="#nowhere">lost</a>`}},
+		{"flattened", true, []string{`<a href="ch02.xhtml#ch02-fig-3-1">Figure 3-1</a>`, `<a href="#ch01-local">here</a>`, `<a href="#ch01-nowhere">lost</a>`}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			parser := NewParser("https://learning.oreilly.com", ParserOptions{FlattenAnchors: c.flattened})
+			ch1 := parseNamedChapter(t, parser, "ch01.xhtml", `<p id="local"><a href="#fig-3-1">Figure 3-1</a> <a href="#local">here</a> <a href="#nowhere">lost</a></p>`)
+			ch2 := parseNamedChapter(t, parser, "ch02.xhtml", `<figure id="fig-3-1"><img src="a.png"/></figure><p><a href="#fig-3-1">see above</a></p>`)
+
+			index := NewIDIndex(c.flattened)
+			index.Add("ch01.xhtml", ch1)
+			index.Add("ch02.xhtml", ch2)
+
+			resolved, changed := index.Resolve("ch01.xhtml", ch1)
+			if changed != 1 {
+				t.Errorf("Expected 1 link rewritten, got %d", changed)
+			}
+			for _, want := range c.wants {
+				if !strings.Contains(resolved, want) {
+					t.Errorf("Expected %s in output, got:\n%s", want, resolved)
+				}
+			}
+
+			if same, changed := index.Resolve("ch02.xhtml", ch2); changed != 0 || same != ch2 {
+				t.Errorf("Expected links to a chapter's own IDs untouched, got %d changes", changed)
+			}
+		})
+	}
+}
+
+func TestIDIndex_Pending(t *testing.T) {
+	index := NewIDIndex(false)
+	ch1 := `<p id="local"><a href="#local">here</a> <a href="#later">ahead</a></p>`
+	index.Add("ch01.xhtml", ch1)
+	if !index.Pending("ch01.xhtml", ch1) {
+		t.Error("Expected a link to an ID no indexed chapter defines to be pending")
+	}
+
+	index.Add("ch02.xhtml", `<p id="later">2</p>`)
+	if index.Pending("ch01.xhtml", ch1) {
+		t.Error("Expected no pending links once the target chapter is indexed")
+	}
+}
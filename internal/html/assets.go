@@ -0,0 +1,114 @@
+package html
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	nethtml "golang.org/x/net/html"
+)
+
+// Asset kinds reported by ChapterAssets
+const (
+	AssetImage      = "image"
+	AssetStylesheet = "stylesheet"
+	AssetFont       = "font"
+)
+
+// Asset is a file a chapter's HTML refers to, its Ref as written in the page
+type Asset struct {
+	Kind string
+	Ref  string
+}
+
+// cssURLRe matches url(...) references in CSS, capturing the target
+var cssURLRe = regexp.MustCompile(`url\(\s*["']?([^"')\s]+)["']?\s*\)`)
+
+// fontExts are the file extensions CSS url() references are fonts for
+var fontExts = map[string]bool{".woff": true, ".woff2": true, ".ttf": true, ".otf": true, ".eot": true}
+
+// ChapterAssets lists the images, stylesheets, and fonts a chapter's HTML
+// refers to, in document order: <img> and SVG <image> sources, linked
+// stylesheets, and url() references in <style> elements. Data URIs are left
+// out as they need no download.
+func ChapterAssets(content string) ([]Asset, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("parse chapter: %w", err)
+	}
+
+	var assets []Asset
+	add := func(kind, ref string) {
+		ref = strings.TrimSpace(ref)
+		if ref != "" && !strings.HasPrefix(ref, "data:") {
+			assets = append(assets, Asset{Kind: kind, Ref: ref})
+		}
+	}
+	doc.Find("img, image, link[rel='stylesheet'], style").Each(func(_ int, sel *goquery.Selection) {
+		node := sel.Get(0)
+		switch node.Data {
+		case "img":
+			add(AssetImage, sel.AttrOr("src", ""))
+		case "image":
+			add(AssetImage, svgImageHref(node))
+		case "link":
+			add(AssetStylesheet, sel.AttrOr("href", ""))
+		case "style":
+			for _, asset := range CSSAssets(sel.Text()) {
+				add(asset.Kind, asset.Ref)
+			}
+		}
+	})
+	return assets, nil
+}
+
+// CSSAssets lists the url() references in a stylesheet, in order: fonts by
+// their extension, imported .css files as stylesheets, and anything else as
+// an image. Data URIs and fragments are left out.
+func CSSAssets(css string) []Asset {
+	var assets []Asset
+	for _, match := range cssURLRe.FindAllStringSubmatch(css, -1) {
+		ref := match[1]
+		if strings.HasPrefix(ref, "data:") || strings.HasPrefix(ref, "#") {
+			continue
+		}
+		kind := AssetImage
+		switch ext := strings.ToLower(path.Ext(strings.SplitN(ref, "?", 2)[0])); {
+		case fontExts[ext]:
+			kind = AssetFont
+		case ext == ".css":
+			kind = AssetStylesheet
+		}
+		assets = append(assets, Asset{Kind: kind, Ref: ref})
+	}
+	return assets
+}
+
+// SVGImageRefs lists the sources of the SVG <image> elements in a chapter's
+// HTML, in document order, such as a full-page cover wrapped in an <svg>
+func SVGImageRefs(content string) []string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return nil
+	}
+	var refs []string
+	for _, node := range doc.Find("svg image").Nodes {
+		if ref := strings.TrimSpace(svgImageHref(node)); ref != "" && !strings.HasPrefix(ref, "data:") {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// svgImageHref returns the source of an SVG <image>: its href or
+// xlink:href, whichever comes first
+func svgImageHref(node *nethtml.Node) string {
+	for _, attr := range node.Attr {
+		if strings.Contains(strings.ToLower(attr.Key), "href") {
+			return attr.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,49 @@
+package html
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestChapterAssets(t *testing.T) {
+	page := `<html><head>
+<link rel="stylesheet" href="/library/css/book.css"/>
+<style>
+@font-face { font-family: "Body"; src: url("fonts/Body.woff2?v=2") format("woff2"); }
+.banner { background: url(images/banner.png); }
+.dot { background: url(data:image/png;base64,AAAA); }
+</style>
+</head><body><div id="sbo-rt-content">
+<p><img src="assets/fig01.png" alt=""/></p>
+<svg xmlns:xlink="http://www.w3.org/1999/xlink"><image xlink:href="assets/diagram.svg"/></svg>
+<img src=""/>
+</div></body></html>`
+
+	assets, err := ChapterAssets(page)
+	if err != nil {
+		t.Fatalf("ChapterAssets failed: %v", err)
+	}
+	want := []Asset{
+		{AssetStylesheet, "/library/css/book.css"},
+		{AssetFont, "fonts/Body.woff2?v=2"},
+		{AssetImage, "images/banner.png"},
+		{AssetImage, "assets/fig01.png"},
+		{AssetImage, "assets/diagram.svg"},
+	}
+	if !slices.Equal(assets, want) {
+		t.Errorf("Expected %v, got %v", want, assets)
+	}
+}
+
+func TestCSSAssets(t *testing.T) {
+	css := `@import url("print.css"); @font-face { src: url('Serif.WOFF2?v=1'); }
+		body { background: url(bg.png); } .a { mask: url(#m); } .b { src: url(data:font/woff;base64,AA); }`
+	want := []Asset{
+		{Kind: AssetStylesheet, Ref: "print.css"},
+		{Kind: AssetFont, Ref: "Serif.WOFF2?v=1"},
+		{Kind: AssetImage, Ref: "bg.png"},
+	}
+	if got := CSSAssets(css); !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,27 @@
+package html
+
+import (
+	"slices"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// normalizeHeadings renumbers a chapter's headings so they start at <h1> and
+// never skip a level: each heading level used is mapped, in order, onto h1,
+// h2 and so on, so a chapter of h3 and h5 headings gets h1 and h2 ones.
+// Relative order is kept and the text and attributes are untouched.
+func normalizeHeadings(content *goquery.Selection) {
+	headings := content.Find("h1, h2, h3, h4, h5, h6").Nodes
+	var levels []byte
+	for _, node := range headings {
+		if !slices.Contains(levels, node.Data[1]) {
+			levels = append(levels, node.Data[1])
+		}
+	}
+	slices.Sort(levels)
+
+	for _, node := range headings {
+		level := slices.Index(levels, node.Data[1])
+		node.Data = "h" + string(rune('1'+level))
+	}
+}
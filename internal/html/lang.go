@@ -0,0 +1,160 @@
+package html
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	// langSampleWords bounds how much chapter text is inspected
+	langSampleWords = 2000
+	// langMinWords is the smallest sample worth guessing from
+	langMinWords = 20
+)
+
+// languageTagRe matches the shape of a BCP 47 language tag: a 2-8 letter
+// primary subtag, then subtags of 1-8 letters or digits
+var languageTagRe = regexp.MustCompile(`^[A-Za-z]{2,8}(-[A-Za-z0-9]{1,8})*$`)
+
+// stopwords holds frequent function words used to tell Latin-script languages apart
+var stopwords = map[string][]string{
+	"en": {"the", "and", "of", "to", "is", "in", "that", "it", "with", "for", "this", "are", "as", "be", "on", "you", "we", "can", "by", "not"},
+	"es": {"el", "la", "los", "las", "y", "de", "que", "en", "un", "una", "es", "por", "con", "para", "del", "se", "lo", "como", "más", "pero"},
+	"fr": {"le", "la", "les", "et", "de", "des", "que", "un", "une", "est", "dans", "pour", "qui", "pas", "sur", "au", "du", "avec", "ce", "nous"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ein", "eine", "zu", "den", "mit", "von", "sich", "auf", "für", "dem", "wir", "auch", "es", "werden"},
+	"pt": {"o", "os", "as", "e", "de", "que", "em", "um", "uma", "é", "para", "com", "não", "do", "da", "se", "por", "mais", "dos", "como"},
+	"it": {"il", "lo", "gli", "e", "di", "che", "è", "un", "una", "per", "non", "con", "del", "della", "sono", "come", "anche", "si", "nel", "questo"},
+	"nl": {"de", "het", "een", "en", "van", "is", "dat", "niet", "op", "te", "zijn", "met", "voor", "die", "ook", "je", "wordt", "aan", "er", "bij"},
+}
+
+// scriptLanguages maps non-Latin scripts to the language they most likely indicate
+var scriptLanguages = []struct {
+	table *unicode.RangeTable
+	lang  string
+}{
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Hangul, "ko"},
+	{unicode.Han, "zh"},
+	{unicode.Arabic, "ar"},
+	{unicode.Hebrew, "he"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Greek, "el"},
+	{unicode.Thai, "th"},
+}
+
+// DetectLanguage guesses the language of text, returning a BCP 47 primary
+// tag and a confidence between 0 and 1. An empty tag means the sample was too
+// small or ambiguous to guess.
+func DetectLanguage(text string) (string, float64) {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	if len(words) > langSampleWords {
+		words = words[:langSampleWords]
+	}
+
+	if lang, confidence := detectScript(words); lang != "" {
+		return lang, confidence
+	}
+
+	if len(words) < langMinWords {
+		return "", 0
+	}
+
+	scores := make(map[string]int, len(stopwords))
+	total := 0
+	for lang, list := range stopwords {
+		set := make(map[string]bool, len(list))
+		for _, w := range list {
+			set[w] = true
+		}
+		for _, w := range words {
+			if set[w] {
+				scores[lang]++
+				total++
+			}
+		}
+	}
+	if total == 0 {
+		return "", 0
+	}
+
+	// Confidence is the winner's share against the runner-up, since related
+	// languages share many function words
+	best, bestScore, runnerUp := "", 0, 0
+	for lang, score := range scores {
+		switch {
+		case score > bestScore || (score == bestScore && lang < best):
+			runnerUp = bestScore
+			best, bestScore = lang, score
+		case score > runnerUp:
+			runnerUp = score
+		}
+	}
+	return best, float64(bestScore) / float64(bestScore+runnerUp)
+}
+
+// detectScript reports a language when most letters belong to a non-Latin script
+func detectScript(words []string) (string, float64) {
+	counts := make(map[string]int)
+	letters := 0
+	for _, w := range words {
+		for _, r := range w {
+			if !unicode.IsLetter(r) {
+				continue
+			}
+			letters++
+			for _, sl := range scriptLanguages {
+				if unicode.Is(sl.table, r) {
+					counts[sl.lang]++
+					break
+				}
+			}
+		}
+	}
+	if letters < langMinWords {
+		return "", 0
+	}
+
+	// Japanese text mixes kana with Han characters
+	if counts["ja"] > 0 {
+		counts["ja"] += counts["zh"]
+		delete(counts, "zh")
+	}
+
+	best, bestCount := "", 0
+	for lang, count := range counts {
+		if count > bestCount || (count == bestCount && lang < best) {
+			best, bestCount = lang, count
+		}
+	}
+	if bestCount*2 < letters {
+		return "", 0
+	}
+	return best, float64(bestCount) / float64(letters)
+}
+
+// rtlLanguages are written right-to-left
+var rtlLanguages = map[string]bool{"ar": true, "he": true, "fa": true, "ur": true, "yi": true, "ps": true, "dv": true}
+
+// IsRTLLanguage reports whether a language tag is written right-to-left
+func IsRTLLanguage(tag string) bool {
+	return rtlLanguages[primaryLanguage(tag)]
+}
+
+// IsLanguageTag reports whether tag is shaped like a BCP 47 language tag, as
+// the lang attributes and dc:language it is written to need
+func IsLanguageTag(tag string) bool {
+	return languageTagRe.MatchString(tag)
+}
+
+// primaryLanguage returns the primary subtag of a language tag, e.g. "en" for "en-US"
+func primaryLanguage(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if idx := strings.IndexAny(tag, "-_"); idx >= 0 {
+		tag = tag[:idx]
+	}
+	return tag
+}
@@ -0,0 +1,117 @@
+package html
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	englishSample = `This chapter explains how the scheduler works and why it is important to
+understand the trade-offs. We will look at the design of the runtime, the way goroutines
+are placed on threads, and what you can do to avoid contention in your own programs.`
+
+	spanishSample = `Este capítulo explica cómo funciona el planificador y por qué es importante
+entender las ventajas y desventajas. Veremos el diseño del entorno de ejecución, la forma en
+que las tareas se asignan a los hilos y lo que se puede hacer para evitar la contención.`
+
+	japaneseSample = `この章では、スケジューラの仕組みと、そのトレードオフを理解することが重要な理由を説明します。
+ランタイムの設計、ゴルーチンがスレッドに配置される方法を見ていきます。`
+)
+
+func TestDetectLanguage(t *testing.T) {
+	cases := map[string]string{
+		englishSample:  "en",
+		spanishSample:  "es",
+		japaneseSample: "ja",
+	}
+	for sample, want := range cases {
+		got, confidence := DetectLanguage(sample)
+		if got != want {
+			t.Errorf("Expected %s, got %s (confidence %.2f) for %q", want, got, confidence, sample[:20])
+		}
+		if confidence <= 0.5 {
+			t.Errorf("Expected confident detection of %s, got %.2f", want, confidence)
+		}
+	}
+}
+
+func TestIsLanguageTag(t *testing.T) {
+	for tag, want := range map[string]bool{
+		"en":             true,
+		"en-US":          true,
+		"zh-Hant-TW":     true,
+		"":               false,
+		"e":              false,
+		"en_US":          false,
+		`en" onload="x`:  false,
+		"en-US<script>":  false,
+		"toolongprimary": false,
+	} {
+		if got := IsLanguageTag(tag); got != want {
+			t.Errorf("IsLanguageTag(%q) = %v, want %v", tag, got, want)
+		}
+	}
+}
+
+func TestDetectLanguage_ShortSample(t *testing.T) {
+	if got, _ := DetectLanguage("Hello world"); got != "" {
+		t.Errorf("Expected no guess for a short sample, got %s", got)
+	}
+}
+
+func TestParseChapter_MixedLanguageChapters(t *testing.T) {
+	parser := NewParser("https://learning.oreilly.com", ParserOptions{
+		Language:          "en-US",
+		DetectLanguage:    true,
+		LanguageThreshold: 0.6,
+	})
+
+	english := parseTestChapter(t, parser, "<p>"+englishSample+"</p>")
+	if !strings.Contains(english, `<html lang="en-US" xml:lang="en-US"`) {
+		t.Errorf("Expected English chapter to keep the book language, got:\n%s", english)
+	}
+
+	spanish := parseTestChapter(t, parser, "<p>"+spanishSample+"</p>")
+	if !strings.Contains(spanish, `<html lang="es" xml:lang="es"`) {
+		t.Errorf("Expected Spanish chapter to be tagged es, got:\n%s", spanish)
+	}
+}
+
+func TestParseChapter_LanguageBelowThreshold(t *testing.T) {
+	parser := NewParser("https://learning.oreilly.com", ParserOptions{
+		Language:          "en",
+		DetectLanguage:    true,
+		LanguageThreshold: 1.1,
+	})
+
+	pageHTML := parseTestChapter(t, parser, "<p>"+spanishSample+"</p>")
+	if !strings.Contains(pageHTML, `<html lang="en" xml:lang="en"`) {
+		t.Errorf("Expected book language when confidence is below threshold, got:\n%s", pageHTML)
+	}
+}
+
+func TestParseChapter_LanguageDetectionDisabled(t *testing.T) {
+	parser := NewParser("https://learning.oreilly.com", ParserOptions{Language: "en"})
+
+	pageHTML := parseTestChapter(t, parser, "<p>"+spanishSample+"</p>")
+	if !strings.Contains(pageHTML, `<html lang="en" xml:lang="en"`) {
+		t.Errorf("Expected book language when detection is off, got:\n%s", pageHTML)
+	}
+}
+
+func TestParseChapter_Direction(t *testing.T) {
+	rtl := NewParser("https://learning.oreilly.com", ParserOptions{Language: "he"})
+	if pageHTML := parseTestChapter(t, rtl, "<p>Text</p>"); !strings.Contains(pageHTML, `<body dir="rtl">`) {
+		t.Errorf("Expected rtl body for a Hebrew book, got:\n%s", pageHTML)
+	}
+
+	forced := NewParser("https://learning.oreilly.com", ParserOptions{Language: "en", Direction: "rtl"})
+	if pageHTML := parseTestChapter(t, forced, "<p>Text</p>"); !strings.Contains(pageHTML, `<body dir="rtl">`) {
+		t.Errorf("Expected forced rtl body, got:\n%s", pageHTML)
+	}
+
+	ltr := NewParser("https://learning.oreilly.com", ParserOptions{Language: "en"})
+	if pageHTML := parseTestChapter(t, ltr, "<p>Text</p>"); !strings.Contains(pageHTML, `<body dir="ltr">`) {
+		t.Errorf("Expected ltr body, got:\n%s", pageHTML)
+	}
+}
@@ -0,0 +1,62 @@
+package html
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	nethtml "golang.org/x/net/html"
+)
+
+// linkAttrRe matches the attributes of a finished page that point at a file
+var linkAttrRe = regexp.MustCompile(`\s(?:href|src|xlink:href)="([^"]*)"`)
+
+// DanglingLink is an internal link whose target is not in the book
+type DanglingLink struct {
+	Page   string // path of the page holding the link
+	Link   string // the link as written
+	Reason string // "missing file" or "missing anchor"
+}
+
+func (l DanglingLink) String() string {
+	return fmt.Sprintf("%s: %s (%s)", l.Page, l.Link, l.Reason)
+}
+
+// CheckLinks returns the links in pages, keyed by their path in the book,
+// that point at a path missing from files or at an element ID their target
+// page does not define. Links with a scheme or host are external and skipped.
+func CheckLinks(pages map[string]string, files map[string]bool) []DanglingLink {
+	ids := make(map[string]map[string]bool, len(pages))
+	for name, page := range pages {
+		ids[name] = make(map[string]bool)
+		for _, m := range idAttrRe.FindAllStringSubmatch(page, -1) {
+			ids[name][nethtml.UnescapeString(m[1])] = true
+		}
+	}
+
+	var dangling []DanglingLink
+	for _, name := range slices.Sorted(maps.Keys(pages)) {
+		for _, m := range linkAttrRe.FindAllStringSubmatch(pages[name], -1) {
+			link := nethtml.UnescapeString(m[1])
+			u, err := url.Parse(strings.TrimSpace(link))
+			if err != nil || u.Scheme != "" || u.Host != "" || (u.Path == "" && u.Fragment == "") {
+				continue
+			}
+			target := name
+			if u.Path != "" {
+				target = path.Join(path.Dir(name), u.Path)
+			}
+			switch {
+			case !files[target]:
+				dangling = append(dangling, DanglingLink{Page: name, Link: link, Reason: "missing file"})
+			case u.Fragment != "" && ids[target] != nil && !ids[target][u.Fragment]:
+				dangling = append(dangling, DanglingLink{Page: name, Link: link, Reason: "missing anchor"})
+			}
+		}
+	}
+	return dangling
+}
@@ -0,0 +1,31 @@
+package html
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckLinks(t *testing.T) {
+	pages := map[string]string{
+		"OEBPS/ch01.xhtml": `<body><h1 id="top">One</h1>` +
+			`<a href="ch02.xhtml#intro">ok</a> <a href="#top">ok</a> <img src="Images/fig1.png"/>` +
+			`<a href="https://example.com/missing.html">external</a> <a href="mailto:a@example.com">mail</a>` +
+			`<a href="ch03.xhtml">gone</a> <a href="ch02.xhtml#nowhere">bad anchor</a> <img src="Images/fig2.png"/></body>`,
+		"OEBPS/ch02.xhtml": `<body><h1 id="intro">Two</h1><a href="ch01.xhtml?x=1&amp;y=2#top">ok</a></body>`,
+	}
+	files := map[string]bool{
+		"OEBPS/ch01.xhtml":      true,
+		"OEBPS/ch02.xhtml":      true,
+		"OEBPS/Images/fig1.png": true,
+	}
+
+	got := CheckLinks(pages, files)
+	want := []DanglingLink{
+		{Page: "OEBPS/ch01.xhtml", Link: "ch03.xhtml", Reason: "missing file"},
+		{Page: "OEBPS/ch01.xhtml", Link: "ch02.xhtml#nowhere", Reason: "missing anchor"},
+		{Page: "OEBPS/ch01.xhtml", Link: "Images/fig2.png", Reason: "missing file"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CheckLinks = %v, want %v", got, want)
+	}
+}
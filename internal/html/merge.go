@@ -0,0 +1,79 @@
+package html
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	nethtml "golang.org/x/net/html"
+)
+
+// Section is a nested chapter merged into its parent chapter's page
+type Section struct {
+	Filename string // the merged chapter's own file, see SectionID
+	Content  string // the chapter HTML as served
+}
+
+// SectionID returns the ID of the section element a merged chapter is
+// wrapped in, derived from its file name, e.g. "ch01s02" for "ch01s02.html"
+func SectionID(filename string) string {
+	return strings.TrimSuffix(anchorPrefix(filename), "-")
+}
+
+// FragmentHref links to the element id in a chapter file as ParseChapter
+// writes it, with the chapter's prefix when anchors are flattened
+func FragmentHref(filename, id string, flattened bool) string {
+	if flattened {
+		id = anchorPrefix(filename) + id
+	}
+	return filename + "#" + id
+}
+
+// MergeSections appends each section's content, wrapped in a <section> with
+// its SectionID, to the book content of page, in order. Both are chapter
+// HTML as served, so the result is parsed like any other chapter.
+func MergeSections(page string, sections []Section) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+	if err != nil {
+		return "", fmt.Errorf("parse chapter: %w", err)
+	}
+	content := doc.Find("div#sbo-rt-content").First()
+	if content.Length() == 0 {
+		return "", errors.New("parser: book content missing")
+	}
+	parent := content.Get(0)
+
+	for _, section := range sections {
+		sectionDoc, err := goquery.NewDocumentFromReader(strings.NewReader(section.Content))
+		if err != nil {
+			return "", fmt.Errorf("parse %s: %w", path.Base(section.Filename), err)
+		}
+		body := sectionDoc.Find("div#sbo-rt-content").First()
+		if body.Length() == 0 {
+			body = sectionDoc.Find("body")
+		}
+
+		wrapper := &nethtml.Node{
+			Type: nethtml.ElementNode,
+			Data: "section",
+			Attr: []nethtml.Attribute{{Key: "id", Val: SectionID(section.Filename)}},
+		}
+		if node := body.Get(0); node != nil {
+			for node.FirstChild != nil {
+				child := node.FirstChild
+				node.RemoveChild(child)
+				wrapper.AppendChild(child)
+			}
+		}
+		parent.AppendChild(wrapper)
+	}
+
+	var buf bytes.Buffer
+	if err := nethtml.Render(&buf, doc.Get(0)); err != nil {
+		return "", fmt.Errorf("render chapter: %w", err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,37 @@
+package html
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMergeSections(t *testing.T) {
+	page := `<html><body><div id="sbo-rt-content"><h1>Parent</h1></div></body></html>`
+	merged, err := MergeSections(page, []Section{
+		{Filename: "ch01s01.html", Content: `<div id="sbo-rt-content"><p>First</p></div>`},
+		{Filename: "ch01s02.html", Content: `<p>Second</p>`},
+	})
+	if err != nil {
+		t.Fatalf("MergeSections failed: %v", err)
+	}
+	want := `<div id="sbo-rt-content"><h1>Parent</h1><section id="ch01s01"><p>First</p></section><section id="ch01s02"><p>Second</p></section></div>`
+	if !strings.Contains(merged, want) {
+		t.Errorf("Expected %s, got:\n%s", want, merged)
+	}
+
+	if _, err := MergeSections(`<p>No content</p>`, nil); err == nil {
+		t.Error("Expected an error for a page without book content")
+	}
+}
+
+func TestSectionIDAndFragmentHref(t *testing.T) {
+	if got := SectionID("OEBPS/ch01s02.xhtml"); got != "ch01s02" {
+		t.Errorf("SectionID = %q, want ch01s02", got)
+	}
+	if got := FragmentHref("ch01.xhtml", "intro", false); got != "ch01.xhtml#intro" {
+		t.Errorf("FragmentHref = %q", got)
+	}
+	if got := FragmentHref("ch01.xhtml", "intro", true); got != "ch01.xhtml#ch01-intro" {
+		t.Errorf("FragmentHref flattened = %q", got)
+	}
+}
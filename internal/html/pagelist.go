@@ -0,0 +1,75 @@
+package html
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	nethtml "golang.org/x/net/html"
+)
+
+// PageBreak is a print page marker in a chapter, such as
+// <span epub:type="pagebreak" id="page_42" title="42"/>
+type PageBreak struct {
+	ID    string // element ID, the target of page-list links
+	Label string // print page number shown to the reader
+}
+
+// pageIDRe takes the page number off the end of marker IDs like "page_42",
+// "p42" or "ch01-page-xii" when a marker has no label of its own
+var pageIDRe = regexp.MustCompile(`(?i)p(?:a?ge?)?[-_]?([0-9]+|[ivxlcdm]+)$`)
+
+// PageBreaks returns the print page markers in a chapter page in document
+// order. Markers without an ID cannot be linked to and are skipped.
+func PageBreaks(page string) []PageBreak {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+	if err != nil {
+		return nil
+	}
+
+	var breaks []PageBreak
+	doc.Find("[id]").Each(func(_ int, sel *goquery.Selection) {
+		if !isPageBreak(sel.Get(0)) {
+			return
+		}
+		id := sel.AttrOr("id", "")
+		if id == "" {
+			return
+		}
+		breaks = append(breaks, PageBreak{ID: id, Label: pageLabel(sel, id)})
+	})
+	return breaks
+}
+
+// isPageBreak reports whether node is marked as a page break by epub:type or
+// its ARIA role
+func isPageBreak(node *nethtml.Node) bool {
+	for _, attr := range node.Attr {
+		switch {
+		case attr.Key == "epub:type" || attr.Namespace == "epub" && attr.Key == "type":
+			if slices.Contains(strings.Fields(attr.Val), "pagebreak") {
+				return true
+			}
+		case attr.Key == "role":
+			if slices.Contains(strings.Fields(attr.Val), "doc-pagebreak") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pageLabel returns a marker's page number from its title, aria-label or
+// text, falling back to the number at the end of its ID
+func pageLabel(sel *goquery.Selection, id string) string {
+	for _, label := range []string{sel.AttrOr("title", ""), sel.AttrOr("aria-label", ""), sel.Text()} {
+		if label = strings.TrimSpace(label); label != "" {
+			return label
+		}
+	}
+	if m := pageIDRe.FindStringSubmatch(id); m != nil {
+		return m[1]
+	}
+	return id
+}
@@ -0,0 +1,37 @@
+package html
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPageBreaks_ParsedChapter(t *testing.T) {
+	body := `<p>End of page one.<span epub:type="pagebreak" id="page_2" title="2"></span> Start of page two.</p>` +
+		`<div role="doc-pagebreak" id="p3" aria-label="3"></div>` +
+		`<span epub:type="pagebreak" id="page_iv"></span>` +
+		`<span epub:type="pagebreak" title="5"></span>` +
+		`<span id="page_6">Not a marker</span>`
+
+	parser := NewParser("https://learning.oreilly.com", ParserOptions{FlattenAnchors: true})
+	pageHTML := parseTestChapter(t, parser, body)
+	if !strings.Contains(pageHTML, `epub:type="pagebreak"`) {
+		t.Fatalf("Expected page-break markers in the chapter output, got:\n%s", pageHTML)
+	}
+
+	want := []PageBreak{
+		{ID: "ch01-page_2", Label: "2"},
+		{ID: "ch01-p3", Label: "3"},
+		{ID: "ch01-page_iv", Label: "iv"},
+	}
+	if got := PageBreaks(pageHTML); !slices.Equal(got, want) {
+		t.Errorf("Expected page breaks %v, got %v", want, got)
+	}
+}
+
+func TestPageBreaks_None(t *testing.T) {
+	parser := NewParser("https://learning.oreilly.com", ParserOptions{})
+	if got := PageBreaks(parseTestChapter(t, parser, `<p id="intro">No print pages.</p>`)); len(got) != 0 {
+		t.Errorf("Expected no page breaks, got %v", got)
+	}
+}
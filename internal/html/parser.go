@@ -2,8 +2,10 @@ package html
 
 import (
 	"bytes"
+	"cmp"
 	"fmt"
 	"html"
+	"path"
 	"strings"
 
 	"github.com/PuerkitoBio/goquery"
@@ -14,40 +16,114 @@ import (
 
 const (
 	baseHTMLTemplate = `<!DOCTYPE html>
-<html lang="en" xml:lang="en" xmlns="http://www.w3.org/1999/xhtml" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://www.w3.org/2002/06/xhtml2/ http://www.w3.org/MarkUp/SCHEMA/xhtml2.xsd" xmlns:epub="http://www.idpf.org/2007/ops">
+<html lang="%s" xml:lang="%s" xmlns="http://www.w3.org/1999/xhtml" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://www.w3.org/2002/06/xhtml2/ http://www.w3.org/MarkUp/SCHEMA/xhtml2.xsd" xmlns:epub="http://www.idpf.org/2007/ops">
 <head>
-%s
+%s%s
 <style type="text/css">%s</style></head>
-<body>%s</body>
+<body dir="%s">%s</body>
 </html>`
 
+	// epub2HTMLTemplate is baseHTMLTemplate as strict XHTML 1.1, without
+	// EPUB 3 namespaces or the HTML5 lang attribute
+	epub2HTMLTemplate = XHTML11Doctype + `
+<html xml:lang="%s" xmlns="http://www.w3.org/1999/xhtml">
+<head>
+%s%s
+<style type="text/css">%s</style></head>
+<body dir="%s">%s</body>
+</html>`
+
+	// XHTML11Doctype is the document type declaration EPUB 2 content documents use
+	XHTML11Doctype = `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.1//EN" "http://www.w3.org/TR/xhtml11/DTD/xhtml11.dtd">`
+
 	baseStyleCSS = `body{margin:1em;background-color:transparent!important;}#sbo-rt-content *{text-indent:0pt!important;}#sbo-rt-content .bq{margin-right:1em!important;}`
 
 	kindleCSS = `#sbo-rt-content *{word-wrap:break-word!important;word-break:break-word!important;}#sbo-rt-content table,#sbo-rt-content pre{overflow-x:unset!important;overflow:unset!important;overflow-y:unset!important;white-space:pre-wrap!important;}`
 )
 
+// ParserOptions configures a Parser
+type ParserOptions struct {
+	KindleMode        bool
+	Language          string  // book-level language tag, "en" when empty
+	DetectLanguage    bool    // tag chapters whose detected language differs from Language
+	LanguageThreshold float64 // minimum detection confidence before overriding Language
+	Direction         string  // "ltr" or "rtl" for every chapter, empty to follow the chapter language
+	StripComments     bool    // drop HTML comments from chapter output
+	DropImages        bool    // replace images with their alt text for text-only output
+	EPUB2             bool    // emit strict XHTML 1.1 without EPUB 3 markup
+	FlattenAnchors    bool    // prefix element IDs per chapter so they are unique book-wide
+	NormalizeHeadings bool    // renumber headings to start at <h1> without skipped levels
+	TitleFromHeading  bool    // add a <title>, from the first <h1> or <h2> when the title is generic
+	// FetchCSS, when set, returns a stylesheet's content so it is inlined
+	// into a <style> block instead of linked
+	FetchCSS func(url string) (string, error)
+	// Transforms run on every chapter after the built-in passes, see Transform
+	Transforms []Transform
+	// MergeCSS, when set, is given every stylesheet URL in reference order
+	// and chapters link the single MergedCSSHref sheet instead
+	MergeCSS func(url string)
+	// MergedFiles maps the .xhtml files of chapters merged into a parent, see
+	// MergeSections, to the parent's file so links into them still resolve
+	MergedFiles map[string]string
+}
+
+// MergedCSSHref is the combined stylesheet chapters link when
+// ParserOptions.MergeCSS is set
+const MergedCSSHref = "Styles/style.css"
+
 // Parser handles HTML parsing and transformation
 type Parser struct {
-	bookURL       string
-	kindleMode    bool
-	baseHTMLStyle string
-	cssIndex      map[string]int
-	cssList       []string
+	bookURL           string
+	kindleMode        bool
+	language          string
+	detectLanguage    bool
+	languageThreshold float64
+	direction         string
+	stripComments     bool
+	dropImages        bool
+	epub2             bool
+	flattenAnchors    bool
+	normalizeHeadings bool
+	titleFromHeading  bool
+	fetchCSS          func(url string) (string, error)
+	transforms        []Transform
+	mergeCSS          func(url string)
+	mergedFiles       map[string]string
+	baseHTMLStyle     string
+	cssIndex          map[string]int
+	cssList           []string
 }
 
 // NewParser creates a new HTML parser
-func NewParser(bookURL string, kindleMode bool) *Parser {
+func NewParser(bookURL string, opts ParserOptions) *Parser {
 	baseStyle := baseStyleCSS
-	if !kindleMode {
+	if !opts.KindleMode {
 		baseStyle += kindleCSS
 	}
+	if !IsLanguageTag(opts.Language) {
+		opts.Language = "en"
+	}
 
 	return &Parser{
-		bookURL:       bookURL,
-		kindleMode:    kindleMode,
-		baseHTMLStyle: baseStyle,
-		cssIndex:      make(map[string]int),
-		cssList:       []string{},
+		bookURL:           bookURL,
+		kindleMode:        opts.KindleMode,
+		language:          opts.Language,
+		detectLanguage:    opts.DetectLanguage,
+		languageThreshold: opts.LanguageThreshold,
+		direction:         opts.Direction,
+		stripComments:     opts.StripComments,
+		dropImages:        opts.DropImages,
+		epub2:             opts.EPUB2,
+		flattenAnchors:    opts.FlattenAnchors,
+		normalizeHeadings: opts.NormalizeHeadings,
+		titleFromHeading:  opts.TitleFromHeading,
+		fetchCSS:          opts.FetchCSS,
+		transforms:        append([]Transform(nil), opts.Transforms...),
+		mergeCSS:          opts.MergeCSS,
+		mergedFiles:       opts.MergedFiles,
+		baseHTMLStyle:     baseStyle,
+		cssIndex:          make(map[string]int),
+		cssList:           []string{},
 	}
 }
 
@@ -60,34 +136,40 @@ func (p *Parser) ParseChapter(chapter models.Chapter, isFirst bool) (string, str
 
 	var pageCSS strings.Builder
 	pageCSS.Grow(256)
+	seenCSS := make(map[string]bool)
+	addCSS := func(abs string) {
+		if seenCSS[abs] {
+			return
+		}
+		seenCSS[abs] = true
+		if p.mergeCSS == nil {
+			pageCSS.WriteString(p.stylesheetTag(abs))
+			return
+		}
+		if len(seenCSS) == 1 {
+			pageCSS.WriteString(`<link href="` + MergedCSSHref + `" rel="stylesheet" type="text/css" />` + "\n")
+		}
+		p.mergeCSS(abs)
+	}
 
 	// Process stylesheets
-	if len(chapter.Stylesheets) > 0 || len(chapter.SiteStyles) > 0 {
-		for _, sheet := range chapter.Stylesheets {
-			if sheet.URL == "" {
-				continue
-			}
-			abs := utils.ResolveURL(chapter.AssetBaseURL, sheet.URL)
-			idx := p.ensureCSS(abs)
-			pageCSS.WriteString(fmt.Sprintf(`<link href="Styles/Style%02d.css" rel="stylesheet" type="text/css" />`+"\n", idx))
+	for _, sheet := range chapter.Stylesheets {
+		if sheet.URL == "" {
+			continue
 		}
-
-		for _, sheet := range chapter.SiteStyles {
-			if sheet == "" {
-				continue
-			}
-			abs := utils.ResolveURL(chapter.AssetBaseURL, sheet)
-			idx := p.ensureCSS(abs)
-			pageCSS.WriteString(fmt.Sprintf(`<link href="Styles/Style%02d.css" rel="stylesheet" type="text/css" />`+"\n", idx))
+		addCSS(utils.ResolveURL(chapter.AssetBaseURL, sheet.URL))
+	}
+	for _, sheet := range chapter.SiteStyles {
+		if sheet == "" {
+			continue
 		}
+		addCSS(utils.ResolveURL(chapter.AssetBaseURL, sheet))
 	}
 
 	// Process link tags in the document
 	doc.Find("link[rel='stylesheet']").Each(func(_ int, sel *goquery.Selection) {
 		if href, ok := sel.Attr("href"); ok {
-			abs := utils.ResolveURL(p.bookURL, href)
-			idx := p.ensureCSS(abs)
-			pageCSS.WriteString(fmt.Sprintf(`<link href="Styles/Style%02d.css" rel="stylesheet" type="text/css" />`+"\n", idx))
+			addCSS(utils.ResolveURL(p.bookURL, href))
 			sel.Remove()
 		}
 	})
@@ -111,7 +193,7 @@ func (p *Parser) ParseChapter(chapter models.Chapter, isFirst bool) (string, str
 		if templateIdx >= 0 {
 			// attribute already removed in branch above
 		}
-		if css, err := nodeToString(node); err == nil {
+		if css, err := nodeToXHTML(node, false); err == nil {
 			pageCSS.WriteString(css)
 			pageCSS.WriteString("\n")
 		}
@@ -123,13 +205,7 @@ func (p *Parser) ParseChapter(chapter models.Chapter, isFirst bool) (string, str
 		if node == nil || node.Parent == nil || node.Parent.Parent == nil {
 			return
 		}
-		var src string
-		for _, attr := range node.Attr {
-			if strings.Contains(strings.ToLower(attr.Key), "href") {
-				src = attr.Val
-				break
-			}
-		}
+		src := svgImageHref(node)
 		if src == "" {
 			return
 		}
@@ -152,21 +228,152 @@ func (p *Parser) ParseChapter(chapter models.Chapter, isFirst bool) (string, str
 		return "", "", fmt.Errorf("parser: book content missing for %s", chapter.Title)
 	}
 
+	if p.epub2 {
+		removeEPUB3Attrs(bookContent)
+	} else {
+		markFootnotes(bookContent)
+	}
+	if p.dropImages {
+		dropImages(bookContent)
+	}
+	if p.normalizeHeadings {
+		normalizeHeadings(bookContent)
+	}
+	var titleTag string
+	if p.titleFromHeading {
+		title := chapter.Title
+		if IsGenericTitle(title, chapter.Filename) {
+			title = cmp.Or(headingTitle(bookContent), title)
+		}
+		if title = strings.TrimSpace(title); title != "" {
+			titleTag = "<title>" + html.EscapeString(title) + "</title>\n"
+		}
+	}
+
 	contentNode := bookContent.Get(0)
 	rewriteLinks(contentNode, p.linkReplace)
+	if p.flattenAnchors {
+		flattenAnchors(contentNode, chapter.Filename)
+	}
+	for _, transform := range p.transforms {
+		transform(doc)
+	}
 
 	// Convert to XHTML
-	xhtml, err := nodeToXHTML(contentNode)
+	xhtml, err := nodeToXHTML(contentNode, p.stripComments)
 	if err != nil {
 		return "", "", fmt.Errorf("parser: unable to serialize chapter %s: %w", chapter.Title, err)
 	}
 
 	// Generate the final HTML
-	pageHTML := fmt.Sprintf(baseHTMLTemplate, pageCSS.String(), p.baseHTMLStyle, xhtml)
+	lang := p.chapterLanguage(bookContent.Text())
+	var pageHTML string
+	if p.epub2 {
+		pageHTML = fmt.Sprintf(epub2HTMLTemplate, lang, titleTag, pageCSS.String(), p.baseHTMLStyle, p.chapterDirection(lang), xhtml)
+	} else {
+		pageHTML = fmt.Sprintf(baseHTMLTemplate, lang, lang, titleTag, pageCSS.String(), p.baseHTMLStyle, p.chapterDirection(lang), xhtml)
+	}
 
 	return pageCSS.String(), pageHTML, nil
 }
 
+// markFootnotes adds EPUB 3 footnote semantics to O'Reilly's data-type markup
+// so readers can show notes as popups
+func markFootnotes(content *goquery.Selection) {
+	content.Find("a[data-type='noteref']").Each(func(_ int, sel *goquery.Selection) {
+		setAttrIfMissing(sel, "epub:type", "noteref")
+		setAttrIfMissing(sel, "role", "doc-noteref")
+	})
+
+	content.Find("[data-type='footnote']").Each(func(_ int, sel *goquery.Selection) {
+		setAttrIfMissing(sel, "epub:type", "footnote")
+		setAttrIfMissing(sel, "role", "doc-footnote")
+	})
+
+	content.Find("[data-type='footnotes']").Each(func(_ int, sel *goquery.Selection) {
+		setAttrIfMissing(sel, "epub:type", "footnotes")
+	})
+}
+
+// removeEPUB3Attrs strips epub:* attributes, such as epub:type, that EPUB 2
+// readers and validators reject
+func removeEPUB3Attrs(content *goquery.Selection) {
+	for _, node := range content.Find("*").AddSelection(content).Nodes {
+		attrs := node.Attr[:0]
+		for _, attr := range node.Attr {
+			if attr.Namespace == "epub" || strings.HasPrefix(attr.Key, "epub:") {
+				continue
+			}
+			attrs = append(attrs, attr)
+		}
+		node.Attr = attrs
+	}
+}
+
+// dropImages replaces each image with its alt text, or removes it when it has none
+func dropImages(content *goquery.Selection) {
+	content.Find("picture source").Remove()
+	content.Find("img").Each(func(_ int, sel *goquery.Selection) {
+		if alt := strings.TrimSpace(sel.AttrOr("alt", "")); alt != "" {
+			sel.ReplaceWithHtml(html.EscapeString(alt))
+			return
+		}
+		sel.Remove()
+	})
+}
+
+// setAttrIfMissing sets an attribute unless the element already has one
+func setAttrIfMissing(sel *goquery.Selection, name, value string) {
+	if _, ok := sel.Attr(name); !ok {
+		sel.SetAttr(name, value)
+	}
+}
+
+// chapterLanguage returns the detected chapter language when detection is
+// enabled, confident enough, and differs from the book language
+func (p *Parser) chapterLanguage(text string) string {
+	if !p.detectLanguage {
+		return p.language
+	}
+	lang, confidence := DetectLanguage(text)
+	if lang == "" || confidence < p.languageThreshold || lang == primaryLanguage(p.language) {
+		return p.language
+	}
+	return lang
+}
+
+// chapterDirection returns the configured text direction, or the natural
+// direction of the chapter language
+func (p *Parser) chapterDirection(lang string) string {
+	if p.direction != "" {
+		return p.direction
+	}
+	if IsRTLLanguage(lang) {
+		return "rtl"
+	}
+	return "ltr"
+}
+
+// stylesheetTag links the stylesheet at url, or inlines its content when
+// FetchCSS is set. A stylesheet that can't be fetched stays linked.
+func (p *Parser) stylesheetTag(url string) string {
+	if p.fetchCSS != nil {
+		if css, err := p.fetchCSS(url); err == nil {
+			return `<style type="text/css">` + styleText(css) + "</style>\n"
+		}
+	}
+	return fmt.Sprintf(`<link href="Styles/Style%02d.css" rel="stylesheet" type="text/css" />`+"\n", p.ensureCSS(url))
+}
+
+// styleText protects CSS containing markup characters for XHTML parsers
+// while keeping it readable as raw text by HTML parsers
+func styleText(css string) string {
+	if !strings.ContainsAny(css, "<&") {
+		return css
+	}
+	return "/*<![CDATA[*/\n" + strings.ReplaceAll(css, "]]>", "]]]]><![CDATA[>") + "\n/*]]>*/"
+}
+
 // ensureCSS adds a CSS URL to the list if not already present
 func (p *Parser) ensureCSS(url string) int {
 	if url == "" {
@@ -200,12 +407,29 @@ func (p *Parser) linkReplace(link string) string {
 			}
 			return "Images/" + name
 		}
-		return strings.ReplaceAll(link, ".html", ".xhtml")
+		return p.mergedLink(strings.ReplaceAll(link, ".html", ".xhtml"))
 	}
 
 	return link
 }
 
+// mergedLink points a link into a chapter merged into its parent at the
+// parent's file: at the same fragment, or at the merged section without one
+func (p *Parser) mergedLink(link string) string {
+	file, fragment, _ := strings.Cut(link, "#")
+	if file == "" {
+		return link
+	}
+	parent, ok := p.mergedFiles[path.Base(file)]
+	if !ok {
+		return link
+	}
+	if fragment == "" {
+		fragment = SectionID(file)
+	}
+	return parent + "#" + fragment
+}
+
 // rewriteLinks rewrites all links in a node
 func rewriteLinks(node *nethtml.Node, repl func(string) string) {
 	if node.Type == nethtml.ElementNode {
@@ -243,30 +467,29 @@ func rewriteSrcset(value string, repl func(string) string) string {
 	return strings.Join(parts, ", ")
 }
 
-// nodeToString converts a node to a string
-func nodeToString(n *nethtml.Node) (string, error) {
+// nodeToXHTML converts a node to XHTML, leaving out comments when stripComments is set
+func nodeToXHTML(node *nethtml.Node, stripComments bool) (string, error) {
 	var buf bytes.Buffer
-	if err := nethtml.Render(&buf, n); err != nil {
+	if err := renderXHTML(&buf, node, stripComments); err != nil {
 		return "", err
 	}
 	return buf.String(), nil
 }
 
-// nodeToXHTML converts a node to XHTML
-func nodeToXHTML(node *nethtml.Node) (string, error) {
-	var buf bytes.Buffer
-	if err := renderXHTML(&buf, node); err != nil {
-		return "", err
-	}
-	return buf.String(), nil
+// voidElements are the HTML elements that never have content. Only these are
+// self-closed; an empty <div/> or <p/> confuses HTML-parsing readers.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"param": true, "source": true, "track": true, "wbr": true,
 }
 
 // renderXHTML renders a node as XHTML
-func renderXHTML(buf *bytes.Buffer, node *nethtml.Node) error {
+func renderXHTML(buf *bytes.Buffer, node *nethtml.Node, stripComments bool) error {
 	switch node.Type {
 	case nethtml.DocumentNode:
 		for child := node.FirstChild; child != nil; child = child.NextSibling {
-			if err := renderXHTML(buf, child); err != nil {
+			if err := renderXHTML(buf, child, stripComments); err != nil {
 				return err
 			}
 		}
@@ -281,13 +504,13 @@ func renderXHTML(buf *bytes.Buffer, node *nethtml.Node) error {
 			buf.WriteString(html.EscapeString(attr.Val))
 			buf.WriteByte('"')
 		}
-		if node.FirstChild == nil {
+		if voidElements[node.Data] {
 			buf.WriteString("/>")
 			return nil
 		}
 		buf.WriteByte('>')
 		for child := node.FirstChild; child != nil; child = child.NextSibling {
-			if err := renderXHTML(buf, child); err != nil {
+			if err := renderXHTML(buf, child, stripComments); err != nil {
 				return err
 			}
 		}
@@ -295,8 +518,16 @@ func renderXHTML(buf *bytes.Buffer, node *nethtml.Node) error {
 		buf.WriteString(node.Data)
 		buf.WriteByte('>')
 	case nethtml.TextNode:
+		if node.Parent != nil && node.Parent.Type == nethtml.ElementNode && node.Parent.Data == "style" {
+			// HTML parsers read style content raw, so it can't be escaped
+			buf.WriteString(styleText(node.Data))
+			return nil
+		}
 		buf.WriteString(html.EscapeString(node.Data))
 	case nethtml.CommentNode:
+		if stripComments {
+			return nil
+		}
 		buf.WriteString("<!--")
 		buf.WriteString(node.Data)
 		buf.WriteString("-->")
@@ -0,0 +1,274 @@
+package html
+
+import (
+	"encoding/xml"
+	"errors"
+	"html"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/dacsang97/safaribooks/internal/models"
+)
+
+// parseTestChapter wraps body in the O'Reilly content div and parses it
+func parseTestChapter(t *testing.T, parser *Parser, body string) string {
+	t.Helper()
+
+	chapter := models.Chapter{
+		Title:    "Chapter 1",
+		Filename: "ch01.html",
+		Content:  `<html><head></head><body><div id="sbo-rt-content">` + body + `</div></body></html>`,
+	}
+	_, pageHTML, err := parser.ParseChapter(chapter, false)
+	if err != nil {
+		t.Fatalf("ParseChapter failed: %v", err)
+	}
+	return pageHTML
+}
+
+func TestParseChapter_Footnotes(t *testing.T) {
+	parser := NewParser("https://learning.oreilly.com", ParserOptions{})
+	pageHTML := parseTestChapter(t, parser, `<p>Text<sup><a data-type="noteref" id="idm1-marker" href="ch01.html#idm1">1</a></sup></p>`+
+		`<div data-type="footnotes"><p data-type="footnote" id="idm1"><sup><a href="ch01.html#idm1-marker">1</a></sup> Note body.</p></div>`)
+
+	wants := []string{
+		`<a data-type="noteref" id="idm1-marker" href="ch01.xhtml#idm1" epub:type="noteref" role="doc-noteref">`,
+		`<div data-type="footnotes" epub:type="footnotes">`,
+		`<p data-type="footnote" id="idm1" epub:type="footnote" role="doc-footnote">`,
+		`<a href="ch01.xhtml#idm1-marker">`,
+	}
+	for _, want := range wants {
+		if !strings.Contains(pageHTML, want) {
+			t.Errorf("Expected output to contain %s, got:\n%s", want, pageHTML)
+		}
+	}
+}
+
+func TestParseChapter_FootnoteKeepsExistingSemantics(t *testing.T) {
+	parser := NewParser("https://learning.oreilly.com", ParserOptions{})
+	pageHTML := parseTestChapter(t, parser, `<aside data-type="footnote" epub:type="endnote" id="n1">Note</aside>`)
+
+	if !strings.Contains(pageHTML, `epub:type="endnote"`) {
+		t.Errorf("Expected existing epub:type to be kept, got:\n%s", pageHTML)
+	}
+	if strings.Contains(pageHTML, `epub:type="footnote"`) {
+		t.Errorf("Expected no duplicate epub:type, got:\n%s", pageHTML)
+	}
+}
+
+func TestParseChapter_StripComments(t *testing.T) {
+	body := `<p>Kept<!-- build: 2023-01-01 --></p><!--[if IE]><p>Old</p><![endif]-->`
+
+	stripped := parseTestChapter(t, NewParser("https://learning.oreilly.com", ParserOptions{StripComments: true}), body)
+	if strings.Contains(stripped, "<!--") {
+		t.Errorf("Expected comments to be removed, got:\n%s", stripped)
+	}
+	if !strings.Contains(stripped, "<p>Kept</p>") {
+		t.Errorf("Expected content to remain, got:\n%s", stripped)
+	}
+
+	kept := parseTestChapter(t, NewParser("https://learning.oreilly.com", ParserOptions{}), body)
+	if !strings.Contains(kept, "<!-- build: 2023-01-01 -->") {
+		t.Errorf("Expected comments kept by default, got:\n%s", kept)
+	}
+}
+
+func TestParseChapter_DropImages(t *testing.T) {
+	parser := NewParser("https://learning.oreilly.com", ParserOptions{DropImages: true})
+	pageHTML := parseTestChapter(t, parser, `<p>See <img src="Images/a.png" alt="a &lt;diagram&gt;"/> and <img src="Images/b.png"/></p>`)
+
+	if strings.Contains(pageHTML, "<img") {
+		t.Errorf("Expected images removed, got:\n%s", pageHTML)
+	}
+	if !strings.Contains(pageHTML, "a &lt;diagram&gt;") {
+		t.Errorf("Expected escaped alt text in place of the image, got:\n%s", pageHTML)
+	}
+}
+
+func TestParseChapter_NormalizeHeadings(t *testing.T) {
+	body := `<section><h3 id="intro">Intro</h3><p>Text</p><h5 class="x">Detail <em>one</em></h5><h4>Part</h4><h3>Next</h3></section>`
+
+	pageHTML := parseTestChapter(t, NewParser("https://learning.oreilly.com", ParserOptions{NormalizeHeadings: true}), body)
+	wants := []string{
+		`<h1 id="intro">Intro</h1>`,
+		`<h3 class="x">Detail <em>one</em></h3>`,
+		`<h2>Part</h2>`,
+		`<h1>Next</h1>`,
+	}
+	for _, want := range wants {
+		if !strings.Contains(pageHTML, want) {
+			t.Errorf("Expected %s in output, got:\n%s", want, pageHTML)
+		}
+	}
+
+	pageHTML = parseTestChapter(t, NewParser("https://learning.oreilly.com", ParserOptions{}), body)
+	if !strings.Contains(pageHTML, `<h3 id="intro">Intro</h3>`) {
+		t.Errorf("Expected headings untouched by default, got:\n%s", pageHTML)
+	}
+}
+
+func TestParseChapter_TitleFromHeading(t *testing.T) {
+	parser := NewParser("https://learning.oreilly.com", ParserOptions{TitleFromHeading: true})
+	body := `<section><p class="label">Part I</p><h2>Getting  <em>Started</em></h2><h1>Later</h1></section>`
+
+	for _, title := range []string{"", "Untitled", "ch01.html"} {
+		chapter := models.Chapter{
+			Title:    title,
+			Filename: "ch01.html",
+			Content:  `<html><head></head><body><div id="sbo-rt-content">` + body + `</div></body></html>`,
+		}
+		_, pageHTML, err := parser.ParseChapter(chapter, false)
+		if err != nil {
+			t.Fatalf("ParseChapter failed: %v", err)
+		}
+		if !strings.Contains(pageHTML, "<title>Getting Started</title>") {
+			t.Errorf("Expected title %q to give way to the first heading, got:\n%s", title, pageHTML)
+		}
+	}
+
+	chapter := models.Chapter{
+		Title:    "1. Introduction & Setup",
+		Filename: "ch01.html",
+		Content:  `<div id="sbo-rt-content">` + body + `</div>`,
+	}
+	_, pageHTML, err := parser.ParseChapter(chapter, false)
+	if err != nil {
+		t.Fatalf("ParseChapter failed: %v", err)
+	}
+	if !strings.Contains(pageHTML, "<title>1. Introduction &amp; Setup</title>") {
+		t.Errorf("Expected the API title kept, got:\n%s", pageHTML)
+	}
+
+	if pageHTML := parseTestChapter(t, NewParser("https://learning.oreilly.com", ParserOptions{}), body); strings.Contains(pageHTML, "<title>") {
+		t.Errorf("Expected no <title> by default, got:\n%s", pageHTML)
+	}
+}
+
+func TestRenderXHTML_VoidElements(t *testing.T) {
+	parser := NewParser("https://learning.oreilly.com", ParserOptions{})
+	pageHTML := parseTestChapter(t, parser, `<div class="empty"></div><p>Line one<br>line two</p><p></p><hr>`)
+
+	wants := []string{
+		`<div class="empty"></div>`,
+		`Line one<br/>line two`,
+		`<p></p>`,
+		`<hr/>`,
+	}
+	for _, want := range wants {
+		if !strings.Contains(pageHTML, want) {
+			t.Errorf("Expected %s in output, got:\n%s", want, pageHTML)
+		}
+	}
+	if strings.Contains(pageHTML, `<div class="empty"/>`) || strings.Contains(pageHTML, "<p/>") {
+		t.Errorf("Expected empty non-void elements not to be self-closed, got:\n%s", pageHTML)
+	}
+}
+
+func TestParseChapter_EPUB2(t *testing.T) {
+	parser := NewParser("https://learning.oreilly.com", ParserOptions{EPUB2: true})
+	pageHTML := parseTestChapter(t, parser, `<section epub:type="chapter"><p>Text<sup><a data-type="noteref" href="ch01.html#n1">1</a></sup></p>`+
+		`<div data-type="footnotes"><p data-type="footnote" id="n1">Note.</p></div></section>`)
+
+	for _, marker := range []string{"epub:", "<!DOCTYPE html>", ` lang="`, "xmlns:epub"} {
+		if strings.Contains(pageHTML, marker) {
+			t.Errorf("Expected no EPUB 3 marker %q, got:\n%s", marker, pageHTML)
+		}
+	}
+	for _, want := range []string{XHTML11Doctype, `<html xml:lang="en" xmlns="http://www.w3.org/1999/xhtml">`} {
+		if !strings.Contains(pageHTML, want) {
+			t.Errorf("Expected %s, got:\n%s", want, pageHTML)
+		}
+	}
+}
+
+func TestParseChapter_InlineCSS(t *testing.T) {
+	fetched := make(map[string]int)
+	parser := NewParser("https://learning.oreilly.com", ParserOptions{
+		FetchCSS: func(url string) (string, error) {
+			fetched[url]++
+			if strings.Contains(url, "missing") {
+				return "", errors.New("not found")
+			}
+			return "p > a { color: red; } /* a & b */", nil
+		},
+	})
+
+	chapter := models.Chapter{
+		Title:        "Chapter 1",
+		Filename:     "ch01.html",
+		AssetBaseURL: "https://learning.oreilly.com/library/view/book/",
+		Stylesheets:  []models.ChapterStylesheet{{URL: "book.css"}, {URL: "book.css"}},
+		Content:      `<html><head><link rel="stylesheet" href="/library/view/book/book.css"/></head><body><div id="sbo-rt-content"><p>Text</p></div></body></html>`,
+	}
+	_, pageHTML, err := parser.ParseChapter(chapter, false)
+	if err != nil {
+		t.Fatalf("ParseChapter failed: %v", err)
+	}
+
+	if strings.Contains(pageHTML, "<link") {
+		t.Errorf("Expected no <link> when CSS is inlined, got:\n%s", pageHTML)
+	}
+	if strings.Count(pageHTML, "p > a { color: red; }") != 1 {
+		t.Errorf("Expected the stylesheet inlined exactly once, got:\n%s", pageHTML)
+	}
+	if !strings.Contains(pageHTML, "/*<![CDATA[*/") {
+		t.Errorf("Expected CSS containing & to be wrapped in CDATA, got:\n%s", pageHTML)
+	}
+
+	chapter.Stylesheets = []models.ChapterStylesheet{{URL: "missing.css"}}
+	chapter.Content = `<div id="sbo-rt-content"><p>Text</p></div>`
+	_, pageHTML, err = parser.ParseChapter(chapter, false)
+	if err != nil {
+		t.Fatalf("ParseChapter failed: %v", err)
+	}
+	if !strings.Contains(pageHTML, `<link href="Styles/Style`) {
+		t.Errorf("Expected an unfetchable stylesheet to stay linked, got:\n%s", pageHTML)
+	}
+}
+
+func TestParseChapter_StyleTemplateSurvivesXHTML(t *testing.T) {
+	css := `a:hover::before{content:"<"} p.a&b{color:red}`
+	parser := NewParser("https://learning.oreilly.com", ParserOptions{})
+	pageHTML := parseTestChapter(t, parser, `<style data-template="`+html.EscapeString(css)+`"></style><p>Text</p>`)
+
+	if strings.Contains(pageHTML, "&amp;lt;") || strings.Contains(pageHTML, "&lt;") {
+		t.Errorf("Expected style content not to be escaped, got:\n%s", pageHTML)
+	}
+
+	decoder := xml.NewDecoder(strings.NewReader(pageHTML))
+	var styles []string
+	inStyle := false
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Expected well-formed XHTML, got %v:\n%s", err, pageHTML)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			inStyle = tok.Name.Local == "style"
+			if inStyle {
+				styles = append(styles, "")
+			}
+		case xml.EndElement:
+			inStyle = false
+		case xml.CharData:
+			if inStyle {
+				styles[len(styles)-1] += string(tok)
+			}
+		}
+	}
+
+	found := false
+	for _, style := range styles {
+		if strings.Contains(style, css) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected the template CSS to round-trip intact, got styles %q", styles)
+	}
+}
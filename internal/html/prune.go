@@ -0,0 +1,128 @@
+package html
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	nethtml "golang.org/x/net/html"
+)
+
+var cssCommentRe = regexp.MustCompile(`(?s)/\*.*?\*/`)
+
+// PruneCSS drops the style rules of css whose selectors match no element in
+// any of pages, returning the pruned stylesheet and the number of rules
+// dropped. It is conservative: at-rules such as @media and @font-face are
+// kept whole, as are rules with a pseudo-class or pseudo-element and rules
+// whose selectors cannot be parsed.
+func PruneCSS(css string, pages []*nethtml.Node) (string, int) {
+	var b strings.Builder
+	dropped := 0
+	for i := 0; i < len(css); {
+		start := skipCSSSpace(css, i)
+		b.WriteString(css[i:start])
+		if start == len(css) {
+			break
+		}
+		end, open := nextCSSRule(css, start)
+		if open < 0 || css[start] == '@' || selectorsUsed(css[start:open], pages) {
+			b.WriteString(css[start:end])
+		} else {
+			dropped++
+		}
+		i = end
+	}
+	return b.String(), dropped
+}
+
+// selectorsUsed reports whether a rule's selector list matches an element in
+// pages, or cannot be judged safely
+func selectorsUsed(selectors string, pages []*nethtml.Node) bool {
+	selectors = strings.TrimSpace(cssCommentRe.ReplaceAllString(selectors, ""))
+	if selectors == "" || strings.Contains(selectors, ":") {
+		return true
+	}
+	sel, err := cascadia.Compile(selectors)
+	if err != nil {
+		return true
+	}
+	for _, page := range pages {
+		if sel.MatchFirst(page) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// skipCSSSpace returns the offset of the first byte at or after i that is
+// neither whitespace nor inside a comment
+func skipCSSSpace(css string, i int) int {
+	for i < len(css) {
+		switch {
+		case strings.HasPrefix(css[i:], "/*"):
+			end := strings.Index(css[i+2:], "*/")
+			if end < 0 {
+				return len(css)
+			}
+			i += end + 4
+		case strings.ContainsRune(" \t\r\n\f", rune(css[i])):
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// nextCSSRule returns the end of the rule starting at start, just past its
+// block's closing brace or its semicolon, and the offset of the block's
+// opening brace, -1 for a statement like @import. Strings and comments are
+// skipped, so braces inside them don't count.
+func nextCSSRule(css string, start int) (end, open int) {
+	open = -1
+	depth := 0
+	for i := start; i < len(css); i++ {
+		switch css[i] {
+		case '"', '\'':
+			i = skipCSSString(css, i)
+		case '/':
+			if strings.HasPrefix(css[i:], "/*") {
+				end := strings.Index(css[i+2:], "*/")
+				if end < 0 {
+					return len(css), open
+				}
+				i += end + 3
+			}
+		case '{':
+			if open < 0 {
+				open = i
+			}
+			depth++
+		case '}':
+			depth--
+			if depth <= 0 {
+				return i + 1, open
+			}
+		case ';':
+			if depth == 0 {
+				return i + 1, open
+			}
+		}
+	}
+	return len(css), open
+}
+
+// skipCSSString returns the offset of the quote closing the string that
+// opens at i, or the last offset when it is never closed
+func skipCSSString(css string, i int) int {
+	quote := css[i]
+	for i++; i < len(css); i++ {
+		switch css[i] {
+		case '\\':
+			i++
+		case quote:
+			return i
+		}
+	}
+	return len(css) - 1
+}
@@ -0,0 +1,41 @@
+package html
+
+import (
+	"strings"
+	"testing"
+
+	nethtml "golang.org/x/net/html"
+)
+
+func TestPruneCSS(t *testing.T) {
+	page, err := nethtml.Parse(strings.NewReader(`<html><body><div class="note"><p id="first">Text</p><pre>code</pre></div></body></html>`))
+	if err != nil {
+		t.Fatalf("Failed to parse page: %v", err)
+	}
+	css := `/* book.css */
+body { margin: 0 }
+.note p, .sidebar { color: gray }
+.sidebar { float: right }
+#missing > p { display: none }
+a:hover { color: red }
+p::first-line { font-weight: bold }
+@font-face { font-family: "Body"; src: url("body.otf") }
+@media print { .sidebar { display: none } }
+.content:after { content: "}" }
+table td { padding: 0 }
+`
+	pruned, dropped := PruneCSS(css, []*nethtml.Node{page})
+	if dropped != 3 {
+		t.Errorf("Expected 3 rules dropped, got %d:\n%s", dropped, pruned)
+	}
+	for _, kept := range []string{"/* book.css */", "body { margin: 0 }", ".note p, .sidebar", "a:hover", "p::first-line", "@font-face", "@media print { .sidebar { display: none } }", `.content:after { content: "}" }`} {
+		if !strings.Contains(pruned, kept) {
+			t.Errorf("Expected %q kept, got:\n%s", kept, pruned)
+		}
+	}
+	for _, gone := range []string{"float: right", "#missing", "table td"} {
+		if strings.Contains(pruned, gone) {
+			t.Errorf("Expected %q pruned, got:\n%s", gone, pruned)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package html
+
+import (
+	"path"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// genericTitles are chapter titles, lowercased, that say nothing about the chapter
+var genericTitles = map[string]bool{
+	"":         true,
+	"untitled": true,
+	"chapter":  true,
+	"section":  true,
+	"content":  true,
+}
+
+// IsGenericTitle reports whether a chapter title is empty, a placeholder
+// such as "Untitled", or just the chapter's file name
+func IsGenericTitle(title, filename string) bool {
+	title = strings.ToLower(strings.TrimSpace(title))
+	name := strings.ToLower(path.Base(filename))
+	return genericTitles[title] || title == name || title == strings.TrimSuffix(name, path.Ext(name))
+}
+
+// HeadingTitle returns the text of the first <h1> or <h2> in a chapter's
+// book content, or "" when it has none
+func HeadingTitle(content string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return ""
+	}
+	return headingTitle(doc.Find("div#sbo-rt-content"))
+}
+
+// headingTitle returns the whitespace-collapsed text of the first <h1> or
+// <h2> in content
+func headingTitle(content *goquery.Selection) string {
+	return strings.Join(strings.Fields(content.Find("h1, h2").First().Text()), " ")
+}
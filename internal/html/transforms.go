@@ -0,0 +1,74 @@
+package html
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Transform edits a parsed chapter in place. Transforms run in the order they
+// were added, after the built-in passes and before the chapter is serialized,
+// so they see rewritten links and flattened anchors.
+type Transform func(doc *goquery.Document)
+
+// namedTransforms are the built-in transforms selectable by name from the CLI
+var namedTransforms = map[string]Transform{
+	"strip-classes":           stripClasses,
+	"unwrap-spans":            unwrapSpans,
+	"remove-empty-paragraphs": removeEmptyParagraphs,
+}
+
+// NamedTransform returns the built-in transform called name
+func NamedTransform(name string) (Transform, error) {
+	t, ok := namedTransforms[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown transform %q (use %s)", name, strings.Join(TransformNames(), ", "))
+	}
+	return t, nil
+}
+
+// TransformNames lists the built-in transform names in sorted order
+func TransformNames() []string {
+	names := make([]string, 0, len(namedTransforms))
+	for name := range namedTransforms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AddTransform appends t to the transforms run on every chapter
+func (p *Parser) AddTransform(t Transform) {
+	p.transforms = append(p.transforms, t)
+}
+
+// stripClasses removes class attributes from the chapter content so only the
+// reader's own styling applies
+func stripClasses(doc *goquery.Document) {
+	doc.Find("div#sbo-rt-content [class]").RemoveAttr("class")
+}
+
+// unwrapSpans replaces attribute-less spans with their children
+func unwrapSpans(doc *goquery.Document) {
+	doc.Find("div#sbo-rt-content span").Each(func(_ int, sel *goquery.Selection) {
+		if len(sel.Get(0).Attr) > 0 {
+			return
+		}
+		if contents := sel.Contents(); contents.Length() > 0 {
+			contents.Unwrap()
+		} else {
+			sel.Remove()
+		}
+	})
+}
+
+// removeEmptyParagraphs drops paragraphs with no text and no child elements
+func removeEmptyParagraphs(doc *goquery.Document) {
+	doc.Find("div#sbo-rt-content p").Each(func(_ int, sel *goquery.Selection) {
+		if sel.Children().Length() == 0 && strings.TrimSpace(sel.Text()) == "" {
+			sel.Remove()
+		}
+	})
+}
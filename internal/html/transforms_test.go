@@ -0,0 +1,79 @@
+package html
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestAddTransform_RunsInOrderAfterBuiltins(t *testing.T) {
+	parser := NewParser("https://learning.oreilly.com", ParserOptions{})
+
+	var sawHref string
+	parser.AddTransform(func(doc *goquery.Document) {
+		sawHref, _ = doc.Find("a").Attr("href")
+		doc.Find("p.ad").Remove()
+		doc.Find("div#sbo-rt-content").AppendHtml(`<p class="note">Added</p>`)
+	})
+	parser.AddTransform(func(doc *goquery.Document) {
+		doc.Find("p.note").SetText("Added second")
+	})
+
+	pageHTML := parseTestChapter(t, parser, `<p><a href="ch02.html">Next</a></p><p class="ad">Buy now</p>`)
+
+	if sawHref != "ch02.xhtml" {
+		t.Errorf("Expected transform to see rewritten link, got %q", sawHref)
+	}
+	if strings.Contains(pageHTML, "Buy now") {
+		t.Errorf("Expected transform to remove the ad, got:\n%s", pageHTML)
+	}
+	if !strings.Contains(pageHTML, `<p class="note">Added second</p>`) {
+		t.Errorf("Expected both transforms applied in order, got:\n%s", pageHTML)
+	}
+}
+
+func TestParserOptions_Transforms(t *testing.T) {
+	var calls int
+	parser := NewParser("https://learning.oreilly.com", ParserOptions{
+		Transforms: []Transform{func(*goquery.Document) { calls++ }},
+	})
+	parseTestChapter(t, parser, `<p>One</p>`)
+	parseTestChapter(t, parser, `<p>Two</p>`)
+	if calls != 2 {
+		t.Errorf("Expected the transform to run once per chapter, ran %d times", calls)
+	}
+}
+
+func TestNamedTransforms(t *testing.T) {
+	tests := []struct {
+		name, body, want, notWant string
+	}{
+		{"strip-classes", `<p class="x">Hi</p>`, `<p>Hi</p>`, `class="x"`},
+		{"unwrap-spans", `<p><span>Hi</span> <span class="k">there</span><span></span></p>`, `<p>Hi <span class="k">there</span></p>`, ""},
+		{"remove-empty-paragraphs", `<p> </p><p><img src="a.png"/></p><p>Hi</p>`, `<p>Hi</p>`, `<p> </p>`},
+	}
+	for _, tt := range tests {
+		transform, err := NamedTransform(tt.name)
+		if err != nil {
+			t.Fatalf("NamedTransform(%q) failed: %v", tt.name, err)
+		}
+		parser := NewParser("https://learning.oreilly.com", ParserOptions{})
+		parser.AddTransform(transform)
+		pageHTML := parseTestChapter(t, parser, tt.body)
+
+		if !strings.Contains(pageHTML, tt.want) {
+			t.Errorf("%s: expected %s in:\n%s", tt.name, tt.want, pageHTML)
+		}
+		if tt.notWant != "" && strings.Contains(pageHTML, tt.notWant) {
+			t.Errorf("%s: expected no %s in:\n%s", tt.name, tt.notWant, pageHTML)
+		}
+	}
+}
+
+func TestNamedTransform_Unknown(t *testing.T) {
+	_, err := NamedTransform("nope")
+	if err == nil || !strings.Contains(err.Error(), "strip-classes") {
+		t.Errorf("Expected an error listing the known transforms, got %v", err)
+	}
+}
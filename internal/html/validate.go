@@ -0,0 +1,46 @@
+package html
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// XHTMLError describes where a page stops being well-formed XML
+type XHTMLError struct {
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *XHTMLError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %v", e.Line, e.Column, e.Err)
+}
+
+func (e *XHTMLError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateXHTML parses page with a strict XML decoder and returns an
+// *XHTMLError at the first well-formedness problem, such as an unescaped
+// '<' or '&', an unclosed void element, or an HTML-only entity like &nbsp;
+func ValidateXHTML(page string) error {
+	decoder := xml.NewDecoder(strings.NewReader(page))
+	decoder.Strict = true
+	for {
+		_, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			line, column := decoder.InputPos()
+			var syntaxErr *xml.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				err = errors.New(syntaxErr.Msg)
+			}
+			return &XHTMLError{Line: line, Column: column, Err: err}
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package html
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateXHTML_ParsedChapterIsWellFormed(t *testing.T) {
+	parser := NewParser("https://learning.oreilly.com", ParserOptions{})
+	pageHTML := parseTestChapter(t, parser, `<p title="a &quot;b&quot;">Fish &amp; chips&nbsp;<br>x < y<img src="a.png"></p><hr><!-- note -->`)
+
+	if err := ValidateXHTML(pageHTML); err != nil {
+		t.Errorf("Expected parser output to be well-formed, got %v in:\n%s", err, pageHTML)
+	}
+}
+
+func TestValidateXHTML_Malformed(t *testing.T) {
+	cases := []struct {
+		name   string
+		page   string
+		line   int
+		substr string
+	}{
+		{"unclosed void element", "<html>\n<body>\n<p>a<br></p>\n</body>\n</html>", 3, "</p>"},
+		{"html entity", "<html>\n<p>a&nbsp;b</p>\n</html>", 2, "nbsp"},
+		{"bare ampersand", "<html><p>Fish & chips</p></html>", 1, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := ValidateXHTML(c.page)
+			var xhtmlErr *XHTMLError
+			if !errors.As(err, &xhtmlErr) {
+				t.Fatalf("Expected an XHTMLError, got %v", err)
+			}
+			if xhtmlErr.Line != c.line || xhtmlErr.Column == 0 {
+				t.Errorf("Expected an error on line %d with a column, got %v", c.line, err)
+			}
+			if !strings.Contains(err.Error(), c.substr) {
+				t.Errorf("Expected %q in %q", c.substr, err.Error())
+			}
+		})
+	}
+}
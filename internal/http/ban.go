@@ -0,0 +1,95 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// banMarkers identify the "access denied" pages served with a 403 once
+// sustained scraping gets the client's IP blocked, as opposed to the JSON
+// errors of a failed login; they are matched case-insensitively
+var banMarkers = []string{
+	"<title>access denied</title>",        // Akamai edge block page
+	"you don't have permission to access", // Akamai block page text
+	"your ip has been blocked",
+	"you have been blocked",
+	"too many requests from your ip",
+}
+
+// BannedError reports an access-denied ban page, served when the site has
+// blocked the client for sending too many requests. Once one is seen the
+// client stops sending requests and fails them all with the same error.
+type BannedError struct {
+	URL    string
+	Marker string // the ban marker found in the response
+}
+
+func (e *BannedError) Error() string {
+	return fmt.Sprintf("access denied at %s (found %q): the site appears to have blocked this IP for too many requests; stop for a while, then retry with a lower --concurrency or with --jitter", e.URL, e.Marker)
+}
+
+// banGuard remembers the first ban seen by a client
+type banGuard struct {
+	mu  sync.Mutex
+	err *BannedError
+}
+
+// detectBans makes a ban page fail its request with a *BannedError and
+// every request after it fail before it is sent, so a blocked client stops
+// hammering the site. It must be set up before detectChallenges, which
+// would otherwise take Akamai's block page for a challenge.
+func detectBans(client *resty.Client) *banGuard {
+	guard := &banGuard{}
+	client.OnBeforeRequest(func(_ *resty.Client, _ *resty.Request) error {
+		return guard.banned()
+	})
+	client.OnAfterResponse(func(_ *resty.Client, resp *resty.Response) error {
+		return guard.check(resp, resp.Body())
+	})
+	return guard
+}
+
+// banned returns the ban seen so far, if any
+func (g *banGuard) banned() error {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.err == nil {
+		return nil
+	}
+	return g.err
+}
+
+// check returns a *BannedError, and remembers it, when body, read from resp,
+// is a 403 ban page for an API or chapter request. Akamai serves the same page
+// for a single asset it refuses, so asset requests never count as a ban.
+func (g *banGuard) check(resp *resty.Response, body []byte) error {
+	if g == nil || resp == nil || resp.StatusCode() != http.StatusForbidden || len(body) > maxChallengeSize {
+		return nil
+	}
+	if asset, _ := resp.Request.Context().Value(assetRequestKey{}).(bool); asset {
+		return nil
+	}
+	if ct := resp.Header().Get("Content-Type"); ct != "" && !strings.Contains(ct, "html") {
+		return nil
+	}
+	lower := bytes.ToLower(body)
+	for _, marker := range banMarkers {
+		if bytes.Contains(lower, []byte(marker)) {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			if g.err == nil {
+				g.err = &BannedError{URL: resp.Request.URL, Marker: marker}
+			}
+			return g.err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// akamaiBanPage is a trimmed Akamai access-denied page
+const akamaiBanPage = `<HTML><HEAD><TITLE>Access Denied</TITLE></HEAD><BODY><H1>Access Denied</H1>` +
+	`You don't have permission to access "http://learning.oreilly.com/api/v1/book/123/" on this server.<P>` +
+	`Reference #18.5a2c1002.1700000000.1b2c3d4<P>https://errors.edgesuite.net/18.5a2c1002.1700000000.1b2c3d4</P></BODY></HTML>`
+
+func TestBannedStopsRequests(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, akamaiBanPage)
+	}))
+	defer server.Close()
+	c := newTestClient(server)
+	configureRetries(c.client, retryCounts{api: 3, asset: 3}, time.Millisecond, time.Millisecond)
+
+	var banned *BannedError
+	if _, err := c.GetBookInfo("123"); !errors.As(err, &banned) {
+		t.Fatalf("Expected a ban error, got %v", err)
+	}
+	if !strings.Contains(banned.Error(), "--concurrency") {
+		t.Errorf("Expected the error to advise lowering --concurrency, got %q", banned.Error())
+	}
+
+	if _, err := c.Get(server.URL + "/ch01.html"); !errors.As(err, &banned) {
+		t.Errorf("Expected later requests to fail with the ban, got %v", err)
+	}
+	if _, _, _, err := c.GetLimited(server.URL+"/ch02.html", 1<<20); !errors.As(err, &banned) {
+		t.Errorf("Expected limited requests to fail with the ban, got %v", err)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("Expected one request before stopping, got %d", got)
+	}
+}
+
+func TestBannedIgnoresOtherForbidden(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"detail": "Authentication credentials were not provided."}`)
+	}))
+	defer server.Close()
+	c := newTestClient(server)
+
+	for range 2 {
+		resp, err := c.Get(server.URL + "/api/v1/book/123/")
+		if err != nil || resp.StatusCode() != http.StatusForbidden {
+			t.Fatalf("Expected a plain 403 response, got %v, %v", resp, err)
+		}
+	}
+}
+
+func TestBannedIgnoresAssets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			fmt.Fprint(w, `{"title": "Book"}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, akamaiBanPage)
+	}))
+	defer server.Close()
+	c := newTestClient(server)
+
+	var banned *BannedError
+	if _, err := c.Get(server.URL + "/images/fig01.png"); err == nil || errors.As(err, &banned) {
+		t.Fatalf("Expected the refused asset to fail on its own, not as a ban, got %v", err)
+	}
+	if _, err := c.GetBookInfo("123"); err != nil {
+		t.Errorf("Expected API requests to go on after a refused asset, got %v", err)
+	}
+}
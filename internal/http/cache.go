@@ -0,0 +1,67 @@
+package http
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/dacsang97/safaribooks/internal/models"
+)
+
+// BookCache keeps the book info and chapter lists fetched during a run, so
+// a book requested again, e.g. listed twice in a playlist, costs no API
+// calls. Clients created with the same ClientOptions.Cache share it. It is
+// safe for concurrent use; a nil BookCache caches nothing.
+type BookCache struct {
+	mu       sync.Mutex
+	info     map[string]models.BookInfo
+	chapters map[string][]models.Chapter
+}
+
+// NewBookCache returns an empty BookCache
+func NewBookCache() *BookCache {
+	return &BookCache{
+		info:     make(map[string]models.BookInfo),
+		chapters: make(map[string][]models.Chapter),
+	}
+}
+
+// bookInfo returns the cached info of a book
+func (c *BookCache) bookInfo(bookID string) (models.BookInfo, bool) {
+	if c == nil {
+		return models.BookInfo{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	info, ok := c.info[NormalizeBookID(bookID)]
+	return info, ok
+}
+
+func (c *BookCache) storeBookInfo(bookID string, info models.BookInfo) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.info[NormalizeBookID(bookID)] = info
+}
+
+// bookChapters returns a copy of the cached chapter list of a book, since
+// callers fill in and rename chapters as they download them
+func (c *BookCache) bookChapters(bookID string) ([]models.Chapter, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	chapters, ok := c.chapters[NormalizeBookID(bookID)]
+	return slices.Clone(chapters), ok
+}
+
+func (c *BookCache) storeChapters(bookID string, chapters []models.Chapter) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.chapters[NormalizeBookID(bookID)] = slices.Clone(chapters)
+}
@@ -0,0 +1,70 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// maxChallengeSize bounds the bodies searched for challenge markers; bot
+// challenge pages are small, so larger bodies are taken to be real content
+const maxChallengeSize = 256 << 10
+
+// challengeMarkers identify bot-challenge interstitials served in place of
+// the requested page, often with a 200 status
+var challengeMarkers = []string{
+	"/_sec/cp_challenge/",          // Akamai Bot Manager challenge script
+	"sec-if-cpt-container",         // Akamai challenge page container
+	`id="bm-verify"`,               // Akamai behavioral verification form
+	"errors.edgesuite.net",         // Akamai "Access Denied" reference page
+	"/cdn-cgi/challenge-platform/", // Cloudflare managed challenge
+	"_Incapsula_Resource",          // Imperva challenge
+}
+
+// contentMarkers identify chapter pages, which are real content even when
+// they mention a challenge marker, say in a book on bot management
+var contentMarkers = []string{`id="sbo-rt-content"`, `id='sbo-rt-content'`}
+
+// BotChallengeError reports a bot-challenge page returned instead of the
+// requested content, usually because the session cookies went stale or
+// requests came too fast
+type BotChallengeError struct {
+	URL    string
+	Marker string // the challenge marker found in the response
+}
+
+func (e *BotChallengeError) Error() string {
+	return fmt.Sprintf("bot challenge served instead of %s (found %q): refresh your cookies from a browser session, or wait and retry with fewer books at a time", e.URL, e.Marker)
+}
+
+// detectChallenges makes requests fail with a *BotChallengeError when the
+// response is a bot-challenge page, so it is never saved as content
+func detectChallenges(client *resty.Client) {
+	client.OnAfterResponse(func(_ *resty.Client, resp *resty.Response) error {
+		return checkChallenge(resp, resp.Body())
+	})
+}
+
+// checkChallenge returns a *BotChallengeError when body, read from resp, is
+// an HTML bot-challenge page rather than a chapter page
+func checkChallenge(resp *resty.Response, body []byte) error {
+	if resp == nil || len(body) == 0 || len(body) > maxChallengeSize {
+		return nil
+	}
+	if ct := resp.Header().Get("Content-Type"); ct != "" && !strings.Contains(ct, "html") {
+		return nil
+	}
+	for _, marker := range contentMarkers {
+		if bytes.Contains(body, []byte(marker)) {
+			return nil
+		}
+	}
+	for _, marker := range challengeMarkers {
+		if bytes.Contains(body, []byte(marker)) {
+			return &BotChallengeError{URL: resp.Request.URL, Marker: marker}
+		}
+	}
+	return nil
+}
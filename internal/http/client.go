@@ -1,11 +1,18 @@
 package http
 
 import (
+	"cmp"
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"path"
+	"slices"
 	"strings"
 	"time"
 
@@ -18,6 +25,27 @@ import (
 const (
 	defaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 " +
 		"(KHTML, like Gecko) Chrome/90.0.4430.212 Safari/537.36"
+
+	// canonicalHost is the O'Reilly host the API uses in absolute URLs,
+	// even when it is reached through a proxy or library host
+	canonicalHost = "learning.oreilly.com"
+
+	// defaultAcceptLanguage is sent when ClientOptions.AcceptLanguage is empty
+	defaultAcceptLanguage = "en-US,en;q=0.9"
+
+	// requestTimeout bounds a whole request, from connecting to reading the body
+	requestTimeout = 60 * time.Second
+
+	// defaultConnectTimeout bounds establishing a connection when
+	// ClientOptions.ConnectTimeout is zero
+	defaultConnectTimeout = 30 * time.Second
+
+	// jwtCookie holds the session JWT, which some API endpoints only accept
+	// as a Bearer token
+	jwtCookie = "orm-jwt"
+
+	// maxChapterPages caps chapter pagination in case the API never stops returning a next page
+	maxChapterPages = 1000
 )
 
 // Client represents an HTTP client for Safari Books API
@@ -25,10 +53,60 @@ type Client struct {
 	client     *resty.Client
 	siteURL    string
 	profileURL string
+	bans       *banGuard
+	profile    Profile
+	cache      *BookCache
+	// authenticated is set once the profile check has passed, so a
+	// forbidden chapter list can be taken for a withheld title
+	authenticated bool
+}
+
+// ClientOptions configures the connection used by a Client
+type ClientOptions struct {
+	Proxy       *url.URL      // see ProxyURL; nil uses the environment's proxy settings
+	RetryBudget time.Duration // total time allowed for retries and their backoff, unlimited when zero
+	// Retries is the number of retries of a failed API request or chapter
+	// page, 3 when zero and none when negative. AssetRetries is the same for
+	// images and other assets fetched with Get, Retries when zero, or 5 when
+	// both are zero.
+	Retries      int
+	AssetRetries int
+	Jitter       time.Duration // random delay below this before each request, none when zero
+	// ConnectTimeout bounds establishing each connection, so dead hosts fail
+	// fast while slow transfers get the whole request timeout;
+	// defaultConnectTimeout when zero
+	ConnectTimeout time.Duration
+	// AcceptLanguage is the Accept-Language header sent with every request,
+	// defaultAcceptLanguage when empty
+	AcceptLanguage string
+	// Cache is shared with other clients so a book is fetched once per run;
+	// each client gets its own when nil
+	Cache *BookCache
+	// KeepHTTP fetches http:// URLs as given; otherwise those on O'Reilly
+	// hosts are upgraded to https
+	KeepHTTP bool
+	// ForceHTTPS upgrades http:// URLs on every host, not only O'Reilly's
+	ForceHTTPS bool
+	// ObserveStatus, when set, is called with the status of every response,
+	// retries included, e.g. to adapt concurrency to rate limiting
+	ObserveStatus func(code int)
+	// RequiredCookies are the cookies a cookie export must hold, checked
+	// before any request; when empty, one of the known session cookies is
+	// required unless the site is a library proxy
+	RequiredCookies []string
 }
 
 // NewClient creates a new HTTP client with authentication
-func NewClient(cookiesPath, siteURL string) (*Client, error) {
+func NewClient(cookiesPath, siteURL string, opts ClientOptions) (*Client, error) {
+	cookies, err := utils.LoadCookies(cookiesPath)
+	if err != nil {
+		return nil, utils.WrapError(err, "load cookies")
+	}
+	return NewClientWithCookies(cookies, siteURL, opts)
+}
+
+// NewClientWithCookies creates a new HTTP client authenticated with the given cookies
+func NewClientWithCookies(cookies map[string]string, siteURL string, opts ClientOptions) (*Client, error) {
 	// Set default site URL if not provided
 	if siteURL == "" {
 		siteURL = "learning.oreilly.com"
@@ -38,12 +116,11 @@ func NewClient(cookiesPath, siteURL string) (*Client, error) {
 	if !strings.HasPrefix(siteURL, "http://") && !strings.HasPrefix(siteURL, "https://") {
 		siteURL = "https://" + siteURL
 	}
+	if err := checkCookies(cookies, siteURL, opts.RequiredCookies); err != nil {
+		return nil, err
+	}
 
 	profileURL := siteURL + "/profile/"
-	cookies, err := utils.LoadCookies(cookiesPath)
-	if err != nil {
-		return nil, utils.WrapError(err, "load cookies")
-	}
 
 	jar, err := cookiejar.New(nil)
 	if err != nil {
@@ -52,8 +129,37 @@ func NewClient(cookiesPath, siteURL string) (*Client, error) {
 
 	// Create resty client
 	client := resty.New().
-		SetTimeout(60 * time.Second).
+		SetTimeout(requestTimeout).
 		SetRedirectPolicy(resty.FlexibleRedirectPolicy(10))
+	if _, err := setConnectTimeout(client, cmp.Or(opts.ConnectTimeout, defaultConnectTimeout)); err != nil {
+		return nil, utils.WrapError(err, "configure connect timeout")
+	}
+	configureRetries(client, retryCounts{
+		api:   retryCount(opts.Retries, defaultRetries),
+		asset: retryCount(opts.AssetRetries, retryCount(opts.Retries, defaultAssetRetries)),
+	}, defaultRetryWait, defaultRetryMaxWait)
+	bans := detectBans(client)
+	detectChallenges(client)
+	if opts.RetryBudget > 0 {
+		setRetryBudget(client, opts.RetryBudget, defaultRetryWait, defaultRetryMaxWait)
+	}
+	if opts.Jitter > 0 {
+		setJitter(client, opts.Jitter)
+	}
+	if opts.Proxy != nil {
+		// The transport sends the URL's userinfo as Proxy-Authorization
+		client.SetProxy(opts.Proxy.String())
+	}
+	if opts.ObserveStatus != nil {
+		transport, err := client.Transport()
+		if err != nil {
+			return nil, utils.WrapError(err, "configure status observer")
+		}
+		client.SetTransport(statusObserver{next: transport, observe: opts.ObserveStatus})
+	}
+	if !opts.KeepHTTP || opts.ForceHTTPS {
+		upgradeRequests(client, opts.ForceHTTPS)
+	}
 
 	// Set cookies
 	base, _ := url.Parse(siteURL)
@@ -74,103 +180,415 @@ func NewClient(cookiesPath, siteURL string) (*Client, error) {
 	_ = jar // Keep the jar to avoid unused variable warning
 
 	// Set default headers
+	acceptLanguage := opts.AcceptLanguage
+	if acceptLanguage == "" {
+		acceptLanguage = defaultAcceptLanguage
+	}
 	client.SetHeaders(map[string]string{
 		"Accept":                    "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,image/apng,*/*;q=0.8",
+		"Accept-Language":           acceptLanguage,
 		"Referer":                   siteURL + "/login/unified/?next=/home/",
 		"Upgrade-Insecure-Requests": "1",
 		"User-Agent":                defaultUserAgent,
 	})
 
+	if token := cookies[jwtCookie]; token != "" {
+		slog.Debug("using " + jwtCookie + " as Bearer token for API requests")
+		setBearerAuth(client, base.Host, token)
+	}
+
 	// Check authentication
-	if err := ensureAuthenticated(client, profileURL); err != nil {
+	profile, err := fetchProfile(client, profileURL)
+	if err != nil {
 		return nil, err
 	}
 
+	cache := opts.Cache
+	if cache == nil {
+		cache = NewBookCache()
+	}
 	return &Client{
 		client:     client,
 		siteURL:    siteURL,
 		profileURL: profileURL,
+		bans:       bans,
+		profile:    profile,
+		cache:      cache,
+
+		authenticated: true,
 	}, nil
 }
 
-// Get performs a GET request
+// Get performs a GET request for an asset, such as an image or stylesheet,
+// retried as ClientOptions.AssetRetries allows
 func (c *Client) Get(url string) (*resty.Response, error) {
-	return c.client.R().Get(url)
+	ctx := context.WithValue(context.Background(), assetRequestKey{}, true)
+	return c.client.R().SetContext(ctx).Get(url)
 }
 
-// GetBookInfo fetches book information from the API
-func (c *Client) GetBookInfo(bookID string) (models.BookInfo, error) {
-	apiURL := fmt.Sprintf("%s/api/v1/book/%s/", c.siteURL, bookID)
+// GetStream performs a GET request for a large asset, such as a code
+// archive, without reading the body into memory; the caller reads and closes
+// resp.RawBody()
+func (c *Client) GetStream(url string) (*resty.Response, error) {
+	ctx := context.WithValue(context.Background(), assetRequestKey{}, true)
+	return c.client.R().SetContext(ctx).SetDoNotParseResponse(true).Get(url)
+}
+
+// GetLimited performs a GET request and reads at most limit bytes of the
+// body; truncated reports whether the body was longer than limit
+func (c *Client) GetLimited(url string, limit int64) (resp *resty.Response, body []byte, truncated bool, err error) {
+	resp, err = c.client.R().SetDoNotParseResponse(true).Get(url)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	raw := resp.RawBody()
+	defer raw.Close()
+
+	body, err = io.ReadAll(io.LimitReader(raw, limit+1))
+	if err != nil {
+		return resp, nil, false, err
+	}
+	// The response middleware never sees unparsed bodies
+	if err := c.bans.check(resp, body); err != nil {
+		return resp, nil, false, err
+	}
+	if err := checkChallenge(resp, body); err != nil {
+		return resp, nil, false, err
+	}
+	if int64(len(body)) > limit {
+		return resp, body[:limit], true, nil
+	}
+	return resp, body, false, nil
+}
+
+// SiteURL returns the site the client talks to, with its scheme
+func (c *Client) SiteURL() string {
+	return c.siteURL
+}
+
+// ResolveURL resolves an API-provided URL against the configured site: relative
+// URLs are made absolute and canonical-host URLs are moved onto the site host
+func (c *Client) ResolveURL(raw string) string {
+	abs := utils.ResolveURL(c.siteURL+"/", raw)
+	u, err := url.Parse(abs)
+	if err != nil || !strings.EqualFold(u.Host, canonicalHost) {
+		return abs
+	}
+	site, err := url.Parse(c.siteURL)
+	if err != nil || site.Host == u.Host {
+		return abs
+	}
+	u.Scheme, u.Host = site.Scheme, site.Host
+	return u.String()
+}
+
+// IsProxyHost reports whether siteURL is a library proxy or other host than
+// the canonical O'Reilly one; an empty siteURL means the canonical host
+func IsProxyHost(siteURL string) bool {
+	if siteURL == "" {
+		return false
+	}
+	if !strings.Contains(siteURL, "://") {
+		siteURL = "https://" + siteURL
+	}
+	u, err := url.Parse(siteURL)
+	return err != nil || !strings.EqualFold(u.Hostname(), canonicalHost)
+}
+
+// setConnectTimeout gives the client's transport a dialer with the timeout,
+// which only covers connecting; the request timeout still covers the rest
+func setConnectTimeout(client *resty.Client, timeout time.Duration) (*net.Dialer, error) {
+	transport, err := client.Transport()
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}
+	transport.DialContext = dialer.DialContext
+	return dialer, nil
+}
+
+// setBearerAuth sends token as an Authorization: Bearer header with every
+// request to an /api/ path on host, next to the cookie, for endpoints that
+// ignore the cookie. Other hosts, such as image CDNs, never see the token,
+// and a request that sets its own Authorization header keeps it.
+func setBearerAuth(client *resty.Client, host, token string) {
+	client.OnBeforeRequest(func(_ *resty.Client, req *resty.Request) error {
+		u, err := url.Parse(req.URL)
+		if err != nil || !strings.EqualFold(u.Host, host) || !strings.HasPrefix(u.Path, "/api/") {
+			return nil
+		}
+		if req.Header.Get("Authorization") == "" {
+			req.SetHeader("Authorization", "Bearer "+token)
+		}
+		return nil
+	})
+}
+
+// NormalizeBookID trims whitespace and stray slashes from a book ID as typed
+// or pasted, e.g. " 9781491950357/ " becomes "9781491950357"
+func NormalizeBookID(bookID string) string {
+	return strings.Trim(strings.TrimSpace(bookID), "/")
+}
+
+// bookAPIURL returns the v1 API URL of a book, ending in a slash
+func (c *Client) bookAPIURL(bookID string) string {
+	return fmt.Sprintf("%s/api/v1/book/%s/", c.siteURL, url.PathEscape(NormalizeBookID(bookID)))
+}
 
+// getJSON fetches a JSON document into target. A 404 is retried once with the
+// trailing slash of the path toggled, since proxies and API versions disagree
+// on it; the original error is returned when that fails too.
+func (c *Client) getJSON(rawURL string, target any, errorMsg string) error {
+	err := utils.HandleJSONResponseWithClient(c.client, rawURL, target, errorMsg)
+	var statusErr *utils.StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		return err
+	}
+	alt := toggleTrailingSlash(rawURL)
+	if alt == "" {
+		return err
+	}
+	if utils.HandleJSONResponseWithClient(c.client, alt, target, errorMsg) == nil {
+		return nil
+	}
+	return err
+}
+
+// toggleTrailingSlash adds a trailing slash to the URL's path or removes the
+// one it has, keeping the query; it returns "" when there is no path to change
+func toggleTrailingSlash(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Path == "" || u.Path == "/" {
+		return ""
+	}
+	if strings.HasSuffix(u.Path, "/") {
+		u.Path = strings.TrimSuffix(u.Path, "/")
+	} else {
+		u.Path += "/"
+	}
+	u.RawPath = ""
+	return u.String()
+}
+
+// BookNotFoundError means the API has no book with the requested ID, most
+// often because of a typo; Err is the underlying 404 status error
+type BookNotFoundError struct {
+	BookID string
+	Err    error
+}
+
+func (e *BookNotFoundError) Error() string {
+	return fmt.Sprintf("book %s not found (check the identifier)", e.BookID)
+}
+
+func (e *BookNotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// GetBookInfo fetches book information from the API, or the client's cache
+// when the book was already fetched
+func (c *Client) GetBookInfo(bookID string) (models.BookInfo, error) {
+	if info, ok := c.cache.bookInfo(bookID); ok {
+		return info, nil
+	}
 	var info models.BookInfo
-	if err := utils.HandleJSONResponseWithClient(c.client, apiURL, &info, "API: unable to retrieve book info"); err != nil {
+	if err := c.getJSON(c.bookAPIURL(bookID), &info, "API: unable to retrieve book info"); err != nil {
+		var statusErr *utils.StatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+			return models.BookInfo{}, &BookNotFoundError{BookID: NormalizeBookID(bookID), Err: err}
+		}
 		return models.BookInfo{}, err
 	}
 
+	c.cache.storeBookInfo(bookID, info)
 	return info, nil
 }
 
-// GetBookChapters fetches all chapters for a book
+// GetBookChapters fetches all chapters for a book, or returns them from the
+// client's cache when the book was already fetched
 func (c *Client) GetBookChapters(bookID string) ([]models.Chapter, error) {
-	apiURL := fmt.Sprintf("%s/api/v1/book/%s/", c.siteURL, bookID)
 	var all []models.Chapter
+	err := c.StreamBookChapters(bookID, func(page []models.Chapter) error {
+		all = append(all, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+// StreamBookChapters passes the chapters of a book to onPage one API page at
+// a time, as each page arrives, so callers can start on them before the list
+// is complete. A book in the client's cache comes as a single page. An error
+// from onPage stops the pagination and is returned.
+func (c *Client) StreamBookChapters(bookID string, onPage func([]models.Chapter) error) error {
+	if chapters, ok := c.cache.bookChapters(bookID); ok {
+		return onPage(chapters)
+	}
+	var all []models.Chapter
+	err := c.fetchBookChapters(bookID, func(page []models.Chapter) error {
+		if c.cache != nil {
+			// Copied before onPage gets to fill in the chapters
+			all = append(all, page...)
+		}
+		return onPage(page)
+	})
+	if err != nil {
+		return err
+	}
+	c.cache.storeChapters(bookID, all)
+	return nil
+}
+
+// fetchBookChapters follows the chapter pages of a book, passing each to
+// onPage with cover chapters first within the page
+func (c *Client) fetchBookChapters(bookID string, onPage func([]models.Chapter) error) error {
+	apiURL := c.bookAPIURL(bookID)
 	pageURL := apiURL + "chapter/?page=1"
+	visited := make(map[string]bool)
+	seenIDs := make(map[string]bool)
 
 	for pageURL != "" {
-		var payload models.ChapterResponse
-		resp, err := c.client.R().Get(pageURL)
-		if err != nil {
-			return nil, utils.WrapError(err, "API: retrieve book chapters")
+		if visited[pageURL] {
+			return fmt.Errorf("API: chapter pagination loops back to %s", pageURL)
+		}
+		if len(visited) >= maxChapterPages {
+			return fmt.Errorf("API: chapter pagination exceeded %d pages", maxChapterPages)
 		}
+		visited[pageURL] = true
 
-		if err := utils.HandleJSONResponse(resp, &payload, "API: unable to retrieve book chapters"); err != nil {
-			return nil, err
+		var payload models.ChapterResponse
+		if err := c.getJSON(pageURL, &payload, "API: unable to retrieve book chapters"); err != nil {
+			// Once the session has been checked, a forbidden chapter list
+			// means the title itself is withheld
+			var statusErr *utils.StatusError
+			if c.authenticated && errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusForbidden {
+				return fmt.Errorf("book %s: %w", NormalizeBookID(bookID), ErrRestricted)
+			}
+			return err
 		}
 
 		if len(payload.Results) == 0 {
-			return nil, errors.New("API: unable to retrieve book chapters")
+			return errors.New("API: unable to retrieve book chapters")
 		}
 
+		// Drop chapters already returned by a previous page
+		results := lo.Filter(payload.Results, func(chapter models.Chapter, index int) bool {
+			if chapter.ID == "" {
+				return true
+			}
+			if seenIDs[chapter.ID] {
+				return false
+			}
+			seenIDs[chapter.ID] = true
+			return true
+		})
+
 		// Use samber/lo to filter chapters
-		covers := lo.Filter(payload.Results, func(chapter models.Chapter, index int) bool {
+		covers := lo.Filter(results, func(chapter models.Chapter, index int) bool {
 			return strings.Contains(strings.ToLower(chapter.Filename), "cover") ||
 				strings.Contains(strings.ToLower(chapter.Title), "cover")
 		})
 
-		remaining := lo.Filter(payload.Results, func(chapter models.Chapter, index int) bool {
+		remaining := lo.Filter(results, func(chapter models.Chapter, index int) bool {
 			return !strings.Contains(strings.ToLower(chapter.Filename), "cover") &&
 				!strings.Contains(strings.ToLower(chapter.Title), "cover")
 		})
 
-		all = append(all, covers...)
-		all = append(all, remaining...)
+		if err := onPage(slices.Concat(covers, remaining)); err != nil {
+			return err
+		}
+
+		pageURL = c.nextPage(payload.Next)
+	}
+
+	return nil
+}
+
+// GetBookTOC fetches the book's table of contents, in reading order
+func (c *Client) GetBookTOC(bookID string) ([]models.TocItem, error) {
+	var toc []models.TocItem
+	if err := c.getJSON(c.bookAPIURL(bookID)+"toc/", &toc, "API: unable to retrieve book table of contents"); err != nil {
+		return nil, err
+	}
+	return toc, nil
+}
+
+// GetBookFiles fetches the v2 EPUB files listing, which maps each in-book
+// path to its download URL. Relative URLs are resolved against the site.
+func (c *Client) GetBookFiles(bookID string) ([]models.BookFile, error) {
+	pageURL := fmt.Sprintf("%s/api/v2/epubs/urn:orm:book:%s/files/?limit=200", c.siteURL, url.PathEscape(NormalizeBookID(bookID)))
+	visited := make(map[string]bool)
+	var all []models.BookFile
+
+	for pageURL != "" {
+		if visited[pageURL] {
+			return nil, fmt.Errorf("API: files pagination loops back to %s", pageURL)
+		}
+		if len(visited) >= maxChapterPages {
+			return nil, fmt.Errorf("API: files pagination exceeded %d pages", maxChapterPages)
+		}
+		visited[pageURL] = true
+
+		var payload models.BookFilesResponse
+		resp, err := c.client.R().Get(pageURL)
+		if err != nil {
+			return nil, utils.WrapError(err, "API: retrieve book files")
+		}
+		if err := utils.HandleJSONResponse(resp, &payload, "API: unable to retrieve book files"); err != nil {
+			return nil, err
+		}
 
-		if payload.Next != nil && *payload.Next != "" {
-			pageURL = *payload.Next
-		} else {
-			pageURL = ""
+		for _, file := range payload.Results {
+			file.URL = utils.ResolveURL(c.siteURL+"/", file.URL)
+			all = append(all, file)
 		}
+
+		pageURL = c.nextPage(payload.Next)
 	}
 
 	return all, nil
 }
 
-// ensureAuthenticated checks if the client is authenticated
-func ensureAuthenticated(client *resty.Client, profileURL string) error {
-	resp, err := client.R().
-		SetHeader("User-Agent", defaultUserAgent).
-		Get(profileURL)
+// supplementaryTypes and supplementaryExts identify the archives in the
+// v2 files listing, such as a book's code examples, as opposed to its EPUB
+// content
+var (
+	supplementaryTypes = []string{"application/zip", "application/x-zip-compressed", "application/gzip", "application/x-gzip", "application/x-tar", "application/x-7z-compressed"}
+	supplementaryExts  = []string{".zip", ".tar", ".gz", ".tgz", ".7z"}
+)
+
+// GetSupplementaryFiles returns the book's downloadable extras, such as code
+// archives: the archives in the v2 files listing, which are not part of the
+// EPUB content. Books without any, or without a listing, return an empty
+// list, not an error.
+func (c *Client) GetSupplementaryFiles(bookID string) ([]models.BookFile, error) {
+	files, err := c.GetBookFiles(bookID)
+	var statusErr *utils.StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
 	if err != nil {
-		return utils.WrapError(err, "authentication check failed")
+		return nil, err
 	}
 
-	if resp.StatusCode() != http.StatusOK {
-		return fmt.Errorf("authentication issue: expected 200, got %d", resp.StatusCode())
+	var extras []models.BookFile
+	for _, file := range files {
+		if file.URL != "" && isSupplementary(file) {
+			extras = append(extras, file)
+		}
 	}
+	return extras, nil
+}
 
-	if strings.Contains(resp.String(), `user_type":"Expired"`) {
-		return errors.New("authentication issue: account subscription expired")
+// isSupplementary reports whether a listed file is an archive, by its media
+// type or else its extension
+func isSupplementary(file models.BookFile) bool {
+	mediaType, _, _ := strings.Cut(file.MediaType, ";")
+	if slices.Contains(supplementaryTypes, strings.ToLower(strings.TrimSpace(mediaType))) {
+		return true
 	}
-
-	return nil
+	name := strings.ToLower(cmp.Or(file.Filename, file.FullPath))
+	return slices.Contains(supplementaryExts, path.Ext(name))
 }
@@ -0,0 +1,592 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dacsang97/safaribooks/internal/models"
+	"github.com/dacsang97/safaribooks/pkg/utils"
+	"github.com/go-resty/resty/v2"
+)
+
+// newTestClient returns a Client pointed at the given test server without running the auth check
+func newTestClient(server *httptest.Server) *Client {
+	client := resty.New()
+	bans := detectBans(client)
+	detectChallenges(client)
+	return &Client{
+		client:     client,
+		siteURL:    server.URL,
+		profileURL: server.URL + "/profile/",
+		bans:       bans,
+	}
+}
+
+func TestGetBookChapters_CyclicPagination(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Page 2 points back to page 1, so the API never stops paginating
+		page := r.URL.Query().Get("page")
+		next := fmt.Sprintf("%s/api/v1/book/123/chapter/?page=2", server.URL)
+		if page == "2" {
+			next = fmt.Sprintf("%s/api/v1/book/123/chapter/?page=1", server.URL)
+		}
+		fmt.Fprintf(w, `{"count": 2, "next": %q, "results": [{"id": "ch-%s", "title": "Chapter %s"}]}`, next, page, page)
+	}))
+	defer server.Close()
+
+	_, err := newTestClient(server).GetBookChapters("123")
+	if err == nil {
+		t.Fatal("Expected error for cyclic pagination, got nil")
+	}
+	if !strings.Contains(err.Error(), "loops") {
+		t.Errorf("Expected pagination loop error, got %v", err)
+	}
+}
+
+func TestGetBookChapters_DedupesByID(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "1" {
+			next := fmt.Sprintf("%s/api/v1/book/123/chapter/?page=2", server.URL)
+			fmt.Fprintf(w, `{"count": 3, "next": %q, "results": [{"id": "a"}, {"id": "b"}]}`, next)
+			return
+		}
+		fmt.Fprint(w, `{"count": 3, "next": null, "results": [{"id": "b"}, {"id": "c"}]}`)
+	}))
+	defer server.Close()
+
+	chapters, err := newTestClient(server).GetBookChapters("123")
+	if err != nil {
+		t.Fatalf("GetBookChapters failed: %v", err)
+	}
+
+	if len(chapters) != 3 {
+		t.Fatalf("Expected 3 chapters, got %d", len(chapters))
+	}
+	for i, id := range []string{"a", "b", "c"} {
+		if chapters[i].ID != id {
+			t.Errorf("Expected chapter %d to have id %s, got %s", i, id, chapters[i].ID)
+		}
+	}
+}
+
+func TestGetBookFiles_Pagination(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			fmt.Fprint(w, `{"count": 2, "next": null, "results": [{"full_path": "OEBPS/b.png", "url": "https://cdn.example.com/b.png"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"count": 2, "next": "/api/v2/epubs/urn:orm:book:123/files/?page=2", "results": [{"full_path": "OEBPS/a.png", "url": "/api/v2/epubs/urn:orm:book:123/files/OEBPS/a.png"}]}`)
+	}))
+	defer server.Close()
+
+	files, err := newTestClient(server).GetBookFiles("123")
+	if err != nil {
+		t.Fatalf("GetBookFiles failed: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected 2 files, got %d", len(files))
+	}
+	if want := server.URL + "/api/v2/epubs/urn:orm:book:123/files/OEBPS/a.png"; files[0].URL != want {
+		t.Errorf("Expected relative URL resolved to %s, got %s", want, files[0].URL)
+	}
+	if files[1].URL != "https://cdn.example.com/b.png" {
+		t.Errorf("Expected absolute URL kept, got %s", files[1].URL)
+	}
+}
+
+func TestGetSupplementaryFiles(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/epubs/urn:orm:book:123/files/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"count": 4, "next": null, "results": [`+
+			`{"url": "/api/v2/epubs/urn:orm:book:123/files/OEBPS/ch01.html", "full_path": "OEBPS/ch01.html", "filename": "ch01.html", "media_type": "text/html"},`+
+			`{"url": "/api/v2/epubs/urn:orm:book:123/files/code.zip", "full_path": "code.zip", "filename": "code.zip", "media_type": "application/zip"},`+
+			`{"url": "https://cdn.example.com/data.tar.gz", "full_path": "extras/data.tar.gz", "filename": "data.tar.gz", "media_type": "application/octet-stream"},`+
+			`{"full_path": "broken.zip", "filename": "broken.zip", "media_type": "application/zip"}]}`)
+	}))
+	defer server.Close()
+
+	files, err := newTestClient(server).GetSupplementaryFiles("123")
+	if err != nil {
+		t.Fatalf("GetSupplementaryFiles failed: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected the 2 archives with URLs, got %+v", files)
+	}
+	want := models.BookFile{URL: server.URL + "/api/v2/epubs/urn:orm:book:123/files/code.zip", FullPath: "code.zip", Filename: "code.zip", MediaType: "application/zip"}
+	if files[0] != want {
+		t.Errorf("Expected %+v, got %+v", want, files[0])
+	}
+	if files[1].Filename != "data.tar.gz" || files[1].URL != "https://cdn.example.com/data.tar.gz" {
+		t.Errorf("Expected the archive found by its extension, got %+v", files[1])
+	}
+}
+
+func TestGetSupplementaryFiles_None(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	files, err := newTestClient(server).GetSupplementaryFiles("123")
+	if err != nil {
+		t.Fatalf("Expected a book without files to be no error, got %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("Expected no files, got %+v", files)
+	}
+}
+
+func TestNewClient_AcceptLanguage(t *testing.T) {
+	cases := []struct {
+		name, option, want string
+	}{
+		{"default", "", defaultAcceptLanguage},
+		{"custom", "de-DE,de;q=0.9", "de-DE,de;q=0.9"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			seen := make(map[string]string)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen[r.URL.Path] = r.Header.Get("Accept-Language")
+				if r.URL.Path == "/profile/" {
+					return
+				}
+				fmt.Fprint(w, `{"title": "Book"}`)
+			}))
+			defer server.Close()
+
+			client, err := NewClientWithCookies(map[string]string{"orm-jwt": "test"}, server.URL, ClientOptions{AcceptLanguage: c.option})
+			if err != nil {
+				t.Fatalf("NewClientWithCookies failed: %v", err)
+			}
+			if _, err := client.GetBookInfo("123"); err != nil {
+				t.Fatalf("GetBookInfo failed: %v", err)
+			}
+
+			for _, path := range []string{"/profile/", "/api/v1/book/123/"} {
+				if got := seen[path]; got != c.want {
+					t.Errorf("Expected Accept-Language %q on %s, got %q", c.want, path, got)
+				}
+			}
+		})
+	}
+}
+
+func TestFetchProfile_States(t *testing.T) {
+	cases := []struct {
+		name      string
+		status    int
+		body      string
+		wantErr   error
+		wantEmail string
+	}{
+		{"valid", http.StatusOK, `<script>window.user = {"email": "reader@example.com", "user_type": "Individual"};</script>`, nil, "reader@example.com"},
+		{"expired", http.StatusOK, `<script>window.user = {"email":"reader@example.com","user_type":"Expired"};</script>`, ErrSubscriptionExpired, "reader@example.com"},
+		{"invalid", http.StatusUnauthorized, `login required`, ErrAuthentication, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(c.status)
+				fmt.Fprint(w, c.body)
+			}))
+			defer server.Close()
+
+			profile, err := fetchProfile(resty.New(), server.URL+"/profile/")
+			if c.wantErr == nil && err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if c.wantErr != nil && !errors.Is(err, c.wantErr) {
+				t.Fatalf("Expected %v, got %v", c.wantErr, err)
+			}
+			if profile.Email != c.wantEmail {
+				t.Errorf("Expected email %q, got %q", c.wantEmail, profile.Email)
+			}
+		})
+	}
+}
+
+func TestFetchProfile_ExpiredIsAuthenticationError(t *testing.T) {
+	if !errors.Is(ErrSubscriptionExpired, ErrAuthentication) {
+		t.Error("Expected ErrSubscriptionExpired to wrap ErrAuthentication")
+	}
+}
+
+func TestSetConnectTimeout(t *testing.T) {
+	client := resty.New()
+	dialer, err := setConnectTimeout(client, 5*time.Second)
+	if err != nil {
+		t.Fatalf("setConnectTimeout failed: %v", err)
+	}
+	if dialer.Timeout != 5*time.Second {
+		t.Errorf("Expected a 5s dial timeout, got %v", dialer.Timeout)
+	}
+	transport, err := client.Transport()
+	if err != nil || transport.DialContext == nil {
+		t.Fatalf("Expected the transport to dial with the dialer, got %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	if _, err := client.R().Get(server.URL); err != nil {
+		t.Errorf("Expected requests to connect through the dialer, got %v", err)
+	}
+}
+
+func TestResolveURL(t *testing.T) {
+	c := &Client{siteURL: "https://learning-oreilly-com.proxy.example.edu"}
+
+	cases := map[string]string{
+		"/api/v1/book/123/chapter-content/ch01.html":                             "https://learning-oreilly-com.proxy.example.edu/api/v1/book/123/chapter-content/ch01.html",
+		"https://learning.oreilly.com/api/v1/book/123/chapter-content/ch01.html": "https://learning-oreilly-com.proxy.example.edu/api/v1/book/123/chapter-content/ch01.html",
+		"https://cdn.example.com/ch01.html":                                      "https://cdn.example.com/ch01.html",
+	}
+	for raw, want := range cases {
+		if got := c.ResolveURL(raw); got != want {
+			t.Errorf("ResolveURL(%q) = %q, want %q", raw, got, want)
+		}
+	}
+
+	direct := &Client{siteURL: "https://learning.oreilly.com"}
+	if got := direct.ResolveURL("https://learning.oreilly.com/a?b=1"); got != "https://learning.oreilly.com/a?b=1" {
+		t.Errorf("Expected canonical URL unchanged on the canonical site, got %q", got)
+	}
+}
+
+func TestGetBookInfo_NormalizesBookID(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		fmt.Fprint(w, `{"title": "Test Book"}`)
+	}))
+	defer server.Close()
+
+	c := newTestClient(server)
+	for _, id := range []string{"123", "/123", "123/", "/123/", "  123 \n", " /123/ "} {
+		paths = nil
+		info, err := c.GetBookInfo(id)
+		if err != nil {
+			t.Fatalf("GetBookInfo(%q) failed: %v", id, err)
+		}
+		if info.Title != "Test Book" {
+			t.Errorf("GetBookInfo(%q): unexpected info %+v", id, info)
+		}
+		if len(paths) != 1 || paths[0] != "/api/v1/book/123/" {
+			t.Errorf("GetBookInfo(%q) requested %v, want [/api/v1/book/123/]", id, paths)
+		}
+	}
+}
+
+func TestGetBookInfo_FallsBackToAlternateSlash(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.RequestURI())
+		switch r.URL.Path {
+		case "/api/v1/book/123":
+			fmt.Fprint(w, `{"title": "Test Book"}`)
+		case "/api/v1/book/123/chapter":
+			fmt.Fprint(w, `{"count": 1, "results": [{"id": "a", "title": "One"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	c := newTestClient(server)
+	info, err := c.GetBookInfo("123/")
+	if err != nil {
+		t.Fatalf("GetBookInfo failed: %v", err)
+	}
+	if info.Title != "Test Book" {
+		t.Errorf("Unexpected info %+v", info)
+	}
+
+	chapters, err := c.GetBookChapters("123")
+	if err != nil {
+		t.Fatalf("GetBookChapters failed: %v", err)
+	}
+	if len(chapters) != 1 || chapters[0].ID != "a" {
+		t.Errorf("Unexpected chapters %+v", chapters)
+	}
+	want := []string{"/api/v1/book/123/", "/api/v1/book/123", "/api/v1/book/123/chapter/?page=1", "/api/v1/book/123/chapter?page=1"}
+	if strings.Join(paths, " ") != strings.Join(want, " ") {
+		t.Errorf("Requested %v, want %v", paths, want)
+	}
+}
+
+func TestGetBookInfo_NotFoundKeepsOriginalError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	_, err := newTestClient(server).GetBookInfo("123")
+	var statusErr *utils.StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected a 404 status error, got %v", err)
+	}
+	if !strings.HasSuffix(statusErr.URL, "/api/v1/book/123/") {
+		t.Errorf("Expected the error to name the original URL, got %s", statusErr.URL)
+	}
+}
+
+func TestGetBookInfo_NotFoundMessage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"detail": "Not found."}`, http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	_, err := newTestClient(server).GetBookInfo(" 9781234567890/")
+	var notFound *BookNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Fatalf("Expected a BookNotFoundError, got %v", err)
+	}
+	if want := "book 9781234567890 not found (check the identifier)"; err.Error() != want {
+		t.Errorf("Expected %q, got %q", want, err.Error())
+	}
+	if errors.Is(err, ErrAuthentication) {
+		t.Error("Expected a missing book not to be reported as an authentication issue")
+	}
+}
+
+func TestGetBook_Restricted(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/chapter") {
+			http.Error(w, `{"detail": "You do not have permission to perform this action."}`, http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"title": "Locked"}`)
+	}))
+	defer server.Close()
+	client := newTestClient(server)
+
+	if _, err := client.GetBookChapters("123"); errors.Is(err, ErrRestricted) {
+		t.Errorf("Expected a forbidden chapter list not to be restricted before authentication, got %v", err)
+	}
+
+	client.authenticated = true
+	_, err := client.GetBookChapters("123")
+	if !errors.Is(err, ErrRestricted) {
+		t.Fatalf("Expected a forbidden chapter list to be reported as restricted, got %v", err)
+	}
+	if want := "book 123: this title cannot be exported (DRM/restricted)"; err.Error() != want {
+		t.Errorf("Expected %q, got %q", want, err.Error())
+	}
+}
+
+func TestIsProxyHost(t *testing.T) {
+	tests := map[string]bool{
+		"":                              false,
+		"learning.oreilly.com":          false,
+		"https://LEARNING.oreilly.com/": false,
+		"learning-oreilly-com.dclibrary.idm.oclc.org":      true,
+		"https://learning-oreilly-com.ezproxy.example.edu": true,
+	}
+	for siteURL, want := range tests {
+		if got := IsProxyHost(siteURL); got != want {
+			t.Errorf("IsProxyHost(%q) = %v, want %v", siteURL, got, want)
+		}
+	}
+}
+
+func TestToggleTrailingSlash(t *testing.T) {
+	cases := map[string]string{
+		"https://example.com/api/v1/book/123/":                "https://example.com/api/v1/book/123",
+		"https://example.com/api/v1/book/123":                 "https://example.com/api/v1/book/123/",
+		"https://example.com/api/v1/book/123/chapter/?page=2": "https://example.com/api/v1/book/123/chapter?page=2",
+		"https://example.com/":                                "",
+	}
+	for raw, want := range cases {
+		if got := toggleTrailingSlash(raw); got != want {
+			t.Errorf("toggleTrailingSlash(%q) = %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestBotChallenge(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><body><div id="sec-if-cpt-container"><script src="/_sec/cp_challenge/ak-challenge-4-3.js"></script></div></body></html>`)
+	}))
+	defer server.Close()
+	c := newTestClient(server)
+
+	var challenge *BotChallengeError
+	if _, err := c.Get(server.URL + "/ch01.html"); !errors.As(err, &challenge) {
+		t.Fatalf("Expected a bot challenge error from Get, got %v", err)
+	}
+	if challenge.URL != server.URL+"/ch01.html" || challenge.Marker == "" {
+		t.Errorf("Unexpected challenge details: %+v", challenge)
+	}
+	if _, body, _, err := c.GetLimited(server.URL+"/ch02.html", 1<<20); !errors.As(err, &challenge) || body != nil {
+		t.Errorf("Expected a bot challenge error and no body from GetLimited, got %v", err)
+	}
+	if _, err := c.GetBookInfo("123"); !errors.As(err, &challenge) {
+		t.Errorf("Expected a bot challenge error from the API, got %v", err)
+	}
+	if isRetryable(http.StatusOK, challenge) {
+		t.Error("Expected bot challenges not to be retried")
+	}
+}
+
+func TestBotChallenge_IgnoresOrdinaryContent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/data.json" {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"note": "/_sec/cp_challenge/ in a JSON string"}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		// A chapter on bot management quoting a challenge page
+		fmt.Fprint(w, `<html><body><div id="sbo-rt-content"><p>Akamai loads <code>/_sec/cp_challenge/ak-challenge-4-3.js</code> into <code>div#sec-if-cpt-container</code></p></div></body></html>`)
+	}))
+	defer server.Close()
+	c := newTestClient(server)
+
+	for _, path := range []string{"/ch01.html", "/data.json"} {
+		if _, err := c.Get(server.URL + path); err != nil {
+			t.Errorf("Expected %s to pass, got %v", path, err)
+		}
+	}
+}
+
+func TestGetBook_CachesByBookID(t *testing.T) {
+	var infoRequests, chapterRequests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/profile/":
+		case "/api/v1/book/123/":
+			infoRequests.Add(1)
+			fmt.Fprint(w, `{"title": "Test Book"}`)
+		case "/api/v1/book/123/chapter/":
+			chapterRequests.Add(1)
+			fmt.Fprint(w, `{"count": 1, "results": [{"id": "a", "title": "One", "filename": "ch01.html"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cache := NewBookCache()
+	var clients []*Client
+	for range 2 {
+		c, err := NewClientWithCookies(map[string]string{"orm-jwt": "test"}, server.URL, ClientOptions{Cache: cache})
+		if err != nil {
+			t.Fatalf("NewClientWithCookies failed: %v", err)
+		}
+		clients = append(clients, c)
+	}
+
+	for i, id := range []string{"123", "/123/"} {
+		if info, err := clients[i].GetBookInfo(id); err != nil || info.Title != "Test Book" {
+			t.Fatalf("GetBookInfo(%q) = %+v, %v", id, info, err)
+		}
+	}
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := clients[i%2].GetBookInfo(" 123 "); err != nil {
+				t.Errorf("GetBookInfo failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := infoRequests.Load(); n != 1 {
+		t.Errorf("Expected book info fetched once, got %d requests", n)
+	}
+
+	chapters, err := clients[0].GetBookChapters("123")
+	if err != nil {
+		t.Fatalf("GetBookChapters failed: %v", err)
+	}
+	chapters[0].Filename = "ch01.xhtml"
+	again, err := clients[1].GetBookChapters("123/")
+	if err != nil {
+		t.Fatalf("Cached GetBookChapters failed: %v", err)
+	}
+	if again[0].Filename != "ch01.html" {
+		t.Errorf("Expected cached chapters unaffected by the caller's changes, got %q", again[0].Filename)
+	}
+	if n := chapterRequests.Load(); n != 1 {
+		t.Errorf("Expected chapters fetched once, got %d requests", n)
+	}
+}
+
+func TestNewClient_BearerFromJWTCookie(t *testing.T) {
+	var mu sync.Mutex
+	auth := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		auth[r.URL.Path] = r.Header.Get("Authorization")
+		mu.Unlock()
+		w.Write([]byte(`{"title": "Book"}`))
+	}))
+	defer server.Close()
+	authFor := func(path string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		return auth[path]
+	}
+
+	client, err := NewClientWithCookies(map[string]string{"orm-jwt": "token123"}, server.URL, ClientOptions{})
+	if err != nil {
+		t.Fatalf("NewClientWithCookies failed: %v", err)
+	}
+	if _, err := client.GetBookInfo("123"); err != nil {
+		t.Fatalf("GetBookInfo failed: %v", err)
+	}
+	if _, err := client.Get(server.URL + "/library/view/123/images/fig01.png"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got := authFor("/api/v1/book/123/"); got != "Bearer token123" {
+		t.Errorf("Expected the JWT as a Bearer token on API requests, got %q", got)
+	}
+	if got := authFor("/library/view/123/images/fig01.png"); got != "" {
+		t.Errorf("Expected no Authorization header outside the API, got %q", got)
+	}
+
+	// Without the JWT cookie there is no token to send
+	other, err := NewClientWithCookies(map[string]string{"sessionid": "abc"}, server.URL, ClientOptions{})
+	if err != nil {
+		t.Fatalf("NewClientWithCookies failed: %v", err)
+	}
+	if _, err := other.GetBookInfo("456"); err != nil {
+		t.Fatalf("GetBookInfo failed: %v", err)
+	}
+	if got := authFor("/api/v1/book/456/"); got != "" {
+		t.Errorf("Expected no Authorization header without the JWT cookie, got %q", got)
+	}
+}
+
+func TestGetSignedURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/epubs/urn:orm:book:123/signed-url/" || r.URL.Query().Get("url") != "https://cdn.example.com/a.png" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"url": "/cdn/a.png?sig=1"}`)
+	}))
+	defer server.Close()
+
+	signed, err := newTestClient(server).GetSignedURL("123", "https://cdn.example.com/a.png")
+	if err != nil {
+		t.Fatalf("GetSignedURL failed: %v", err)
+	}
+	if want := server.URL + "/cdn/a.png?sig=1"; signed != want {
+		t.Errorf("Expected %s, got %s", want, signed)
+	}
+}
@@ -0,0 +1,51 @@
+package http
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dacsang97/safaribooks/pkg/utils"
+)
+
+// MissingCookiesError reports a cookie export without the cookies a
+// logged-in session needs, found before any request is made
+type MissingCookiesError struct {
+	Missing []string // the required cookies not found
+	AnyOf   bool     // any one of Missing would do
+}
+
+func (e *MissingCookiesError) Error() string {
+	names := strings.Join(e.Missing, ", ")
+	if e.AnyOf {
+		names = "one of " + names
+	}
+	return fmt.Sprintf("your cookie export is missing required cookies (%s): export them again from a browser logged in to the site", names)
+}
+
+func (e *MissingCookiesError) Unwrap() error {
+	return ErrAuthentication
+}
+
+// checkCookies fails fast on cookie exports that cannot hold a session: it
+// requires every cookie named in required, or when none are named, one of
+// the known O'Reilly session cookies. Library proxies use cookies of their
+// own, so without required names nothing is checked for them.
+func checkCookies(cookies map[string]string, siteURL string, required []string) error {
+	if len(required) == 0 {
+		if IsProxyHost(siteURL) || utils.HasSessionCookie(cookies) {
+			return nil
+		}
+		return &MissingCookiesError{Missing: utils.SessionCookieNames(), AnyOf: true}
+	}
+
+	var missing []string
+	for _, name := range required {
+		if cookies[name] == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return &MissingCookiesError{Missing: missing}
+	}
+	return nil
+}
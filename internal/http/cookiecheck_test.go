@@ -0,0 +1,56 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNewClient_MissingSessionCookie(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer server.Close()
+
+	cookiesPath := filepath.Join(t.TempDir(), "cookies.json")
+	if err := os.WriteFile(cookiesPath, []byte(`{"_ga": "GA1.2.3", "csrftoken": "abc"}`), 0644); err != nil {
+		t.Fatalf("Failed to write cookies file: %v", err)
+	}
+
+	_, err := NewClient(cookiesPath, server.URL, ClientOptions{RequiredCookies: []string{"orm-jwt", "orm-rt"}})
+	var missing *MissingCookiesError
+	if !errors.As(err, &missing) {
+		t.Fatalf("Expected a missing cookies error, got %v", err)
+	}
+	if !errors.Is(err, ErrAuthentication) {
+		t.Errorf("Expected the error to be an authentication error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "missing required cookies (orm-jwt, orm-rt)") {
+		t.Errorf("Expected the missing cookies named, got %q", err)
+	}
+	if got := requests.Load(); got != 0 {
+		t.Errorf("Expected no request before the cookie check, got %d", got)
+	}
+}
+
+func TestCheckCookies(t *testing.T) {
+	noSession := map[string]string{"_ga": "GA1.2.3"}
+	if err := checkCookies(noSession, "https://learning.oreilly.com", nil); err == nil || !strings.Contains(err.Error(), "one of orm-jwt") {
+		t.Errorf("Expected a missing session cookie error on O'Reilly, got %v", err)
+	}
+	if err := checkCookies(noSession, "https://learning-oreilly-com.example.org", nil); err != nil {
+		t.Errorf("Expected library proxies not checked by default, got %v", err)
+	}
+	if err := checkCookies(map[string]string{"orm-rt": "x"}, "https://learning.oreilly.com", nil); err != nil {
+		t.Errorf("Expected any session cookie to do, got %v", err)
+	}
+	if err := checkCookies(map[string]string{"ezproxy": "x"}, "https://learning-oreilly-com.example.org", []string{"ezproxy"}); err != nil {
+		t.Errorf("Expected the required cookie found, got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package http
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// oreillyDomains are the domains, subdomains included, whose http:// URLs
+// are always fetched over https: the site itself and the asset CDN
+var oreillyDomains = []string{"oreilly.com", "oreillystatic.com"}
+
+// isOReillyHost reports whether host is on one of oreillyDomains
+func isOReillyHost(host string) bool {
+	host = strings.ToLower(host)
+	for _, domain := range oreillyDomains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// upgradeHTTP returns raw with the https scheme when it is an http:// URL on
+// an O'Reilly host, or on any host when all is set. Other URLs are returned
+// as is; protocol-relative ones are already made https by utils.ResolveURL.
+func upgradeHTTP(raw string, all bool) string {
+	u, err := url.Parse(raw)
+	if err != nil || !strings.EqualFold(u.Scheme, "http") || u.Host == "" {
+		return raw
+	}
+	if !all && !isOReillyHost(u.Hostname()) {
+		return raw
+	}
+	u.Scheme = "https"
+	u.Host = strings.TrimSuffix(u.Host, ":80")
+	return u.String()
+}
+
+// upgradeRequests rewrites the URL of every request with upgradeHTTP before
+// it is sent, saving the redirect to https or a mixed-content failure
+func upgradeRequests(client *resty.Client, all bool) {
+	client.OnBeforeRequest(func(_ *resty.Client, req *resty.Request) error {
+		req.URL = upgradeHTTP(req.URL, all)
+		return nil
+	})
+}
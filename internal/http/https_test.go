@@ -0,0 +1,39 @@
+package http
+
+import (
+	"testing"
+
+	"github.com/dacsang97/safaribooks/pkg/utils"
+)
+
+func TestUpgradeHTTP(t *testing.T) {
+	const base = "https://learning.oreilly.com/api/v2/epubs/urn:orm:book:123/files/"
+	cases := []struct {
+		href     string
+		want     string
+		wantAll  string
+		describe string
+	}{
+		{"http://learning.oreilly.com/library/view/x/graphics/a.png", "https://learning.oreilly.com/library/view/x/graphics/a.png", "", "http on the site"},
+		{"http://cdn.oreillystatic.com/images/a.png", "https://cdn.oreillystatic.com/images/a.png", "", "http on the CDN"},
+		{"http://learning.oreilly.com:80/a.png", "https://learning.oreilly.com/a.png", "", "default http port"},
+		{"http://images.example.com/a.png", "http://images.example.com/a.png", "https://images.example.com/a.png", "http elsewhere"},
+		{"http://notoreilly.com/a.png", "http://notoreilly.com/a.png", "https://notoreilly.com/a.png", "lookalike domain"},
+		{"//images.example.com/a.png", "https://images.example.com/a.png", "", "protocol-relative"},
+		{"https://images.example.com/a.png", "https://images.example.com/a.png", "", "https"},
+		{"graphics/a.png", base + "graphics/a.png", "", "relative"},
+	}
+	for _, c := range cases {
+		abs := utils.ResolveURL(base, c.href)
+		if got := upgradeHTTP(abs, false); got != c.want {
+			t.Errorf("%s: upgradeHTTP(%q) = %q, want %q", c.describe, abs, got, c.want)
+		}
+		wantAll := c.wantAll
+		if wantAll == "" {
+			wantAll = c.want
+		}
+		if got := upgradeHTTP(abs, true); got != wantAll {
+			t.Errorf("%s: upgradeHTTP(%q, all) = %q, want %q", c.describe, abs, got, wantAll)
+		}
+	}
+}
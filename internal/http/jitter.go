@@ -0,0 +1,29 @@
+package http
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// jitterDelay returns a random delay below max; a variable so tests can
+// watch the delays drawn
+var jitterDelay = func(max time.Duration) time.Duration {
+	return rand.N(max)
+}
+
+// setJitter delays every request, retries included, by a random time below
+// max, so workers started together don't hit the site in bursts
+func setJitter(client *resty.Client, max time.Duration) {
+	client.OnBeforeRequest(func(_ *resty.Client, req *resty.Request) error {
+		timer := time.NewTimer(jitterDelay(max))
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			return nil
+		case <-req.Context().Done():
+			return req.Context().Err()
+		}
+	})
+}
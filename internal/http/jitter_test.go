@@ -0,0 +1,48 @@
+package http
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSetJitter_DelaysWithinBounds(t *testing.T) {
+	const max = 20 * time.Millisecond
+
+	var mu sync.Mutex
+	var delays []time.Duration
+	orig := jitterDelay
+	t.Cleanup(func() { jitterDelay = orig })
+	jitterDelay = func(bound time.Duration) time.Duration {
+		delay := orig(bound)
+		mu.Lock()
+		delays = append(delays, delay)
+		mu.Unlock()
+		return delay
+	}
+
+	server, _ := countingServer(t, 0, 0)
+	client := newTestClient(server)
+	setJitter(client.client, max)
+
+	for range 10 {
+		start := time.Now()
+		if _, err := client.Get(server.URL); err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		elapsed := time.Since(start)
+
+		mu.Lock()
+		delay := delays[len(delays)-1]
+		mu.Unlock()
+		if delay < 0 || delay >= max {
+			t.Errorf("Expected a delay in [0, %v), got %v", max, delay)
+		}
+		if elapsed < delay {
+			t.Errorf("Expected the request to wait its %v delay, took %v", delay, elapsed)
+		}
+	}
+	if len(delays) != 10 {
+		t.Errorf("Expected a delay before each of 10 requests, got %d", len(delays))
+	}
+}
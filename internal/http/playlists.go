@@ -0,0 +1,71 @@
+package http
+
+import (
+	"fmt"
+
+	"github.com/dacsang97/safaribooks/internal/models"
+	"github.com/dacsang97/safaribooks/pkg/utils"
+)
+
+// GetPlaylists fetches the user's playlists
+func (c *Client) GetPlaylists() ([]models.Playlist, error) {
+	pageURL := c.siteURL + "/api/v3/collections/?page=1"
+	visited := make(map[string]bool)
+	var all []models.Playlist
+
+	for pageURL != "" {
+		if visited[pageURL] {
+			return nil, fmt.Errorf("API: playlists pagination loops back to %s", pageURL)
+		}
+		if len(visited) >= maxChapterPages {
+			return nil, fmt.Errorf("API: playlists pagination exceeded %d pages", maxChapterPages)
+		}
+		visited[pageURL] = true
+
+		var payload models.PlaylistResponse
+		if err := utils.HandleJSONResponseWithClient(c.client, pageURL, &payload, "API: unable to retrieve playlists"); err != nil {
+			return nil, err
+		}
+		all = append(all, payload.Results...)
+
+		pageURL = c.nextPage(payload.Next)
+	}
+
+	return all, nil
+}
+
+// GetPlaylistItems fetches every item in a playlist, including non-book
+// content such as videos; use PlaylistItem.BookID to pick out books
+func (c *Client) GetPlaylistItems(playlistID string) ([]models.PlaylistItem, error) {
+	pageURL := fmt.Sprintf("%s/api/v3/collections/%s/content/?page=1", c.siteURL, playlistID)
+	visited := make(map[string]bool)
+	var all []models.PlaylistItem
+
+	for pageURL != "" {
+		if visited[pageURL] {
+			return nil, fmt.Errorf("API: playlist pagination loops back to %s", pageURL)
+		}
+		if len(visited) >= maxChapterPages {
+			return nil, fmt.Errorf("API: playlist pagination exceeded %d pages", maxChapterPages)
+		}
+		visited[pageURL] = true
+
+		var payload models.PlaylistItemResponse
+		if err := utils.HandleJSONResponseWithClient(c.client, pageURL, &payload, "API: unable to retrieve playlist items"); err != nil {
+			return nil, err
+		}
+		all = append(all, payload.Results...)
+
+		pageURL = c.nextPage(payload.Next)
+	}
+
+	return all, nil
+}
+
+// nextPage resolves a pagination link against the site, or returns "" on the last page
+func (c *Client) nextPage(next *string) string {
+	if next == nil || *next == "" {
+		return ""
+	}
+	return c.ResolveURL(*next)
+}
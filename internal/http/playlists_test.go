@@ -0,0 +1,72 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetPlaylists_Pagination(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			fmt.Fprint(w, `{"count": 2, "next": null, "results": [{"id": "b2", "name": "Rust", "item_count": 1}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"count": 2, "next": "/api/v3/collections/?page=2", "results": [{"id": "a1", "name": "Go", "description": "Go books", "item_count": 3}]}`)
+	}))
+	defer server.Close()
+
+	playlists, err := newTestClient(server).GetPlaylists()
+	if err != nil {
+		t.Fatalf("GetPlaylists failed: %v", err)
+	}
+	if len(playlists) != 2 {
+		t.Fatalf("Expected 2 playlists, got %d", len(playlists))
+	}
+	if playlists[0].ID != "a1" || playlists[0].Name != "Go" || playlists[0].ItemCount != 3 {
+		t.Errorf("Unexpected first playlist: %+v", playlists[0])
+	}
+	if playlists[1].ID != "b2" {
+		t.Errorf("Expected second page playlist b2, got %+v", playlists[1])
+	}
+}
+
+func TestGetPlaylistItems_MixedContent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/collections/a1/content/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"count": 3, "next": null, "results": [
+			{"ourn": "urn:orm:book:9781491950357", "title": "A Book", "content_type": "book"},
+			{"ourn": "urn:orm:video:9781491912345", "title": "A Video", "content_type": "video"},
+			{"ourn": "urn:orm:book:0636920000000", "title": "Untyped Book"}
+		]}`)
+	}))
+	defer server.Close()
+
+	items, err := newTestClient(server).GetPlaylistItems("a1")
+	if err != nil {
+		t.Fatalf("GetPlaylistItems failed: %v", err)
+	}
+	if len(items) != 3 {
+		t.Fatalf("Expected 3 items, got %d", len(items))
+	}
+
+	want := []string{"9781491950357", "", "0636920000000"}
+	for i, item := range items {
+		if got := item.BookID(); got != want[i] {
+			t.Errorf("Item %d (%s): expected book ID %q, got %q", i, item.Title, want[i], got)
+		}
+	}
+}
+
+func TestGetPlaylistItems_NotFound(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	if _, err := newTestClient(server).GetPlaylistItems("missing"); err == nil {
+		t.Fatal("Expected error for unknown playlist, got nil")
+	}
+}
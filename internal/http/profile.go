@@ -0,0 +1,70 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/dacsang97/safaribooks/pkg/utils"
+	"github.com/go-resty/resty/v2"
+)
+
+var (
+	// ErrAuthentication means the cookies did not give a logged-in session
+	ErrAuthentication = errors.New("authentication issue")
+	// ErrSubscriptionExpired means the session is valid but the subscription has lapsed
+	ErrSubscriptionExpired = fmt.Errorf("%w: account subscription expired", ErrAuthentication)
+	// ErrRestricted means the title is DRM-protected or otherwise withheld
+	// from export, even though the session is valid
+	ErrRestricted = errors.New("this title cannot be exported (DRM/restricted)")
+)
+
+var (
+	profileEmailRe    = regexp.MustCompile(`"email"\s*:\s*"([^"]*)"`)
+	profileUserTypeRe = regexp.MustCompile(`"user_type"\s*:\s*"([^"]*)"`)
+)
+
+// Profile holds the account details embedded in the profile page
+type Profile struct {
+	Email    string
+	UserType string
+}
+
+// Profile returns the account details read during the authentication check
+func (c *Client) Profile() Profile {
+	return c.profile
+}
+
+// fetchProfile requests the profile page and reports whether the session is
+// logged in and subscribed
+func fetchProfile(client *resty.Client, profileURL string) (Profile, error) {
+	resp, err := client.R().
+		SetHeader("User-Agent", defaultUserAgent).
+		Get(profileURL)
+	if err != nil {
+		return Profile{}, utils.WrapError(err, "authentication check failed")
+	}
+
+	if resp.StatusCode() != http.StatusOK {
+		return Profile{}, fmt.Errorf("%w: expected 200, got %d", ErrAuthentication, resp.StatusCode())
+	}
+
+	profile := parseProfile(resp.String())
+	if profile.UserType == "Expired" {
+		return profile, ErrSubscriptionExpired
+	}
+	return profile, nil
+}
+
+// parseProfile extracts the account fields from the profile page's embedded JSON
+func parseProfile(body string) Profile {
+	var profile Profile
+	if m := profileEmailRe.FindStringSubmatch(body); m != nil {
+		profile.Email = m[1]
+	}
+	if m := profileUserTypeRe.FindStringSubmatch(body); m != nil {
+		profile.UserType = m[1]
+	}
+	return profile
+}
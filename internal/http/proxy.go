@@ -0,0 +1,44 @@
+package http
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ProxyURL parses a proxy URL and applies credentials from auth ("user:pass"),
+// which override any userinfo in the URL. The result's Redacted method masks
+// the password for logging.
+func ProxyURL(raw, auth string) (*url.URL, error) {
+	if raw == "" {
+		if auth != "" {
+			return nil, fmt.Errorf("proxy auth given without a proxy URL")
+		}
+		return nil, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid proxy URL %q: scheme must be http, https, or socks5", u.Redacted())
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q: missing host", u.Redacted())
+	}
+	if u.User != nil && u.User.Username() == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q: empty proxy user", u.Redacted())
+	}
+
+	if auth != "" {
+		user, pass, ok := strings.Cut(auth, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("invalid proxy auth: expected user:pass")
+		}
+		u.User = url.UserPassword(user, pass)
+	}
+	return u, nil
+}
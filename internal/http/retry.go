@@ -0,0 +1,124 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+const (
+	defaultRetries      = 3
+	defaultAssetRetries = 5 // large downloads from the CDN fail transiently more often
+	defaultRetryWait    = 1 * time.Second
+	defaultRetryMaxWait = 10 * time.Second
+)
+
+// assetRequestKey marks the context of asset downloads, see Client.Get
+type assetRequestKey struct{}
+
+// retryCounts are the retries allowed per request: asset for images,
+// stylesheets and other files fetched with Client.Get, api for the rest,
+// chapter pages included
+type retryCounts struct {
+	api, asset int
+}
+
+// retryCount resolves a configured retry count: fallback when zero, none
+// when negative
+func retryCount(n, fallback int) int {
+	switch {
+	case n == 0:
+		return fallback
+	case n < 0:
+		return 0
+	}
+	return n
+}
+
+// limit returns the retries allowed for req
+func (c retryCounts) limit(req *resty.Request) int {
+	if asset, _ := req.Context().Value(assetRequestKey{}).(bool); asset {
+		return c.asset
+	}
+	return c.api
+}
+
+// isRetryable reports whether a request outcome is worth retrying: network
+// errors, 429, and 5xx are transient, while other statuses (401/403/404 and
+// friends), bot challenges and bans are permanent and retrying them only wastes
+// time or invites bans
+func isRetryable(statusCode int, err error) bool {
+	var challenge *BotChallengeError
+	var banned *BannedError
+	if errors.As(err, &challenge) || errors.As(err, &banned) {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
+
+// configureRetries sets up resty to retry transient failures only, as many
+// times as counts allows for the kind of request
+func configureRetries(client *resty.Client, counts retryCounts, wait, maxWait time.Duration) {
+	client.
+		SetRetryCount(max(counts.api, counts.asset)).
+		SetRetryWaitTime(wait).
+		SetRetryMaxWaitTime(maxWait).
+		AddRetryCondition(func(resp *resty.Response, err error) bool {
+			statusCode := 0
+			if resp != nil {
+				statusCode = resp.StatusCode()
+				// Attempt counts the first try, so it exceeds the limit once
+				// every retry is used
+				if resp.Request != nil && resp.Request.Attempt > counts.limit(resp.Request) {
+					return false
+				}
+			}
+			return isRetryable(statusCode, err)
+		})
+}
+
+// ErrRetryBudgetExhausted ends a request that would have been retried once
+// the client's retry budget is spent
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// retryBudget caps the total time a client spends on failed attempts and the
+// backoff before retrying them, across all of its requests
+type retryBudget struct {
+	mu    sync.Mutex
+	limit time.Duration
+	spent time.Duration
+}
+
+// charge records time spent on a retry, refusing once the total would
+// exceed the limit
+func (b *retryBudget) charge(d time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.spent+d > b.limit {
+		return ErrRetryBudgetExhausted
+	}
+	b.spent += d
+	return nil
+}
+
+// setRetryBudget replaces resty's jittered backoff with a capped exponential
+// one charged to a budget of limit, so retries stop once it is used up
+func setRetryBudget(client *resty.Client, limit, wait, maxWait time.Duration) {
+	budget := &retryBudget{limit: limit}
+	client.SetRetryAfter(func(_ *resty.Client, resp *resty.Response) (time.Duration, error) {
+		backoff := maxWait
+		if attempt := resp.Request.Attempt; attempt > 0 && attempt < 32 && wait<<(attempt-1) < maxWait {
+			backoff = wait << (attempt - 1)
+		}
+		if err := budget.charge(resp.Time() + backoff); err != nil {
+			return 0, err
+		}
+		return backoff, nil
+	})
+}
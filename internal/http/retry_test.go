@@ -0,0 +1,172 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dacsang97/safaribooks/pkg/utils"
+)
+
+func TestIsRetryable(t *testing.T) {
+	cases := []struct {
+		status int
+		err    error
+		want   bool
+	}{
+		{0, errors.New("connection reset"), true},
+		{http.StatusTooManyRequests, nil, true},
+		{http.StatusInternalServerError, nil, true},
+		{http.StatusServiceUnavailable, nil, true},
+		{http.StatusOK, nil, false},
+		{http.StatusUnauthorized, nil, false},
+		{http.StatusForbidden, nil, false},
+		{http.StatusNotFound, nil, false},
+	}
+	for _, c := range cases {
+		if got := isRetryable(c.status, c.err); got != c.want {
+			t.Errorf("isRetryable(%d, %v) = %v, want %v", c.status, c.err, got, c.want)
+		}
+	}
+}
+
+// countingServer fails the first failures requests with status, then succeeds
+func countingServer(t *testing.T, status, failures int) (*httptest.Server, *int32) {
+	t.Helper()
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if int(atomic.AddInt32(&requests, 1)) <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte(`{"title": "Book"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestGetBookInfo_NotFoundIsNotRetried(t *testing.T) {
+	server, requests := countingServer(t, http.StatusNotFound, 10)
+	client := newTestClient(server)
+	configureRetries(client.client, retryCounts{api: 3, asset: 3}, time.Millisecond, time.Millisecond)
+
+	_, err := client.GetBookInfo("123")
+	if err == nil {
+		t.Fatal("Expected error for 404, got nil")
+	}
+
+	var statusErr *utils.StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected a 404 StatusError, got %v", err)
+	}
+	// The only second request is the one with the other trailing slash
+	if got := atomic.LoadInt32(requests); got != 2 {
+		t.Errorf("Expected 2 requests for a 404, got %d", got)
+	}
+}
+
+func TestGetBookInfo_ServiceUnavailableIsRetried(t *testing.T) {
+	server, requests := countingServer(t, http.StatusServiceUnavailable, 2)
+	client := newTestClient(server)
+	configureRetries(client.client, retryCounts{api: 3, asset: 3}, time.Millisecond, time.Millisecond)
+
+	info, err := client.GetBookInfo("123")
+	if err != nil {
+		t.Fatalf("Expected retries to recover from 503, got %v", err)
+	}
+	if info.Title != "Book" {
+		t.Errorf("Expected title Book, got %q", info.Title)
+	}
+	if got := atomic.LoadInt32(requests); got != 3 {
+		t.Errorf("Expected 3 requests, got %d", got)
+	}
+}
+
+func TestRetryCounts_PerResource(t *testing.T) {
+	server, requests := countingServer(t, http.StatusServiceUnavailable, 100)
+	client := newTestClient(server)
+	configureRetries(client.client, retryCounts{api: 1, asset: 4}, time.Millisecond, time.Millisecond)
+
+	if _, err := client.GetBookInfo("123"); err == nil {
+		t.Fatal("Expected the API request to fail")
+	}
+	if got := atomic.SwapInt32(requests, 0); got != 2 {
+		t.Errorf("Expected 1 API retry, 2 requests, got %d", got)
+	}
+
+	if resp, err := client.Get(server.URL + "/Images/fig1.png"); err != nil || resp.StatusCode() != http.StatusServiceUnavailable {
+		t.Fatalf("Expected the asset to fail with 503, got %v, %v", resp, err)
+	}
+	if got := atomic.SwapInt32(requests, 0); got != 5 {
+		t.Errorf("Expected 4 asset retries, 5 requests, got %d", got)
+	}
+
+	if _, _, _, err := client.GetLimited(server.URL+"/ch01.html", 1<<20); err != nil {
+		t.Fatalf("GetLimited failed: %v", err)
+	}
+	if got := atomic.LoadInt32(requests); got != 2 {
+		t.Errorf("Expected chapter pages retried like API requests, 2 requests, got %d", got)
+	}
+}
+
+func TestRetryCount(t *testing.T) {
+	cases := []struct{ n, fallback, want int }{
+		{0, 3, 3},
+		{2, 3, 2},
+		{-1, 3, 0},
+		{0, retryCount(-1, 5), 0},
+		{0, retryCount(2, 5), 2},
+	}
+	for _, c := range cases {
+		if got := retryCount(c.n, c.fallback); got != c.want {
+			t.Errorf("retryCount(%d, %d) = %d, want %d", c.n, c.fallback, got, c.want)
+		}
+	}
+}
+
+func TestRetryBudget_StopsRetries(t *testing.T) {
+	server, requests := countingServer(t, http.StatusServiceUnavailable, 100)
+	client := newTestClient(server)
+	configureRetries(client.client, retryCounts{api: 50, asset: 50}, 10*time.Millisecond, 10*time.Millisecond)
+	setRetryBudget(client.client, 35*time.Millisecond, 10*time.Millisecond, 10*time.Millisecond)
+
+	start := time.Now()
+	_, err := client.GetBookInfo("123")
+	if !errors.Is(err, ErrRetryBudgetExhausted) {
+		t.Fatalf("Expected ErrRetryBudgetExhausted, got %v", err)
+	}
+	// Each retry costs at least its 10ms backoff, so a 35ms budget allows three
+	if got := atomic.LoadInt32(requests); got < 2 || got > 4 {
+		t.Errorf("Expected the budget to stop after a few requests, got %d", got)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the budget to bound the run, took %v", elapsed)
+	}
+
+	// The budget is shared, so later requests are not retried at all
+	before := atomic.LoadInt32(requests)
+	if _, err := client.GetBookInfo("123"); !errors.Is(err, ErrRetryBudgetExhausted) {
+		t.Fatalf("Expected ErrRetryBudgetExhausted, got %v", err)
+	}
+	if got := atomic.LoadInt32(requests) - before; got != 1 {
+		t.Errorf("Expected a single attempt once the budget is spent, got %d", got)
+	}
+}
+
+func TestRetryBudget_AllowsRecovery(t *testing.T) {
+	server, requests := countingServer(t, http.StatusServiceUnavailable, 2)
+	client := newTestClient(server)
+	configureRetries(client.client, retryCounts{api: 3, asset: 3}, time.Millisecond, time.Millisecond)
+	setRetryBudget(client.client, time.Second, time.Millisecond, time.Millisecond)
+
+	if _, err := client.GetBookInfo("123"); err != nil {
+		t.Fatalf("Expected retries within budget to recover, got %v", err)
+	}
+	if got := atomic.LoadInt32(requests); got != 3 {
+		t.Errorf("Expected 3 requests, got %d", got)
+	}
+}
@@ -0,0 +1,26 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/dacsang97/safaribooks/internal/models"
+)
+
+// GetSignedURL asks the API for a short-lived signed URL of a book asset,
+// for CDN assets that refuse the session cookie alone. Relative URLs are
+// resolved against the site.
+func (c *Client) GetSignedURL(bookID, assetURL string) (string, error) {
+	apiURL := fmt.Sprintf("%s/api/v2/epubs/urn:orm:book:%s/signed-url/?url=%s",
+		c.siteURL, url.PathEscape(NormalizeBookID(bookID)), url.QueryEscape(assetURL))
+
+	var payload models.SignedURL
+	if err := c.getJSON(apiURL, &payload, "API: unable to sign asset URL"); err != nil {
+		return "", err
+	}
+	if payload.URL == "" {
+		return "", errors.New("API: signed URL missing from response")
+	}
+	return c.ResolveURL(payload.URL), nil
+}
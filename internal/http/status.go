@@ -0,0 +1,18 @@
+package http
+
+import "net/http"
+
+// statusObserver is a transport passing the status of every response,
+// retries included, to observe
+type statusObserver struct {
+	next    http.RoundTripper
+	observe func(code int)
+}
+
+func (o statusObserver) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := o.next.RoundTrip(req)
+	if err == nil {
+		o.observe(resp.StatusCode)
+	}
+	return resp, err
+}
@@ -12,6 +12,7 @@ type BookInfo struct {
 	Issued      string        `json:"issued"`
 	Rights      string        `json:"rights"`
 	Cover       string        `json:"cover"`
+	Language    string        `json:"language"`
 	Authors     []namedEntity `json:"authors"`
 	Publishers  []namedEntity `json:"publishers"`
 	Subjects    []namedEntity `json:"subjects"`
@@ -22,6 +23,14 @@ type namedEntity struct {
 	Name string `json:"name"`
 }
 
+// SetAuthors replaces the book's authors with names, in order
+func (b *BookInfo) SetAuthors(names []string) {
+	b.Authors = make([]namedEntity, len(names))
+	for i, name := range names {
+		b.Authors[i] = namedEntity{Name: name}
+	}
+}
+
 // Chapter represents a book chapter
 type Chapter struct {
 	Title        string              `json:"title"`
@@ -49,6 +58,67 @@ type ChapterResponse struct {
 	Results []Chapter `json:"results"`
 }
 
+// BookFile represents a file from the v2 EPUB files listing
+type BookFile struct {
+	URL       string `json:"url"`
+	FullPath  string `json:"full_path"`
+	Filename  string `json:"filename"`
+	MediaType string `json:"media_type"`
+}
+
+// BookFilesResponse represents a page of the v2 EPUB files listing
+type BookFilesResponse struct {
+	Count   int        `json:"count"`
+	Next    *string    `json:"next"`
+	Results []BookFile `json:"results"`
+}
+
+// SignedURL represents the API response signing an asset URL for download
+type SignedURL struct {
+	URL string `json:"url"`
+}
+
+// Playlist represents a user playlist (collection)
+type Playlist struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	ItemCount   int    `json:"item_count"`
+}
+
+// PlaylistResponse represents a page of the playlists API
+type PlaylistResponse struct {
+	Count   int        `json:"count"`
+	Next    *string    `json:"next"`
+	Results []Playlist `json:"results"`
+}
+
+// PlaylistItem represents a title in a playlist, which may be a book, video, or other content
+type PlaylistItem struct {
+	OURN        string `json:"ourn"`
+	Title       string `json:"title"`
+	ContentType string `json:"content_type"`
+}
+
+// BookID returns the book identifier for book items, or "" for other content
+func (i PlaylistItem) BookID() string {
+	const bookURN = "urn:orm:book:"
+	if i.ContentType != "" && i.ContentType != "book" {
+		return ""
+	}
+	if len(i.OURN) <= len(bookURN) || i.OURN[:len(bookURN)] != bookURN {
+		return ""
+	}
+	return i.OURN[len(bookURN):]
+}
+
+// PlaylistItemResponse represents a page of a playlist's items
+type PlaylistItemResponse struct {
+	Count   int            `json:"count"`
+	Next    *string        `json:"next"`
+	Results []PlaylistItem `json:"results"`
+}
+
 // TocItem represents a table of contents item
 type TocItem struct {
 	Fragment string      `json:"fragment"`
@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// partialSuffix marks the temporary files WriteFileAtomic renames into place;
+// any left behind were interrupted mid-write
+const partialSuffix = ".partial"
+
+// syncFile and syncDir flush a file's data and a directory's entries to
+// disk; tests replace them to check when they run
+var (
+	syncFile = (*os.File).Sync
+	syncDir  = SyncDir
+)
+
+// SyncDir flushes the entries of dir, such as a file just renamed into it,
+// so the new name survives a power loss. Windows cannot sync a directory;
+// there it does nothing.
+func SyncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// RenameDurable renames oldpath, a file already synced, to newpath and
+// syncs the directory of newpath, so a power loss leaves either the old file
+// or the complete new one there, never an empty one. It is meant for the
+// finished EPUB or archive; syncing the directory after every intermediate
+// file would slow a download down for no gain.
+func RenameDurable(oldpath, newpath string) error {
+	if err := os.Rename(oldpath, newpath); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(newpath))
+}
+
+// WriteFileAtomic writes data to path through a synced temporary file in the
+// same directory that is then renamed over path, so an interrupted write
+// leaves the previous content (or nothing) rather than a truncated file. The
+// directory is not synced, see RenameDurable. As with os.WriteFile, the
+// umask applies to perm.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return writeFileAtomic(path, perm, func(f *os.File) error {
+		_, err := f.Write(data)
+		return err
+	})
+}
+
+// WriteFileAtomicFrom is WriteFileAtomic with the content read from r, for
+// files too large to hold in memory; it returns the number of bytes written
+func WriteFileAtomicFrom(path string, r io.Reader, perm os.FileMode) (int64, error) {
+	var n int64
+	err := writeFileAtomic(path, perm, func(f *os.File) error {
+		var err error
+		n, err = io.Copy(f, r)
+		return err
+	})
+	return n, err
+}
+
+// writeFileAtomic is WriteFileAtomic with the content produced by write
+func writeFileAtomic(path string, perm os.FileMode, write func(f *os.File) error) (err error) {
+	tmp, err := createTemp(filepath.Dir(path), filepath.Base(path)+".*"+partialSuffix, perm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err = write(tmp); err != nil {
+		return err
+	}
+	if err = syncFile(tmp); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// createTemp is os.CreateTemp creating the file with perm, which the umask
+// applies to, instead of 0600
+func createTemp(dir, pattern string, perm os.FileMode) (*os.File, error) {
+	prefix, suffix, _ := strings.Cut(pattern, "*")
+	for range 10000 {
+		name := filepath.Join(dir, prefix+strconv.FormatUint(uint64(rand.Uint32()), 10)+suffix)
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if !errors.Is(err, fs.ErrExist) {
+			return f, err
+		}
+	}
+	return nil, &fs.PathError{Op: "createtemp", Path: filepath.Join(dir, pattern), Err: fs.ErrExist}
+}
@@ -0,0 +1,199 @@
+package utils
+
+import (
+	"archive/zip"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "content.opf")
+	if err := WriteFileAtomic(path, []byte("old"), 0644); err != nil {
+		t.Fatalf("WriteFileAtomic failed: %v", err)
+	}
+	if err := WriteFileAtomic(path, []byte("new"), 0644); err != nil {
+		t.Fatalf("WriteFileAtomic failed: %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if string(got) != "new" {
+		t.Errorf("Expected %q, got %q", "new", got)
+	}
+	assertNoPartialFiles(t, filepath.Dir(path))
+}
+
+func TestWriteFileAtomic_AppliesUmask(t *testing.T) {
+	dir := t.TempDir()
+	ref := filepath.Join(dir, "ref")
+	if err := os.WriteFile(ref, nil, 0666); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	path := filepath.Join(dir, "content.opf")
+	if err := WriteFileAtomic(path, []byte("data"), 0666); err != nil {
+		t.Fatalf("WriteFileAtomic failed: %v", err)
+	}
+
+	want, err := os.Stat(ref)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	got, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if got.Mode().Perm() != want.Mode().Perm() {
+		t.Errorf("Expected the mode os.WriteFile gives, %04o, got %04o", want.Mode().Perm(), got.Mode().Perm())
+	}
+}
+
+func TestWriteFileAtomicFrom(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "code.zip")
+	n, err := WriteFileAtomicFrom(path, strings.NewReader("PK zip bytes"), 0644)
+	if err != nil {
+		t.Fatalf("WriteFileAtomicFrom failed: %v", err)
+	}
+	if n != int64(len("PK zip bytes")) {
+		t.Errorf("Expected %d bytes written, got %d", len("PK zip bytes"), n)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if string(got) != "PK zip bytes" {
+		t.Errorf("Expected %q, got %q", "PK zip bytes", got)
+	}
+	assertNoPartialFiles(t, filepath.Dir(path))
+}
+
+func TestWriteFileAtomic_InterruptedKeepsOldContent(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ch01.xhtml")
+	if err := os.WriteFile(path, []byte("complete chapter"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	interrupted := errors.New("interrupted")
+	err := writeFileAtomic(path, 0644, func(f *os.File) error {
+		if _, err := f.WriteString("half a chap"); err != nil {
+			return err
+		}
+		return interrupted
+	})
+	if !errors.Is(err, interrupted) {
+		t.Fatalf("Expected the write error, got %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if string(got) != "complete chapter" {
+		t.Errorf("Expected old content to survive, got %q", got)
+	}
+	assertNoPartialFiles(t, dir)
+
+	// A file that never existed stays absent
+	fresh := filepath.Join(dir, "ch02.xhtml")
+	writeFileAtomic(fresh, 0644, func(*os.File) error { return interrupted })
+	if _, err := os.Stat(fresh); !os.IsNotExist(err) {
+		t.Errorf("Expected no file after an interrupted first write, got %v", err)
+	}
+}
+
+func TestZipDirectory_SkipsPartialFiles(t *testing.T) {
+	src := t.TempDir()
+	for name, content := range map[string]string{
+		"mimetype":                       "application/epub+zip",
+		"toc.ncx.123456" + partialSuffix: "<ncx",
+	} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	dest := filepath.Join(t.TempDir(), "book.zip")
+	if err := ZipDirectory(src, dest); err != nil {
+		t.Fatalf("ZipDirectory failed: %v", err)
+	}
+
+	reader, err := zip.OpenReader(dest)
+	if err != nil {
+		t.Fatalf("Failed to open zip: %v", err)
+	}
+	defer reader.Close()
+	var names []string
+	for _, f := range reader.File {
+		names = append(names, f.Name)
+	}
+	if len(names) != 1 || names[0] != "mimetype" {
+		t.Errorf("Expected only mimetype in the zip, got %v", names)
+	}
+}
+
+func assertNoPartialFiles(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read dir: %v", err)
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), partialSuffix) {
+			t.Errorf("Expected no leftover temporary file, found %s", entry.Name())
+		}
+	}
+}
+
+func TestWriteFileAtomic_SyncsBeforeRename(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "book.epub")
+	zipPath := filepath.Join(dir, "book.zip")
+
+	var steps []string
+	origFile, origDir := syncFile, syncDir
+	t.Cleanup(func() { syncFile, syncDir = origFile, origDir })
+	syncFile = func(f *os.File) error {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected the file synced before it is renamed into place, got %v", err)
+		}
+		steps = append(steps, "file")
+		return f.Sync()
+	}
+	syncDir = func(d string) error {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected the directory synced after the rename, got %v", err)
+		}
+		steps = append(steps, "dir "+filepath.Base(d))
+		return SyncDir(d)
+	}
+
+	// Intermediate files skip the directory sync; the finished file gets it
+	if err := WriteFileAtomic(zipPath, []byte("epub"), 0644); err != nil {
+		t.Fatalf("WriteFileAtomic failed: %v", err)
+	}
+	if want := "file"; strings.Join(steps, ",") != want {
+		t.Errorf("Expected syncs %q, got %q", want, strings.Join(steps, ","))
+	}
+	if err := RenameDurable(zipPath, path); err != nil {
+		t.Fatalf("RenameDurable failed: %v", err)
+	}
+	if want := "file,dir " + filepath.Base(dir); strings.Join(steps, ",") != want {
+		t.Errorf("Expected syncs %q, got %q", want, strings.Join(steps, ","))
+	}
+}
+
+func TestSyncDir(t *testing.T) {
+	if err := SyncDir(t.TempDir()); err != nil {
+		t.Errorf("SyncDir failed: %v", err)
+	}
+	if err := SyncDir(filepath.Join(t.TempDir(), "missing")); err == nil && runtime.GOOS != "windows" {
+		t.Error("Expected an error for a missing directory")
+	}
+}
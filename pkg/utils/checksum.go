@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checksumSuffix is appended to a file name to form its checksum file
+const checksumSuffix = ".sha256"
+
+// SHA256File returns the hex-encoded SHA-256 digest of a file
+func SHA256File(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// WriteChecksumFile writes "<hash>  <filename>" to path.sha256, the format
+// sha256sum -c reads, with the given mode, and returns the hash
+func WriteChecksumFile(path string, perm os.FileMode) (string, error) {
+	sum, err := SHA256File(path)
+	if err != nil {
+		return "", err
+	}
+	line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(path))
+	if err := WriteFileAtomic(path+checksumSuffix, []byte(line), perm); err != nil {
+		return "", err
+	}
+	return sum, nil
+}
+
+// VerifyChecksumFile checks path against the hash recorded in path.sha256
+func VerifyChecksumFile(path string) error {
+	data, err := os.ReadFile(path + checksumSuffix)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return fmt.Errorf("empty checksum file %s", path+checksumSuffix)
+	}
+
+	sum, err := SHA256File(path)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(fields[0], sum) {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", filepath.Base(path), fields[0], sum)
+	}
+	return nil
+}
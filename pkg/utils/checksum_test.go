@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteChecksumFile(t *testing.T) {
+	content := []byte("epub bytes")
+	path := filepath.Join(t.TempDir(), "Book (123).epub")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	sum, err := WriteChecksumFile(path, 0644)
+	if err != nil {
+		t.Fatalf("WriteChecksumFile failed: %v", err)
+	}
+
+	digest := sha256.Sum256(content)
+	want := hex.EncodeToString(digest[:])
+	if sum != want {
+		t.Errorf("Expected hash %s, got %s", want, sum)
+	}
+
+	got, err := os.ReadFile(path + ".sha256")
+	if err != nil {
+		t.Fatalf("Failed to read checksum file: %v", err)
+	}
+	if string(got) != want+"  Book (123).epub\n" {
+		t.Errorf("Unexpected checksum file content: %q", got)
+	}
+
+	if err := VerifyChecksumFile(path); err != nil {
+		t.Errorf("Expected checksum to verify, got %v", err)
+	}
+}
+
+func TestVerifyChecksumFile_Mismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.epub")
+	if err := os.WriteFile(path, []byte("original"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := WriteChecksumFile(path, 0644); err != nil {
+		t.Fatalf("WriteChecksumFile failed: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("corrupted"), 0644); err != nil {
+		t.Fatalf("Failed to rewrite file: %v", err)
+	}
+
+	if err := VerifyChecksumFile(path); err == nil {
+		t.Error("Expected checksum mismatch, got nil")
+	}
+}
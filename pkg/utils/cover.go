@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// CoverWidth and CoverHeight are the generated cover dimensions (2:3)
+	CoverWidth  = 1200
+	CoverHeight = 1800
+
+	coverMargin     = 120
+	coverTitleSize  = 96
+	coverAuthorSize = 56
+)
+
+// GenerateCover renders a JPEG cover with the title and author on a
+// gradient picked from the title. fontPath selects a TTF/OTF font for both;
+// empty uses the bundled Go fonts.
+func GenerateCover(title, author, fontPath string) ([]byte, error) {
+	titleFont, authorFont, err := coverFonts(fontPath)
+	if err != nil {
+		return nil, err
+	}
+
+	titleFace, err := opentype.NewFace(titleFont, &opentype.FaceOptions{Size: coverTitleSize, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, fmt.Errorf("title font face: %w", err)
+	}
+	defer titleFace.Close()
+	authorFace, err := opentype.NewFace(authorFont, &opentype.FaceOptions{Size: coverAuthorSize, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, fmt.Errorf("author font face: %w", err)
+	}
+	defer authorFace.Close()
+
+	img := image.NewRGBA(image.Rect(0, 0, CoverWidth, CoverHeight))
+	top, bottom := coverColors(title)
+	for y := 0; y < CoverHeight; y++ {
+		c := blend(top, bottom, float64(y)/float64(CoverHeight-1))
+		for x := 0; x < CoverWidth; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	// Title in the upper third, author near the bottom
+	y := CoverHeight / 4
+	for _, line := range wrapText(titleFace, title, CoverWidth-2*coverMargin) {
+		y += titleFace.Metrics().Height.Ceil()
+		drawCentered(img, titleFace, line, y)
+	}
+	if author != "" {
+		lines := wrapText(authorFace, author, CoverWidth-2*coverMargin)
+		y = CoverHeight - coverMargin - len(lines)*authorFace.Metrics().Height.Ceil()
+		for _, line := range lines {
+			y += authorFace.Metrics().Height.Ceil()
+			drawCentered(img, authorFace, line, y)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, fmt.Errorf("encode cover: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// coverFonts loads fontPath, or the bundled Go Bold and Go Regular fonts
+func coverFonts(fontPath string) (*opentype.Font, *opentype.Font, error) {
+	if fontPath != "" {
+		data, err := os.ReadFile(fontPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read cover font: %w", err)
+		}
+		f, err := opentype.Parse(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parse cover font: %w", err)
+		}
+		return f, f, nil
+	}
+
+	bold, err := opentype.Parse(gobold.TTF)
+	if err != nil {
+		return nil, nil, err
+	}
+	regular, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bold, regular, nil
+}
+
+// coverColors derives a dark gradient from the title so covers differ in a grid
+func coverColors(title string) (color.RGBA, color.RGBA) {
+	h := fnv.New32a()
+	h.Write([]byte(title))
+	sum := h.Sum32()
+	top := color.RGBA{R: uint8(40 + sum%80), G: uint8(40 + (sum>>8)%80), B: uint8(60 + (sum>>16)%100), A: 255}
+	bottom := color.RGBA{R: top.R / 3, G: top.G / 3, B: top.B / 3, A: 255}
+	return top, bottom
+}
+
+func blend(a, b color.RGBA, t float64) color.RGBA {
+	mix := func(x, y uint8) uint8 { return uint8(float64(x) + (float64(y)-float64(x))*t) }
+	return color.RGBA{R: mix(a.R, b.R), G: mix(a.G, b.G), B: mix(a.B, b.B), A: 255}
+}
+
+// wrapText splits text into lines no wider than width; a single word wider
+// than width gets its own line
+func wrapText(face font.Face, text string, width int) []string {
+	var lines []string
+	var line string
+	for _, word := range strings.Fields(text) {
+		candidate := strings.TrimSpace(line + " " + word)
+		if line != "" && font.MeasureString(face, candidate).Ceil() > width {
+			lines = append(lines, line)
+			line = word
+			continue
+		}
+		line = candidate
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// drawCentered draws one line of white text centered horizontally with its baseline at y
+func drawCentered(img *image.RGBA, face font.Face, text string, y int) {
+	d := &font.Drawer{Dst: img, Src: image.White, Face: face}
+	x := (CoverWidth - d.MeasureString(text).Ceil()) / 2
+	d.Dot = fixed.P(max(x, coverMargin/2), y)
+	d.DrawString(text)
+}
@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"bytes"
+	"image/jpeg"
+	"testing"
+)
+
+func TestGenerateCover(t *testing.T) {
+	data, err := GenerateCover("Learning Go: An Idiomatic Approach to Real-World Go Programming", "Jon Bodner", "")
+	if err != nil {
+		t.Fatalf("GenerateCover failed: %v", err)
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Expected a valid JPEG: %v", err)
+	}
+	width, height, err := ImageSize(data)
+	if err != nil {
+		t.Fatalf("ImageSize failed: %v", err)
+	}
+	if width != img.Bounds().Dx() || height != img.Bounds().Dy() {
+		t.Errorf("Expected ImageSize %dx%d, got %dx%d", img.Bounds().Dx(), img.Bounds().Dy(), width, height)
+	}
+	if b := img.Bounds(); b.Dx() != CoverWidth || b.Dy() != CoverHeight {
+		t.Errorf("Expected %dx%d cover, got %dx%d", CoverWidth, CoverHeight, b.Dx(), b.Dy())
+	}
+}
+
+func TestGenerateCover_MissingFont(t *testing.T) {
+	if _, err := GenerateCover("Title", "", "/nonexistent/font.ttf"); err == nil {
+		t.Error("Expected error for a missing font, got nil")
+	}
+}
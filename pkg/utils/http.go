@@ -1,30 +1,133 @@
 package utils
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/go-resty/resty/v2"
 )
 
+// StatusError reports a request that completed with a non-success HTTP status
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d: %s", e.StatusCode, TruncateBody([]byte(e.Body), maxErrorBody))
+}
+
+// maxErrorBody caps how much of a response body an error message quotes
+const maxErrorBody = 200
+
+// InvalidJSONError reports a successful response whose body isn't the
+// expected JSON. Its message quotes the start of the body only; Body keeps
+// all of it for SaveBody.
+type InvalidJSONError struct {
+	URL         string
+	ContentType string
+	Body        []byte
+	Err         error
+}
+
+func (e *InvalidJSONError) Error() string {
+	if e.IsHTML() {
+		return "invalid response: got an HTML page instead of JSON, " +
+			"likely a login or challenge page (refresh your cookies and try again)"
+	}
+	return fmt.Sprintf("invalid response: %v (body: %q)", e.Err, TruncateBody(e.Body, maxErrorBody))
+}
+
+func (e *InvalidJSONError) Unwrap() error {
+	return e.Err
+}
+
+// IsHTML reports whether the body is an HTML page, by its content type or,
+// when that is missing or generic, its first tag
+func (e *InvalidJSONError) IsHTML() bool {
+	if strings.Contains(e.ContentType, "html") {
+		return true
+	}
+	start := strings.ToLower(string(TruncateBody(bytes.TrimSpace(TrimBOM(e.Body)), 64)))
+	return strings.HasPrefix(start, "<!doctype html") || strings.HasPrefix(start, "<html") ||
+		strings.HasPrefix(start, "<head") || strings.HasPrefix(start, "<body")
+}
+
+// SaveBody writes the whole body to a new file in dir, named after the time
+// and kind of content, and returns its path
+func (e *InvalidJSONError) SaveBody(dir string) (string, error) {
+	ext := ".txt"
+	if e.IsHTML() {
+		ext = ".html"
+	}
+	name := filepath.Join(dir, "response-"+time.Now().Format("20060102-150405")+ext)
+	if err := os.WriteFile(name, e.Body, 0644); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// TruncateBody returns at most limit bytes of body, cut back to a UTF-8
+// boundary, with an ellipsis when something was cut
+func TruncateBody(body []byte, limit int) []byte {
+	if len(body) <= limit {
+		return body
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(body[cut]) {
+		cut--
+	}
+	return append(body[:cut:cut], "..."...)
+}
+
 // HandleJSONResponse handles JSON HTTP responses
 func HandleJSONResponse(resp *resty.Response, target interface{}, errorMsg string) error {
 	if !resp.IsSuccess() {
-		return fmt.Errorf("%s: unexpected status %d: %s", errorMsg, resp.StatusCode(), resp.String())
+		return fmt.Errorf("%s: %w", errorMsg, &StatusError{
+			URL:        resp.Request.URL,
+			StatusCode: resp.StatusCode(),
+			Body:       resp.String(),
+		})
 	}
 	if err := json.Unmarshal(resp.Body(), target); err != nil {
-		return fmt.Errorf("%s: invalid response: %w", errorMsg, err)
+		return fmt.Errorf("%s: %w", errorMsg, &InvalidJSONError{
+			URL:         resp.Request.URL,
+			ContentType: resp.Header().Get("Content-Type"),
+			Body:        resp.Body(),
+			Err:         err,
+		})
 	}
 	return nil
 }
 
-// HandleJSONResponseWithClient uses resty client directly to get JSON response
+// HandleJSONResponseWithClient uses resty client directly to get JSON
+// response. A body that isn't valid JSON is fetched once more, since it is
+// usually cut short by a dropped connection; an HTML page is not, as
+// fetching it again serves the same page.
 func HandleJSONResponseWithClient(client *resty.Client, url string, target interface{}, errorMsg string) error {
 	resp, err := client.R().Get(url)
 	if err != nil {
 		return fmt.Errorf("%s: request failed: %w", errorMsg, err)
 	}
-	return HandleJSONResponse(resp, target, errorMsg)
+	err = HandleJSONResponse(resp, target, errorMsg)
+	var invalid *InvalidJSONError
+	if !errors.As(err, &invalid) || invalid.IsHTML() {
+		return err
+	}
+	if resp, retryErr := client.R().Get(url); retryErr == nil {
+		if HandleJSONResponse(resp, target, errorMsg) == nil {
+			return nil
+		}
+	}
+	return err
 }
 
 // WrapError wraps an error with a consistent message format
@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+)
+
+func TestHandleJSONResponse_HTMLBody(t *testing.T) {
+	page := "<!DOCTYPE html><html><head><title>Sign In</title></head><body>" +
+		strings.Repeat("<div class=\"login\">Please sign in</div>", 200) + "</body></html>"
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(page))
+	}))
+	defer server.Close()
+
+	var target map[string]any
+	err := HandleJSONResponseWithClient(resty.New(), server.URL, &target, "API: unable to retrieve book info")
+	if err == nil {
+		t.Fatal("Expected an error for an HTML body")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "got an HTML page instead of JSON") || !strings.Contains(msg, "refresh your cookies") {
+		t.Errorf("Expected a hint about the session, got %q", msg)
+	}
+	if strings.Contains(msg, "<div") || len(msg) > 300 {
+		t.Errorf("Expected no markup in the error, got %q", msg)
+	}
+	if requests != 1 {
+		t.Errorf("Expected an HTML page not to be fetched again, got %d requests", requests)
+	}
+
+	var invalid *InvalidJSONError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Expected an *InvalidJSONError, got %T", err)
+	}
+	path, err := invalid.SaveBody(t.TempDir())
+	if err != nil {
+		t.Fatalf("SaveBody failed: %v", err)
+	}
+	if !strings.HasSuffix(path, ".html") {
+		t.Errorf("Expected an .html file, got %s", path)
+	}
+	if saved, err := os.ReadFile(path); err != nil || string(saved) != page {
+		t.Errorf("Expected the whole body saved, got %d bytes (%v)", len(saved), err)
+	}
+}
+
+func TestHandleJSONResponse_TruncatedJSON(t *testing.T) {
+	var requests, broken int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		if requests <= broken {
+			w.Write([]byte(`{"title": "Learning Go", "chapters": [` + strings.Repeat(`"ch01.html", `, 100)))
+			return
+		}
+		w.Write([]byte(`{"title": "Learning Go"}`))
+	}))
+	defer server.Close()
+
+	broken = 1
+	var target struct {
+		Title string `json:"title"`
+	}
+	if err := HandleJSONResponseWithClient(resty.New(), server.URL, &target, "API"); err != nil {
+		t.Fatalf("Expected the second response to be used, got %v", err)
+	}
+	if target.Title != "Learning Go" || requests != 2 {
+		t.Errorf("Expected the title after 2 requests, got %q after %d", target.Title, requests)
+	}
+
+	// Still invalid the second time: the message quotes the start of the body only
+	requests, broken = 0, 2
+	err := HandleJSONResponseWithClient(resty.New(), server.URL, &target, "API")
+	if err == nil || !strings.Contains(err.Error(), `...")`) || len(err.Error()) > 350 {
+		t.Errorf("Expected a truncated body in the error, got %v", err)
+	}
+}
+
+func TestTruncateBody(t *testing.T) {
+	if got := string(TruncateBody([]byte("short"), 10)); got != "short" {
+		t.Errorf("Expected a short body unchanged, got %q", got)
+	}
+	if got := string(TruncateBody([]byte("héllo"), 2)); got != "h..." {
+		t.Errorf("Expected the cut at a rune boundary, got %q", got)
+	}
+}
+
+func TestStatusError_TruncatesBody(t *testing.T) {
+	err := &StatusError{StatusCode: http.StatusForbidden, Body: strings.Repeat("x", 1000)}
+	if got := err.Error(); len(got) > 250 || !strings.HasSuffix(got, "...") {
+		t.Errorf("Expected the body truncated in the error, got %q", got)
+	}
+}
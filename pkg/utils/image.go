@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// IsWebP reports whether data is a WebP image, judged by its RIFF header
+func IsWebP(data []byte) bool {
+	return len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+}
+
+// ConvertWebP transcodes WebP data to "jpeg" or "png" and returns the new
+// bytes with the matching file extension. JPEG output is flattened onto a
+// white background since JPEG has no alpha channel.
+func ConvertWebP(data []byte, format string, quality int) ([]byte, string, error) {
+	img, err := webp.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("decode webp: %w", err)
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "jpeg", "jpg":
+		flat := image.NewRGBA(img.Bounds())
+		draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+		if err := jpeg.Encode(&buf, flat, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, "", fmt.Errorf("encode jpeg: %w", err)
+		}
+		return buf.Bytes(), ".jpg", nil
+	case "png":
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, "", fmt.Errorf("encode png: %w", err)
+		}
+		return buf.Bytes(), ".png", nil
+	default:
+		return nil, "", fmt.Errorf("unsupported image format %q", format)
+	}
+}
+
+// Thumbnail scales a JPEG, PNG, GIF, or WebP image down to width pixels wide,
+// keeping its aspect ratio, and encodes it as JPEG. Images already narrower
+// than width keep their size.
+func Thumbnail(data []byte, width, quality int) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+
+	src := img.Bounds()
+	if src.Dx() == 0 || src.Dy() == 0 {
+		return nil, errors.New("empty image")
+	}
+	if src.Dx() < width {
+		width = src.Dx()
+	}
+	height := max(1, (src.Dy()*width+src.Dx()/2)/src.Dx())
+
+	thumb := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(thumb, thumb.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	xdraw.CatmullRom.Scale(thumb, thumb.Bounds(), img, src, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, fmt.Errorf("encode jpeg: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// ImageSize returns the pixel dimensions of JPEG, PNG, GIF, or WebP data
+// without decoding the whole image
+func ImageSize(data []byte) (width, height int, err error) {
+	var cfg image.Config
+	if IsWebP(data) {
+		cfg, err = webp.DecodeConfig(bytes.NewReader(data))
+	} else {
+		cfg, _, err = image.DecodeConfig(bytes.NewReader(data))
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("decode image size: %w", err)
+	}
+	return cfg.Width, cfg.Height, nil
+}
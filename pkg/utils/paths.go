@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const (
+	appDirName         = "safaribooks"
+	defaultCookiesFile = "cookies.json"
+)
+
+// ConfigDir returns the directory holding configuration and cookies.
+// An explicit baseDir wins, otherwise $XDG_CONFIG_HOME/safaribooks is used.
+func ConfigDir(baseDir string) string {
+	if baseDir != "" {
+		return baseDir
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, appDirName)
+	}
+	return ""
+}
+
+// ResolveCookiesPath picks the cookies file to use. The precedence is:
+// an explicit path, cookies.json in baseDir (when given), cookies.json in the
+// working directory, then cookies.json in the config directory. When none
+// exist the working-directory path is returned so callers report it as missing.
+func ResolveCookiesPath(explicit, baseDir string) string {
+	if explicit != "" {
+		return explicit
+	}
+
+	fallback := defaultCookiesFile
+	if wd, err := os.Getwd(); err == nil {
+		fallback = filepath.Join(wd, defaultCookiesFile)
+	}
+
+	var candidates []string
+	if baseDir != "" {
+		candidates = append(candidates, filepath.Join(baseDir, defaultCookiesFile))
+	}
+	candidates = append(candidates, fallback)
+	if dir := ConfigDir(""); dir != "" {
+		candidates = append(candidates, filepath.Join(dir, defaultCookiesFile))
+	}
+
+	for _, candidate := range candidates {
+		if FileExists(candidate) {
+			return candidate
+		}
+	}
+	return fallback
+}
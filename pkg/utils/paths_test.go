@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setupCookieDirs points the config dir and working directory at temp dirs
+func setupCookieDirs(t *testing.T) (configHome, workDir, baseDir string) {
+	t.Helper()
+
+	configHome = t.TempDir()
+	workDir = t.TempDir()
+	baseDir = t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	t.Setenv("HOME", t.TempDir())
+	t.Chdir(workDir)
+	return configHome, workDir, baseDir
+}
+
+func writeCookies(t *testing.T, dir string) string {
+	t.Helper()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	path := filepath.Join(dir, "cookies.json")
+	if err := os.WriteFile(path, []byte(`{"orm-jwt": "x"}`), 0644); err != nil {
+		t.Fatalf("Failed to write cookies: %v", err)
+	}
+	return path
+}
+
+func TestResolveCookiesPath_ExplicitWins(t *testing.T) {
+	configHome, workDir, baseDir := setupCookieDirs(t)
+	writeCookies(t, filepath.Join(configHome, "safaribooks"))
+	writeCookies(t, workDir)
+	writeCookies(t, baseDir)
+
+	if got := ResolveCookiesPath("/custom/cookies.json", baseDir); got != "/custom/cookies.json" {
+		t.Errorf("Expected explicit path, got %s", got)
+	}
+}
+
+func TestResolveCookiesPath_BaseDirBeforeWorkingDir(t *testing.T) {
+	_, workDir, baseDir := setupCookieDirs(t)
+	writeCookies(t, workDir)
+	want := writeCookies(t, baseDir)
+
+	if got := ResolveCookiesPath("", baseDir); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestResolveCookiesPath_WorkingDirBeforeConfigDir(t *testing.T) {
+	configHome, workDir, _ := setupCookieDirs(t)
+	writeCookies(t, filepath.Join(configHome, "safaribooks"))
+	want := writeCookies(t, workDir)
+
+	if got := ResolveCookiesPath("", ""); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestResolveCookiesPath_ConfigDirFallback(t *testing.T) {
+	configHome, _, _ := setupCookieDirs(t)
+	want := writeCookies(t, filepath.Join(configHome, "safaribooks"))
+
+	if got := ResolveCookiesPath("", ""); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestResolveCookiesPath_NoneFound(t *testing.T) {
+	_, workDir, _ := setupCookieDirs(t)
+
+	want := filepath.Join(workDir, "cookies.json")
+	if got := ResolveCookiesPath("", ""); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestConfigDir(t *testing.T) {
+	configHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+
+	if got := ConfigDir(""); got != filepath.Join(configHome, "safaribooks") {
+		t.Errorf("Unexpected config dir: %s", got)
+	}
+	if got := ConfigDir("/base"); got != "/base" {
+		t.Errorf("Expected base dir to override config dir, got %s", got)
+	}
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ParsePermissions parses an octal permission mode such as "0775" or "664"
+func ParsePermissions(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid permissions %q (use an octal mode like 0755)", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// MkdirAllMode is os.MkdirAll, but the directories it creates get exactly
+// perm instead of perm masked by the umask. Existing directories are left
+// as they are.
+func MkdirAllMode(dir string, perm os.FileMode) error {
+	var missing []string
+	for p := filepath.Clean(dir); ; p = filepath.Dir(p) {
+		_, err := os.Stat(p)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		missing = append(missing, p)
+		if filepath.Dir(p) == p {
+			break
+		}
+	}
+
+	if err := os.MkdirAll(dir, perm); err != nil {
+		return err
+	}
+	for _, p := range missing {
+		if err := os.Chmod(p, perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
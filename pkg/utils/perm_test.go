@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParsePermissions(t *testing.T) {
+	cases := map[string]os.FileMode{"0775": 0775, "664": 0664, "0700": 0700}
+	for input, want := range cases {
+		got, err := ParsePermissions(input)
+		if err != nil || got != want {
+			t.Errorf("ParsePermissions(%q) = %o, %v, want %o", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"", "rwxr-xr-x", "0789", "1777", "-1"} {
+		if _, err := ParsePermissions(input); err == nil {
+			t.Errorf("Expected ParsePermissions(%q) to fail", input)
+		}
+	}
+}
+
+func TestMkdirAllMode(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Chmod(root, 0700); err != nil {
+		t.Fatalf("Chmod failed: %v", err)
+	}
+	dir := filepath.Join(root, "a", "b")
+	if err := MkdirAllMode(dir, 0775); err != nil {
+		t.Fatalf("MkdirAllMode failed: %v", err)
+	}
+
+	for path, want := range map[string]os.FileMode{root: 0700, filepath.Join(root, "a"): 0775, dir: 0775} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		if got := info.Mode().Perm(); got != want {
+			t.Errorf("Expected %s to have mode %o, got %o", path, want, got)
+		}
+	}
+}
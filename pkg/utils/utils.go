@@ -1,15 +1,16 @@
 package utils
 
 import (
-	"archive/zip"
+	"bytes"
 	"encoding/json"
 	"errors"
-	"io"
-	"io/fs"
+	"fmt"
+	"mime"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 )
 
@@ -71,6 +72,47 @@ func FilenameFromURL(raw string) string {
 	return name
 }
 
+// FilenameFromContentDisposition extracts a safe base filename from a
+// Content-Disposition header value
+func FilenameFromContentDisposition(header string) string {
+	if header == "" {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		return ""
+	}
+	name := path.Base(strings.ReplaceAll(params["filename"], "\\", "/"))
+	if name == "." || name == "/" || name == ".." {
+		return ""
+	}
+	return name
+}
+
+// ExtensionFromContentType returns the file extension for common asset content types
+func ExtensionFromContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	switch mediaType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/svg+xml":
+		return ".svg"
+	case "image/webp":
+		return ".webp"
+	case "text/css":
+		return ".css"
+	default:
+		return ""
+	}
+}
+
 // StripQueryFragment removes query parameters and fragments from a URL
 func StripQueryFragment(link string) string {
 	if idx := strings.IndexAny(link, "?#"); idx >= 0 {
@@ -103,53 +145,30 @@ func EscapeDirname(name string) string {
 	return replacer.Replace(name)
 }
 
-// ZipDirectory creates a zip file from a directory
-func ZipDirectory(srcDir, destZip string) error {
+// ZipDirectory creates a zip file from a directory, skipping the given
+// slash-separated files and directories relative to srcDir as well as files
+// left behind by interrupted WriteFileAtomic calls
+func ZipDirectory(srcDir, destZip string, exclude ...string) error {
 	out, err := os.Create(destZip)
 	if err != nil {
 		return err
 	}
-	defer out.Close()
-
-	zipWriter := zip.NewWriter(out)
-	defer zipWriter.Close()
-
-	return filepath.WalkDir(srcDir, func(pathname string, d fs.DirEntry, walkErr error) error {
-		if walkErr != nil {
-			return walkErr
-		}
-		if pathname == srcDir {
-			return nil
-		}
-
-		rel, err := filepath.Rel(srcDir, pathname)
-		if err != nil {
-			return err
-		}
-		rel = filepath.ToSlash(rel)
 
-		if d.IsDir() {
-			_, err := zipWriter.Create(rel + "/")
-			return err
-		}
-
-		file, err := os.Open(pathname)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
-
-		writer, err := zipWriter.Create(rel)
-		if err != nil {
-			return err
-		}
-
-		if _, err := io.Copy(writer, file); err != nil {
-			return err
-		}
-
-		return nil
-	})
+	zs := NewZipStream(out)
+	err = zs.AddDirectory(srcDir, exclude...)
+	if closeErr := zs.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = SyncDir(filepath.Dir(destZip))
+	}
+	return err
 }
 
 // J2TeamCookie represents a cookie in J2Team Cookies format
@@ -183,13 +202,75 @@ type BrowserCookie struct {
 	StoreID  *string `json:"storeId"`
 }
 
+// sessionCookieNames are cookies that carry the login session
+var sessionCookieNames = []string{"orm-jwt", "orm-rt", "groot_sessionid", "sessionid"}
+
+// ParseCookieHeader parses a raw Cookie header value ("name1=val1; name2=val2"),
+// optionally prefixed with "Cookie:", into a cookie map
+func ParseCookieHeader(raw string) (map[string]string, error) {
+	raw = strings.TrimSpace(raw)
+	if len(raw) >= len("cookie:") && strings.EqualFold(raw[:len("cookie:")], "cookie:") {
+		raw = raw[len("cookie:"):]
+	}
+
+	cookies := make(map[string]string)
+	for _, part := range strings.Split(raw, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t\r\n{}\"") {
+			return nil, fmt.Errorf("invalid cookie pair %q", part)
+		}
+		cookies[name] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+
+	if len(cookies) == 0 {
+		return nil, errors.New("cookie header is empty")
+	}
+	return cookies, nil
+}
+
+// SessionCookieNames returns the names of the cookies that carry the login session
+func SessionCookieNames() []string {
+	return slices.Clone(sessionCookieNames)
+}
+
+// HasSessionCookie reports whether cookies include a login session cookie
+func HasSessionCookie(cookies map[string]string) bool {
+	for _, name := range sessionCookieNames {
+		if cookies[name] != "" {
+			return true
+		}
+	}
+	for name := range cookies {
+		if strings.HasPrefix(strings.ToLower(name), "ezproxy") {
+			return true
+		}
+	}
+	return false
+}
+
+// utf8BOM is the byte order mark some Windows tools put at the start of UTF-8 files
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// TrimBOM strips a leading UTF-8 byte order mark, which JSON and other
+// parsers reject, from file data
+func TrimBOM(data []byte) []byte {
+	return bytes.TrimPrefix(data, utf8BOM)
+}
+
 // LoadCookies loads cookies from a JSON file and auto-detects the format
-// Supports Cookie-Editor format (flat JSON), J2Team Cookies format, and browser extension export format
+// Supports Cookie-Editor format (flat JSON), J2Team Cookies format, browser extension export format,
+// and a raw Cookie header string
 func LoadCookies(path string) (map[string]string, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
+	data = TrimBOM(data)
 
 	// Try J2Team format first
 	var j2team J2TeamCookiesFile
@@ -216,7 +297,11 @@ func LoadCookies(path string) (map[string]string, error) {
 	// Fall back to Cookie-Editor format (flat JSON map)
 	var cookies map[string]string
 	if err := json.Unmarshal(data, &cookies); err != nil {
-		return nil, errors.New("unsupported cookie format: unable to parse as J2Team, browser extension, or Cookie-Editor format")
+		// Last resort: a raw "name=value; name2=value2" Cookie header pasted into the file
+		if headerCookies, headerErr := ParseCookieHeader(string(data)); headerErr == nil {
+			return headerCookies, nil
+		}
+		return nil, errors.New("unsupported cookie format: unable to parse as J2Team, browser extension, Cookie-Editor, or Cookie header format")
 	}
 
 	if len(cookies) == 0 {
@@ -1,8 +1,10 @@
 package utils
 
 import (
+	"archive/zip"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -39,6 +41,22 @@ func TestLoadCookies_CookieEditorFormat(t *testing.T) {
 	}
 }
 
+func TestLoadCookies_ByteOrderMark(t *testing.T) {
+	cookiePath := filepath.Join(t.TempDir(), "cookies.json")
+	data := "\xef\xbb\xbf" + `{"orm-jwt": "test_value", "orm-rt": "refresh"}`
+	if err := os.WriteFile(cookiePath, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	cookies, err := LoadCookies(cookiePath)
+	if err != nil {
+		t.Fatalf("LoadCookies failed on a BOM-prefixed file: %v", err)
+	}
+	if len(cookies) != 2 || cookies["orm-jwt"] != "test_value" {
+		t.Errorf("Expected the Cookie-Editor cookies, got %v", cookies)
+	}
+}
+
 func TestLoadCookies_J2TeamFormat(t *testing.T) {
 	// Create a temporary file with J2Team format
 	tmpDir := t.TempDir()
@@ -117,3 +135,105 @@ func TestLoadCookies_InvalidFormat(t *testing.T) {
 		t.Error("Expected error for invalid JSON, got nil")
 	}
 }
+
+func TestFilenameFromContentDisposition(t *testing.T) {
+	cases := map[string]string{
+		`attachment; filename="figure1.png"`:     "figure1.png",
+		`inline; filename=cover.jpg`:             "cover.jpg",
+		`attachment; filename="../../etc/x.png"`: "x.png",
+		`attachment`:                             "",
+		``:                                       "",
+	}
+	for header, want := range cases {
+		if got := FilenameFromContentDisposition(header); got != want {
+			t.Errorf("FilenameFromContentDisposition(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestParseCookieHeader(t *testing.T) {
+	cookies, err := ParseCookieHeader(`Cookie: orm-jwt=abc.def; orm-rt=xyz ;  _abck="q=1";`)
+	if err != nil {
+		t.Fatalf("ParseCookieHeader failed: %v", err)
+	}
+
+	if len(cookies) != 3 {
+		t.Errorf("Expected 3 cookies, got %d", len(cookies))
+	}
+	if cookies["orm-jwt"] != "abc.def" {
+		t.Errorf("Expected orm-jwt=abc.def, got %s", cookies["orm-jwt"])
+	}
+	if cookies["orm-rt"] != "xyz" {
+		t.Errorf("Expected orm-rt=xyz, got %s", cookies["orm-rt"])
+	}
+	if cookies["_abck"] != "q=1" {
+		t.Errorf("Expected _abck=q=1, got %s", cookies["_abck"])
+	}
+	if !HasSessionCookie(cookies) {
+		t.Error("Expected orm-jwt to count as a session cookie")
+	}
+}
+
+func TestParseCookieHeader_Invalid(t *testing.T) {
+	for _, raw := range []string{"", " ; ;", "Cookie:", "novalue", "=value"} {
+		if _, err := ParseCookieHeader(raw); err == nil {
+			t.Errorf("Expected error for %q, got nil", raw)
+		}
+	}
+}
+
+func TestLoadCookies_HeaderStringFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	cookiePath := filepath.Join(tmpDir, "cookies.txt")
+
+	if err := os.WriteFile(cookiePath, []byte("orm-jwt=abc; groot_sessionid=def\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	cookies, err := LoadCookies(cookiePath)
+	if err != nil {
+		t.Fatalf("LoadCookies failed: %v", err)
+	}
+
+	if cookies["orm-jwt"] != "abc" || cookies["groot_sessionid"] != "def" {
+		t.Errorf("Unexpected cookies: %v", cookies)
+	}
+}
+
+func TestZipDirectory_ExcludesDirectory(t *testing.T) {
+	srcDir := t.TempDir()
+	files := map[string]string{
+		"mimetype":             "application/epub+zip",
+		"OEBPS/ch01.xhtml":     "<html/>",
+		"OEBPS/_raw/ch01.html": "<html>raw</html>",
+	}
+	for name, content := range files {
+		path := filepath.Join(srcDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	zipPath := filepath.Join(t.TempDir(), "book.zip")
+	if err := ZipDirectory(srcDir, zipPath, "OEBPS/_raw"); err != nil {
+		t.Fatalf("ZipDirectory failed: %v", err)
+	}
+
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatalf("Failed to open zip: %v", err)
+	}
+	defer reader.Close()
+
+	for _, f := range reader.File {
+		if strings.HasPrefix(f.Name, "OEBPS/_raw") {
+			t.Errorf("Expected OEBPS/_raw to be excluded, found %s", f.Name)
+		}
+	}
+	if len(reader.File) != 3 {
+		t.Errorf("Expected mimetype, OEBPS/ and ch01.xhtml, got %d entries", len(reader.File))
+	}
+}
@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ZipStream writes a zip archive one entry at a time, so files can be added
+// as they are produced instead of being staged on disk first. It is safe for
+// concurrent use and rejects duplicate entry names.
+type ZipStream struct {
+	mu    sync.Mutex
+	zw    *zip.Writer
+	names map[string]bool
+}
+
+// NewZipStream starts a zip archive written to w
+func NewZipStream(w io.Writer) *ZipStream {
+	return &ZipStream{zw: zip.NewWriter(w), names: make(map[string]bool)}
+}
+
+// Store adds an uncompressed entry, as EPUB requires for its mimetype file
+func (z *ZipStream) Store(name string, data []byte) error {
+	return z.add(&zip.FileHeader{Name: name, Method: zip.Store}, data)
+}
+
+// Write adds a compressed entry
+func (z *ZipStream) Write(name string, data []byte) error {
+	return z.add(&zip.FileHeader{Name: name, Method: zip.Deflate}, data)
+}
+
+// Has reports whether an entry called name was already added
+func (z *ZipStream) Has(name string) bool {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.names[name]
+}
+
+func (z *ZipStream) add(header *zip.FileHeader, data []byte) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if z.names[header.Name] {
+		return fmt.Errorf("zip: duplicate entry %s", header.Name)
+	}
+	w, err := z.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	z.names[header.Name] = true
+	return nil
+}
+
+// AddDirectory adds the files below srcDir under their slash-separated
+// relative paths, skipping entries already in the archive, the given
+// excluded files and directories, and files left behind by interrupted
+// WriteFileAtomic calls
+func (z *ZipStream) AddDirectory(srcDir string, exclude ...string) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	return filepath.WalkDir(srcDir, func(pathname string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if pathname == srcDir {
+			return nil
+		}
+
+		rel, err := filepath.Rel(srcDir, pathname)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if slices.Contains(exclude, rel) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			rel += "/"
+		}
+		if z.names[rel] || !d.IsDir() && strings.HasSuffix(rel, partialSuffix) {
+			return nil
+		}
+
+		if d.IsDir() {
+			_, err := z.zw.Create(rel)
+			z.names[rel] = true
+			return err
+		}
+
+		file, err := os.Open(pathname)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		writer, err := z.zw.Create(rel)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(writer, file); err != nil {
+			return err
+		}
+		z.names[rel] = true
+		return nil
+	})
+}
+
+// Close finishes the archive; it does not close the underlying writer
+func (z *ZipStream) Close() error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.zw.Close()
+}